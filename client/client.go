@@ -0,0 +1,310 @@
+// Package client is a Go client for the kvstore gRPC API. It wraps the
+// generated KVService client so callers work with plain Go values rather
+// than protobuf messages.
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	kvstorev1 "task1/api/kvstore/v1"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+var (
+	ErrNotFound = errors.New("key not found")
+	ErrConflict = errors.New("version conflict")
+
+	// ErrTTLTooShort is returned for a ttl under a millisecond, the
+	// server's resolution, which would otherwise keep the key forever.
+	ErrTTLTooShort = errors.New("ttl under a millisecond")
+)
+
+// Op types for Batch.
+const (
+	OpGet          = kvstorev1.Op_TYPE_GET
+	OpSet          = kvstorev1.Op_TYPE_SET
+	OpDelete       = kvstorev1.Op_TYPE_DELETE
+	OpCheckVersion = kvstorev1.Op_TYPE_CHECK_VERSION
+)
+
+// Op is a single operation in a Batch. Value and TTL are only used by
+// OpSet, and Version only by OpCheckVersion where a version of 0 requires
+// the key to not exist.
+type Op struct {
+	Type    kvstorev1.Op_Type
+	Key     string
+	Value   interface{}
+	TTL     time.Duration
+	Version uint64
+}
+
+// Result is the outcome of an Op. Value is only set for an OpGet, and Err
+// only for an OpGet of a key which does not exist.
+type Result struct {
+	Type    kvstorev1.Op_Type
+	Key     string
+	Value   interface{}
+	Version uint64
+	Err     string
+}
+
+// Event is a single change to a key. Old is nil when the key did not exist
+// and New is nil when it was deleted, expired or evicted.
+type Event struct {
+	Op      string
+	Key     string
+	Old     interface{}
+	New     interface{}
+	Version uint64
+}
+
+// Option configures Dial.
+type Option func(*options)
+
+type options struct {
+	token string
+	tls   *tls.Config
+}
+
+// WithToken sends token with every call, for servers with an ACL.
+func WithToken(token string) Option {
+	return func(o *options) {
+		o.token = token
+	}
+}
+
+// WithTLS connects over TLS using config. Without it the connection is
+// plaintext.
+func WithTLS(config *tls.Config) Option {
+	return func(o *options) {
+		o.tls = config
+	}
+}
+
+// Client is safe for concurrent use.
+type Client struct {
+	conn  *grpc.ClientConn
+	kv    kvstorev1.KVServiceClient
+	token string
+}
+
+// Dial returns a Client for the server at addr. The connection is made
+// lazily, so an unreachable server is only reported by the first call.
+func Dial(addr string, opts ...Option) (*Client, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	creds := insecure.NewCredentials()
+	if o.tls != nil {
+		creds = credentials.NewTLS(o.tls)
+	}
+
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		conn:  conn,
+		kv:    kvstorev1.NewKVServiceClient(conn),
+		token: o.token,
+	}, nil
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Get returns the value and version of key, ErrNotFound if it does not
+// exist.
+func (c *Client) Get(ctx context.Context, key string) (interface{}, uint64, error) {
+	res, err := c.kv.Get(c.context(ctx), &kvstorev1.GetRequest{Key: key})
+	if err != nil {
+		return nil, 0, clientError(err)
+	}
+
+	return res.GetValue().AsInterface(), res.GetVersion(), nil
+}
+
+// Put writes every item with ttl, a ttl of 0 keeps them until deleted. ttls
+// have millisecond resolution, a ttl under a millisecond is ErrTTLTooShort.
+// Values must be JSON types: nil, bool, numbers, strings, and slices and
+// string keyed maps of those.
+func (c *Client) Put(ctx context.Context, items map[string]interface{}, ttl time.Duration) error {
+	ttlMillis, err := millis(ttl)
+	if err != nil {
+		return err
+	}
+
+	req := &kvstorev1.PutRequest{
+		Items:     make(map[string]*structpb.Value, len(items)),
+		TtlMillis: ttlMillis,
+	}
+
+	for key, item := range items {
+		value, err := structpb.NewValue(item)
+		if err != nil {
+			return fmt.Errorf("value for %q: %w", key, err)
+		}
+
+		req.Items[key] = value
+	}
+
+	_, err = c.kv.Put(c.context(ctx), req)
+
+	return clientError(err)
+}
+
+// Delete removes key, ErrNotFound if it does not exist.
+func (c *Client) Delete(ctx context.Context, key string) error {
+	_, err := c.kv.Delete(c.context(ctx), &kvstorev1.DeleteRequest{Key: key})
+
+	return clientError(err)
+}
+
+// Increment adds delta to the integer value of key, creating it as 0 if it
+// does not exist, and returns the new value and version. A negative delta
+// decrements.
+func (c *Client) Increment(ctx context.Context, key string, delta int64) (int64, uint64, error) {
+	res, err := c.kv.Increment(c.context(ctx), &kvstorev1.IncrementRequest{Key: key, Delta: delta})
+	if err != nil {
+		return 0, 0, clientError(err)
+	}
+
+	return res.GetValue(), res.GetVersion(), nil
+}
+
+// List returns up to limit keys starting with prefix which sort after
+// cursor, and the cursor for the next page which is empty on the last page.
+func (c *Client) List(ctx context.Context, prefix, cursor string, limit int) ([]string, string, error) {
+	res, err := c.kv.List(c.context(ctx), &kvstorev1.ListRequest{
+		Prefix: prefix,
+		Cursor: cursor,
+		Limit:  int32(limit),
+	})
+	if err != nil {
+		return nil, "", clientError(err)
+	}
+
+	return res.GetKeys(), res.GetNext(), nil
+}
+
+// Batch applies ops in order, all or nothing.
+func (c *Client) Batch(ctx context.Context, ops []Op) ([]Result, error) {
+	req := &kvstorev1.BatchRequest{Ops: make([]*kvstorev1.Op, len(ops))}
+	for i, op := range ops {
+		value, err := structpb.NewValue(op.Value)
+		if err != nil {
+			return nil, fmt.Errorf("value for %q: %w", op.Key, err)
+		}
+
+		ttlMillis, err := millis(op.TTL)
+		if err != nil {
+			return nil, fmt.Errorf("ttl for %q: %w", op.Key, err)
+		}
+
+		req.Ops[i] = &kvstorev1.Op{
+			Type:      op.Type,
+			Key:       op.Key,
+			Value:     value,
+			TtlMillis: ttlMillis,
+			Version:   op.Version,
+		}
+	}
+
+	res, err := c.kv.Batch(c.context(ctx), req)
+	if err != nil {
+		return nil, clientError(err)
+	}
+
+	results := make([]Result, len(res.GetResults()))
+	for i, result := range res.GetResults() {
+		results[i] = Result{
+			Type:    result.GetType(),
+			Key:     result.GetKey(),
+			Value:   result.GetValue().AsInterface(),
+			Version: result.GetVersion(),
+			Err:     result.GetErr(),
+		}
+	}
+
+	return results, nil
+}
+
+// Watcher receives the events of a Watch.
+type Watcher struct {
+	stream kvstorev1.KVService_WatchClient
+}
+
+// Watch streams changes to keys starting with prefix until ctx is
+// cancelled.
+func (c *Client) Watch(ctx context.Context, prefix string) (*Watcher, error) {
+	stream, err := c.kv.Watch(c.context(ctx), &kvstorev1.WatchRequest{Prefix: prefix})
+	if err != nil {
+		return nil, clientError(err)
+	}
+
+	return &Watcher{stream: stream}, nil
+}
+
+// Recv blocks for the next event. Once the watch ends every call returns
+// the error which ended it.
+func (w *Watcher) Recv() (Event, error) {
+	res, err := w.stream.Recv()
+	if err != nil {
+		return Event{}, clientError(err)
+	}
+
+	event := res.GetEvent()
+
+	return Event{
+		Op:      event.GetOp(),
+		Key:     event.GetKey(),
+		Old:     event.GetOld().AsInterface(),
+		New:     event.GetNew().AsInterface(),
+		Version: event.GetVersion(),
+	}, nil
+}
+
+// context adds the client's token to ctx.
+func (c *Client) context(ctx context.Context) context.Context {
+	if c.token == "" {
+		return ctx
+	}
+
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+c.token)
+}
+
+// clientError wraps the gRPC errors callers are likely to handle in
+// ErrNotFound and ErrConflict, keeping the server's message.
+func clientError(err error) error {
+	switch status.Code(err) {
+	case codes.NotFound:
+		return fmt.Errorf("%w: %s", ErrNotFound, status.Convert(err).Message())
+	case codes.Aborted:
+		return fmt.Errorf("%w: %s", ErrConflict, status.Convert(err).Message())
+	default:
+		return err
+	}
+}
+
+// millis converts ttl to the milliseconds sent to the server.
+func millis(ttl time.Duration) (int64, error) {
+	if ttl > 0 && ttl < time.Millisecond {
+		return 0, fmt.Errorf("%w: %v", ErrTTLTooShort, ttl)
+	}
+
+	return int64(ttl / time.Millisecond), nil
+}
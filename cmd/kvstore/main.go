@@ -1,51 +1,365 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"log"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
+	"task1/internal/auth"
+	"task1/internal/config"
 	"task1/internal/logger"
 	"task1/internal/metrics"
+	"task1/internal/pipeline"
 	"task1/internal/protocols"
+	"task1/internal/replication"
 	"task1/internal/store"
+	"time"
 )
 
+// version is reported by the admin API, set it at build time with
+// -ldflags "-X main.version=v1.2.3".
+var version = "dev"
+
 func main() {
-	logger := logger.NewLogger()
-	metrics := metrics.NewMetrics(logger)
-	storage := store.NewStorage(logger)
+	cfg, err := config.Load(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+
+	if err != nil {
+		log.Fatalf("config error: %v", err)
+	}
+
+	logger, logFile, err := newLogger(cfg.Log)
+	if err != nil {
+		log.Fatalf("log error: %v", err)
+	}
+
+	metrics := metrics.NewMetrics(logger.Component("metrics"))
+
+	// config has validated the policy
+	overflow, _ := pipeline.ParsePolicy(cfg.Log.Overflow)
+	metrics.SetOverflow(cfg.Log.Buffer, overflow)
+
+	// replication needs the single lock engines, config rejects it for sharded
+	var storage store.Store
+	var replicated *store.Storage
+
+	switch cfg.Engine {
+	case config.EngineSharded:
+		storage = store.NewShardedStorage(logger.Component("store"), store.DefaultShards)
+		log.Printf("sharded engine keeps keys in memory only, nothing is written to %s", cfg.DataDir)
+	case config.EngineDisk:
+		replicated = store.NewDiskStorage(logger.Component("store"), cfg.DataDir)
+		storage = replicated
+	default:
+		replicated = store.NewDurableStorage(logger.Component("store"), cfg.DataDir)
+		storage = replicated
+	}
+
+	if err := storage.SetLimits(cfg.Limits); err != nil {
+		log.Fatalf("config error: %v", err)
+	}
+
+	metrics.RegisterGauge("store_keys", "Keys in the store.", func() float64 {
+		keys, _ := storage.Stats()
+		return float64(keys)
+	})
+	metrics.RegisterGauge("store_bytes", "Approximate size of the store keys and values in bytes.", func() float64 {
+		_, bytes := storage.Stats()
+		return float64(bytes)
+	})
+
+	metrics.RegisterCounter("store_evictions_total", "Keys evicted to keep the store within its limits.", func() float64 {
+		return float64(storage.Evictions())
+	})
 
-	udp := *protocols.NewUDP(logger, storage, metrics)
-	http := *protocols.NewHTTP(logger, storage, metrics)
-	tcp := *protocols.NewTCP(logger, storage, metrics)
+	metrics.RegisterCounter("log_dropped_total", "Log entries dropped because the log queue was full.", func() float64 {
+		return float64(logger.Dropped())
+	})
+	metrics.RegisterCounter("metrics_dropped_total", "Request counts dropped because the metrics queue was full.", func() float64 {
+		return float64(metrics.Dropped())
+	})
 
 	starts := []func(){
 		logger.Start,
-		udp.Start,
-		http.Start,
-		tcp.Start,
-		metrics.Start,
+		storage.Start,
+	}
+
+	stops := []func(){}
+
+	// listeners shut down first, together, draining in-flight requests
+	shutdowns := []func(context.Context) (drained, aborted int){}
+
+	var acl *auth.ACL
+	if cfg.ACLFile != "" {
+		acl, err = auth.Load(cfg.ACLFile)
+		if err != nil {
+			log.Fatalf("acl error: %v", err)
+		}
+	}
+
+	var certs *protocols.TLS
+	if cfg.TLS.CertFile != "" {
+		certs, err = protocols.NewTLS(cfg.TLS.CertFile, cfg.TLS.KeyFile, cfg.TLS.ClientCAFile)
+		if err != nil {
+			log.Fatalf("tls error: %v", err)
+		}
+
+		// SIGHUP reloads the certificate, open connections keep the old one
+		reload := make(chan os.Signal, 1)
+		signal.Notify(reload, syscall.SIGHUP)
+
+		go func() {
+			for range reload {
+				if err := certs.Reload(); err != nil {
+					log.Printf("tls reload error: %v", err)
+				}
+			}
+		}()
+	}
+
+	// the admin API switches every listener between modes
+	mode := protocols.NewMode()
+
+	admin := protocols.NewAdmin(cfg.Admin.Addr, logger.Component("admin"), storage, mode, protocols.ReadBuildInfo(version))
+	admin.SetACL(acl)
+
+	if cfg.Admin.Enabled {
+		if certs != nil {
+			admin.SetTLS(certs)
+		}
+		starts = append(starts, admin.Start)
+
+		// kept up until the listeners have drained
+		stops = append(stops, admin.Stop)
+	}
+
+	if cfg.UDP.Enabled {
+		udp := *protocols.NewUDP(cfg.UDP.Addr, logger.Component("udp"), storage, metrics)
+		udp.SetACL(acl)
+		udp.SetMode(mode)
+		starts = append(starts, udp.Start)
+		shutdowns = append(shutdowns, udp.Shutdown)
+		admin.AddListener(udp)
+	}
+
+	if cfg.HTTP.Enabled {
+		http := *protocols.NewHTTP(cfg.HTTP.Addr, logger.Component("http"), storage, metrics)
+		http.SetACL(acl)
+		http.SetMode(mode)
+		if !cfg.Admin.Enabled {
+			http.SetAdmin(admin)
+		}
+		if certs != nil {
+			http.SetTLS(certs)
+		}
+		starts = append(starts, http.Start)
+		shutdowns = append(shutdowns, http.Shutdown)
+		admin.AddListener(http)
+	}
+
+	if cfg.TCP.Enabled {
+		tcp := *protocols.NewTCP(cfg.TCP.Addr, logger.Component("tcp"), storage, metrics)
+		tcp.SetACL(acl)
+		tcp.SetMode(mode)
+		if certs != nil {
+			tcp.SetTLS(certs)
+		}
+		starts = append(starts, tcp.Start)
+		shutdowns = append(shutdowns, tcp.Shutdown)
+		admin.AddListener(tcp)
+	}
+
+	if cfg.Redis.Enabled {
+		redis := *protocols.NewRedis(cfg.Redis.Addr, logger.Component("redis"), storage, metrics)
+		redis.SetACL(acl)
+		redis.SetMode(mode)
+		if certs != nil {
+			redis.SetTLS(certs)
+		}
+		starts = append(starts, redis.Start)
+		shutdowns = append(shutdowns, redis.Shutdown)
+		admin.AddListener(redis)
+	}
+
+	if cfg.Memcached.Enabled {
+		memcached := *protocols.NewMemcached(cfg.Memcached.Addr, logger.Component("memcached"), storage, metrics)
+		memcached.SetACL(acl)
+		memcached.SetMode(mode)
+		if certs != nil {
+			memcached.SetTLS(certs)
+		}
+		starts = append(starts, memcached.Start)
+		shutdowns = append(shutdowns, memcached.Shutdown)
+		admin.AddListener(memcached)
+	}
+
+	if cfg.GRPC.Enabled {
+		grpc := *protocols.NewGRPC(cfg.GRPC.Addr, logger.Component("grpc"), storage, metrics)
+		grpc.SetACL(acl)
+		grpc.SetMode(mode)
+		if certs != nil {
+			grpc.SetTLS(certs)
+		}
+		starts = append(starts, grpc.Start)
+		shutdowns = append(shutdowns, grpc.Shutdown)
+		admin.AddListener(grpc)
+	}
+
+	if cfg.Replication.LeaderAddr != "" {
+		var fwd store.Forwarder
+		if cfg.Replication.ForwardAddr != "" {
+			forwarder := replication.NewForwarder(cfg.Replication.ForwardAddr)
+			forwarder.SetToken(cfg.Replication.ForwardToken)
+			if certs != nil {
+				forwarder.SetTLS(certs.ClientConfig())
+			}
+			fwd = forwarder
+		}
+		replicated.SetReplica(fwd)
+
+		follower := replication.NewFollower(cfg.Replication.LeaderAddr, logger.Component("replication"), replicated)
+		follower.SetToken(cfg.Replication.Token)
+		if certs != nil {
+			follower.SetTLS(certs.ClientConfig())
+		}
+		starts = append(starts, follower.Start)
+		stops = append(stops, follower.Stop)
+
+		metrics.RegisterGauge("replication_lag_entries", "Writes made on the leader not yet applied on this replica.", func() float64 {
+			return float64(follower.LagEntries())
+		})
+		metrics.RegisterGauge("replication_last_contact_seconds", "Seconds since this replica last heard from the leader.", func() float64 {
+			return follower.LastContact().Seconds()
+		})
 	}
 
-	stops := []func(){
-		udp.Stop,
-		http.Stop,
-		tcp.Stop,
+	if cfg.Replication.Addr != "" {
+		leader := replication.NewLeader(cfg.Replication.Addr, logger.Component("replication"), replicated)
+		leader.SetACL(acl)
+		if certs != nil {
+			leader.SetTLS(certs.Config())
+		}
+		starts = append(starts, leader.Start)
+		stops = append(stops, leader.Stop)
+
+		metrics.RegisterGauge("replication_followers", "Followers connected to this leader.", func() float64 {
+			return float64(leader.Followers())
+		})
+	}
+
+	starts = append(starts, metrics.Start)
+	stops = append(stops,
 		metrics.Stop,
+		storage.Stop,
 		logger.Stop,
+	)
+
+	if logFile != nil {
+		stops = append(stops, func() { logFile.Close() })
 	}
 
 	wait := make(chan os.Signal, 1)
-	signal.Notify(wait, syscall.SIGINT)
+	signal.Notify(wait, syscall.SIGINT, syscall.SIGTERM)
 
 	run(starts)
 
-	<-wait
-	defer close(wait)
+	sig := <-wait
+
+	// config has validated the timeout
+	timeout, _ := time.ParseDuration(cfg.ShutdownTimeout)
+	log.Printf("%v received, shutting down within %s", sig, timeout)
 
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// a second signal stops waiting for in-flight requests
+	go func() {
+		<-wait
+		cancel()
+	}()
+
+	drained, aborted := shutdown(ctx, shutdowns)
+	log.Printf("listeners shutdown ok, %d drained, %d aborted", drained, aborted)
+
+	// then persistence is flushed and queued log entries written
 	run(stops)
 }
 
+// newLogger builds the logger from cfg, which has been validated. The log
+// file, if there is one, is returned so it can be closed after the logger
+// stops.
+func newLogger(cfg config.Log) (*logger.Logger, *logger.RotatingFile, error) {
+	l := logger.NewLogger()
+
+	level, _ := logger.ParseLevel(cfg.Level)
+	l.SetLevel(level)
+
+	for component, name := range cfg.Components {
+		level, _ := logger.ParseLevel(name)
+		l.SetComponentLevel(component, level)
+	}
+
+	if err := l.SetFormat(cfg.Format); err != nil {
+		return nil, nil, err
+	}
+
+	overflow, err := pipeline.ParsePolicy(cfg.Overflow)
+	if err != nil {
+		return nil, nil, err
+	}
+	l.SetOverflow(cfg.Buffer, overflow)
+
+	if cfg.Stderr {
+		l.AddSink(os.Stderr)
+	}
+
+	if cfg.File == "" {
+		return l, nil, nil
+	}
+
+	file, err := logger.NewRotatingFile(cfg.File, cfg.MaxBytes, cfg.MaxBackups)
+	if err != nil {
+		return nil, nil, err
+	}
+	l.AddSink(file)
+
+	return l, file, nil
+}
+
+// shutdown runs every listener's Shutdown at once, so they share the
+// deadline in ctx, and totals what they drained and aborted.
+func shutdown(ctx context.Context, fn []func(context.Context) (int, int)) (drained, aborted int) {
+	var (
+		wg    sync.WaitGroup
+		mutex sync.Mutex
+	)
+
+	for _, f := range fn {
+		wg.Add(1)
+
+		go func(f func(context.Context) (int, int)) {
+			defer wg.Done()
+
+			d, a := f(ctx)
+
+			mutex.Lock()
+			drained += d
+			aborted += a
+			mutex.Unlock()
+		}(f)
+	}
+
+	wg.Wait()
+
+	return drained, aborted
+}
+
 func run(fn []func()) {
 	for _, f := range fn {
 		f()
@@ -23,10 +23,15 @@ type jsonResponse struct {
 }
 
 func main() {
-	// server connection check
+	// server connection, kept open for every request
+	var (
+		conn net.Conn
+		err  error
+	)
+
 	svrConnLimit := 0
 	for {
-		conn, err := net.Dial("tcp", ":8181")
+		conn, err = net.Dial("tcp", ":8181")
 		if err != nil {
 			fmt.Println("no sever connection...")
 			fmt.Printf("trying again in 3 seconds...\n\n")
@@ -40,10 +45,14 @@ func main() {
 			continue
 		}
 
-		conn.Close()
 		break
 	}
 
+	defer conn.Close()
+
+	encoder := json.NewEncoder(conn)
+	responses := bufio.NewReader(conn)
+
 	// option loop
 	for {
 		var request jsonRequest
@@ -103,31 +112,25 @@ func main() {
 			}
 		case "q":
 			fmt.Printf("quitting\n")
-			os.Exit(0)
+			return
 		default:
 			fmt.Printf("\n\ninvalid option\n\n")
 			continue
 		}
 
-		// server connection
-		conn, err := net.Dial("tcp", ":8181")
-		if err != nil {
+		if err := encoder.Encode(request); err != nil {
 			panic(err)
 		}
 
-		json.NewEncoder(conn).Encode(request)
-
-		buf := make([]byte, 1024)
-		n, err := conn.Read(buf)
+		line, err := responses.ReadBytes('\n')
 		if err != nil {
 			panic(err)
 		}
 
 		var response jsonResponse
 
-		json.Unmarshal(buf[:n], &response)
+		json.Unmarshal(line, &response)
 
-		conn.Close()
 		fmt.Println("\n\nresponse:")
 		fmt.Printf("%+v\n\n:", response)
 	}
@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Permissions granted on a key prefix.
+const (
+	Read   = "read"
+	Write  = "write"
+	Delete = "delete"
+
+	// Admin allows the admin API rather than any keys, it is only checked
+	// against a grant on the empty Prefix
+	Admin = "admin"
+)
+
+var (
+	ErrUnauthorized = errors.New("missing or unknown token")
+	ErrForbidden    = errors.New("token not permitted")
+	ErrACLInvalid   = errors.New("invalid acl")
+)
+
+// Grant gives Permissions on every key starting with Prefix. An empty
+// Prefix covers every key.
+type Grant struct {
+	Prefix      string   `json:"Prefix"`
+	Permissions []string `json:"Permissions"`
+}
+
+// Token is an API token along with what it may do. Name is only used in
+// logs so the token itself is never written out.
+type Token struct {
+	Name   string  `json:"Name"`
+	Token  string  `json:"Token"`
+	Grants []Grant `json:"Grants"`
+}
+
+// ACL authorizes requests by token. A nil ACL allows every request, so
+// authentication stays off unless an ACL is configured.
+type ACL struct {
+	// keyed by token hash so lookups do not leak the token through timing
+	tokens map[[sha256.Size]byte]Token
+}
+
+// NewACL returns an ACL allowing each of tokens what it is granted.
+func NewACL(tokens []Token) (*ACL, error) {
+	acl := &ACL{
+		tokens: make(map[[sha256.Size]byte]Token, len(tokens)),
+	}
+
+	for i, token := range tokens {
+		if token.Token == "" {
+			return nil, fmt.Errorf("%w: token %d is empty", ErrACLInvalid, i)
+		}
+
+		for _, grant := range token.Grants {
+			for _, permission := range grant.Permissions {
+				switch permission {
+				case Read, Write, Delete, Admin:
+				default:
+					return nil, fmt.Errorf("%w: token %q: unknown permission %q", ErrACLInvalid, token.Name, permission)
+				}
+			}
+		}
+
+		hash := sha256.Sum256([]byte(token.Token))
+		if _, ok := acl.tokens[hash]; ok {
+			return nil, fmt.Errorf("%w: token %q is a duplicate", ErrACLInvalid, token.Name)
+		}
+
+		acl.tokens[hash] = token
+	}
+
+	return acl, nil
+}
+
+// Load reads an ACL from a JSON file holding a list of Tokens:
+//
+//	{"Tokens": [{"Name": "app", "Token": "...", "Grants": [{"Prefix": "app/", "Permissions": ["read", "write"]}]}]}
+func Load(path string) (*ACL, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrACLInvalid, err)
+	}
+
+	var file struct {
+		Tokens []Token `json:"Tokens"`
+	}
+
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrACLInvalid, path, err)
+	}
+
+	return NewACL(file.Tokens)
+}
+
+// Authenticate returns ErrUnauthorized unless token is known.
+func (a *ACL) Authenticate(token string) error {
+	_, err := a.lookup(token)
+
+	return err
+}
+
+// Authorize returns ErrUnauthorized for an unknown token and ErrForbidden
+// unless the token is granted permission on key.
+func (a *ACL) Authorize(token, permission, key string) error {
+	return a.authorize(token, permission, func(grant Grant) bool {
+		return strings.HasPrefix(key, grant.Prefix)
+	})
+}
+
+// AuthorizePrefix is Authorize for every key starting with prefix, as needed
+// to list or watch them. The token needs a single grant covering all of
+// them.
+func (a *ACL) AuthorizePrefix(token, permission, prefix string) error {
+	return a.authorize(token, permission, func(grant Grant) bool {
+		return strings.HasPrefix(prefix, grant.Prefix)
+	})
+}
+
+func (a *ACL) authorize(token, permission string, covers func(Grant) bool) error {
+	t, err := a.lookup(token)
+	if err != nil || a == nil {
+		return err
+	}
+
+	for _, grant := range t.Grants {
+		if !covers(grant) {
+			continue
+		}
+
+		for _, p := range grant.Permissions {
+			if p == permission {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("%w: %s %s", ErrForbidden, t.Name, permission)
+}
+
+func (a *ACL) lookup(token string) (Token, error) {
+	if a == nil {
+		return Token{}, nil
+	}
+
+	if token == "" {
+		return Token{}, ErrUnauthorized
+	}
+
+	t, ok := a.tokens[sha256.Sum256([]byte(token))]
+	if !ok {
+		return Token{}, ErrUnauthorized
+	}
+
+	return t, nil
+}
@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestACL_Authorize(t *testing.T) {
+	acl, err := NewACL([]Token{
+		{
+			Name:  "admin",
+			Token: "admin-token",
+			Grants: []Grant{
+				{Prefix: "", Permissions: []string{Read, Write, Delete}},
+			},
+		},
+		{
+			Name:  "app",
+			Token: "app-token",
+			Grants: []Grant{
+				{Prefix: "app/", Permissions: []string{Read, Write}},
+				{Prefix: "shared/", Permissions: []string{Read}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewACL() error = %v", err)
+	}
+
+	type args struct {
+		token      string
+		permission string
+		key        string
+	}
+	tests := []struct {
+		name    string
+		acl     *ACL
+		args    args
+		wantErr error
+	}{
+		{
+			name: "ok - admin delete",
+			acl:  acl,
+			args: args{token: "admin-token", permission: Delete, key: "app/1"},
+		},
+		{
+			name: "ok - app write in prefix",
+			acl:  acl,
+			args: args{token: "app-token", permission: Write, key: "app/1"},
+		},
+		{
+			name: "ok - app read shared",
+			acl:  acl,
+			args: args{token: "app-token", permission: Read, key: "shared/1"},
+		},
+		{
+			name: "ok - no acl",
+			args: args{permission: Delete, key: "app/1"},
+		},
+		{
+			name:    "fail - app write shared",
+			acl:     acl,
+			args:    args{token: "app-token", permission: Write, key: "shared/1"},
+			wantErr: ErrForbidden,
+		},
+		{
+			name:    "fail - app delete in prefix",
+			acl:     acl,
+			args:    args{token: "app-token", permission: Delete, key: "app/1"},
+			wantErr: ErrForbidden,
+		},
+		{
+			name:    "fail - missing token",
+			acl:     acl,
+			args:    args{permission: Read, key: "app/1"},
+			wantErr: ErrUnauthorized,
+		},
+		{
+			name:    "fail - unknown token",
+			acl:     acl,
+			args:    args{token: "nope", permission: Read, key: "app/1"},
+			wantErr: ErrUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.acl.Authorize(tt.args.token, tt.args.permission, tt.args.key)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ACL.Authorize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestACL_AuthorizePrefix(t *testing.T) {
+	acl, _ := NewACL([]Token{
+		{
+			Name:   "app",
+			Token:  "app-token",
+			Grants: []Grant{{Prefix: "app/", Permissions: []string{Read}}},
+		},
+	})
+
+	tests := []struct {
+		name    string
+		prefix  string
+		wantErr error
+	}{
+		{
+			name:   "ok - grant prefix",
+			prefix: "app/",
+		},
+		{
+			name:   "ok - narrower prefix",
+			prefix: "app/users/",
+		},
+		{
+			name:    "fail - wider prefix",
+			prefix:  "ap",
+			wantErr: ErrForbidden,
+		},
+		{
+			name:    "fail - every key",
+			prefix:  "",
+			wantErr: ErrForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := acl.AuthorizePrefix("app-token", Read, tt.prefix)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ACL.AuthorizePrefix() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		wantErr bool
+	}{
+		{
+			name: "ok",
+			file: `{"Tokens": [{"Name": "app", "Token": "t", "Grants": [{"Prefix": "app/", "Permissions": ["read"]}]}]}`,
+		},
+		{
+			name: "ok - admin",
+			file: `{"Tokens": [{"Name": "ops", "Token": "t", "Grants": [{"Prefix": "", "Permissions": ["admin"]}]}]}`,
+		},
+		{
+			name:    "fail - unknown permission",
+			file:    `{"Tokens": [{"Name": "app", "Token": "t", "Grants": [{"Prefix": "", "Permissions": ["owner"]}]}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "fail - empty token",
+			file:    `{"Tokens": [{"Name": "app", "Token": ""}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "fail - duplicate token",
+			file:    `{"Tokens": [{"Name": "a", "Token": "t"}, {"Name": "b", "Token": "t"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "fail - bad json",
+			file:    `{"Tokens":`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "acl.json")
+			os.WriteFile(path, []byte(tt.file), 0o600)
+
+			_, err := Load(path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,532 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"task1/internal/logger"
+	"task1/internal/pipeline"
+	"task1/internal/store"
+	"time"
+)
+
+const (
+	DefaultHTTPAddr      = ":8080"
+	DefaultTCPAddr       = ":8181"
+	DefaultUDPAddr       = "0.0.0.0:9001"
+	DefaultRedisAddr     = ":6379"
+	DefaultMemcachedAddr = ":11211"
+	DefaultGRPCAddr      = ":9090"
+	DefaultAdminAddr     = ":8081"
+	DefaultDataDir       = "data"
+	DefaultLogMaxBytes   = 100 << 20
+	DefaultLogMaxBackups = 5
+
+	// DefaultShutdownTimeout is how long shutdown waits for in-flight
+	// requests
+	DefaultShutdownTimeout = "10s"
+
+	envPrefix = "KVSTORE_"
+)
+
+// Storage engines, see store.Store.
+const (
+	EngineMemory  = "memory"
+	EngineSharded = "sharded"
+	EngineDisk    = "disk"
+)
+
+var ErrConfigInvalid = errors.New("invalid config")
+
+// Listener configures a single protocol server.
+type Listener struct {
+	Enabled bool   `json:"Enabled"`
+	Addr    string `json:"Addr"`
+}
+
+// Replication configures leader and replica roles. A leader serves
+// followers on Addr, a replica follows LeaderAddr and, if ForwardAddr is set,
+// forwards writes to the leader's TCP listener there instead of rejecting
+// them, sending ForwardToken if the leader has an ACL. A leader with an ACL
+// only serves followers sending a Token granted read on every key. An
+// instance can be both, to chain replicas.
+type Replication struct {
+	Addr         string `json:"Addr"`
+	LeaderAddr   string `json:"LeaderAddr"`
+	Token        string `json:"Token"`
+	ForwardAddr  string `json:"ForwardAddr"`
+	ForwardToken string `json:"ForwardToken"`
+}
+
+// TLS configures the certificate served by the HTTP, TCP, Redis, Memcached
+// and gRPC listeners. They all use TLS when CertFile is set. If ClientCAFile
+// is set too, clients must present a certificate signed by one of its CAs.
+type TLS struct {
+	CertFile     string `json:"CertFile"`
+	KeyFile      string `json:"KeyFile"`
+	ClientCAFile string `json:"ClientCAFile"`
+}
+
+// Log configures the logger. Level applies to every component without an
+// entry in Components, which maps a component (store, metrics, replication,
+// or a listener such as http) to its own level.
+type Log struct {
+	Level      string            `json:"Level"`
+	Format     string            `json:"Format"`
+	Components map[string]string `json:"Components"`
+
+	// Stderr and File are the sinks, File rotates once it reaches MaxBytes
+	// keeping MaxBackups old files
+	Stderr     bool   `json:"Stderr"`
+	File       string `json:"File"`
+	MaxBytes   int64  `json:"MaxBytes"`
+	MaxBackups int    `json:"MaxBackups"`
+
+	// Buffer is how many log entries, and request counts for the metrics
+	// log, are queued for writing. Overflow is what happens once the queue
+	// is full: drop the entry, or block the caller until there is room
+	Buffer   int    `json:"Buffer"`
+	Overflow string `json:"Overflow"`
+}
+
+// Config holds everything cmd/kvstore needs to start. Values are resolved
+// from defaults, then the config file, then environment variables, then
+// flags, with later sources taking precedence.
+type Config struct {
+	HTTP      Listener `json:"HTTP"`
+	TCP       Listener `json:"TCP"`
+	UDP       Listener `json:"UDP"`
+	Redis     Listener `json:"Redis"`
+	Memcached Listener `json:"Memcached"`
+	GRPC      Listener `json:"GRPC"`
+
+	// Admin serves /healthz, /readyz and the admin API on an address of
+	// their own. Disabled they are served by the HTTP listener.
+	Admin Listener `json:"Admin"`
+
+	DataDir string `json:"DataDir"`
+	Engine  string `json:"Engine"`
+
+	// Limits caps the store size, zero limits are not checked
+	Limits store.Limits `json:"Limits"`
+
+	Replication Replication `json:"Replication"`
+	TLS         TLS         `json:"TLS"`
+	Log         Log         `json:"Log"`
+
+	// ACLFile holds the API tokens and their grants, see auth.Load. Without
+	// one every request is allowed.
+	ACLFile string `json:"ACLFile"`
+
+	// ShutdownTimeout is a duration such as "10s". On SIGINT or SIGTERM the
+	// listeners stop accepting and wait this long for in-flight requests
+	// before closing the rest.
+	ShutdownTimeout string `json:"ShutdownTimeout"`
+}
+
+func Default() Config {
+	return Config{
+		HTTP:      Listener{Enabled: true, Addr: DefaultHTTPAddr},
+		TCP:       Listener{Enabled: true, Addr: DefaultTCPAddr},
+		UDP:       Listener{Enabled: true, Addr: DefaultUDPAddr},
+		Redis:     Listener{Enabled: false, Addr: DefaultRedisAddr},
+		Memcached: Listener{Enabled: false, Addr: DefaultMemcachedAddr},
+		GRPC:      Listener{Enabled: false, Addr: DefaultGRPCAddr},
+		Admin:     Listener{Enabled: false, Addr: DefaultAdminAddr},
+		DataDir:   DefaultDataDir,
+		Engine:    EngineMemory,
+		Limits:    store.Limits{Policy: store.EvictLRU},
+
+		ShutdownTimeout: DefaultShutdownTimeout,
+
+		Log: Log{
+			Level:      "info",
+			Format:     logger.FormatText,
+			Stderr:     true,
+			MaxBytes:   DefaultLogMaxBytes,
+			MaxBackups: DefaultLogMaxBackups,
+			Buffer:     pipeline.DefaultSize,
+			Overflow:   string(pipeline.Drop),
+		},
+	}
+}
+
+// Load builds a Config from the command line args and environment. The
+// config file is read from the -config flag or KVSTORE_CONFIG.
+func Load(args []string, getenv func(string) string) (Config, error) {
+	cfg := Default()
+
+	fs := flag.NewFlagSet("kvstore", flag.ContinueOnError)
+	path := fs.String("config", getenv(envPrefix+"CONFIG"), "path to a JSON config file")
+	flags := cfg.flags(fs)
+
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	if *path != "" {
+		if err := cfg.loadFile(*path); err != nil {
+			return cfg, err
+		}
+	}
+
+	if err := cfg.loadEnv(getenv); err != nil {
+		return cfg, err
+	}
+
+	// only flags given on the command line override the file and env
+	fs.Visit(func(f *flag.Flag) {
+		if apply, ok := flags[f.Name]; ok {
+			apply()
+		}
+	})
+
+	return cfg, cfg.validate()
+}
+
+// flags registers a flag per setting and returns a func per flag name which
+// copies the parsed value into cfg.
+func (cfg *Config) flags(fs *flag.FlagSet) map[string]func() {
+	httpAddr := fs.String("http-addr", cfg.HTTP.Addr, "http listen address")
+	tcpAddr := fs.String("tcp-addr", cfg.TCP.Addr, "tcp listen address")
+	udpAddr := fs.String("udp-addr", cfg.UDP.Addr, "udp listen address")
+	redisAddr := fs.String("redis-addr", cfg.Redis.Addr, "redis protocol listen address")
+	memcachedAddr := fs.String("memcached-addr", cfg.Memcached.Addr, "memcached protocol listen address")
+	grpcAddr := fs.String("grpc-addr", cfg.GRPC.Addr, "grpc listen address")
+	adminAddr := fs.String("admin-addr", cfg.Admin.Addr, "admin listen address")
+	httpEnabled := fs.Bool("http", cfg.HTTP.Enabled, "enable the http listener")
+	tcpEnabled := fs.Bool("tcp", cfg.TCP.Enabled, "enable the tcp listener")
+	udpEnabled := fs.Bool("udp", cfg.UDP.Enabled, "enable the udp listener")
+	redisEnabled := fs.Bool("redis", cfg.Redis.Enabled, "enable the redis protocol listener")
+	memcachedEnabled := fs.Bool("memcached", cfg.Memcached.Enabled, "enable the memcached protocol listener")
+	grpcEnabled := fs.Bool("grpc", cfg.GRPC.Enabled, "enable the grpc listener")
+	adminEnabled := fs.Bool("admin", cfg.Admin.Enabled, "serve health checks and the admin api on their own listener rather than http")
+	dataDir := fs.String("data-dir", cfg.DataDir, "directory for the write log and snapshots, unused by the sharded engine")
+	engine := fs.String("engine", cfg.Engine, "storage engine: memory, sharded or disk")
+	maxKeys := fs.Int("max-keys", cfg.Limits.MaxKeys, "evict once the store holds more keys, 0 for no limit")
+	maxBytes := fs.Int64("max-bytes", cfg.Limits.MaxBytes, "evict once keys and values take more bytes, 0 for no limit")
+	eviction := fs.String("eviction", cfg.Limits.Policy, "eviction policy: lru, lfu, random, ttl or reject")
+	replicationAddr := fs.String("replication-addr", cfg.Replication.Addr, "serve followers on this address")
+	leaderAddr := fs.String("replica-of", cfg.Replication.LeaderAddr, "follow the leader replication address")
+	replicaToken := fs.String("replica-token", cfg.Replication.Token, "token sent to the leader when following")
+	forwardAddr := fs.String("replica-forward", cfg.Replication.ForwardAddr, "forward replica writes to the leader tcp address")
+	forwardToken := fs.String("replica-forward-token", cfg.Replication.ForwardToken, "token sent with forwarded writes")
+	certFile := fs.String("tls-cert", cfg.TLS.CertFile, "serve http, tcp, redis, memcached and grpc over tls with this certificate")
+	keyFile := fs.String("tls-key", cfg.TLS.KeyFile, "key for the tls certificate")
+	clientCAFile := fs.String("tls-client-ca", cfg.TLS.ClientCAFile, "require client certificates signed by these CAs")
+	aclFile := fs.String("acl-file", cfg.ACLFile, "require API tokens from this JSON file")
+	shutdownTimeout := fs.String("shutdown-timeout", cfg.ShutdownTimeout, "wait this long for in-flight requests on shutdown")
+	logLevel := fs.String("log-level", cfg.Log.Level, "log level: debug, info, warn or error")
+	logFormat := fs.String("log-format", cfg.Log.Format, "log format: text or json")
+	logComponents := fs.String("log-components", "", "per component log levels, e.g. store=debug,http=warn")
+	logStderr := fs.Bool("log-stderr", cfg.Log.Stderr, "write logs to stderr")
+	logFile := fs.String("log-file", cfg.Log.File, "write logs to this file too")
+	logMaxBytes := fs.Int64("log-max-bytes", cfg.Log.MaxBytes, "rotate the log file once it reaches this size, 0 never rotates")
+	logMaxBackups := fs.Int("log-max-backups", cfg.Log.MaxBackups, "rotated log files to keep")
+	logBuffer := fs.Int("log-buffer", cfg.Log.Buffer, "log entries and request counts queued for writing")
+	logOverflow := fs.String("log-overflow", cfg.Log.Overflow, "when the log queue is full: drop or block")
+
+	return map[string]func(){
+		"http-addr":      func() { cfg.HTTP.Addr = *httpAddr },
+		"tcp-addr":       func() { cfg.TCP.Addr = *tcpAddr },
+		"udp-addr":       func() { cfg.UDP.Addr = *udpAddr },
+		"redis-addr":     func() { cfg.Redis.Addr = *redisAddr },
+		"memcached-addr": func() { cfg.Memcached.Addr = *memcachedAddr },
+		"grpc-addr":      func() { cfg.GRPC.Addr = *grpcAddr },
+		"admin-addr":     func() { cfg.Admin.Addr = *adminAddr },
+		"http":           func() { cfg.HTTP.Enabled = *httpEnabled },
+		"tcp":            func() { cfg.TCP.Enabled = *tcpEnabled },
+		"udp":            func() { cfg.UDP.Enabled = *udpEnabled },
+		"redis":          func() { cfg.Redis.Enabled = *redisEnabled },
+		"memcached":      func() { cfg.Memcached.Enabled = *memcachedEnabled },
+		"grpc":           func() { cfg.GRPC.Enabled = *grpcEnabled },
+		"admin":          func() { cfg.Admin.Enabled = *adminEnabled },
+		"data-dir":       func() { cfg.DataDir = *dataDir },
+		"engine":         func() { cfg.Engine = *engine },
+		"max-keys":       func() { cfg.Limits.MaxKeys = *maxKeys },
+		"max-bytes":      func() { cfg.Limits.MaxBytes = *maxBytes },
+		"eviction":       func() { cfg.Limits.Policy = *eviction },
+
+		"replication-addr": func() { cfg.Replication.Addr = *replicationAddr },
+		"replica-of":       func() { cfg.Replication.LeaderAddr = *leaderAddr },
+		"replica-forward":  func() { cfg.Replication.ForwardAddr = *forwardAddr },
+
+		"replica-token":         func() { cfg.Replication.Token = *replicaToken },
+		"replica-forward-token": func() { cfg.Replication.ForwardToken = *forwardToken },
+
+		"tls-cert":      func() { cfg.TLS.CertFile = *certFile },
+		"tls-key":       func() { cfg.TLS.KeyFile = *keyFile },
+		"tls-client-ca": func() { cfg.TLS.ClientCAFile = *clientCAFile },
+		"acl-file":      func() { cfg.ACLFile = *aclFile },
+
+		"shutdown-timeout": func() { cfg.ShutdownTimeout = *shutdownTimeout },
+
+		"log-level":       func() { cfg.Log.Level = *logLevel },
+		"log-format":      func() { cfg.Log.Format = *logFormat },
+		"log-components":  func() { cfg.Log.Components = componentLevels(*logComponents) },
+		"log-stderr":      func() { cfg.Log.Stderr = *logStderr },
+		"log-file":        func() { cfg.Log.File = *logFile },
+		"log-max-bytes":   func() { cfg.Log.MaxBytes = *logMaxBytes },
+		"log-max-backups": func() { cfg.Log.MaxBackups = *logMaxBackups },
+		"log-buffer":      func() { cfg.Log.Buffer = *logBuffer },
+		"log-overflow":    func() { cfg.Log.Overflow = *logOverflow },
+	}
+}
+
+func (cfg *Config) loadFile(path string) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrConfigInvalid, err)
+	}
+
+	// decode over the defaults so omitted fields keep their default value
+	if err := json.Unmarshal(raw, cfg); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrConfigInvalid, path, err)
+	}
+
+	return nil
+}
+
+func (cfg *Config) loadEnv(getenv func(string) string) error {
+	strs := map[string]*string{
+		"HTTP_ADDR":      &cfg.HTTP.Addr,
+		"TCP_ADDR":       &cfg.TCP.Addr,
+		"UDP_ADDR":       &cfg.UDP.Addr,
+		"REDIS_ADDR":     &cfg.Redis.Addr,
+		"MEMCACHED_ADDR": &cfg.Memcached.Addr,
+		"GRPC_ADDR":      &cfg.GRPC.Addr,
+		"ADMIN_ADDR":     &cfg.Admin.Addr,
+		"DATA_DIR":       &cfg.DataDir,
+		"ENGINE":         &cfg.Engine,
+		"EVICTION":       &cfg.Limits.Policy,
+
+		"REPLICATION_ADDR": &cfg.Replication.Addr,
+		"REPLICA_OF":       &cfg.Replication.LeaderAddr,
+		"REPLICA_FORWARD":  &cfg.Replication.ForwardAddr,
+
+		"REPLICA_TOKEN":         &cfg.Replication.Token,
+		"REPLICA_FORWARD_TOKEN": &cfg.Replication.ForwardToken,
+
+		"TLS_CERT":      &cfg.TLS.CertFile,
+		"TLS_KEY":       &cfg.TLS.KeyFile,
+		"TLS_CLIENT_CA": &cfg.TLS.ClientCAFile,
+		"ACL_FILE":      &cfg.ACLFile,
+
+		"SHUTDOWN_TIMEOUT": &cfg.ShutdownTimeout,
+
+		"LOG_LEVEL":    &cfg.Log.Level,
+		"LOG_FORMAT":   &cfg.Log.Format,
+		"LOG_FILE":     &cfg.Log.File,
+		"LOG_OVERFLOW": &cfg.Log.Overflow,
+	}
+
+	for name, field := range strs {
+		if v := getenv(envPrefix + name); v != "" {
+			*field = v
+		}
+	}
+
+	bools := map[string]*bool{
+		"HTTP_ENABLED":      &cfg.HTTP.Enabled,
+		"TCP_ENABLED":       &cfg.TCP.Enabled,
+		"UDP_ENABLED":       &cfg.UDP.Enabled,
+		"REDIS_ENABLED":     &cfg.Redis.Enabled,
+		"MEMCACHED_ENABLED": &cfg.Memcached.Enabled,
+		"GRPC_ENABLED":      &cfg.GRPC.Enabled,
+		"ADMIN_ENABLED":     &cfg.Admin.Enabled,
+		"LOG_STDERR":        &cfg.Log.Stderr,
+	}
+
+	for name, field := range bools {
+		v := getenv(envPrefix + name)
+		if v == "" {
+			continue
+		}
+
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("%w: %s%s: %v", ErrConfigInvalid, envPrefix, name, err)
+		}
+
+		*field = enabled
+	}
+
+	if v := getenv(envPrefix + "MAX_KEYS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("%w: %sMAX_KEYS: %v", ErrConfigInvalid, envPrefix, err)
+		}
+
+		cfg.Limits.MaxKeys = n
+	}
+
+	if v := getenv(envPrefix + "MAX_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: %sMAX_BYTES: %v", ErrConfigInvalid, envPrefix, err)
+		}
+
+		cfg.Limits.MaxBytes = n
+	}
+
+	if v := getenv(envPrefix + "LOG_COMPONENTS"); v != "" {
+		cfg.Log.Components = componentLevels(v)
+	}
+
+	if v := getenv(envPrefix + "LOG_MAX_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: %sLOG_MAX_BYTES: %v", ErrConfigInvalid, envPrefix, err)
+		}
+
+		cfg.Log.MaxBytes = n
+	}
+
+	if v := getenv(envPrefix + "LOG_MAX_BACKUPS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("%w: %sLOG_MAX_BACKUPS: %v", ErrConfigInvalid, envPrefix, err)
+		}
+
+		cfg.Log.MaxBackups = n
+	}
+
+	if v := getenv(envPrefix + "LOG_BUFFER"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("%w: %sLOG_BUFFER: %v", ErrConfigInvalid, envPrefix, err)
+		}
+
+		cfg.Log.Buffer = n
+	}
+
+	return nil
+}
+
+// componentLevels splits a component=level list without checking the
+// levels, validate does that for every source.
+func componentLevels(list string) map[string]string {
+	levels := make(map[string]string)
+
+	for _, pair := range strings.Split(list, ",") {
+		component, level, _ := strings.Cut(strings.TrimSpace(pair), "=")
+		if component != "" {
+			levels[component] = level
+		}
+	}
+
+	return levels
+}
+
+func (cfg Config) validate() error {
+	listeners := map[string]Listener{
+		"http":      cfg.HTTP,
+		"tcp":       cfg.TCP,
+		"udp":       cfg.UDP,
+		"redis":     cfg.Redis,
+		"memcached": cfg.Memcached,
+		"grpc":      cfg.GRPC,
+		"admin":     cfg.Admin,
+	}
+
+	for name, l := range listeners {
+		if l.Enabled && l.Addr == "" {
+			return fmt.Errorf("%w: %s listener enabled without an address", ErrConfigInvalid, name)
+		}
+	}
+
+	if cfg.DataDir == "" {
+		return fmt.Errorf("%w: data dir cannot be empty", ErrConfigInvalid)
+	}
+
+	if cfg.Replication.ForwardAddr != "" && cfg.Replication.LeaderAddr == "" {
+		return fmt.Errorf("%w: replica forward address needs a leader", ErrConfigInvalid)
+	}
+
+	if cfg.Replication.Token != "" && cfg.Replication.LeaderAddr == "" {
+		return fmt.Errorf("%w: replica token needs a leader", ErrConfigInvalid)
+	}
+
+	if cfg.Replication.ForwardToken != "" && cfg.Replication.ForwardAddr == "" {
+		return fmt.Errorf("%w: replica forward token needs a forward address", ErrConfigInvalid)
+	}
+
+	if (cfg.TLS.CertFile == "") != (cfg.TLS.KeyFile == "") {
+		return fmt.Errorf("%w: tls needs both a certificate and a key", ErrConfigInvalid)
+	}
+
+	if cfg.TLS.ClientCAFile != "" && cfg.TLS.CertFile == "" {
+		return fmt.Errorf("%w: tls client CAs need a certificate", ErrConfigInvalid)
+	}
+
+	if err := cfg.Log.validate(); err != nil {
+		return err
+	}
+
+	if d, err := time.ParseDuration(cfg.ShutdownTimeout); err != nil || d < 0 {
+		return fmt.Errorf("%w: shutdown timeout %q is not a duration", ErrConfigInvalid, cfg.ShutdownTimeout)
+	}
+
+	switch cfg.Engine {
+	case EngineMemory, EngineDisk:
+	case EngineSharded:
+		replicated := cfg.Replication.Addr != "" || cfg.Replication.LeaderAddr != ""
+		if replicated {
+			return fmt.Errorf("%w: the sharded engine does not support replication", ErrConfigInvalid)
+		}
+	default:
+		return fmt.Errorf("%w: unknown engine %q", ErrConfigInvalid, cfg.Engine)
+	}
+
+	switch cfg.Limits.Policy {
+	case store.EvictLRU, store.EvictLFU, store.EvictRandom, store.EvictTTL, store.EvictReject:
+	default:
+		return fmt.Errorf("%w: unknown eviction policy %q", ErrConfigInvalid, cfg.Limits.Policy)
+	}
+
+	if cfg.Limits.MaxKeys < 0 || cfg.Limits.MaxBytes < 0 {
+		return fmt.Errorf("%w: limits cannot be negative", ErrConfigInvalid)
+	}
+
+	return nil
+}
+
+func (l Log) validate() error {
+	if _, err := logger.ParseLevel(l.Level); err != nil {
+		return fmt.Errorf("%w: %v", ErrConfigInvalid, err)
+	}
+
+	for component, level := range l.Components {
+		if _, err := logger.ParseLevel(level); err != nil {
+			return fmt.Errorf("%w: log component %s: %v", ErrConfigInvalid, component, err)
+		}
+	}
+
+	switch l.Format {
+	case logger.FormatText, logger.FormatJSON:
+	default:
+		return fmt.Errorf("%w: unknown log format %q", ErrConfigInvalid, l.Format)
+	}
+
+	if !l.Stderr && l.File == "" {
+		return fmt.Errorf("%w: logs need stderr or a file", ErrConfigInvalid)
+	}
+
+	if l.MaxBytes < 0 || l.MaxBackups < 0 {
+		return fmt.Errorf("%w: log rotation limits cannot be negative", ErrConfigInvalid)
+	}
+
+	if l.Buffer < 1 {
+		return fmt.Errorf("%w: log buffer must be at least 1", ErrConfigInvalid)
+	}
+
+	if _, err := pipeline.ParsePolicy(l.Overflow); err != nil {
+		return fmt.Errorf("%w: %v", ErrConfigInvalid, err)
+	}
+
+	return nil
+}
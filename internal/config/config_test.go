@@ -0,0 +1,412 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"task1/internal/store"
+	"testing"
+)
+
+func TestConfig_Load(t *testing.T) {
+	type args struct {
+		args []string
+		env  map[string]string
+		file string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    func(cfg *Config)
+		wantErr bool
+	}{
+		{
+			name: "defaults - ok",
+			want: func(cfg *Config) {},
+		},
+		{
+			name: "file - ok",
+			args: args{
+				file: `{"HTTP":{"Addr":":9080"},"UDP":{"Enabled":false}}`,
+			},
+			want: func(cfg *Config) {
+				cfg.HTTP.Addr = ":9080"
+				cfg.UDP.Enabled = false
+			},
+		},
+		{
+			name: "env overrides file - ok",
+			args: args{
+				file: `{"HTTP":{"Addr":":9080"}}`,
+				env: map[string]string{
+					"KVSTORE_HTTP_ADDR":   ":9180",
+					"KVSTORE_TCP_ENABLED": "false",
+					"KVSTORE_DATA_DIR":    "/tmp/kv",
+				},
+			},
+			want: func(cfg *Config) {
+				cfg.HTTP.Addr = ":9180"
+				cfg.TCP.Enabled = false
+				cfg.DataDir = "/tmp/kv"
+			},
+		},
+		{
+			name: "flags override env - ok",
+			args: args{
+				args: []string{"-http-addr", ":9280", "-udp=false"},
+				env: map[string]string{
+					"KVSTORE_HTTP_ADDR": ":9180",
+				},
+			},
+			want: func(cfg *Config) {
+				cfg.HTTP.Addr = ":9280"
+				cfg.UDP.Enabled = false
+			},
+		},
+		{
+			name: "unset flags keep env - ok",
+			args: args{
+				args: []string{"-tcp=false"},
+				env: map[string]string{
+					"KVSTORE_UDP_ADDR": ":9301",
+				},
+			},
+			want: func(cfg *Config) {
+				cfg.TCP.Enabled = false
+				cfg.UDP.Addr = ":9301"
+			},
+		},
+		{
+			name: "redis - ok",
+			args: args{
+				args: []string{"-redis"},
+				env: map[string]string{
+					"KVSTORE_REDIS_ADDR": ":6380",
+				},
+			},
+			want: func(cfg *Config) {
+				cfg.Redis = Listener{Enabled: true, Addr: ":6380"}
+			},
+		},
+		{
+			name: "memcached - ok",
+			args: args{
+				args: []string{"-memcached-addr", ":11311"},
+				env: map[string]string{
+					"KVSTORE_MEMCACHED_ENABLED": "true",
+				},
+			},
+			want: func(cfg *Config) {
+				cfg.Memcached = Listener{Enabled: true, Addr: ":11311"}
+			},
+		},
+		{
+			name: "grpc - ok",
+			args: args{
+				args: []string{"-grpc"},
+				env: map[string]string{
+					"KVSTORE_GRPC_ADDR": ":9091",
+				},
+			},
+			want: func(cfg *Config) {
+				cfg.GRPC = Listener{Enabled: true, Addr: ":9091"}
+			},
+		},
+		{
+			name: "admin - ok",
+			args: args{
+				args: []string{"-admin-addr", "127.0.0.1:8082"},
+				env: map[string]string{
+					"KVSTORE_ADMIN_ENABLED": "true",
+				},
+			},
+			want: func(cfg *Config) {
+				cfg.Admin = Listener{Enabled: true, Addr: "127.0.0.1:8082"}
+			},
+		},
+		{
+			name: "log - ok",
+			args: args{
+				args: []string{"-log-level", "warn", "-log-components", "store=debug, http=error", "-log-stderr=false"},
+				env: map[string]string{
+					"KVSTORE_LOG_FORMAT":      "json",
+					"KVSTORE_LOG_FILE":        "/tmp/kv.log",
+					"KVSTORE_LOG_MAX_BACKUPS": "2",
+				},
+			},
+			want: func(cfg *Config) {
+				cfg.Log.Level = "warn"
+				cfg.Log.Format = "json"
+				cfg.Log.Components = map[string]string{"store": "debug", "http": "error"}
+				cfg.Log.Stderr = false
+				cfg.Log.File = "/tmp/kv.log"
+				cfg.Log.MaxBackups = 2
+			},
+		},
+		{
+			name: "fail - unknown log level",
+			args: args{
+				args: []string{"-log-level", "loud"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "fail - unknown component log level",
+			args: args{
+				env: map[string]string{
+					"KVSTORE_LOG_COMPONENTS": "store",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "shutdown timeout - ok",
+			args: args{
+				env: map[string]string{
+					"KVSTORE_SHUTDOWN_TIMEOUT": "30s",
+				},
+			},
+			want: func(cfg *Config) {
+				cfg.ShutdownTimeout = "30s"
+			},
+		},
+		{
+			name: "fail - shutdown timeout not a duration",
+			args: args{
+				args: []string{"-shutdown-timeout", "10"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "log overflow - ok",
+			args: args{
+				args: []string{"-log-overflow", "block"},
+				env: map[string]string{
+					"KVSTORE_LOG_BUFFER": "128",
+				},
+			},
+			want: func(cfg *Config) {
+				cfg.Log.Buffer = 128
+				cfg.Log.Overflow = "block"
+			},
+		},
+		{
+			name: "fail - unknown log overflow",
+			args: args{
+				args: []string{"-log-overflow", "wait"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "fail - no log sink",
+			args: args{
+				args: []string{"-log-stderr=false"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "fail - redis enabled without address",
+			args: args{
+				args: []string{"-redis", "-redis-addr", ""},
+			},
+			wantErr: true,
+		},
+		{
+			name: "replica - ok",
+			args: args{
+				args: []string{"-replica-of", "leader:7000", "-replica-forward", "leader:8181"},
+				env: map[string]string{
+					"KVSTORE_REPLICATION_ADDR": ":7000",
+					"KVSTORE_REPLICA_TOKEN":    "replica",
+				},
+			},
+			want: func(cfg *Config) {
+				cfg.Replication = Replication{
+					Addr:        ":7000",
+					LeaderAddr:  "leader:7000",
+					Token:       "replica",
+					ForwardAddr: "leader:8181",
+				}
+			},
+		},
+		{
+			name: "engine - ok",
+			args: args{
+				args: []string{"-engine", "disk"},
+				env: map[string]string{
+					"KVSTORE_ENGINE": "sharded",
+				},
+			},
+			want: func(cfg *Config) {
+				cfg.Engine = EngineDisk
+			},
+		},
+		{
+			name: "limits - ok",
+			args: args{
+				args: []string{"-eviction", "lfu"},
+				env: map[string]string{
+					"KVSTORE_MAX_KEYS":  "1000",
+					"KVSTORE_MAX_BYTES": "1048576",
+					"KVSTORE_EVICTION":  "ttl",
+				},
+			},
+			want: func(cfg *Config) {
+				cfg.Limits = store.Limits{MaxKeys: 1000, MaxBytes: 1 << 20, Policy: store.EvictLFU}
+			},
+		},
+		{
+			name: "fail - unknown eviction policy",
+			args: args{
+				args: []string{"-eviction", "oldest"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "fail - bad env int",
+			args: args{
+				env: map[string]string{"KVSTORE_MAX_KEYS": "lots"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "tls - ok",
+			args: args{
+				args: []string{"-tls-cert", "cert.pem", "-tls-key", "key.pem"},
+				env: map[string]string{
+					"KVSTORE_TLS_CLIENT_CA": "ca.pem",
+				},
+			},
+			want: func(cfg *Config) {
+				cfg.TLS = TLS{CertFile: "cert.pem", KeyFile: "key.pem", ClientCAFile: "ca.pem"}
+			},
+		},
+		{
+			name: "fail - tls cert without key",
+			args: args{
+				args: []string{"-tls-cert", "cert.pem"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "fail - tls client ca without cert",
+			args: args{
+				env: map[string]string{"KVSTORE_TLS_CLIENT_CA": "ca.pem"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "acl - ok",
+			args: args{
+				args: []string{"-replica-of", "leader:7000", "-replica-forward", "leader:8181"},
+				env: map[string]string{
+					"KVSTORE_ACL_FILE":              "acl.json",
+					"KVSTORE_REPLICA_FORWARD_TOKEN": "secret",
+				},
+			},
+			want: func(cfg *Config) {
+				cfg.ACLFile = "acl.json"
+				cfg.Replication = Replication{
+					LeaderAddr:   "leader:7000",
+					ForwardAddr:  "leader:8181",
+					ForwardToken: "secret",
+				}
+			},
+		},
+		{
+			name: "fail - replica token without leader",
+			args: args{
+				args: []string{"-replica-token", "secret"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "fail - forward token without forward",
+			args: args{
+				args: []string{"-replica-forward-token", "secret"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "fail - unknown engine",
+			args: args{
+				env: map[string]string{"KVSTORE_ENGINE": "btree"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "fail - sharded with replication",
+			args: args{
+				args: []string{"-engine", "sharded", "-replication-addr", ":7000"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "fail - forward without leader",
+			args: args{
+				args: []string{"-replica-forward", "leader:8181"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "fail - bad env bool",
+			args: args{
+				env: map[string]string{"KVSTORE_HTTP_ENABLED": "maybe"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "fail - bad file",
+			args: args{
+				file: `{"HTTP":`,
+			},
+			wantErr: true,
+		},
+		{
+			name: "fail - enabled without addr",
+			args: args{
+				args: []string{"-tcp-addr", ""},
+			},
+			wantErr: true,
+		},
+		{
+			name: "fail - unknown flag",
+			args: args{
+				args: []string{"-nope"},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := tt.args.env
+			if env == nil {
+				env = map[string]string{}
+			}
+
+			if tt.args.file != "" {
+				path := filepath.Join(t.TempDir(), "kvstore.json")
+				if err := os.WriteFile(path, []byte(tt.args.file), 0o644); err != nil {
+					t.Fatalf("write config file error: %v", err)
+				}
+				env["KVSTORE_CONFIG"] = path
+			}
+
+			got, err := Load(tt.args.args, func(key string) string { return env[key] })
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Load() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+
+			want := Default()
+			tt.want(&want)
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Load() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Formats an entry can be written in.
+const (
+	// FormatText is one line per entry for reading in a terminal:
+	// time LEVEL component: msg key=value ...
+	FormatText = "text"
+
+	// FormatJSON is one JSON object per line with time, level, component
+	// and msg keys followed by the entry's fields.
+	FormatJSON = "json"
+)
+
+func (c *core) encode(e entry) []byte {
+	if c.format == FormatJSON {
+		return c.encodeJSON(e)
+	}
+
+	return c.encodeText(e)
+}
+
+func (c *core) encodeText(e entry) []byte {
+	var b bytes.Buffer
+
+	b.WriteString(e.time.Format(c.timeFormat))
+	b.WriteByte(' ')
+	b.WriteString(strings.ToUpper(e.level.String()))
+	b.WriteByte(' ')
+
+	if e.component != "" {
+		b.WriteString(e.component)
+		b.WriteString(": ")
+	}
+
+	b.WriteString(e.msg)
+
+	for _, f := range e.fields {
+		b.WriteByte(' ')
+		b.WriteString(f.Key)
+		b.WriteByte('=')
+		b.WriteString(textValue(f.Value))
+	}
+
+	b.WriteByte('\n')
+
+	return b.Bytes()
+}
+
+// textValue formats v, quoting it when it would otherwise be ambiguous.
+func textValue(v interface{}) string {
+	var s string
+
+	switch v := v.(type) {
+	case string:
+		s = v
+	case error:
+		s = v.Error()
+	case fmt.Stringer:
+		s = v.String()
+	default:
+		s = fmt.Sprint(v)
+	}
+
+	if s == "" || strings.ContainsAny(s, " =\"\n\t") {
+		return strconv.Quote(s)
+	}
+
+	return s
+}
+
+func (c *core) encodeJSON(e entry) []byte {
+	var b bytes.Buffer
+
+	b.WriteString(`{"time":`)
+	writeJSON(&b, e.time.Format(c.timeFormat))
+	b.WriteString(`,"level":`)
+	writeJSON(&b, e.level.String())
+
+	if e.component != "" {
+		b.WriteString(`,"component":`)
+		writeJSON(&b, e.component)
+	}
+
+	b.WriteString(`,"msg":`)
+	writeJSON(&b, e.msg)
+
+	for _, f := range e.fields {
+		b.WriteByte(',')
+		writeJSON(&b, f.Key)
+		b.WriteByte(':')
+
+		value := f.Value
+		if err, ok := value.(error); ok {
+			value = err.Error()
+		}
+		writeJSON(&b, value)
+	}
+
+	b.WriteString("}\n")
+
+	return b.Bytes()
+}
+
+// writeJSON writes v as JSON, or as its fmt string if it cannot be encoded,
+// so a bad field never loses the entry.
+func writeJSON(b *bytes.Buffer, v interface{}) {
+	out, err := json.Marshal(v)
+	if err != nil {
+		out, _ = json.Marshal(fmt.Sprint(v))
+	}
+
+	b.Write(out)
+}
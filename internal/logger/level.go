@@ -0,0 +1,49 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Level is the severity of an entry, entries below a logger's level are
+// dropped.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var (
+	ErrLevelInvalid  = errors.New("invalid log level")
+	ErrFormatInvalid = errors.New("invalid log format")
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+func (lvl Level) String() string {
+	if name, ok := levelNames[lvl]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("level(%d)", int(lvl))
+}
+
+// ParseLevel returns the Level named debug, info, warn or error.
+func ParseLevel(name string) (Level, error) {
+	for lvl, n := range levelNames {
+		if strings.EqualFold(name, n) {
+			return lvl, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%w: %q", ErrLevelInvalid, name)
+}
@@ -1,65 +1,201 @@
 package logger
 
 import (
+	"fmt"
+	"io"
 	"log"
+	"os"
+	"task1/internal/pipeline"
 	"time"
 )
 
+// Logger writes leveled, structured entries to its sinks from a single
+// goroutine. Entries are queued without waiting on the sinks, by default an
+// entry which does not fit the queue is dropped and counted in Dropped, so a
+// slow sink never slows the request which logged. Component returns a Logger
+// for part of the server which shares the same sinks but can have its own
+// level, so a noisy component can be turned down, or a quiet one up, without
+// touching the rest.
 type Logger struct {
-	logging    chan string
-	done       chan struct{}
+	core      *core
+	component string
+	fields    []Field
+}
+
+// core is shared by a Logger and every Logger made from it.
+type core struct {
+	entries    *pipeline.Pipeline[entry]
 	timeFormat string
+	format     string
+	level      Level
+	components map[string]Level
+	sinks      []io.Writer
 }
 
-func NewLogger() *Logger {
-	logging := make(chan string)
-	done := make(chan struct{})
+// entry is a single line waiting to be written.
+type entry struct {
+	time      time.Time
+	level     Level
+	component string
+	msg       string
+	fields    []Field
+}
 
+// Field is a key and value attached to an entry.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// F returns a Field, for example logger.F("key", key).
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+func NewLogger() *Logger {
 	return &Logger{
-		logging:    logging,
-		done:       done,
-		timeFormat: time.RFC822,
+		core: &core{
+			entries:    pipeline.New[entry](pipeline.DefaultSize, pipeline.Drop),
+			timeFormat: time.RFC3339,
+			format:     FormatText,
+			level:      LevelInfo,
+			components: make(map[string]Level),
+		},
+	}
+}
+
+// SetLevel drops entries below level, for components without a level of
+// their own. It must be called before Start.
+func (l *Logger) SetLevel(level Level) {
+	l.core.level = level
+}
+
+// SetComponentLevel sets the level of component, overriding SetLevel. It
+// must be called before Start.
+func (l *Logger) SetComponentLevel(component string, level Level) {
+	l.core.components[component] = level
+}
+
+// SetFormat writes entries as FormatText or FormatJSON. It must be called
+// before Start.
+func (l *Logger) SetFormat(format string) error {
+	switch format {
+	case FormatText, FormatJSON:
+		l.core.format = format
+
+		return nil
+	default:
+		return fmt.Errorf("%w: %q", ErrFormatInvalid, format)
 	}
 }
 
+// SetOverflow queues up to size entries, and sets what happens to an entry
+// logged with the queue full. It must be called before Start.
+func (l *Logger) SetOverflow(size int, policy pipeline.Policy) {
+	l.core.entries = pipeline.New[entry](size, policy)
+}
+
+// Dropped is the number of entries lost to a full queue, or logged after
+// Stop.
+func (l Logger) Dropped() uint64 {
+	return l.core.entries.Dropped()
+}
+
+// AddSink writes every entry to w as well as the other sinks. Without a sink
+// entries go to stderr. The caller closes w after Stop. It must be called
+// before Start.
+func (l *Logger) AddSink(w io.Writer) {
+	l.core.sinks = append(l.core.sinks, w)
+}
+
+// Component returns a Logger which tags its entries with component and is
+// filtered by the component's level.
+func (l Logger) Component(component string) *Logger {
+	return &Logger{core: l.core, component: component, fields: l.fields}
+}
+
+// With returns a Logger which adds fields to every entry.
+func (l Logger) With(fields ...Field) *Logger {
+	merged := make([]Field, 0, len(l.fields)+len(fields))
+	merged = append(merged, l.fields...)
+	merged = append(merged, fields...)
+
+	return &Logger{core: l.core, component: l.component, fields: merged}
+}
+
 func (l Logger) Start() {
 	log.Print("logger started")
-	go func() {
-		for {
-			select {
-			case out := <-l.logging:
-				l.print(out)
-			case <-l.done:
-				return
-			}
-		}
-	}()
+
+	if len(l.core.sinks) == 0 {
+		l.core.sinks = []io.Writer{os.Stderr}
+	}
+
+	l.core.entries.Start(l.print)
 }
 
 func (l Logger) StartNoopLogger() {
-	go func() {
-		for {
-			select {
-			case <-l.logging:
-			case <-l.done:
-				return
-			}
-		}
-	}()
+	l.core.entries.Start(func(entry) {})
 }
 
-func (l Logger) Log(s string) {
-	l.logging <- s
+func (l Logger) Debug(msg string, fields ...Field) {
+	l.log(LevelDebug, msg, fields)
 }
 
-func (l Logger) print(s string) {
-	t := time.Now().Format(l.timeFormat)
+func (l Logger) Info(msg string, fields ...Field) {
+	l.log(LevelInfo, msg, fields)
+}
+
+func (l Logger) Warn(msg string, fields ...Field) {
+	l.log(LevelWarn, msg, fields)
+}
 
-	log.Printf("Logger @ %s: %s", t, s)
+func (l Logger) Error(msg string, fields ...Field) {
+	l.log(LevelError, msg, fields)
+}
+
+// Enabled reports whether entries at level are written, so callers can skip
+// building fields which would be dropped.
+func (l Logger) Enabled(level Level) bool {
+	threshold, ok := l.core.components[l.component]
+	if !ok {
+		threshold = l.core.level
+	}
+
+	return level >= threshold
+}
+
+func (l Logger) log(level Level, msg string, fields []Field) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	if len(l.fields) > 0 {
+		fields = append(append([]Field{}, l.fields...), fields...)
+	}
+
+	l.core.entries.Send(entry{
+		time:      time.Now(),
+		level:     level,
+		component: l.component,
+		msg:       msg,
+		fields:    fields,
+	})
+}
+
+// print writes e to every sink, a failing sink does not stop the others.
+func (l Logger) print(e entry) {
+	out := l.core.encode(e)
+
+	for _, sink := range l.core.sinks {
+		if _, err := sink.Write(out); err != nil {
+			log.Printf("log sink write error: %v", err)
+		}
+	}
 }
 
+// Stop writes every queued entry before returning, entries logged after it
+// are dropped.
 func (l Logger) Stop() {
-	close(l.logging)
-	close(l.done)
-	log.Print("logger shutdown ok")
+	l.core.entries.Stop()
+	log.Printf("logger shutdown ok, %d entries dropped", l.Dropped())
 }
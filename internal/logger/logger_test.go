@@ -0,0 +1,223 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"task1/internal/pipeline"
+	"testing"
+	"time"
+)
+
+// lineSink sends every entry written to it on a channel.
+type lineSink chan string
+
+func (ls lineSink) Write(p []byte) (int, error) {
+	ls <- string(p)
+
+	return len(p), nil
+}
+
+// next returns the next entry, or "" if none is written within a second.
+func (ls lineSink) next() string {
+	select {
+	case line := <-ls:
+		return line
+	case <-time.After(time.Second):
+		return ""
+	}
+}
+
+func newTestLogger(t *testing.T, format string) (*Logger, lineSink) {
+	t.Helper()
+
+	sink := make(lineSink, 16)
+
+	l := NewLogger()
+	if err := l.SetFormat(format); err != nil {
+		t.Fatalf("SetFormat() error = %v", err)
+	}
+	l.AddSink(sink)
+	l.core.timeFormat = "ts"
+
+	return l, sink
+}
+
+func TestLogger_format(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		log    func(l *Logger)
+		want   string
+	}{
+		{
+			name:   "text",
+			format: FormatText,
+			log: func(l *Logger) {
+				l.Info("conn added", F("protocol", "tcp"), F("conn", "ab12"))
+			},
+			want: "ts INFO conn added protocol=tcp conn=ab12\n",
+		},
+		{
+			name:   "text component and quoting",
+			format: FormatText,
+			log: func(l *Logger) {
+				l.Component("store").Warn("write rejected", F("err", errors.New("store is full")), F("key", ""))
+			},
+			want: "ts WARN store: write rejected err=\"store is full\" key=\"\"\n",
+		},
+		{
+			name:   "json",
+			format: FormatJSON,
+			log: func(l *Logger) {
+				l.Component("store").Error("snapshot error", F("err", errors.New("disk full")), F("keys", 3))
+			},
+			want: `{"time":"ts","level":"error","component":"store","msg":"snapshot error","err":"disk full","keys":3}` + "\n",
+		},
+		{
+			name:   "json with fields",
+			format: FormatJSON,
+			log: func(l *Logger) {
+				l.With(F("conn", "ab12")).Info("request", F("key", "1"), F("value", map[string]interface{}{"a": 1}))
+			},
+			want: `{"time":"ts","level":"info","msg":"request","conn":"ab12","key":"1","value":{"a":1}}` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, sink := newTestLogger(t, tt.format)
+			l.Start()
+			defer l.Stop()
+
+			tt.log(l)
+
+			if got := sink.next(); got != tt.want {
+				t.Errorf("entry = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogger_levels(t *testing.T) {
+	l, sink := newTestLogger(t, FormatText)
+	l.SetLevel(LevelWarn)
+	l.SetComponentLevel("store", LevelDebug)
+	l.SetComponentLevel("http", LevelError)
+	l.Start()
+	defer l.Stop()
+
+	store, http := l.Component("store"), l.Component("http")
+
+	l.Info("dropped")
+	http.Warn("dropped")
+	store.Debug("kept")
+	http.Error("kept")
+	l.Warn("kept")
+
+	for _, want := range []string{"ts DEBUG store: kept\n", "ts ERROR http: kept\n", "ts WARN kept\n"} {
+		if got := sink.next(); got != want {
+			t.Errorf("entry = %q, want %q", got, want)
+		}
+	}
+
+	if !store.Enabled(LevelDebug) || http.Enabled(LevelWarn) || l.Enabled(LevelInfo) {
+		t.Errorf("Enabled() does not match the levels set")
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    Level
+		wantErr bool
+	}{
+		{name: "debug", want: LevelDebug},
+		{name: "INFO", want: LevelInfo},
+		{name: "warn", want: LevelWarn},
+		{name: "error", want: LevelError},
+		{name: "loud", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseLevel(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLevel() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("ParseLevel() = %v, want %v", got, tt.want)
+			}
+
+			if !tt.wantErr && !strings.EqualFold(got.String(), tt.name) {
+				t.Errorf("String() = %q, want %q", got.String(), tt.name)
+			}
+		})
+	}
+}
+
+// slowSink is a sink which takes delay to write every entry, such as a disk
+// or a pipe falling behind.
+type slowSink time.Duration
+
+func (s slowSink) Write(p []byte) (int, error) {
+	time.Sleep(time.Duration(s))
+
+	return len(p), nil
+}
+
+func TestLogger_overflow(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      pipeline.Policy
+		wantDropped bool
+	}{
+		{name: "drop", policy: pipeline.Drop, wantDropped: true},
+		{name: "block", policy: pipeline.Block},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewLogger()
+			l.SetOverflow(2, tt.policy)
+			l.AddSink(slowSink(time.Millisecond))
+			l.Start()
+
+			for i := 0; i < 20; i++ {
+				l.Info("entry", F("i", i))
+			}
+
+			l.Stop()
+
+			if got := l.Dropped() > 0; got != tt.wantDropped {
+				t.Errorf("Dropped() = %d, want dropped %v", l.Dropped(), tt.wantDropped)
+			}
+
+			// logging after Stop must not panic
+			l.Info("after stop")
+		})
+	}
+}
+
+// BenchmarkLogger_sinkDelay logs from parallel goroutines, as request handlers
+// do, into sinks of increasing delay. With the drop policy the cost of a log
+// call stays the same however slow the sink, with block it follows the sink.
+func BenchmarkLogger_sinkDelay(b *testing.B) {
+	for _, policy := range []pipeline.Policy{pipeline.Drop, pipeline.Block} {
+		for _, delay := range []time.Duration{0, time.Millisecond} {
+			b.Run(fmt.Sprintf("%s/delay=%s", policy, delay), func(b *testing.B) {
+				l := NewLogger()
+				l.SetOverflow(256, policy)
+				l.AddSink(slowSink(delay))
+				l.Start()
+
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						l.Info("GET request", F("protocol", "tcp"), F("key", "1"))
+					}
+				})
+
+				b.StopTimer()
+				l.Stop()
+			})
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// RotatingFile is a sink writing to a file which is rotated once it reaches
+// maxBytes. The current file is renamed path.1, an existing path.1 becomes
+// path.2 and so on, keeping at most maxBackups old files.
+type RotatingFile struct {
+	path       string
+	maxBytes   int64
+	maxBackups int
+	mutex      sync.Mutex
+	file       *os.File
+	size       int64
+}
+
+// NewRotatingFile opens path for appending. A maxBytes of 0 never rotates.
+func NewRotatingFile(path string, maxBytes int64, maxBackups int) (*RotatingFile, error) {
+	rf := &RotatingFile{
+		path:       path,
+		maxBytes:   maxBytes,
+		maxBackups: maxBackups,
+	}
+
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+
+	return rf, nil
+}
+
+func (rf *RotatingFile) open() error {
+	file, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+
+		return err
+	}
+
+	rf.file = file
+	rf.size = info.Size()
+
+	return nil
+}
+
+// Write appends p, rotating first if p would take the file past maxBytes.
+// An entry larger than maxBytes is still written, to a file of its own.
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+
+	if rf.maxBytes > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.maxBytes {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+
+	return n, err
+}
+
+// rotate shifts the backups along, dropping the oldest, and starts a new
+// file. Callers must hold the lock.
+func (rf *RotatingFile) rotate() error {
+	if err := rf.file.Close(); err != nil {
+		return err
+	}
+
+	if rf.maxBackups > 0 {
+		for i := rf.maxBackups - 1; i > 0; i-- {
+			os.Rename(rf.backup(i), rf.backup(i+1))
+		}
+
+		if err := os.Rename(rf.path, rf.backup(1)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(rf.path); err != nil {
+		return err
+	}
+
+	return rf.open()
+}
+
+func (rf *RotatingFile) backup(i int) string {
+	return fmt.Sprintf("%s.%d", rf.path, i)
+}
+
+func (rf *RotatingFile) Close() error {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+
+	return rf.file.Close()
+}
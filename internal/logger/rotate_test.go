@@ -0,0 +1,101 @@
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotatingFile_Write(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxBytes   int64
+		maxBackups int
+		writes     []string
+		want       map[string]string
+	}{
+		{
+			name:       "no rotation",
+			writes:     []string{"one\n", "two\n"},
+			want:       map[string]string{"kv.log": "one\ntwo\n"},
+			maxBackups: 2,
+		},
+		{
+			name:       "rotates keeping backups",
+			maxBytes:   8,
+			maxBackups: 2,
+			writes:     []string{"one\n", "two\n", "three\n", "four\n", "five\n"},
+			want: map[string]string{
+				"kv.log":   "five\n",
+				"kv.log.1": "four\n",
+				"kv.log.2": "three\n",
+			},
+		},
+		{
+			name:     "rotates without backups",
+			maxBytes: 4,
+			writes:   []string{"one\n", "two\n"},
+			want:     map[string]string{"kv.log": "two\n"},
+		},
+		{
+			name:       "entry larger than the limit",
+			maxBytes:   2,
+			maxBackups: 1,
+			writes:     []string{"one\n", "two\n"},
+			want: map[string]string{
+				"kv.log":   "two\n",
+				"kv.log.1": "one\n",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			rf, err := NewRotatingFile(filepath.Join(dir, "kv.log"), tt.maxBytes, tt.maxBackups)
+			if err != nil {
+				t.Fatalf("NewRotatingFile() error = %v", err)
+			}
+
+			for _, w := range tt.writes {
+				if _, err := rf.Write([]byte(w)); err != nil {
+					t.Fatalf("Write() error = %v", err)
+				}
+			}
+
+			if err := rf.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+
+			entries, _ := os.ReadDir(dir)
+			if len(entries) != len(tt.want) {
+				t.Errorf("files = %d, want %d", len(entries), len(tt.want))
+			}
+
+			for name, want := range tt.want {
+				got, err := os.ReadFile(filepath.Join(dir, name))
+				if err != nil || string(got) != want {
+					t.Errorf("%s = %q, %v, want %q", name, got, err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestNewRotatingFile_appends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kv.log")
+	os.WriteFile(path, []byte("old\n"), 0o644)
+
+	rf, err := NewRotatingFile(path, 8, 1)
+	if err != nil {
+		t.Fatalf("NewRotatingFile() error = %v", err)
+	}
+
+	// the existing 4 bytes count towards the limit
+	rf.Write([]byte("new!\n"))
+	rf.Close()
+
+	if got, _ := os.ReadFile(path + ".1"); string(got) != "old\n" {
+		t.Errorf("kv.log.1 = %q, want %q", got, "old\n")
+	}
+}
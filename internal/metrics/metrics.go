@@ -2,10 +2,10 @@ package metrics
 
 import (
 	"log"
+	"sync"
 	"task1/internal/logger"
+	"task1/internal/pipeline"
 	"time"
-
-	"fmt"
 )
 
 const (
@@ -13,16 +13,22 @@ const (
 	statPost   = "POST"
 	statDelete = "DELETE"
 	printDelay = 10 * time.Second
+
+	// UnknownMethod labels requests whose method is not a known command, so
+	// clients cannot create a new series per made up method
+	UnknownMethod = "UNKNOWN"
 )
 
 type Metrics struct {
-	metrics chan string
-	done    chan struct{}
-	stats   *stats
-	logger  *logger.Logger
+	methods  *pipeline.Pipeline[string]
+	done     chan struct{}
+	stats    *stats
+	registry *registry
+	logger   *logger.Logger
 }
 
 type stats struct {
+	mutex   sync.Mutex
 	get     int
 	post    int
 	delete  int
@@ -31,7 +37,7 @@ type stats struct {
 
 func NewMetrics(logger *logger.Logger) *Metrics {
 	metrics := &Metrics{
-		metrics: make(chan string),
+		methods: pipeline.New[string](pipeline.DefaultSize, pipeline.Drop),
 		done:    make(chan struct{}),
 		stats: &stats{
 			get:     0,
@@ -39,29 +45,40 @@ func NewMetrics(logger *logger.Logger) *Metrics {
 			delete:  0,
 			unknown: 0,
 		},
-		logger: logger,
+		registry: newRegistry(),
+		logger:   logger,
 	}
 
 	return metrics
 }
 
+// SetOverflow queues up to size methods from LogMetrics, and sets what
+// happens to a method sent with the queue full. It must be called before
+// Start.
+func (m *Metrics) SetOverflow(size int, policy pipeline.Policy) {
+	m.methods = pipeline.New[string](size, policy)
+}
+
+// Dropped is the number of methods from LogMetrics lost to a full queue, or
+// sent after Stop.
+func (m *Metrics) Dropped() uint64 {
+	return m.methods.Dropped()
+}
+
+// Start counts methods sent through LogMetrics and prints the totals every
+// printDelay rather than on every request.
 func (m *Metrics) Start() {
 	log.Print("metrics started")
+
+	m.methods.Start(m.count)
+
 	go func() {
+		ticker := time.NewTicker(printDelay)
+		defer ticker.Stop()
+
 		for {
 			select {
-			case stat := <-m.metrics:
-				switch stat {
-				case statGet:
-					m.stats.get++
-				case statPost:
-					m.stats.post++
-				case statDelete:
-					m.stats.delete++
-				default:
-					m.stats.unknown++
-				}
-
+			case <-ticker.C:
 				m.PrintMetrics()
 			case <-m.done:
 				return
@@ -71,33 +88,142 @@ func (m *Metrics) Start() {
 }
 
 func (m *Metrics) StartNoopMetrics() {
-	go func() {
-		for {
-			select {
-			case <-m.metrics:
-			case <-m.done:
-				return
-			}
-		}
-	}()
+	m.methods.Start(func(string) {})
 }
 
+// LogMetrics counts a request for method without waiting for the count.
 func (m *Metrics) LogMetrics(method string) {
-	m.metrics <- method
+	m.methods.Send(method)
+}
+
+func (m *Metrics) count(method string) {
+	m.stats.mutex.Lock()
+	defer m.stats.mutex.Unlock()
+
+	switch method {
+	case statGet:
+		m.stats.get++
+	case statPost:
+		m.stats.post++
+	case statDelete:
+		m.stats.delete++
+	default:
+		m.stats.unknown++
+	}
 }
 
 func (m *Metrics) PrintMetrics() {
-	out := fmt.Sprintf("\nMETRICS - GET: %d, POST: %d, DELETE: %d, UNKNOWN: %d ",
-		m.stats.get,
-		m.stats.post,
-		m.stats.delete,
-		m.stats.unknown,
+	m.stats.mutex.Lock()
+	defer m.stats.mutex.Unlock()
+
+	m.logger.Info("metrics",
+		logger.F("get", m.stats.get),
+		logger.F("post", m.stats.post),
+		logger.F("delete", m.stats.delete),
+		logger.F("unknown", m.stats.unknown),
 	)
-	m.logger.Log(out)
 }
 
+// Stop counts every queued method before returning.
 func (m *Metrics) Stop() {
-	close(m.metrics)
 	close(m.done)
+	m.methods.Stop()
 	log.Print("metrics shutdown ok")
 }
+
+// Observe records a handled request for the Prometheus endpoint. It is safe
+// to call from any goroutine and does not wait on the metrics goroutine.
+// method becomes a label, callers must pass UnknownMethod rather than a
+// method taken straight from a client.
+func (m *Metrics) Observe(protocol, method string, status int, elapsed time.Duration) {
+	m.registry.observe(protocol, method, status, elapsed)
+}
+
+// ConnOpened and ConnClosed track the active connections gauge for protocol.
+func (m *Metrics) ConnOpened(protocol string) {
+	m.registry.addConns(protocol, 1)
+}
+
+func (m *Metrics) ConnClosed(protocol string) {
+	m.registry.addConns(protocol, -1)
+}
+
+// RegisterGauge adds a gauge read from fn every time metrics are scraped.
+func (m *Metrics) RegisterGauge(name, help string, fn func() float64) {
+	m.registry.addGauge(name, help, "gauge", fn)
+}
+
+// RegisterCounter adds a counter read from fn every time metrics are
+// scraped. fn must only ever return an increasing total.
+func (m *Metrics) RegisterCounter(name, help string, fn func() float64) {
+	m.registry.addGauge(name, help, "counter", fn)
+}
+
+type registry struct {
+	mutex    sync.Mutex
+	requests map[requestKey]uint64
+	latency  map[latencyKey]*histogram
+	conns    map[string]int64
+	gauges   []gauge
+}
+
+type requestKey struct {
+	protocol string
+	method   string
+	status   int
+}
+
+type latencyKey struct {
+	protocol string
+	method   string
+}
+
+// gauge is any metric read from a func on scrape, a gauge or a counter.
+type gauge struct {
+	name string
+	help string
+	kind string
+	fn   func() float64
+}
+
+func newRegistry() *registry {
+	return &registry{
+		requests: make(map[requestKey]uint64),
+		latency:  make(map[latencyKey]*histogram),
+		conns:    make(map[string]int64),
+	}
+}
+
+func (r *registry) observe(protocol, method string, status int, elapsed time.Duration) {
+	if method == "" {
+		method = UnknownMethod
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.requests[requestKey{protocol, method, status}]++
+
+	key := latencyKey{protocol, method}
+	h, ok := r.latency[key]
+	if !ok {
+		h = newHistogram()
+		r.latency[key] = h
+	}
+
+	h.observe(elapsed.Seconds())
+}
+
+func (r *registry) addConns(protocol string, delta int64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.conns[protocol] += delta
+}
+
+func (r *registry) addGauge(name, help, kind string, fn func() float64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.gauges = append(r.gauges, gauge{name: name, help: help, kind: kind, fn: fn})
+}
@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"task1/internal/logger"
+	"task1/internal/pipeline"
+	"testing"
+)
+
+func TestMetrics_LogMetrics(t *testing.T) {
+	tests := []struct {
+		name        string
+		size        int
+		policy      pipeline.Policy
+		wantCounted int
+	}{
+		{name: "fits", size: 8, policy: pipeline.Drop, wantCounted: 6},
+		{name: "drop", size: 2, policy: pipeline.Drop, wantCounted: 2},
+		{name: "block", size: 2, policy: pipeline.Block, wantCounted: 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := logger.NewLogger()
+			logger.StartNoopLogger()
+
+			m := NewMetrics(logger)
+			m.SetOverflow(tt.size, tt.policy)
+
+			// nothing is counted until Start, so a blocking send needs the
+			// consumer running
+			if tt.policy == pipeline.Block {
+				m.Start()
+			}
+
+			for _, method := range []string{statGet, statGet, statPost, statDelete, "PATCH", statGet} {
+				m.LogMetrics(method)
+			}
+
+			if tt.policy != pipeline.Block {
+				m.Start()
+			}
+			m.Stop()
+
+			s := m.stats
+			if got := s.get + s.post + s.delete + s.unknown; got != tt.wantCounted {
+				t.Errorf("counted = %d, want %d", got, tt.wantCounted)
+			}
+
+			if got := int(m.Dropped()); got != 6-tt.wantCounted {
+				t.Errorf("Dropped() = %d, want %d", got, 6-tt.wantCounted)
+			}
+
+			// counting after Stop must not panic
+			m.LogMetrics(statGet)
+		})
+	}
+}
+
+func BenchmarkMetrics_LogMetrics(b *testing.B) {
+	logger := logger.NewLogger()
+	logger.StartNoopLogger()
+
+	m := NewMetrics(logger)
+	m.Start()
+	defer m.Stop()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			m.LogMetrics(statGet)
+		}
+	})
+}
@@ -0,0 +1,149 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	contentType = "text/plain; version=0.0.4; charset=utf-8"
+	namespace   = "kvstore_"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the request latency
+// histogram buckets.
+var latencyBuckets = []float64{
+	0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1,
+}
+
+// labelEscaper escapes a label value the way the text format expects, which
+// unlike Go quoting leaves every other character as it is.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newHistogram() *histogram {
+	return &histogram{
+		counts: make([]uint64, len(latencyBuckets)),
+	}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, bound := range latencyBuckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+
+	h.sum += v
+	h.count++
+}
+
+// Handler serves every metric in the Prometheus text exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		m.WritePrometheus(w)
+	})
+}
+
+// WritePrometheus writes every metric to w in the Prometheus text format.
+// Series are sorted so the output is stable between scrapes.
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	r := m.registry
+	out := bufio.NewWriter(w)
+
+	r.mutex.Lock()
+
+	requests := make([]requestKey, 0, len(r.requests))
+	for key := range r.requests {
+		requests = append(requests, key)
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		a, b := requests[i], requests[j]
+		if a.protocol != b.protocol {
+			return a.protocol < b.protocol
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.status < b.status
+	})
+
+	header(out, "requests_total", "Requests handled by protocol, method and status.", "counter")
+	for _, key := range requests {
+		fmt.Fprintf(out, "%srequests_total{protocol=\"%s\",method=\"%s\",status=\"%d\"} %d\n",
+			namespace, label(key.protocol), label(key.method), key.status, r.requests[key])
+	}
+
+	latency := make([]latencyKey, 0, len(r.latency))
+	for key := range r.latency {
+		latency = append(latency, key)
+	}
+	sort.Slice(latency, func(i, j int) bool {
+		a, b := latency[i], latency[j]
+		if a.protocol != b.protocol {
+			return a.protocol < b.protocol
+		}
+		return a.method < b.method
+	})
+
+	header(out, "request_duration_seconds", "Request latency by protocol and method.", "histogram")
+	for _, key := range latency {
+		h := r.latency[key]
+		labels := fmt.Sprintf("protocol=\"%s\",method=\"%s\"", label(key.protocol), label(key.method))
+
+		for i, bound := range latencyBuckets {
+			fmt.Fprintf(out, "%srequest_duration_seconds_bucket{%s,le=\"%s\"} %d\n",
+				namespace, labels, formatFloat(bound), h.counts[i])
+		}
+		fmt.Fprintf(out, "%srequest_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", namespace, labels, h.count)
+		fmt.Fprintf(out, "%srequest_duration_seconds_sum{%s} %s\n", namespace, labels, formatFloat(h.sum))
+		fmt.Fprintf(out, "%srequest_duration_seconds_count{%s} %d\n", namespace, labels, h.count)
+	}
+
+	protocols := make([]string, 0, len(r.conns))
+	for protocol := range r.conns {
+		protocols = append(protocols, protocol)
+	}
+	sort.Strings(protocols)
+
+	header(out, "active_connections", "Open connections by protocol.", "gauge")
+	for _, protocol := range protocols {
+		fmt.Fprintf(out, "%sactive_connections{protocol=\"%s\"} %d\n", namespace, label(protocol), r.conns[protocol])
+	}
+
+	gauges := make([]gauge, len(r.gauges))
+	copy(gauges, r.gauges)
+
+	r.mutex.Unlock()
+
+	// gauge funcs may take other locks so are read without holding ours
+	for _, g := range gauges {
+		header(out, g.name, g.help, g.kind)
+		fmt.Fprintf(out, "%s%s %s\n", namespace, g.name, formatFloat(g.fn()))
+	}
+
+	return out.Flush()
+}
+
+func header(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s%s %s\n# TYPE %s%s %s\n", namespace, name, help, namespace, name, kind)
+}
+
+func label(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
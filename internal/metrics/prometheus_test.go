@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"strings"
+	"task1/internal/logger"
+	"testing"
+	"time"
+)
+
+func TestMetrics_WritePrometheus(t *testing.T) {
+	logger := logger.NewLogger()
+	logger.StartNoopLogger()
+	m := NewMetrics(logger)
+
+	m.Observe("tcp", "GET", 200, 2*time.Millisecond)
+	m.Observe("tcp", "GET", 404, 20*time.Millisecond)
+	m.Observe("http", "", 500, time.Millisecond)
+	m.ConnOpened("tcp")
+	m.ConnOpened("tcp")
+	m.ConnClosed("tcp")
+	m.RegisterGauge("store_keys", "Keys in the store.", func() float64 { return 3 })
+	m.RegisterCounter("store_evictions_total", "Keys evicted.", func() float64 { return 2 })
+
+	var out strings.Builder
+	if err := m.WritePrometheus(&out); err != nil {
+		t.Fatalf("WritePrometheus() error = %v", err)
+	}
+
+	want := []string{
+		"# TYPE kvstore_requests_total counter",
+		`kvstore_requests_total{protocol="http",method="UNKNOWN",status="500"} 1`,
+		`kvstore_requests_total{protocol="tcp",method="GET",status="200"} 1`,
+		`kvstore_requests_total{protocol="tcp",method="GET",status="404"} 1`,
+		"# TYPE kvstore_request_duration_seconds histogram",
+		`kvstore_request_duration_seconds_bucket{protocol="tcp",method="GET",le="0.001"} 0`,
+		`kvstore_request_duration_seconds_bucket{protocol="tcp",method="GET",le="0.0025"} 1`,
+		`kvstore_request_duration_seconds_bucket{protocol="tcp",method="GET",le="0.025"} 2`,
+		`kvstore_request_duration_seconds_bucket{protocol="tcp",method="GET",le="+Inf"} 2`,
+		`kvstore_request_duration_seconds_sum{protocol="tcp",method="GET"} 0.022`,
+		`kvstore_request_duration_seconds_count{protocol="tcp",method="GET"} 2`,
+		`kvstore_active_connections{protocol="tcp"} 1`,
+		"# TYPE kvstore_store_keys gauge",
+		"kvstore_store_keys 3",
+		"# TYPE kvstore_store_evictions_total counter",
+		"kvstore_store_evictions_total 2",
+	}
+
+	got := out.String()
+	for _, line := range want {
+		if !strings.Contains(got, line+"\n") {
+			t.Errorf("WritePrometheus() missing line %q in:\n%s", line, got)
+		}
+	}
+}
+
+func TestMetrics_WritePrometheusEscaping(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		want   string
+	}{
+		{
+			name:   "backslash",
+			method: `a\b`,
+			want:   `method="a\\b"`,
+		},
+		{
+			name:   "quote",
+			method: `a"b`,
+			want:   `method="a\"b"`,
+		},
+		{
+			name:   "newline",
+			method: "a\nb",
+			want:   `method="a\nb"`,
+		},
+		{
+			name:   "tab and unicode kept",
+			method: "a\tb é",
+			want:   "method=\"a\tb é\"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := logger.NewLogger()
+			logger.StartNoopLogger()
+			m := NewMetrics(logger)
+			m.Observe("tcp", tt.method, 200, time.Millisecond)
+
+			var out strings.Builder
+			m.WritePrometheus(&out)
+
+			want := `kvstore_requests_total{protocol="tcp",` + tt.want + `,status="200"} 1`
+			if !strings.Contains(out.String(), want+"\n") {
+				t.Errorf("WritePrometheus() missing line %q in:\n%s", want, out.String())
+			}
+		})
+	}
+}
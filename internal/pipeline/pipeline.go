@@ -0,0 +1,132 @@
+// Package pipeline hands values from any number of goroutines to a single
+// consumer through a bounded buffer, so producers such as request handlers
+// never wait on a slow consumer unless asked to.
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// Policy decides what Send does when the buffer is full.
+type Policy string
+
+const (
+	// Drop discards the value and counts it in Dropped, Send never waits
+	Drop Policy = "drop"
+
+	// Block waits for the consumer to make room, nothing is lost but
+	// producers run at the consumer's pace
+	Block Policy = "block"
+)
+
+const DefaultSize = 4096
+
+var ErrPolicyInvalid = errors.New("invalid overflow policy")
+
+// ParsePolicy returns the Policy named drop or block.
+func ParsePolicy(name string) (Policy, error) {
+	switch p := Policy(name); p {
+	case Drop, Block:
+		return p, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrPolicyInvalid, name)
+	}
+}
+
+type Pipeline[T any] struct {
+	values  chan T
+	policy  Policy
+	mutex   sync.RWMutex
+	closed  bool
+	start   sync.Once
+	stopped chan struct{}
+	dropped atomic.Uint64
+}
+
+// New returns a Pipeline buffering up to size values. A size below 1 uses
+// DefaultSize.
+func New[T any](size int, policy Policy) *Pipeline[T] {
+	if size < 1 {
+		size = DefaultSize
+	}
+
+	return &Pipeline[T]{
+		values:  make(chan T, size),
+		policy:  policy,
+		stopped: make(chan struct{}),
+	}
+}
+
+// Start runs handle on every value in order, on a goroutine of its own. Only
+// the first call has any effect.
+func (p *Pipeline[T]) Start(handle func(T)) {
+	p.start.Do(func() {
+		go func() {
+			defer close(p.stopped)
+
+			for v := range p.values {
+				handle(v)
+			}
+		}()
+	})
+}
+
+// Send queues v, reporting whether it was queued. Values sent once Stop has
+// been called are dropped.
+func (p *Pipeline[T]) Send(v T) bool {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	if p.closed {
+		p.dropped.Add(1)
+
+		return false
+	}
+
+	if p.policy == Block {
+		p.values <- v
+
+		return true
+	}
+
+	select {
+	case p.values <- v:
+		return true
+	default:
+		p.dropped.Add(1)
+
+		return false
+	}
+}
+
+// Stop refuses further values and returns once every queued value has been
+// handled. A pipeline which was never started discards its values. It is
+// safe to call more than once.
+func (p *Pipeline[T]) Stop() {
+	// a blocked Send holds the read lock until the consumer makes room, so
+	// there must be a consumer before taking the write lock
+	p.Start(func(T) {})
+
+	p.mutex.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.values)
+	}
+	p.mutex.Unlock()
+
+	<-p.stopped
+}
+
+// Dropped is the number of values discarded because the buffer was full or
+// the pipeline stopped.
+func (p *Pipeline[T]) Dropped() uint64 {
+	return p.dropped.Load()
+}
+
+// Len is the number of values waiting for the consumer.
+func (p *Pipeline[T]) Len() int {
+	return len(p.values)
+}
@@ -0,0 +1,153 @@
+package pipeline
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPipeline_Send(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      Policy
+		sends       int
+		wantQueued  int
+		wantDropped uint64
+	}{
+		{
+			name:       "drop - fits",
+			policy:     Drop,
+			sends:      2,
+			wantQueued: 2,
+		},
+		{
+			name:        "drop - overflow",
+			policy:      Drop,
+			sends:       5,
+			wantQueued:  2,
+			wantDropped: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New[int](2, tt.policy)
+
+			queued := 0
+			for i := 0; i < tt.sends; i++ {
+				if p.Send(i) {
+					queued++
+				}
+			}
+
+			if queued != tt.wantQueued || p.Len() != tt.wantQueued {
+				t.Errorf("queued = %d, Len() = %d, want %d", queued, p.Len(), tt.wantQueued)
+			}
+
+			if got := p.Dropped(); got != tt.wantDropped {
+				t.Errorf("Dropped() = %d, want %d", got, tt.wantDropped)
+			}
+		})
+	}
+}
+
+func TestPipeline_block(t *testing.T) {
+	p := New[int](1, Block)
+	p.Send(1)
+
+	sent := make(chan struct{})
+	go func() {
+		p.Send(2)
+		close(sent)
+	}()
+
+	select {
+	case <-sent:
+		t.Fatal("Send() returned with the buffer full")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	var got []int
+	p.Start(func(v int) { got = append(got, v) })
+
+	<-sent
+	p.Stop()
+
+	if len(got) != 2 || got[0] != 1 || got[1] != 2 || p.Dropped() != 0 {
+		t.Errorf("handled = %v, dropped %d, want [1 2], 0", got, p.Dropped())
+	}
+}
+
+func TestPipeline_Stop(t *testing.T) {
+	p := New[int](64, Drop)
+
+	// the consumer is slower than the producers, Stop must still wait for
+	// every queued value
+	var (
+		mutex   sync.Mutex
+		handled int
+	)
+	p.Start(func(int) {
+		time.Sleep(time.Millisecond)
+		mutex.Lock()
+		handled++
+		mutex.Unlock()
+	})
+
+	for i := 0; i < 32; i++ {
+		p.Send(i)
+	}
+
+	p.Stop()
+
+	if handled != 32 {
+		t.Errorf("handled = %d before Stop returned, want 32", handled)
+	}
+
+	// sends after Stop are dropped rather than panicking on a closed channel
+	if p.Send(1) || p.Dropped() != 1 {
+		t.Errorf("Send() after Stop queued, dropped %d", p.Dropped())
+	}
+
+	p.Stop()
+}
+
+func TestPipeline_Stop_unstarted(t *testing.T) {
+	p := New[int](1, Block)
+	p.Send(1)
+
+	done := make(chan struct{})
+	go func() {
+		p.Stop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Stop() of an unstarted pipeline did not return")
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    Policy
+		wantErr bool
+	}{
+		{name: "drop", want: Drop},
+		{name: "block", want: Block},
+		{name: "wait", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePolicy(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("ParsePolicy() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
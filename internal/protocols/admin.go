@@ -0,0 +1,320 @@
+package protocols
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"task1/internal/auth"
+	"task1/internal/logger"
+	"task1/internal/store"
+	"time"
+)
+
+const (
+	healthzPath   = "/healthz"
+	readyzPath    = "/readyz"
+	adminPath     = "/admin"
+	adminModePath = "/admin/mode"
+)
+
+var ErrNotReady = errors.New("not ready")
+
+// BuildInfo identifies the running binary.
+type BuildInfo struct {
+	Version   string `json:"Version"`
+	Revision  string `json:"Revision,omitempty"`
+	Time      string `json:"Time,omitempty"`
+	Modified  bool   `json:"Modified,omitempty"`
+	GoVersion string `json:"GoVersion"`
+}
+
+// ReadBuildInfo returns version along with the VCS revision and Go version
+// go build embeds in the binary.
+func ReadBuildInfo(version string) BuildInfo {
+	build := BuildInfo{Version: version, GoVersion: runtime.Version()}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return build
+	}
+
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			build.Revision = setting.Value
+		case "vcs.time":
+			build.Time = setting.Value
+		case "vcs.modified":
+			build.Modified = setting.Value == "true"
+		}
+	}
+
+	return build
+}
+
+// AdminServer serves /healthz and /readyz for orchestrators to probe, and
+// the admin API under /admin. It is either mounted on the HTTP listener with
+// HTTPServer.SetAdmin or started on an address of its own, so the admin API
+// can be kept off the public port.
+//
+//   - /healthz answers as long as the server is running
+//   - /readyz fails while a listener is not serving or in maintenance mode
+//   - GET /admin reports uptime, build, store stats, mode and listeners
+//   - GET /admin/mode reports the mode, PUT {"Mode": "read-only"} sets it
+//
+// With an ACL the /admin endpoints need a token granted admin.
+type AdminServer struct {
+	http      *http.Server
+	mux       *http.ServeMux
+	started   time.Time
+	build     BuildInfo
+	mode      *Mode
+	listeners []Listener
+	state     *listenerState
+	acl       *auth.ACL
+	logger    *logger.Logger
+	storage   store.Store
+}
+
+// adminStatus is the response Data of GET /admin.
+type adminStatus struct {
+	Started   time.Time        `json:"Started"`
+	Uptime    string           `json:"Uptime"`
+	Build     BuildInfo        `json:"Build"`
+	Mode      string           `json:"Mode"`
+	Store     storeStatus      `json:"Store"`
+	Listeners []ListenerStatus `json:"Listeners"`
+}
+
+type storeStatus struct {
+	Keys      int    `json:"Keys"`
+	Bytes     int64  `json:"Bytes"`
+	Evictions uint64 `json:"Evictions"`
+}
+
+// adminMode is the request body and response Data of /admin/mode.
+type adminMode struct {
+	Mode string `json:"Mode"`
+}
+
+func NewAdmin(
+	addr string,
+	logger *logger.Logger,
+	storage store.Store,
+	mode *Mode,
+	build BuildInfo,
+) *AdminServer {
+
+	as := &AdminServer{
+		http: &http.Server{
+			Addr: addr,
+		},
+		mux:     http.NewServeMux(),
+		started: time.Now(),
+		build:   build,
+		mode:    mode,
+		state:   newListenerState(protocolAdmin, addr),
+		logger:  logger,
+		storage: storage,
+	}
+
+	as.routes(as.mux)
+	as.http.Handler = as.mux
+
+	return as
+}
+
+// SetTLS serves HTTPS with the certificate in t when started on its own
+// address. It must be called before Start.
+func (as *AdminServer) SetTLS(t *TLS) {
+	as.http.TLSConfig = t.Config()
+}
+
+// SetACL requires /admin requests to carry a token granted admin by acl. It
+// must be called before Start.
+func (as *AdminServer) SetACL(acl *auth.ACL) {
+	as.acl = acl
+}
+
+// AddListener reports l in /readyz and the admin API. It must be called
+// before Start.
+func (as *AdminServer) AddListener(l Listener) {
+	as.listeners = append(as.listeners, l)
+}
+
+func (as *AdminServer) routes(mux *http.ServeMux) {
+	as.probes(mux)
+	mux.HandleFunc(adminPath, as.statusHandler)
+	mux.HandleFunc(adminModePath, as.modeHandler)
+}
+
+// probes mounts the health and readiness endpoints, which need no token.
+func (as *AdminServer) probes(mux *http.ServeMux) {
+	mux.HandleFunc(healthzPath, as.healthzHandler)
+	mux.HandleFunc(readyzPath, as.readyzHandler)
+}
+
+// Start serves the admin endpoints on the address given to NewAdmin. It is
+// only needed when they are not mounted on the HTTP listener.
+func (as AdminServer) Start() {
+	as.state.set(StateServing)
+
+	go func() {
+		serve := as.http.ListenAndServe
+		if as.http.TLSConfig != nil {
+			serve = func() error { return as.http.ListenAndServeTLS("", "") }
+		}
+
+		log.Printf("admin listening on %s", as.http.Addr)
+		if err := serve(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("admin listener error: %v", err)
+			as.state.fail(err)
+		}
+	}()
+}
+
+// Status reports the admin listener started by Start, as for the other
+// listeners.
+func (as AdminServer) Status() ListenerStatus {
+	return as.state.get()
+}
+
+func (as AdminServer) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	as.state.set(StateStopping)
+	if err := as.http.Shutdown(ctx); err != nil {
+		as.http.Close()
+	}
+	as.state.set(StateStopped)
+	log.Print("admin shutdown ok")
+}
+
+func (as *AdminServer) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		as.writeJson(w, ErrRouteForbidden, nil)
+
+		return
+	}
+
+	as.writeJson(w, nil, "ok")
+}
+
+func (as *AdminServer) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		as.writeJson(w, ErrRouteForbidden, nil)
+
+		return
+	}
+
+	as.writeJson(w, as.ready(), "ready")
+}
+
+// ready returns ErrNotReady, naming why, unless every listener is serving
+// and the server is not in maintenance mode.
+func (as *AdminServer) ready() error {
+	var reasons []string
+
+	if mode := as.mode.Get(); mode == ModeMaintenance {
+		reasons = append(reasons, mode+" mode")
+	}
+
+	for _, l := range as.listeners {
+		if status := l.Status(); status.State != StateServing {
+			reasons = append(reasons, status.Protocol+" "+status.State)
+		}
+	}
+
+	if len(reasons) > 0 {
+		return fmt.Errorf("%w: %s", ErrNotReady, strings.Join(reasons, ", "))
+	}
+
+	return nil
+}
+
+func (as *AdminServer) statusHandler(w http.ResponseWriter, r *http.Request) {
+	if err := as.authorize(r); err != nil {
+		as.writeJson(w, err, nil)
+
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		as.writeJson(w, ErrRouteForbidden, nil)
+
+		return
+	}
+
+	keys, bytes := as.storage.Stats()
+
+	status := adminStatus{
+		Started: as.started,
+		Uptime:  time.Since(as.started).Round(time.Second).String(),
+		Build:   as.build,
+		Mode:    as.mode.Get(),
+		Store: storeStatus{
+			Keys:      keys,
+			Bytes:     bytes,
+			Evictions: as.storage.Evictions(),
+		},
+		Listeners: make([]ListenerStatus, 0, len(as.listeners)),
+	}
+
+	for _, l := range as.listeners {
+		status.Listeners = append(status.Listeners, l.Status())
+	}
+
+	as.writeJson(w, nil, status)
+}
+
+func (as *AdminServer) modeHandler(w http.ResponseWriter, r *http.Request) {
+	if err := as.authorize(r); err != nil {
+		as.writeJson(w, err, nil)
+
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		as.writeJson(w, nil, adminMode{Mode: as.mode.Get()})
+	case http.MethodPut:
+		var req adminMode
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxFrameSize)).Decode(&req); err != nil {
+			as.writeJson(w, fmt.Errorf("%w: %v", ErrModeInvalid, err), nil)
+
+			return
+		}
+
+		old, err := as.mode.Set(req.Mode)
+		if err != nil {
+			as.writeJson(w, err, nil)
+
+			return
+		}
+
+		as.logger.Info("mode changed", logger.F("mode", req.Mode), logger.F("was", old))
+		as.writeJson(w, nil, req)
+	default:
+		as.writeJson(w, ErrRouteForbidden, nil)
+	}
+}
+
+// authorize checks the request's token is granted admin.
+func (as *AdminServer) authorize(r *http.Request) error {
+	return as.acl.Authorize(tokenFromHeader(r), auth.Admin, "")
+}
+
+func (as *AdminServer) writeJson(w http.ResponseWriter, err error, data interface{}) {
+	status, out := BuildJsonResponse(err, data, as.logger)
+	w.Header().Set("Content-Type", contentTypeJSON)
+	challenge(w, status)
+	w.WriteHeader(status)
+	w.Write(out)
+}
@@ -0,0 +1,375 @@
+package protocols
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"task1/internal/logger"
+	"task1/internal/metrics"
+	"task1/internal/store"
+	"testing"
+	"time"
+)
+
+// fakeListener reports a fixed status.
+type fakeListener ListenerStatus
+
+func (fl fakeListener) Status() ListenerStatus {
+	return ListenerStatus(fl)
+}
+
+func newTestAdmin(t *testing.T) (*AdminServer, *http.ServeMux) {
+	t.Helper()
+
+	logger := logger.NewLogger()
+	logger.StartNoopLogger()
+	storage := store.NewStorage(logger)
+	storage.Post(store.StoreData{"1": "one", "2": "two"})
+
+	admin := NewAdmin("", logger, storage, NewMode(), BuildInfo{Version: "v1.0.0", GoVersion: "go"})
+	admin.SetACL(testACL(t))
+	admin.AddListener(fakeListener{Protocol: protocolTCP, Addr: ":8181", State: StateServing})
+
+	// mounted the way HTTPServer.SetAdmin does
+	mux := http.NewServeMux()
+	admin.routes(mux)
+
+	return admin, mux
+}
+
+func TestAdminServer_handlers(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       string
+		listener   *fakeListener
+		method     string
+		path       string
+		token      string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "healthz",
+			mode:       ModeMaintenance,
+			method:     http.MethodGet,
+			path:       healthzPath,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"Err":"","Status":200,"Data":"ok"}`,
+		},
+		{
+			name:       "readyz",
+			method:     http.MethodGet,
+			path:       readyzPath,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"Err":"","Status":200,"Data":"ready"}`,
+		},
+		{
+			name:       "readyz - maintenance",
+			mode:       ModeMaintenance,
+			method:     http.MethodGet,
+			path:       readyzPath,
+			wantStatus: http.StatusServiceUnavailable,
+			wantBody:   `{"Err":"not ready: maintenance mode","Status":503,"Data":null}`,
+		},
+		{
+			name:       "readyz - listener stopping",
+			listener:   &fakeListener{Protocol: protocolHTTP, State: StateStopping},
+			method:     http.MethodGet,
+			path:       readyzPath,
+			wantStatus: http.StatusServiceUnavailable,
+			wantBody:   `{"Err":"not ready: http stopping","Status":503,"Data":null}`,
+		},
+		{
+			name:       "mode - no token",
+			method:     http.MethodGet,
+			path:       adminModePath,
+			wantStatus: http.StatusUnauthorized,
+			wantBody:   `{"Err":"missing or unknown token","Status":401,"Data":null}`,
+		},
+		{
+			name:       "mode - not granted admin",
+			method:     http.MethodGet,
+			path:       adminModePath,
+			token:      "app-token",
+			wantStatus: http.StatusForbidden,
+			wantBody:   `{"Err":"token not permitted: app admin","Status":403,"Data":null}`,
+		},
+		{
+			name:       "mode - get",
+			mode:       ModeReadOnly,
+			method:     http.MethodGet,
+			path:       adminModePath,
+			token:      "ops-token",
+			wantStatus: http.StatusOK,
+			wantBody:   `{"Err":"","Status":200,"Data":{"Mode":"read-only"}}`,
+		},
+		{
+			name:       "mode - set",
+			method:     http.MethodPut,
+			path:       adminModePath,
+			token:      "ops-token",
+			body:       `{"Mode":"maintenance"}`,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"Err":"","Status":200,"Data":{"Mode":"maintenance"}}`,
+		},
+		{
+			name:       "mode - set unknown",
+			method:     http.MethodPut,
+			path:       adminModePath,
+			token:      "ops-token",
+			body:       `{"Mode":"off"}`,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `{"Err":"invalid mode: \"off\"","Status":400,"Data":null}`,
+		},
+		{
+			name:       "status - method",
+			method:     http.MethodPost,
+			path:       adminPath,
+			token:      "ops-token",
+			wantStatus: http.StatusMethodNotAllowed,
+			wantBody:   `{"Err":"method forbidden","Status":405,"Data":null}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			admin, mux := newTestAdmin(t)
+			if tt.mode != "" {
+				admin.mode.Set(tt.mode)
+			}
+			if tt.listener != nil {
+				admin.AddListener(*tt.listener)
+			}
+
+			r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.token != "" {
+				r.Header.Set(headerAuthorization, "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+
+			mux.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus || w.Body.String() != tt.wantBody {
+				t.Errorf("%s %s = %d %s, want %d %s", tt.method, tt.path, w.Code, w.Body, tt.wantStatus, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestAdminServer_statusHandler(t *testing.T) {
+	_, mux := newTestAdmin(t)
+
+	r := httptest.NewRequest(http.MethodGet, adminPath, nil)
+	r.Header.Set(headerAuthorization, "Bearer ops-token")
+	w := httptest.NewRecorder()
+
+	mux.ServeHTTP(w, r)
+
+	var got struct {
+		Status int
+		Data   adminStatus
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("decode status error: %v, body %s", err, w.Body)
+	}
+
+	status := got.Data
+	if got.Status != http.StatusOK || status.Mode != ModeNormal || status.Build.Version != "v1.0.0" || status.Uptime == "" {
+		t.Errorf("status = %+v", got)
+	}
+
+	if status.Store.Keys != 2 || status.Store.Bytes == 0 {
+		t.Errorf("store = %+v, want 2 keys", status.Store)
+	}
+
+	want := ListenerStatus{Protocol: protocolTCP, Addr: ":8181", State: StateServing}
+	if len(status.Listeners) != 1 || status.Listeners[0] != want {
+		t.Errorf("listeners = %+v, want [%+v]", status.Listeners, want)
+	}
+}
+
+func TestAdminServer_modeGatesREST(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       string
+		method     string
+		path       string
+		wantStatus int
+	}{
+		{
+			name:       "read-only - PUT refused",
+			mode:       ModeReadOnly,
+			method:     http.MethodPut,
+			path:       "/keys/1",
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:       "read-only - DELETE refused",
+			mode:       ModeReadOnly,
+			method:     http.MethodDelete,
+			path:       "/keys/1",
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:       "read-only - GET served",
+			mode:       ModeReadOnly,
+			method:     http.MethodGet,
+			path:       "/keys/1",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "maintenance - PUT refused",
+			mode:       ModeMaintenance,
+			method:     http.MethodPut,
+			path:       "/keys/1",
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:       "maintenance - DELETE refused",
+			mode:       ModeMaintenance,
+			method:     http.MethodDelete,
+			path:       "/keys/1",
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:       "maintenance - GET refused",
+			mode:       ModeMaintenance,
+			method:     http.MethodGet,
+			path:       "/keys/1",
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:       "maintenance - list refused",
+			mode:       ModeMaintenance,
+			method:     http.MethodGet,
+			path:       "/keys?prefix=",
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:       "maintenance - watch refused",
+			mode:       ModeMaintenance,
+			method:     http.MethodGet,
+			path:       "/watch?prefix=",
+			wantStatus: http.StatusServiceUnavailable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := logger.NewLogger()
+			logger.StartNoopLogger()
+			metrics := metrics.NewMetrics(logger)
+			metrics.StartNoopMetrics()
+			storage := store.NewStorage(logger)
+			storage.Post(store.StoreData{"1": "one"})
+
+			mode := NewMode()
+			mode.Set(tt.mode)
+
+			hs := NewHTTP("", logger, storage, metrics)
+			hs.SetMode(mode)
+
+			mux := http.NewServeMux()
+			mux.HandleFunc(keysPath, hs.keysHandler)
+			mux.HandleFunc(listPath, hs.listHandler)
+			mux.HandleFunc(watchPath, hs.watchHandler)
+
+			r := httptest.NewRequest(tt.method, tt.path, strings.NewReader("changed"))
+			w := httptest.NewRecorder()
+
+			mux.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("%s %s = %d %s, want %d", tt.method, tt.path, w.Code, w.Body, tt.wantStatus)
+			}
+
+			if got, err := storage.Get("1"); err != nil || got != "one" {
+				t.Errorf("storage.Get(1) = %v, %v, want one", got, err)
+			}
+		})
+	}
+}
+
+// Without an ACL the admin API stays off the public port, the probes do not.
+func TestHTTPServer_SetAdmin(t *testing.T) {
+	tests := []struct {
+		name       string
+		acl        bool
+		path       string
+		wantStatus int
+	}{
+		{name: "acl - admin", acl: true, path: adminPath, wantStatus: http.StatusUnauthorized},
+		{name: "acl - mode", acl: true, path: adminModePath, wantStatus: http.StatusUnauthorized},
+		{name: "acl - healthz", acl: true, path: healthzPath, wantStatus: http.StatusOK},
+		{name: "no acl - admin", path: adminPath, wantStatus: http.StatusNotFound},
+		{name: "no acl - mode", path: adminModePath, wantStatus: http.StatusNotFound},
+		{name: "no acl - healthz", path: healthzPath, wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := logger.NewLogger()
+			logger.StartNoopLogger()
+			metrics := metrics.NewMetrics(logger)
+			metrics.StartNoopMetrics()
+			storage := store.NewStorage(logger)
+
+			admin := NewAdmin("", logger, storage, NewMode(), BuildInfo{})
+			if tt.acl {
+				admin.SetACL(testACL(t))
+			}
+
+			hs := NewHTTP(":8080", logger, storage, metrics)
+			hs.SetAdmin(admin)
+			// mounted as Start does
+			hs.mux.HandleFunc("/", hs.rootHandler)
+
+			rec := httptest.NewRecorder()
+			hs.mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("GET %s status = %v, want %v", tt.path, rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+// A listener which cannot bind reports failed rather than taking the process
+// down, so /readyz can report it.
+func TestAdminServer_listenFailure(t *testing.T) {
+	taken, err := net.Listen(tcpnetwork, "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen error: %v", err)
+	}
+	defer taken.Close()
+
+	logger := logger.NewLogger()
+	logger.StartNoopLogger()
+	metrics := metrics.NewMetrics(logger)
+	metrics.StartNoopMetrics()
+	storage := store.NewStorage(logger)
+
+	server := NewHTTP(taken.Addr().String(), logger, storage, metrics)
+	admin := NewAdmin(taken.Addr().String(), logger, storage, NewMode(), BuildInfo{})
+	admin.AddListener(server)
+
+	server.Start()
+	admin.Start()
+
+	deadline := time.Now().Add(3 * time.Second)
+	for server.Status().State != StateFailed || admin.Status().State != StateFailed {
+		if time.Now().After(deadline) {
+			t.Fatalf("Status() = %+v, %+v, want both failed", server.Status(), admin.Status())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if got := server.Status(); !strings.Contains(got.Err, "address already in use") {
+		t.Errorf("HTTPServer.Status() Err = %q, want address already in use", got.Err)
+	}
+
+	w := httptest.NewRecorder()
+	admin.readyzHandler(w, httptest.NewRequest(http.MethodGet, readyzPath, nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+}
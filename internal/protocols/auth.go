@@ -0,0 +1,48 @@
+package protocols
+
+import (
+	"net/http"
+	"strings"
+	"task1/internal/auth"
+	"task1/internal/store"
+)
+
+const headerAuthorization = "Authorization"
+
+// authorize checks token may run the request, using the permit of its
+// command. Unknown methods only need a valid token. A nil acl allows
+// everything.
+func authorize(acl *auth.ACL, token, method string, req jsonRequest) error {
+	if cmd, ok := commands[method]; ok {
+		return cmd.permit(acl, token, req)
+	}
+
+	return acl.Authenticate(token)
+}
+
+// txnPermission is the permission a transaction op needs on its key.
+func txnPermission(op string) string {
+	switch op {
+	case store.TxSet:
+		return auth.Write
+	case store.TxDelete:
+		return auth.Delete
+	default:
+		return auth.Read
+	}
+}
+
+// tokenFromHeader returns the token from an "Authorization: Bearer" header.
+func tokenFromHeader(r *http.Request) string {
+	return bearerToken(r.Header.Get(headerAuthorization))
+}
+
+// bearerToken returns the token from a "Bearer <token>" credential.
+func bearerToken(credential string) string {
+	scheme, token, ok := strings.Cut(credential, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+
+	return strings.TrimSpace(token)
+}
@@ -0,0 +1,226 @@
+package protocols
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"task1/internal/auth"
+	"task1/internal/logger"
+	"task1/internal/metrics"
+	"task1/internal/store"
+	"testing"
+)
+
+func testACL(t *testing.T) *auth.ACL {
+	t.Helper()
+
+	acl, err := auth.NewACL([]auth.Token{
+		{
+			Name:  "app",
+			Token: "app-token",
+			Grants: []auth.Grant{
+				{Prefix: "app/", Permissions: []string{auth.Read, auth.Write}},
+			},
+		},
+		{
+			Name:  "ops",
+			Token: "ops-token",
+			Grants: []auth.Grant{
+				{Prefix: "", Permissions: []string{auth.Admin}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewACL() error = %v", err)
+	}
+
+	return acl
+}
+
+func Test_authorize(t *testing.T) {
+	acl := testACL(t)
+
+	type args struct {
+		token  string
+		method string
+		req    jsonRequest
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantErr error
+	}{
+		{
+			name: "GET ok",
+			args: args{token: "app-token", method: http.MethodGet, req: jsonRequest{Query: "app/1"}},
+		},
+		{
+			name: "POST ok",
+			args: args{token: "app-token", method: http.MethodPost, req: jsonRequest{
+				Payload: map[string]interface{}{"app/1": "hello", "app/2": "world"},
+			}},
+		},
+		{
+			name: "LIST ok - within grant",
+			args: args{token: "app-token", method: methodList, req: jsonRequest{Query: "app/users/"}},
+		},
+		{
+			name: "TXN ok",
+			args: args{token: "app-token", method: methodTxn, req: jsonRequest{Ops: []jsonOp{
+				{Op: store.TxGet, Key: "app/1"},
+				{Op: store.TxSet, Key: "app/2"},
+			}}},
+		},
+		{
+			name: "POST fail - one key outside grant",
+			args: args{token: "app-token", method: http.MethodPost, req: jsonRequest{
+				Payload: map[string]interface{}{"app/1": "hello", "other/2": "world"},
+			}},
+			wantErr: auth.ErrForbidden,
+		},
+		{
+			name:    "DELETE fail - not granted",
+			args:    args{token: "app-token", method: http.MethodDelete, req: jsonRequest{Query: "app/1"}},
+			wantErr: auth.ErrForbidden,
+		},
+		{
+			name: "TXN fail - delete not granted",
+			args: args{token: "app-token", method: methodTxn, req: jsonRequest{Ops: []jsonOp{
+				{Op: store.TxGet, Key: "app/1"},
+				{Op: store.TxDelete, Key: "app/2"},
+			}}},
+			wantErr: auth.ErrForbidden,
+		},
+		{
+			name:    "WATCH fail - wider than grant",
+			args:    args{token: "app-token", method: methodWatch, req: jsonRequest{Query: ""}},
+			wantErr: auth.ErrForbidden,
+		},
+		{
+			name:    "POST fail - empty payload without token",
+			args:    args{method: http.MethodPost},
+			wantErr: auth.ErrUnauthorized,
+		},
+		{
+			name:    "GET fail - unknown token",
+			args:    args{token: "nope", method: http.MethodGet, req: jsonRequest{Query: "app/1"}},
+			wantErr: auth.ErrUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := authorize(acl, tt.args.token, tt.args.method, tt.args.req)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("authorize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHTTPHandlers_auth(t *testing.T) {
+	type args struct {
+		method string
+		path   string
+		body   string
+		header string
+	}
+	tests := []struct {
+		name       string
+		handler    func(hs *HTTPServer) http.HandlerFunc
+		args       args
+		wantStatus int
+	}{
+		{
+			name:       "keys GET ok",
+			handler:    func(hs *HTTPServer) http.HandlerFunc { return hs.keysHandler },
+			args:       args{method: http.MethodGet, path: "/keys/app/1", header: "Bearer app-token"},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "keys GET fail - no token",
+			handler:    func(hs *HTTPServer) http.HandlerFunc { return hs.keysHandler },
+			args:       args{method: http.MethodGet, path: "/keys/app/1"},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "keys DELETE fail - not granted",
+			handler:    func(hs *HTTPServer) http.HandlerFunc { return hs.keysHandler },
+			args:       args{method: http.MethodDelete, path: "/keys/app/1", header: "Bearer app-token"},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "list fail - prefix wider than grant",
+			handler:    func(hs *HTTPServer) http.HandlerFunc { return hs.listHandler },
+			args:       args{method: http.MethodGet, path: "/keys?prefix=", header: "Bearer app-token"},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:    "root GET ok - token in body",
+			handler: func(hs *HTTPServer) http.HandlerFunc { return hs.rootHandler },
+			args: args{
+				method: http.MethodGet,
+				path:   "/",
+				body:   `{"Query":"app/1","Token":"app-token"}`,
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:    "root POST fail - unknown token",
+			handler: func(hs *HTTPServer) http.HandlerFunc { return hs.rootHandler },
+			args: args{
+				method: http.MethodPost,
+				path:   "/",
+				body:   `{"Payload":{"app/2":"hello"}}`,
+				header: "Bearer nope",
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "metrics ok - admin token",
+			handler:    func(hs *HTTPServer) http.HandlerFunc { return hs.metricsHandler },
+			args:       args{method: http.MethodGet, path: metricsPath, header: "Bearer ops-token"},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "metrics fail - no token",
+			handler:    func(hs *HTTPServer) http.HandlerFunc { return hs.metricsHandler },
+			args:       args{method: http.MethodGet, path: metricsPath},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "metrics fail - not granted admin",
+			handler:    func(hs *HTTPServer) http.HandlerFunc { return hs.metricsHandler },
+			args:       args{method: http.MethodGet, path: metricsPath, header: "Bearer app-token"},
+			wantStatus: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := logger.NewLogger()
+			logger.StartNoopLogger()
+			metrics := metrics.NewMetrics(logger)
+			metrics.StartNoopMetrics()
+			storage := store.NewStorage(logger)
+			storage.Post(store.StoreData{"app/1": "hello world"})
+
+			hs := NewHTTP(":8080", logger, storage, metrics)
+			hs.SetACL(testACL(t))
+
+			req := httptest.NewRequest(tt.args.method, tt.args.path, strings.NewReader(tt.args.body))
+			if tt.args.header != "" {
+				req.Header.Set(headerAuthorization, tt.args.header)
+			}
+			rec := httptest.NewRecorder()
+
+			tt.handler(hs)(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("handler status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantStatus == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("handler missing WWW-Authenticate header on 401")
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package protocols
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"task1/internal/logger"
+	"task1/internal/metrics"
+	"time"
+)
+
+// connSet tracks the open connections of a stream listener so shutdown can
+// drain them, and counts them in metrics under protocol.
+type connSet struct {
+	protocol string
+	mutex    sync.Mutex
+	conns    map[net.Conn]string
+	draining bool
+	open     inflight
+	logger   *logger.Logger
+	metrics  *metrics.Metrics
+}
+
+func newConnSet(protocol string, logger *logger.Logger, metrics *metrics.Metrics) *connSet {
+	return &connSet{
+		protocol: protocol,
+		conns:    make(map[net.Conn]string),
+		logger:   logger,
+		metrics:  metrics,
+	}
+}
+
+// add tracks conn and returns an ID for it to be logged under. IDs are random
+// and may repeat, so conns are tracked by the conn itself.
+func (cs *connSet) add(conn net.Conn) string {
+	connID := createConnID()
+
+	cs.logger.Debug("conn added", logger.F("protocol", cs.protocol), logger.F("conn", connID))
+	cs.metrics.ConnOpened(cs.protocol)
+
+	cs.open.begin()
+
+	cs.mutex.Lock()
+	cs.conns[conn] = connID
+	cs.mutex.Unlock()
+
+	return connID
+}
+
+func (cs *connSet) remove(conn net.Conn) {
+	cs.mutex.Lock()
+	connID := cs.conns[conn]
+	delete(cs.conns, conn)
+	cs.mutex.Unlock()
+
+	cs.logger.Debug("conn removed", logger.F("protocol", cs.protocol), logger.F("conn", connID))
+	cs.metrics.ConnClosed(cs.protocol)
+
+	cs.open.end()
+}
+
+// keepAlive sets conn's read deadline timeout from now, or clears it for a
+// zero timeout, before the handler waits on the client. Once the set is
+// draining it reports false, the handler should answer the requests it has
+// already read and close the conn.
+func (cs *connSet) keepAlive(conn net.Conn, timeout time.Duration) bool {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	if cs.draining {
+		return false
+	}
+
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	conn.SetReadDeadline(deadline)
+
+	return true
+}
+
+// drain wakes every handler waiting on its client so it closes its conn,
+// waits for handlers busy with a request until ctx is done, then closes the
+// conns still open. It returns how many conns closed on their own and how
+// many were aborted.
+func (cs *connSet) drain(ctx context.Context) (drained, aborted int) {
+	cs.mutex.Lock()
+	cs.draining = true
+	for conn := range cs.conns {
+		conn.SetReadDeadline(time.Now())
+	}
+	cs.mutex.Unlock()
+
+	drained, aborted = cs.open.wait(ctx)
+	if aborted > 0 {
+		cs.closeAll()
+	}
+
+	return drained, aborted
+}
+
+// closeAll closes every open connection, their handlers remove them.
+func (cs *connSet) closeAll() {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	for conn, connID := range cs.conns {
+		cs.logger.Debug("active conn closed", logger.F("protocol", cs.protocol), logger.F("conn", connID))
+		conn.Close()
+	}
+}
+
+// connEnded reports whether err from reading a conn is the client closing it
+// or the read deadline passing, on idle or on shutdown, rather than a fault.
+func connEnded(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, os.ErrDeadlineExceeded)
+}
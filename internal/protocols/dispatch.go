@@ -0,0 +1,286 @@
+package protocols
+
+import (
+	"encoding/json"
+	"net/http"
+	"task1/internal/auth"
+	"task1/internal/logger"
+	"task1/internal/metrics"
+	"task1/internal/store"
+	"time"
+)
+
+// command is a request Method. Every command in commands is served the same
+// way by each transport, a new command only needs adding here.
+type command struct {
+	// run applies the request, returning the response Data and version
+	run func(storage store.Store, req jsonRequest) (interface{}, uint64, error)
+
+	// permit checks token may run the request. Every key a request reads or
+	// changes needs its own grant, LIST and WATCH need a grant covering the
+	// whole prefix.
+	permit func(acl *auth.ACL, token string, req jsonRequest) error
+
+	// stream commands switch the connection to a stream of events rather
+	// than running once, only transports which can stream accept them
+	stream bool
+
+	// write commands may change the store and are refused in read-only
+	// mode
+	write bool
+
+	// writes narrows write down to the request, a TXN only writes if one of
+	// its ops does
+	writes func(req jsonRequest) bool
+}
+
+var commands = map[string]command{
+	http.MethodGet: {
+		run: func(storage store.Store, req jsonRequest) (interface{}, uint64, error) {
+			return storage.GetVersion(req.Query)
+		},
+		permit: func(acl *auth.ACL, token string, req jsonRequest) error {
+			return acl.Authorize(token, auth.Read, req.Query)
+		},
+	},
+	http.MethodPost: {
+		run: func(storage store.Store, req jsonRequest) (interface{}, uint64, error) {
+			return nil, 0, storage.PostWithTTL(req.Payload, ttlFromRequest(req))
+		},
+		write: true,
+		permit: func(acl *auth.ACL, token string, req jsonRequest) error {
+			for key := range req.Payload {
+				if err := acl.Authorize(token, auth.Write, key); err != nil {
+					return err
+				}
+			}
+
+			return acl.Authenticate(token)
+		},
+	},
+	http.MethodDelete: {
+		run: func(storage store.Store, req jsonRequest) (interface{}, uint64, error) {
+			return nil, 0, storage.Delete(req.Query)
+		},
+		write: true,
+		permit: func(acl *auth.ACL, token string, req jsonRequest) error {
+			return acl.Authorize(token, auth.Delete, req.Query)
+		},
+	},
+	methodCAS: {
+		run: func(storage store.Store, req jsonRequest) (interface{}, uint64, error) {
+			version, err := compareAndSwap(storage, req)
+
+			return nil, version, err
+		},
+		write: true,
+		permit: func(acl *auth.ACL, token string, req jsonRequest) error {
+			return acl.Authorize(token, auth.Write, req.Query)
+		},
+	},
+	methodTxn: {
+		run: func(storage store.Store, req jsonRequest) (interface{}, uint64, error) {
+			results, err := transact(storage, req)
+
+			return results, 0, err
+		},
+		write: true,
+		writes: func(req jsonRequest) bool {
+			for _, op := range req.Ops {
+				if op.Op == store.TxSet || op.Op == store.TxDelete {
+					return true
+				}
+			}
+
+			return false
+		},
+		permit: func(acl *auth.ACL, token string, req jsonRequest) error {
+			for _, op := range req.Ops {
+				if err := acl.Authorize(token, txnPermission(op.Op), op.Key); err != nil {
+					return err
+				}
+			}
+
+			return acl.Authenticate(token)
+		},
+	},
+	methodIncr: {
+		run: func(storage store.Store, req jsonRequest) (interface{}, uint64, error) {
+			return increment(storage, req, false)
+		},
+		write: true,
+		permit: func(acl *auth.ACL, token string, req jsonRequest) error {
+			return acl.Authorize(token, auth.Write, req.Query)
+		},
+	},
+	methodDecr: {
+		run: func(storage store.Store, req jsonRequest) (interface{}, uint64, error) {
+			return increment(storage, req, true)
+		},
+		write: true,
+		permit: func(acl *auth.ACL, token string, req jsonRequest) error {
+			return acl.Authorize(token, auth.Write, req.Query)
+		},
+	},
+	methodUpdate: {
+		run: func(storage store.Store, req jsonRequest) (interface{}, uint64, error) {
+			if req.update == nil {
+				return nil, 0, ErrRouteForbidden
+			}
+
+			return storage.Update(req.Query, req.update)
+		},
+		write: true,
+		permit: func(acl *auth.ACL, token string, req jsonRequest) error {
+			return acl.Authorize(token, auth.Write, req.Query)
+		},
+	},
+	methodList: {
+		run: func(storage store.Store, req jsonRequest) (interface{}, uint64, error) {
+			page, err := storage.List(req.Query, req.Cursor, req.Limit)
+
+			return page, 0, err
+		},
+		permit: permitPrefix,
+	},
+	methodWatch: {
+		permit: permitPrefix,
+		stream: true,
+	},
+	methodExpire: {
+		run: func(storage store.Store, req jsonRequest) (interface{}, uint64, error) {
+			version, err := expire(storage, req)
+
+			return nil, version, err
+		},
+		write: true,
+		permit: func(acl *auth.ACL, token string, req jsonRequest) error {
+			if ttlFromRequest(req) <= 0 {
+				return acl.Authorize(token, auth.Delete, req.Query)
+			}
+
+			return acl.Authorize(token, auth.Write, req.Query)
+		},
+	},
+	methodTTL: {
+		run: func(storage store.Store, req jsonRequest) (interface{}, uint64, error) {
+			ttl, err := storage.TTL(req.Query)
+			if err != nil {
+				return nil, 0, err
+			}
+
+			// -1 for a key without a ttl, as redis reports it
+			if ttl == 0 {
+				return int64(-1), 0, nil
+			}
+
+			// round up so a key with any time left never reports 0
+			return int64((ttl + time.Second - 1) / time.Second), 0, nil
+		},
+		permit: func(acl *auth.ACL, token string, req jsonRequest) error {
+			return acl.Authorize(token, auth.Read, req.Query)
+		},
+	},
+}
+
+func permitPrefix(acl *auth.ACL, token string, req jsonRequest) error {
+	return acl.AuthorizePrefix(token, auth.Read, req.Query)
+}
+
+// reply is a dispatched request's response, both as the JSON body and as
+// the data and error it was built from for transports with their own wire
+// format. If watch is set the transport should go on to stream events for
+// keys starting with req.Query.
+type reply struct {
+	status  int
+	body    []byte
+	data    interface{}
+	version uint64
+	err     error
+	watch   bool
+	req     jsonRequest
+}
+
+// dispatcher runs requests from every transport through commands, so each
+// gets the same authorization, logging and metrics.
+type dispatcher struct {
+	logger  *logger.Logger
+	storage store.Store
+	metrics *metrics.Metrics
+	acl     *auth.ACL
+	mode    *Mode
+}
+
+func newDispatcher(logger *logger.Logger, storage store.Store, metrics *metrics.Metrics) *dispatcher {
+	return &dispatcher{
+		logger:  logger,
+		storage: storage,
+		metrics: metrics,
+	}
+}
+
+// dispatchFrame decodes a JSON request read from protocol and dispatches it.
+func (d *dispatcher) dispatchFrame(protocol string, frame []byte, streaming bool) reply {
+	start := time.Now()
+
+	var req jsonRequest
+	if err := json.Unmarshal(frame, &req); err != nil {
+		return d.respond(protocol, req, start, nil, 0, err)
+	}
+
+	return d.dispatch(protocol, req, streaming, start)
+}
+
+// dispatch runs req, read from protocol at start. Stream commands are refused
+// unless streaming is set.
+func (d *dispatcher) dispatch(protocol string, req jsonRequest, streaming bool, start time.Time) reply {
+	if err := authorize(d.acl, req.Token, req.Method, req); err != nil {
+		return d.respond(protocol, req, start, nil, 0, err)
+	}
+
+	d.metrics.LogMetrics(req.Method)
+
+	cmd, ok := commands[req.Method]
+	if !ok || (cmd.stream && !streaming) {
+		return d.respond(protocol, req, start, nil, 0, ErrRouteForbidden)
+	}
+
+	if err := d.mode.allow(cmd, req); err != nil {
+		return d.respond(protocol, req, start, nil, 0, err)
+	}
+
+	fields := []logger.Field{logger.F("protocol", protocol)}
+	if req.Query != "" {
+		fields = append(fields, logger.F("key", req.Query))
+	}
+	d.logger.Debug(req.Method+" request", fields...)
+
+	if cmd.stream {
+		r := d.respond(protocol, req, start, nil, 0, nil)
+		r.watch = true
+
+		return r
+	}
+
+	data, version, err := cmd.run(d.storage, req)
+
+	return d.respond(protocol, req, start, data, version, err)
+}
+
+// respond builds the response to req and records it in metrics.
+func (d *dispatcher) respond(protocol string, req jsonRequest, start time.Time, data interface{}, version uint64, err error) reply {
+	status, body := BuildVersionedJsonResponse(err, data, version, d.logger)
+	d.metrics.Observe(protocol, methodLabel(req.Method), status, time.Since(start))
+
+	return reply{status: status, body: body, data: data, version: version, err: err, req: req}
+}
+
+// methodLabel is the metrics label for method, methods which are not commands
+// share one label.
+func methodLabel(method string) string {
+	if _, ok := commands[method]; !ok {
+		return metrics.UnknownMethod
+	}
+
+	return method
+}
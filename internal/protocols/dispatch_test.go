@@ -0,0 +1,180 @@
+package protocols
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"task1/internal/logger"
+	"task1/internal/metrics"
+	"task1/internal/store"
+	"testing"
+)
+
+func Test_dispatcher_dispatchFrame(t *testing.T) {
+	type args struct {
+		frame     string
+		streaming bool
+		mode      string
+	}
+	tests := []struct {
+		name      string
+		args      args
+		want      jsonResponse
+		wantWatch bool
+	}{
+		{
+			name: "GET ok",
+			args: args{frame: `{"Method":"GET","Query":"1"}`},
+			want: jsonResponse{Status: http.StatusOK, Data: "hello world", Version: 1},
+		},
+		{
+			name: "CAS ok",
+			args: args{frame: `{"Method":"CAS","Query":"1","Value":"swapped","ExpectedVersion":1}`},
+			want: jsonResponse{Status: http.StatusOK, Version: 2},
+		},
+		{
+			name: "INCR ok - create",
+			args: args{frame: `{"Method":"INCR","Query":"n"}`},
+			want: jsonResponse{Status: http.StatusOK, Data: float64(1), Version: 2},
+		},
+		{
+			name: "DECR ok - delta",
+			args: args{frame: `{"Method":"DECR","Query":"n","Delta":5}`},
+			want: jsonResponse{Status: http.StatusOK, Data: float64(-5), Version: 2},
+		},
+		{
+			name: "INCR fail - not integer",
+			args: args{frame: `{"Method":"INCR","Query":"1"}`},
+			want: jsonResponse{Err: store.ErrNotInteger.Error(), Status: http.StatusBadRequest, Version: 1},
+		},
+		{
+			name: "DECR fail - overflow",
+			args: args{frame: `{"Method":"DECR","Query":"n","Delta":-9223372036854775808}`},
+			want: jsonResponse{Err: store.ErrOverflow.Error(), Status: http.StatusBadRequest},
+		},
+		{
+			name: "WATCH ok - streaming",
+			args: args{frame: `{"Method":"WATCH","Query":"1"}`, streaming: true},
+			want: jsonResponse{Status: http.StatusOK},
+
+			wantWatch: true,
+		},
+		{
+			name: "WATCH fail - not streaming",
+			args: args{frame: `{"Method":"WATCH","Query":"1"}`},
+			want: jsonResponse{Err: ErrRouteForbidden.Error(), Status: http.StatusMethodNotAllowed},
+		},
+		{
+			name: "fail - unknown method",
+			args: args{frame: `{"Method":"PATCH","Query":"1"}`},
+			want: jsonResponse{Err: ErrRouteForbidden.Error(), Status: http.StatusMethodNotAllowed},
+		},
+		{
+			name: "GET ok - read-only",
+			args: args{frame: `{"Method":"GET","Query":"1"}`, mode: ModeReadOnly},
+			want: jsonResponse{Status: http.StatusOK, Data: "hello world", Version: 1},
+		},
+		{
+			name: "POST fail - read-only",
+			args: args{frame: `{"Method":"POST","Payload":{"2":"two"}}`, mode: ModeReadOnly},
+			want: jsonResponse{Err: ErrModeReadOnly.Error(), Status: http.StatusServiceUnavailable},
+		},
+		{
+			name: "TXN ok - read-only reads",
+			args: args{frame: `{"Method":"TXN","Ops":[{"Op":"get","Key":"1"}]}`, mode: ModeReadOnly},
+			want: jsonResponse{Status: http.StatusOK, Data: []interface{}{map[string]interface{}{"Op": "get", "Key": "1", "Value": "hello world", "Version": float64(1)}}},
+		},
+		{
+			name: "TXN fail - read-only write",
+			args: args{frame: `{"Method":"TXN","Ops":[{"Op":"get","Key":"1"},{"Op":"delete","Key":"1"}]}`, mode: ModeReadOnly},
+			want: jsonResponse{Err: ErrModeReadOnly.Error(), Status: http.StatusServiceUnavailable},
+		},
+		{
+			name: "INCR fail - read-only",
+			args: args{frame: `{"Method":"INCR","Query":"n"}`, mode: ModeReadOnly},
+			want: jsonResponse{Err: ErrModeReadOnly.Error(), Status: http.StatusServiceUnavailable},
+		},
+		{
+			name: "GET fail - maintenance",
+			args: args{frame: `{"Method":"GET","Query":"1"}`, mode: ModeMaintenance},
+			want: jsonResponse{Err: ErrModeMaintenance.Error(), Status: http.StatusServiceUnavailable},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := logger.NewLogger()
+			logger.StartNoopLogger()
+			metrics := metrics.NewMetrics(logger)
+			metrics.StartNoopMetrics()
+			storage := store.NewStorage(logger)
+			storage.Post(store.StoreData{"1": "hello world"})
+
+			d := newDispatcher(logger, storage, metrics)
+			if tt.args.mode != "" {
+				d.mode = NewMode()
+				d.mode.Set(tt.args.mode)
+			}
+
+			rep := d.dispatchFrame(protocolTCP, []byte(tt.args.frame), tt.args.streaming)
+
+			var got jsonResponse
+			json.Unmarshal(rep.body, &got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("dispatchFrame() = %+v, want %+v", got, tt.want)
+			}
+			if rep.watch != tt.wantWatch {
+				t.Errorf("dispatchFrame() watch = %v, want %v", rep.watch, tt.wantWatch)
+			}
+		})
+	}
+}
+
+// Every transport records a request under the same method label, including
+// HTTP requests whose command is in the body rather than the HTTP method and
+// requests to the REST routes.
+func Test_dispatcher_metrics(t *testing.T) {
+	logger := logger.NewLogger()
+	logger.StartNoopLogger()
+	metrics := metrics.NewMetrics(logger)
+	metrics.StartNoopMetrics()
+	storage := store.NewStorage(logger)
+	storage.Post(store.StoreData{"1": "hello world"})
+
+	frame := `{"Method":"LIST","Query":""}`
+
+	d := newDispatcher(logger, storage, metrics)
+	d.dispatchFrame(protocolTCP, []byte(frame), true)
+	d.dispatchFrame(protocolUDP, []byte(frame), false)
+
+	hs := NewHTTP(":8080", logger, storage, metrics)
+	hs.rootHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", strings.NewReader(frame)))
+	hs.keysHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, keysPath+"1", nil))
+	d.dispatchFrame(protocolTCP, []byte(`{"Method":"MADE-UP-1"}`), true)
+	d.dispatchFrame(protocolTCP, []byte(`{"Method":"MADE-UP-2"}`), true)
+
+	var out bytes.Buffer
+	metrics.WritePrometheus(&out)
+
+	for _, protocol := range []string{protocolHTTP, protocolTCP, protocolUDP} {
+		want := fmt.Sprintf(`kvstore_requests_total{protocol=%q,method="LIST",status="200"} 1`, protocol)
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("metrics missing %s:\n%s", want, out.String())
+		}
+	}
+
+	for _, want := range []string{
+		`kvstore_requests_total{protocol="http",method="GET",status="200"} 1`,
+		`kvstore_requests_total{protocol="tcp",method="UNKNOWN",status="405"} 2`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("metrics missing %s:\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "MADE-UP") {
+		t.Errorf("metrics has a series for an unknown method:\n%s", out.String())
+	}
+}
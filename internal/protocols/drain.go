@@ -0,0 +1,68 @@
+package protocols
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// shutdownTimeout is how long Stop waits for in-flight requests, Shutdown
+// takes its deadline from the caller.
+const shutdownTimeout = 5 * time.Second
+
+// inflight counts the requests, or conns, a listener is serving so shutdown
+// can wait for them.
+type inflight struct {
+	mutex  sync.Mutex
+	active int
+	ended  int
+	idle   chan struct{}
+}
+
+func (in *inflight) begin() {
+	in.mutex.Lock()
+	in.active++
+	in.mutex.Unlock()
+}
+
+func (in *inflight) end() {
+	in.mutex.Lock()
+	defer in.mutex.Unlock()
+
+	in.active--
+	in.ended++
+
+	if in.active == 0 && in.idle != nil {
+		close(in.idle)
+		in.idle = nil
+	}
+}
+
+// wait returns once nothing is active or ctx is done, with how many ended
+// while waiting and how many were still active at the deadline. The listener
+// must have stopped accepting first.
+func (in *inflight) wait(ctx context.Context) (drained, aborted int) {
+	in.mutex.Lock()
+	if in.active == 0 {
+		in.mutex.Unlock()
+
+		return 0, 0
+	}
+
+	ended := in.ended
+	if in.idle == nil {
+		in.idle = make(chan struct{})
+	}
+	idle := in.idle
+	in.mutex.Unlock()
+
+	select {
+	case <-idle:
+	case <-ctx.Done():
+	}
+
+	in.mutex.Lock()
+	defer in.mutex.Unlock()
+
+	return in.ended - ended, in.active
+}
@@ -0,0 +1,173 @@
+package protocols
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"task1/internal/logger"
+	"task1/internal/metrics"
+	"task1/internal/store"
+	"testing"
+	"time"
+)
+
+func TestInflight_wait(t *testing.T) {
+	tests := []struct {
+		name        string
+		active      int
+		ends        int
+		wantDrained int
+		wantAborted int
+	}{
+		{name: "idle"},
+		{name: "drained", active: 2, ends: 2, wantDrained: 2},
+		{name: "aborted", active: 2, ends: 1, wantDrained: 1, wantAborted: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := &inflight{}
+			for i := 0; i < tt.active; i++ {
+				in.begin()
+			}
+
+			ends := tt.ends
+			go func() {
+				time.Sleep(10 * time.Millisecond)
+				for i := 0; i < ends; i++ {
+					in.end()
+				}
+			}()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+
+			drained, aborted := in.wait(ctx)
+			if drained != tt.wantDrained || aborted != tt.wantAborted {
+				t.Errorf("wait() = %d, %d, want %d, %d", drained, aborted, tt.wantDrained, tt.wantAborted)
+			}
+		})
+	}
+}
+
+func TestConnSet_drainAborts(t *testing.T) {
+	logger := logger.NewLogger()
+	logger.StartNoopLogger()
+
+	cs := newConnSet(protocolTCP, logger, metrics.NewMetrics(logger))
+
+	// a handler busy with a request does not notice the drain
+	server, client := net.Pipe()
+	cs.add(server)
+	defer cs.remove(server)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if drained, aborted := cs.drain(ctx); drained != 0 || aborted != 1 {
+		t.Errorf("drain() = %d, %d, want 0, 1", drained, aborted)
+	}
+
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("read from aborted conn error = %v, want EOF", err)
+	}
+
+	if cs.keepAlive(server, tcpIdleTimeout) {
+		t.Errorf("keepAlive() = true while draining")
+	}
+}
+
+// Every conn is tracked on its own, whatever ID it is logged under.
+func TestConnSet_addRemove(t *testing.T) {
+	logger := logger.NewLogger()
+	logger.StartNoopLogger()
+
+	cs := newConnSet(protocolTCP, logger, metrics.NewMetrics(logger))
+
+	const conns = 64
+	servers := make([]net.Conn, conns)
+	for i := range servers {
+		server, client := net.Pipe()
+		defer client.Close()
+
+		servers[i] = server
+		cs.add(server)
+	}
+
+	if got := len(cs.conns); got != conns {
+		t.Errorf("connSet tracks %d conns, want %d", got, conns)
+	}
+
+	for _, server := range servers {
+		cs.remove(server)
+	}
+
+	if got := len(cs.conns); got != 0 {
+		t.Errorf("connSet tracks %d conns after remove, want 0", got)
+	}
+}
+
+func TestTCPServer_Shutdown(t *testing.T) {
+	logger := logger.NewLogger()
+	logger.StartNoopLogger()
+	metrics := metrics.NewMetrics(logger)
+	metrics.StartNoopMetrics()
+	storage := store.NewStorage(logger)
+	storage.Post(store.StoreData{"1": "one"})
+
+	server := NewTCP("127.0.0.1:0", logger, storage, metrics)
+	server.Start()
+
+	conn, err := net.Dial(tcpnetwork, server.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial tcp server error: %v", err)
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	conn.Write([]byte(`{"Method":"GET","Query":"1"}` + "\n"))
+	if _, err := reader.ReadBytes('\n'); err != nil {
+		t.Fatalf("read response error: %v", err)
+	}
+
+	// the conn is idle waiting for its next request
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if drained, aborted := server.Shutdown(ctx); drained != 1 || aborted != 0 {
+		t.Errorf("Shutdown() = %d, %d, want 1, 0", drained, aborted)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Errorf("read after Shutdown error = %v, want EOF", err)
+	}
+
+	if _, err := net.Dial(tcpnetwork, server.listener.Addr().String()); err == nil {
+		t.Errorf("dial after Shutdown succeeded")
+	}
+}
+
+func TestUDPServer_Shutdown(t *testing.T) {
+	logger := logger.NewLogger()
+	logger.StartNoopLogger()
+	metrics := metrics.NewMetrics(logger)
+	metrics.StartNoopMetrics()
+
+	server := NewUDP("127.0.0.1:0", logger, store.NewStorage(logger), metrics)
+	server.Start()
+
+	// the read loop is blocked waiting for a datagram
+	done := make(chan struct{})
+	go func() {
+		server.Stop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Stop() did not return with no datagrams arriving")
+	}
+}
@@ -0,0 +1,108 @@
+package protocols
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// A TCP stream carries either newline-delimited JSON or length-prefixed
+// frames, chosen per request by the first byte. Length-prefixed frames start
+// with a 4 byte big-endian length whose first byte is always 0 because frames
+// are capped at maxFrameSize, while JSON requests can never start with a 0
+// byte.
+const (
+	framingLine = iota
+	framingLength
+)
+
+const (
+	prefixLen    = 4
+	maxFrameSize = 4 << 20
+)
+
+var ErrFrameTooLarge = errors.New("frame too large")
+
+// readFrame reads the next request from r and reports which framing it used
+// so the response can be written back the same way. A final line without a
+// trailing newline is returned along with io.EOF.
+func readFrame(r *bufio.Reader) ([]byte, int, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, framingLine, err
+	}
+
+	if first[0] == 0 {
+		frame, err := readLengthFrame(r)
+
+		return frame, framingLength, err
+	}
+
+	line, err := readLine(r)
+
+	return line, framingLine, err
+}
+
+func readLengthFrame(r *bufio.Reader) ([]byte, error) {
+	prefix := make([]byte, prefixLen)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return nil, err
+	}
+
+	size := binary.BigEndian.Uint32(prefix)
+	if size > maxFrameSize {
+		return nil, ErrFrameTooLarge
+	}
+
+	frame := make([]byte, size)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return nil, err
+	}
+
+	return frame, nil
+}
+
+func readLine(r *bufio.Reader) ([]byte, error) {
+	var line []byte
+
+	for {
+		chunk, err := r.ReadSlice('\n')
+		line = append(line, chunk...)
+
+		if len(line) > maxFrameSize {
+			return nil, ErrFrameTooLarge
+		}
+
+		switch {
+		case err == nil:
+			return line, nil
+		case errors.Is(err, bufio.ErrBufferFull):
+			continue
+		default:
+			return line, err
+		}
+	}
+}
+
+// writeFrame writes a response using the framing of the request it answers.
+func writeFrame(w *bufio.Writer, framing int, data []byte) error {
+	if framing == framingLength {
+		prefix := make([]byte, prefixLen)
+		binary.BigEndian.PutUint32(prefix, uint32(len(data)))
+
+		if _, err := w.Write(prefix); err != nil {
+			return err
+		}
+
+		_, err := w.Write(data)
+
+		return err
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+
+	return w.WriteByte('\n')
+}
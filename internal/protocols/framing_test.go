@@ -0,0 +1,133 @@
+package protocols
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_readFrame(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		want        []string
+		wantFraming int
+		wantErr     error
+	}{
+		{
+			name:        "line - single request",
+			input:       "{\"Method\":\"GET\"}\n",
+			want:        []string{"{\"Method\":\"GET\"}\n"},
+			wantFraming: framingLine,
+			wantErr:     io.EOF,
+		},
+		{
+			name:        "line - pipelined requests",
+			input:       "{\"Query\":\"1\"}\n{\"Query\":\"2\"}\n",
+			want:        []string{"{\"Query\":\"1\"}\n", "{\"Query\":\"2\"}\n"},
+			wantFraming: framingLine,
+			wantErr:     io.EOF,
+		},
+		{
+			name:        "line - no trailing newline",
+			input:       `{"Query":"1"}`,
+			want:        []string{`{"Query":"1"}`},
+			wantFraming: framingLine,
+			wantErr:     io.EOF,
+		},
+		{
+			name:        "length - pipelined requests",
+			input:       "\x00\x00\x00\x03abc\x00\x00\x00\x02de",
+			want:        []string{"abc", "de"},
+			wantFraming: framingLength,
+			wantErr:     io.EOF,
+		},
+		{
+			name:        "length - truncated frame",
+			input:       "\x00\x00\x00\x05abc",
+			want:        []string{},
+			wantFraming: framingLength,
+			wantErr:     io.ErrUnexpectedEOF,
+		},
+		{
+			name:        "length - too large",
+			input:       "\x00\x50\x00\x00abc",
+			want:        []string{},
+			wantFraming: framingLength,
+			wantErr:     ErrFrameTooLarge,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bufio.NewReader(strings.NewReader(tt.input))
+			got := []string{}
+
+			var err error
+			for {
+				var (
+					frame   []byte
+					framing int
+				)
+
+				frame, framing, err = readFrame(r)
+				if len(frame) > 0 {
+					got = append(got, string(frame))
+					if framing != tt.wantFraming {
+						t.Errorf("readFrame() framing = %v, want %v", framing, tt.wantFraming)
+					}
+				}
+				if err != nil {
+					break
+				}
+			}
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("readFrame() error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readFrame() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_writeFrame(t *testing.T) {
+	tests := []struct {
+		name    string
+		framing int
+		data    string
+		want    string
+	}{
+		{
+			name:    "line",
+			framing: framingLine,
+			data:    `{"Status":200}`,
+			want:    "{\"Status\":200}\n",
+		},
+		{
+			name:    "length",
+			framing: framingLength,
+			data:    `{"Status":200}`,
+			want:    "\x00\x00\x00\x0e{\"Status\":200}",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := bufio.NewWriter(&buf)
+
+			if err := writeFrame(w, tt.framing, []byte(tt.data)); err != nil {
+				t.Errorf("writeFrame() error = %v", err)
+			}
+			w.Flush()
+
+			if got := buf.String(); got != tt.want {
+				t.Errorf("writeFrame() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
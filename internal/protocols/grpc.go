@@ -0,0 +1,438 @@
+package protocols
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	kvstorev1 "task1/api/kvstore/v1"
+	"task1/internal/auth"
+	"task1/internal/logger"
+	"task1/internal/metrics"
+	"task1/internal/store"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// metadataAuthorization is the gRPC metadata key carrying the API token, as
+// "Bearer <token>" like the HTTP header.
+const metadataAuthorization = "authorization"
+
+var ErrWatchOverflow = errors.New("watch fell behind, watch again")
+
+// GRPCServer serves the KVService defined in api/kvstore/v1/kvstore.proto.
+// Every call is translated to a jsonRequest and runs through the shared
+// dispatcher, so a gRPC Get is authorized and counted like a GET from any
+// other protocol.
+type GRPCServer struct {
+	kvstorev1.UnimplementedKVServiceServer
+
+	listener   net.Listener
+	server     *grpc.Server
+	done       chan struct{}
+	requests   *inflight
+	state      *listenerState
+	logger     *logger.Logger
+	storage    store.Store
+	dispatcher *dispatcher
+}
+
+func NewGRPC(
+	addr string,
+	logger *logger.Logger,
+	storage store.Store,
+	metrics *metrics.Metrics,
+) *GRPCServer {
+
+	lis, err := net.Listen(tcpnetwork, addr)
+	if err != nil {
+		panic(err)
+	}
+
+	requests := &inflight{}
+
+	gs := &GRPCServer{
+		listener:   lis,
+		server:     grpc.NewServer(grpc.StatsHandler(grpcConns{metrics: metrics, requests: requests})),
+		done:       make(chan struct{}),
+		requests:   requests,
+		state:      newListenerState(protocolGRPC, lis.Addr().String()),
+		logger:     logger,
+		storage:    storage,
+		dispatcher: newDispatcher(logger, storage, metrics),
+	}
+
+	kvstorev1.RegisterKVServiceServer(gs.server, gs)
+
+	return gs
+}
+
+// SetTLS accepts only TLS connections, using the certificate in t. It must
+// be called before Start.
+func (gs *GRPCServer) SetTLS(t *TLS) {
+	config := t.Config()
+
+	// gRPC clients refuse a TLS connection which did not negotiate HTTP/2,
+	// so offer it on the config picked for each handshake
+	configForClient := config.GetConfigForClient
+	config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		c, err := configForClient(hello)
+		if err != nil {
+			return nil, err
+		}
+
+		c = c.Clone()
+		c.NextProtos = []string{"h2"}
+
+		return c, nil
+	}
+
+	gs.listener = tls.NewListener(gs.listener, config)
+}
+
+// SetACL requires every call to carry an allowed token in its
+// "authorization" metadata. It must be called before Start.
+func (gs *GRPCServer) SetACL(acl *auth.ACL) {
+	gs.dispatcher.acl = acl
+}
+
+// SetMode refuses the calls mode does not allow. It must be called before
+// Start.
+func (gs *GRPCServer) SetMode(mode *Mode) {
+	gs.dispatcher.mode = mode
+}
+
+func (gs GRPCServer) Status() ListenerStatus {
+	return gs.state.get()
+}
+
+func (gs GRPCServer) Start() {
+	log.Printf("grpc listening on %s", gs.listener.Addr().String())
+	gs.state.set(StateServing)
+
+	go func() {
+		if err := gs.server.Serve(gs.listener); err != nil {
+			log.Printf("grpc listener error: %v", err)
+			gs.state.fail(err)
+		}
+	}()
+}
+
+func (gs GRPCServer) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	gs.Shutdown(ctx)
+}
+
+// Shutdown stops accepting and waits until ctx is done for in-flight calls,
+// then cancels the calls still running. It returns how many calls were
+// drained and how many aborted.
+func (gs GRPCServer) Shutdown(ctx context.Context) (drained, aborted int) {
+	gs.state.set(StateStopping)
+
+	// end watch streams, GracefulStop waits for them otherwise
+	close(gs.done)
+
+	stopped := make(chan struct{})
+	go func() {
+		gs.server.GracefulStop()
+		close(stopped)
+	}()
+
+	drained, aborted = gs.requests.wait(ctx)
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		gs.server.Stop()
+		<-stopped
+	}
+	gs.state.set(StateStopped)
+	log.Printf("gRPC shutdown ok, %d calls drained, %d aborted", drained, aborted)
+
+	return drained, aborted
+}
+
+func (gs *GRPCServer) Get(ctx context.Context, in *kvstorev1.GetRequest) (*kvstorev1.GetResponse, error) {
+	rep := gs.dispatch(ctx, jsonRequest{Method: http.MethodGet, Query: in.GetKey()}, false)
+	if rep.err != nil {
+		return nil, grpcError(rep)
+	}
+
+	value, err := protoValue(rep.data)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &kvstorev1.GetResponse{Value: value, Version: rep.version}, nil
+}
+
+func (gs *GRPCServer) Put(ctx context.Context, in *kvstorev1.PutRequest) (*kvstorev1.PutResponse, error) {
+	payload := make(map[string]interface{}, len(in.GetItems()))
+	for key, value := range in.GetItems() {
+		payload[key] = value.AsInterface()
+	}
+
+	rep := gs.dispatch(ctx, jsonRequest{Method: http.MethodPost, Payload: payload, TTL: in.GetTtlSeconds(), TTLMillis: in.GetTtlMillis()}, false)
+	if rep.err != nil {
+		return nil, grpcError(rep)
+	}
+
+	return &kvstorev1.PutResponse{}, nil
+}
+
+func (gs *GRPCServer) Delete(ctx context.Context, in *kvstorev1.DeleteRequest) (*kvstorev1.DeleteResponse, error) {
+	rep := gs.dispatch(ctx, jsonRequest{Method: http.MethodDelete, Query: in.GetKey()}, false)
+	if rep.err != nil {
+		return nil, grpcError(rep)
+	}
+
+	return &kvstorev1.DeleteResponse{}, nil
+}
+
+func (gs *GRPCServer) Increment(ctx context.Context, in *kvstorev1.IncrementRequest) (*kvstorev1.IncrementResponse, error) {
+	delta := in.GetDelta()
+
+	rep := gs.dispatch(ctx, jsonRequest{Method: methodIncr, Query: in.GetKey(), Delta: &delta}, false)
+	if rep.err != nil {
+		return nil, grpcError(rep)
+	}
+
+	return &kvstorev1.IncrementResponse{Value: rep.data.(int64), Version: rep.version}, nil
+}
+
+func (gs *GRPCServer) List(ctx context.Context, in *kvstorev1.ListRequest) (*kvstorev1.ListResponse, error) {
+	req := jsonRequest{
+		Method: methodList,
+		Query:  in.GetPrefix(),
+		Cursor: in.GetCursor(),
+		Limit:  int(in.GetLimit()),
+	}
+
+	rep := gs.dispatch(ctx, req, false)
+	if rep.err != nil {
+		return nil, grpcError(rep)
+	}
+
+	page := rep.data.(store.ListPage)
+
+	return &kvstorev1.ListResponse{Keys: page.Keys, Next: page.Next}, nil
+}
+
+// Watch streams events until the client cancels the call or the server
+// stops. As over TCP, a watcher which falls behind has its stream ended.
+func (gs *GRPCServer) Watch(in *kvstorev1.WatchRequest, stream kvstorev1.KVService_WatchServer) error {
+	rep := gs.dispatch(stream.Context(), jsonRequest{Method: methodWatch, Query: in.GetPrefix()}, true)
+	if rep.err != nil {
+		return grpcError(rep)
+	}
+
+	events, cancel := gs.storage.Watch(in.GetPrefix())
+	defer cancel()
+
+	gs.logger.Debug("watching prefix", logger.F("protocol", protocolGRPC), logger.F("prefix", in.GetPrefix()))
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, ErrWatchOverflow.Error())
+			}
+
+			out, err := protoEvent(event)
+			if err != nil {
+				return status.Error(codes.Internal, err.Error())
+			}
+
+			if err := stream.Send(&kvstorev1.WatchResponse{Event: out}); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		case <-gs.done:
+			return nil
+		}
+	}
+}
+
+func (gs *GRPCServer) Batch(ctx context.Context, in *kvstorev1.BatchRequest) (*kvstorev1.BatchResponse, error) {
+	ops := make([]jsonOp, len(in.GetOps()))
+	for i, op := range in.GetOps() {
+		ops[i] = jsonOp{
+			Op:        txnOps[op.GetType()],
+			Key:       op.GetKey(),
+			Value:     op.GetValue().AsInterface(),
+			TTL:       op.GetTtlSeconds(),
+			TTLMillis: op.GetTtlMillis(),
+			Version:   op.GetVersion(),
+		}
+	}
+
+	rep := gs.dispatch(ctx, jsonRequest{Method: methodTxn, Ops: ops}, false)
+	if rep.err != nil {
+		return nil, grpcError(rep)
+	}
+
+	results := rep.data.([]store.TxResult)
+
+	out := make([]*kvstorev1.Result, len(results))
+	for i, result := range results {
+		out[i] = &kvstorev1.Result{
+			Type:    in.GetOps()[i].GetType(),
+			Key:     result.Key,
+			Version: result.Version,
+			Err:     result.Err,
+		}
+
+		if result.Op == store.TxGet && result.Err == "" {
+			value, err := protoValue(result.Value)
+			if err != nil {
+				return nil, status.Error(codes.Internal, err.Error())
+			}
+
+			out[i].Value = value
+		}
+	}
+
+	return &kvstorev1.BatchResponse{Results: out}, nil
+}
+
+// txnOps maps Batch op types to TXN ops. TYPE_UNSPECIFIED maps to no op, so
+// the transaction fails as invalid.
+var txnOps = map[kvstorev1.Op_Type]string{
+	kvstorev1.Op_TYPE_GET:           store.TxGet,
+	kvstorev1.Op_TYPE_SET:           store.TxSet,
+	kvstorev1.Op_TYPE_DELETE:        store.TxDelete,
+	kvstorev1.Op_TYPE_CHECK_VERSION: store.TxCheckVersion,
+}
+
+// dispatch runs req with the token from the call's metadata.
+func (gs *GRPCServer) dispatch(ctx context.Context, req jsonRequest, streaming bool) reply {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(metadataAuthorization); len(values) > 0 {
+			req.Token = bearerToken(values[0])
+		}
+	}
+
+	return gs.dispatcher.dispatch(protocolGRPC, req, streaming, time.Now())
+}
+
+// grpcError converts a failed reply to a gRPC status, keeping the store
+// error as the message.
+func grpcError(rep reply) error {
+	code := codes.Internal
+
+	switch rep.status {
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusBadRequest:
+		code = codes.InvalidArgument
+	case http.StatusConflict:
+		code = codes.Aborted
+	case http.StatusMisdirectedRequest:
+		code = codes.FailedPrecondition
+	case http.StatusUnauthorized:
+		code = codes.Unauthenticated
+	case http.StatusForbidden:
+		code = codes.PermissionDenied
+	case http.StatusInsufficientStorage:
+		code = codes.ResourceExhausted
+	case http.StatusMethodNotAllowed:
+		code = codes.Unimplemented
+	case http.StatusServiceUnavailable:
+		code = codes.Unavailable
+	}
+
+	// an empty store has no keys to find
+	if errors.Is(rep.err, store.ErrStoreEmpty) {
+		code = codes.NotFound
+	}
+
+	return status.Error(code, rep.err.Error())
+}
+
+// protoValue converts a stored value to a protobuf Value. Values which are
+// not already JSON types, such as those written in-process, are converted
+// through their JSON encoding.
+func protoValue(v interface{}) (*structpb.Value, error) {
+	value, err := structpb.NewValue(v)
+	if err == nil {
+		return value, nil
+	}
+
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		return nil, err
+	}
+
+	return structpb.NewValue(decoded)
+}
+
+// protoEvent converts a store event, leaving Old and New unset when the key
+// did not exist before or after the change.
+func protoEvent(event store.Event) (*kvstorev1.Event, error) {
+	out := &kvstorev1.Event{Op: event.Op, Key: event.Key, Version: event.Version}
+
+	var err error
+	if event.Old != nil {
+		if out.Old, err = protoValue(event.Old); err != nil {
+			return nil, err
+		}
+	}
+
+	if event.New != nil {
+		if out.New, err = protoValue(event.New); err != nil {
+			return nil, err
+		}
+	}
+
+	return out, nil
+}
+
+// grpcConns counts gRPC connections in metrics like the other listeners',
+// and the calls in flight so Shutdown can wait for them.
+type grpcConns struct {
+	metrics  *metrics.Metrics
+	requests *inflight
+}
+
+func (gc grpcConns) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (gc grpcConns) HandleRPC(_ context.Context, s stats.RPCStats) {
+	switch s.(type) {
+	case *stats.Begin:
+		gc.requests.begin()
+	case *stats.End:
+		gc.requests.end()
+	}
+}
+
+func (gc grpcConns) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (gc grpcConns) HandleConn(_ context.Context, s stats.ConnStats) {
+	switch s.(type) {
+	case *stats.ConnBegin:
+		gc.metrics.ConnOpened(protocolGRPC)
+	case *stats.ConnEnd:
+		gc.metrics.ConnClosed(protocolGRPC)
+	}
+}
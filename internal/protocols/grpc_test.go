@@ -0,0 +1,427 @@
+package protocols
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"reflect"
+	"task1/client"
+	"task1/internal/logger"
+	"task1/internal/metrics"
+	"task1/internal/store"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// newTestGRPC starts a gRPC server over a store holding "1" and returns a
+// client for it.
+func newTestGRPC(t *testing.T, acl bool, opts ...client.Option) (*client.Client, store.Store) {
+	t.Helper()
+
+	logger := logger.NewLogger()
+	logger.StartNoopLogger()
+	metrics := metrics.NewMetrics(logger)
+	metrics.StartNoopMetrics()
+	storage := store.NewStorage(logger)
+	storage.Post(store.StoreData{"1": "hello world"})
+
+	server := NewGRPC("127.0.0.1:0", logger, storage, metrics)
+	if acl {
+		server.SetACL(testACL(t))
+	}
+	server.Start()
+	t.Cleanup(server.Stop)
+
+	c, err := client.Dial(server.listener.Addr().String(), opts...)
+	if err != nil {
+		t.Fatalf("failed to dial grpc server error: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+
+	return c, storage
+}
+
+func TestGRPCServer_Get(t *testing.T) {
+	tests := []struct {
+		name        string
+		key         string
+		want        interface{}
+		wantVersion uint64
+		wantErr     error
+	}{
+		{
+			name:        "ok",
+			key:         "1",
+			want:        "hello world",
+			wantVersion: 1,
+		},
+		{
+			name:    "fail - not found",
+			key:     "2",
+			wantErr: client.ErrNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newTestGRPC(t, false)
+
+			got, version, err := c.Get(context.Background(), tt.key)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) || version != tt.wantVersion {
+				t.Errorf("Get() = %v, %d, want %v, %d", got, version, tt.want, tt.wantVersion)
+			}
+		})
+	}
+}
+
+func TestGRPCServer_PutDeleteList(t *testing.T) {
+	c, storage := newTestGRPC(t, false)
+	ctx := context.Background()
+
+	items := map[string]interface{}{
+		"app/1": "one",
+		"app/2": map[string]interface{}{"a": float64(1), "b": []interface{}{true, nil}},
+		"app/3": float64(3),
+	}
+	if err := c.Put(ctx, items, time.Minute); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	got, err := storage.Get("app/2")
+	if err != nil || !reflect.DeepEqual(got, items["app/2"]) {
+		t.Fatalf("stored app/2 = %v, %v, want %v", got, err, items["app/2"])
+	}
+
+	if ttl, _ := storage.TTL("app/1"); ttl <= 0 || ttl > time.Minute {
+		t.Errorf("stored app/1 ttl = %v, want up to 1m", ttl)
+	}
+
+	if err := c.Delete(ctx, "app/3"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	if err := c.Delete(ctx, "app/3"); !errors.Is(err, client.ErrNotFound) {
+		t.Fatalf("Delete() again error = %v, want %v", err, client.ErrNotFound)
+	}
+
+	keys, next, err := c.List(ctx, "app/", "", 1)
+	if err != nil || !reflect.DeepEqual(keys, []string{"app/1"}) || next != "app/1" {
+		t.Fatalf("List() = %v, %q, %v, want [app/1], app/1", keys, next, err)
+	}
+
+	keys, next, err = c.List(ctx, "app/", next, 1)
+	if err != nil || !reflect.DeepEqual(keys, []string{"app/2"}) {
+		t.Fatalf("List() page 2 = %v, %q, %v, want [app/2]", keys, next, err)
+	}
+
+	if _, _, err := c.List(ctx, "", "", 5000); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("List() limit 5000 error = %v, want InvalidArgument", err)
+	}
+}
+
+// TTLs are sent in milliseconds, so a sub-second ttl is kept rather than
+// truncated to 0, which would keep the key forever.
+func TestGRPCServer_ttl(t *testing.T) {
+	tests := []struct {
+		name    string
+		ttl     time.Duration
+		batch   bool
+		wantTTL time.Duration
+		wantErr error
+	}{
+		{
+			name:    "Put ok - sub-second",
+			ttl:     1500 * time.Millisecond,
+			wantTTL: 1500 * time.Millisecond,
+		},
+		{
+			name:    "Batch ok - sub-second",
+			ttl:     250 * time.Millisecond,
+			batch:   true,
+			wantTTL: 250 * time.Millisecond,
+		},
+		{
+			name:    "Put fail - under a millisecond",
+			ttl:     500 * time.Microsecond,
+			wantErr: client.ErrTTLTooShort,
+		},
+		{
+			name:    "Batch fail - under a millisecond",
+			ttl:     500 * time.Microsecond,
+			batch:   true,
+			wantErr: client.ErrTTLTooShort,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, storage := newTestGRPC(t, false)
+			ctx := context.Background()
+
+			var err error
+			if tt.batch {
+				_, err = c.Batch(ctx, []client.Op{{Type: client.OpSet, Key: "ttl", Value: "hello", TTL: tt.ttl}})
+			} else {
+				err = c.Put(ctx, map[string]interface{}{"ttl": "hello"}, tt.ttl)
+			}
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr != nil {
+				if _, err := storage.Get("ttl"); !errors.Is(err, store.ErrStoreKeyNotFound) {
+					t.Errorf("stored ttl error = %v, want %v", err, store.ErrStoreKeyNotFound)
+				}
+				return
+			}
+
+			if ttl, _ := storage.TTL("ttl"); ttl <= 0 || ttl > tt.wantTTL {
+				t.Errorf("stored ttl ttl = %v, want up to %v", ttl, tt.wantTTL)
+			}
+		})
+	}
+}
+
+func TestGRPCServer_Increment(t *testing.T) {
+	tests := []struct {
+		name        string
+		key         string
+		delta       int64
+		want        int64
+		wantVersion uint64
+		wantCode    codes.Code
+	}{
+		{
+			name:        "ok - create",
+			key:         "n",
+			delta:       3,
+			want:        3,
+			wantVersion: 2,
+		},
+		{
+			name:        "ok - decrement",
+			key:         "n",
+			delta:       -3,
+			want:        -3,
+			wantVersion: 2,
+		},
+		{
+			name:     "fail - not integer",
+			key:      "1",
+			delta:    1,
+			wantCode: codes.InvalidArgument,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newTestGRPC(t, false)
+
+			got, version, err := c.Increment(context.Background(), tt.key, tt.delta)
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("Increment() error = %v, want %v", err, tt.wantCode)
+			}
+
+			if got != tt.want || version != tt.wantVersion {
+				t.Errorf("Increment() = %v, %d, want %v, %d", got, version, tt.want, tt.wantVersion)
+			}
+		})
+	}
+}
+
+func TestGRPCServer_Batch(t *testing.T) {
+	tests := []struct {
+		name    string
+		ops     []client.Op
+		want    []client.Result
+		wantErr error
+	}{
+		{
+			name: "ok",
+			ops: []client.Op{
+				{Type: client.OpCheckVersion, Key: "1", Version: 1},
+				{Type: client.OpSet, Key: "2", Value: "two"},
+				{Type: client.OpDelete, Key: "1"},
+				{Type: client.OpGet, Key: "2"},
+				{Type: client.OpGet, Key: "1"},
+			},
+			want: []client.Result{
+				{Type: client.OpCheckVersion, Key: "1", Version: 1},
+				{Type: client.OpSet, Key: "2", Version: 2},
+				{Type: client.OpDelete, Key: "1", Version: 1},
+				{Type: client.OpGet, Key: "2", Value: "two", Version: 2},
+				{Type: client.OpGet, Key: "1", Err: store.ErrStoreKeyNotFound.Error()},
+			},
+		},
+		{
+			name: "fail - version conflict",
+			ops: []client.Op{
+				{Type: client.OpCheckVersion, Key: "1", Version: 5},
+				{Type: client.OpDelete, Key: "1"},
+			},
+			wantErr: client.ErrConflict,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newTestGRPC(t, false)
+
+			got, err := c.Batch(context.Background(), tt.ops)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Batch() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Batch() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGRPCServer_Watch(t *testing.T) {
+	c, storage := newTestGRPC(t, false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	watcher, err := c.Watch(ctx, "app/")
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+
+	// the watch is only registered once the server has the call, retry the
+	// write until the first event arrives
+	events := make(chan client.Event)
+	go func() {
+		for {
+			event, err := watcher.Recv()
+			if err != nil {
+				close(events)
+
+				return
+			}
+			events <- event
+		}
+	}()
+
+	deadline := time.After(time.Second)
+
+	var got client.Event
+	for got.Key == "" {
+		storage.Post(store.StoreData{"other": 1, "app/1": "one"})
+
+		select {
+		case got = <-events:
+		case <-time.After(50 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("no watch event")
+		}
+	}
+
+	if got.Op != store.OpPost || got.Key != "app/1" || got.New != "one" {
+		t.Fatalf("first event = %+v, want POST app/1 one", got)
+	}
+
+	storage.Delete("app/1")
+
+	for got.Op != store.OpDelete {
+		select {
+		case got = <-events:
+		case <-time.After(time.Second):
+			t.Fatal("no delete event")
+		}
+	}
+
+	if got.Key != "app/1" || got.Old != "one" || got.New != nil {
+		t.Errorf("delete event = %+v, want app/1 one -> nil", got)
+	}
+}
+
+func TestGRPCServer_acl(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []client.Option
+		key      string
+		wantCode codes.Code
+	}{
+		{
+			name:     "ok",
+			opts:     []client.Option{client.WithToken("app-token")},
+			key:      "app/1",
+			wantCode: codes.NotFound,
+		},
+		{
+			name:     "fail - no token",
+			key:      "app/1",
+			wantCode: codes.Unauthenticated,
+		},
+		{
+			name:     "fail - outside grant",
+			opts:     []client.Option{client.WithToken("app-token")},
+			key:      "1",
+			wantCode: codes.PermissionDenied,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newTestGRPC(t, true, tt.opts...)
+
+			_, _, err := c.Get(context.Background(), tt.key)
+
+			// the client only wraps codes callers handle, such as NotFound
+			code := status.Code(err)
+			if errors.Is(err, client.ErrNotFound) {
+				code = codes.NotFound
+			}
+
+			if code != tt.wantCode {
+				t.Errorf("Get() error = %v, want code %v", err, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestGRPCServer_TLS(t *testing.T) {
+	ca := newTestCA(t)
+	certFile, keyFile := ca.issue(t, t.TempDir(), 2)
+
+	certs, err := NewTLS(certFile, keyFile, "")
+	if err != nil {
+		t.Fatalf("NewTLS() error = %v", err)
+	}
+
+	logger := logger.NewLogger()
+	logger.StartNoopLogger()
+	metrics := metrics.NewMetrics(logger)
+	metrics.StartNoopMetrics()
+	storage := store.NewStorage(logger)
+	storage.Post(store.StoreData{"1": "hello world"})
+
+	server := NewGRPC("127.0.0.1:0", logger, storage, metrics)
+	server.SetTLS(certs)
+	server.Start()
+	defer server.Stop()
+
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca.pem)
+
+	// the client requires the server to negotiate h2 over ALPN
+	c, err := client.Dial(server.listener.Addr().String(), client.WithTLS(&tls.Config{RootCAs: pool}))
+	if err != nil {
+		t.Fatalf("failed to dial grpc server error: %v", err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if got, _, err := c.Get(ctx, "1"); err != nil || got != "hello world" {
+		t.Errorf("Get() over tls = %v, %v, want hello world", got, err)
+	}
+}
@@ -3,12 +3,22 @@ package protocols
 import (
 	"encoding/json"
 	"errors"
+	"math"
 	"net/http"
+	"task1/internal/auth"
 	"task1/internal/logger"
 	"task1/internal/store"
+	"time"
 )
 
-var ErrRouteForbidden = errors.New("method forbidden")
+// expireRetries bounds how often EXPIRE retries when the key is written
+// between reading its value and setting the ttl.
+const expireRetries = 8
+
+var (
+	ErrRouteForbidden       = errors.New("method forbidden")
+	ErrStreamingUnsupported = errors.New("streaming unsupported")
+)
 
 func statusFromError(err error) int {
 	switch {
@@ -16,31 +26,143 @@ func statusFromError(err error) int {
 		return http.StatusOK
 	case errors.Is(err, store.ErrStoreKeyNotFound):
 		return http.StatusNotFound
-	case errors.Is(err, store.ErrKeyEmpty):
+	case errors.Is(err, store.ErrKeyEmpty),
+		errors.Is(err, store.ErrTTLInvalid),
+		errors.Is(err, store.ErrTTLTooShort),
+		errors.Is(err, store.ErrCASNoCondition),
+		errors.Is(err, store.ErrTxnEmpty),
+		errors.Is(err, store.ErrTxnOpInvalid),
+		errors.Is(err, store.ErrLimitInvalid),
+		errors.Is(err, store.ErrNotInteger),
+		errors.Is(err, store.ErrOverflow):
 		return http.StatusBadRequest
+	case errors.Is(err, store.ErrCASConflict):
+		return http.StatusConflict
+	case errors.Is(err, store.ErrReadOnly):
+		// the write has to be sent to the leader instead
+		return http.StatusMisdirectedRequest
+	case errors.Is(err, auth.ErrUnauthorized):
+		return http.StatusUnauthorized
+	case errors.Is(err, auth.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, store.ErrStoreFull):
+		return http.StatusInsufficientStorage
 	case errors.Is(err, ErrRouteForbidden):
 		return http.StatusMethodNotAllowed
+	case errors.Is(err, ErrModeInvalid):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrModeReadOnly),
+		errors.Is(err, ErrModeMaintenance),
+		errors.Is(err, ErrNotReady):
+		return http.StatusServiceUnavailable
 	default:
 		return http.StatusInternalServerError
 	}
 }
 
-func BuildJsonResponse(err error, data interface{}, logger *logger.Logger) (int, []byte) {
+// ttlFromRequest converts the request TTL, given in seconds, or TTLMillis
+// if set, to a duration.
+func ttlFromRequest(req jsonRequest) time.Duration {
+	return ttl(req.TTL, req.TTLMillis)
+}
+
+func ttl(seconds, millis int64) time.Duration {
+	if millis != 0 {
+		return time.Duration(millis) * time.Millisecond
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+// compareAndSwap runs a CAS request against storage.
+func compareAndSwap(storage store.Store, req jsonRequest) (uint64, error) {
+	return storage.CompareAndSwap(req.Query, req.Value, req.ExpectedVersion, req.Expected, ttlFromRequest(req))
+}
+
+// expire runs an EXPIRE request against storage, keeping the key's value but
+// replacing its ttl. A TTL of 0 or less deletes the key.
+func expire(storage store.Store, req jsonRequest) (uint64, error) {
+	if ttlFromRequest(req) <= 0 {
+		return 0, storage.Delete(req.Query)
+	}
+
+	for i := 0; ; i++ {
+		value, version, err := storage.GetVersion(req.Query)
+		if err != nil {
+			return 0, err
+		}
+
+		version, err = storage.CompareAndSwap(req.Query, value, &version, nil, ttlFromRequest(req))
+		if !errors.Is(err, store.ErrCASConflict) || i == expireRetries {
+			return version, err
+		}
+	}
+}
+
+// increment runs an INCR request against storage, or a DECR if negate is
+// set, returning the new value.
+func increment(storage store.Store, req jsonRequest, negate bool) (interface{}, uint64, error) {
+	delta := int64(1)
+	if req.Delta != nil {
+		delta = *req.Delta
+	}
+
+	if negate {
+		// -math.MinInt64 does not fit in an int64
+		if delta == math.MinInt64 {
+			return nil, 0, store.ErrOverflow
+		}
+
+		delta = -delta
+	}
+
+	value, version, err := storage.Increment(req.Query, delta)
+	if err != nil {
+		return nil, version, err
+	}
+
+	return value, version, nil
+}
+
+// transact runs a TXN request against storage.
+func transact(storage store.Store, req jsonRequest) ([]store.TxResult, error) {
+	ops := make([]store.TxOp, len(req.Ops))
+	for i, op := range req.Ops {
+		ops[i] = store.TxOp{
+			Op:      op.Op,
+			Key:     op.Key,
+			Value:   op.Value,
+			TTL:     ttl(op.TTL, op.TTLMillis),
+			Version: op.Version,
+		}
+	}
+
+	return storage.Transact(ops)
+}
+
+func BuildJsonResponse(err error, data interface{}, l *logger.Logger) (int, []byte) {
+	return BuildVersionedJsonResponse(err, data, 0, l)
+}
+
+// BuildVersionedJsonResponse is BuildJsonResponse for responses carrying the
+// version of a key. A version of 0 is left out of the response.
+func BuildVersionedJsonResponse(err error, data interface{}, version uint64, l *logger.Logger) (int, []byte) {
 	res := jsonResponse{
-		Err:    "",
-		Status: statusFromError(err),
-		Data:   data,
+		Err:     "",
+		Status:  statusFromError(err),
+		Data:    data,
+		Version: version,
 	}
 
 	if err != nil {
-		logger.Log("buildJsonResponseErr:" + err.Error())
+		l.Debug("request failed", logger.F("err", err))
 		res.Err = err.Error()
 		res.Data = nil
 	}
 
 	out, err1 := json.Marshal(res)
 	if err1 != nil {
-		logger.Log("jsonError encoding error")
+		l.Error("response encoding error", logger.F("err", err1))
 		res.Err = err1.Error()
 		res.Status = http.StatusInternalServerError
 	}
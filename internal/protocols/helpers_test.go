@@ -4,9 +4,11 @@ import (
 	"encoding/json"
 	"errors"
 	"reflect"
+	"task1/internal/auth"
 	"task1/internal/logger"
 	"task1/internal/store"
 	"testing"
+	"time"
 )
 
 func Test_protocolHelpers_buildJsonResponse(t *testing.T) {
@@ -72,6 +74,58 @@ func Test_protocolHelpers_buildJsonResponse(t *testing.T) {
 				Data:   nil,
 			},
 		},
+		{
+			name: "err compare and swap conflict",
+			args: args{
+				err:  store.ErrCASConflict,
+				data: "",
+			},
+			want: 409,
+			want1: jsonResponse{
+				Err:    "compare and swap conflict",
+				Status: 409,
+				Data:   nil,
+			},
+		},
+		{
+			name: "err unauthorized",
+			args: args{
+				err:  auth.ErrUnauthorized,
+				data: "",
+			},
+			want: 401,
+			want1: jsonResponse{
+				Err:    "missing or unknown token",
+				Status: 401,
+				Data:   nil,
+			},
+		},
+		{
+			name: "err forbidden",
+			args: args{
+				err:  auth.ErrForbidden,
+				data: "",
+			},
+			want: 403,
+			want1: jsonResponse{
+				Err:    "token not permitted",
+				Status: 403,
+				Data:   nil,
+			},
+		},
+		{
+			name: "err store is full",
+			args: args{
+				err:  store.ErrStoreFull,
+				data: "",
+			},
+			want: 507,
+			want1: jsonResponse{
+				Err:    "store is full",
+				Status: 507,
+				Data:   nil,
+			},
+		},
 		{
 			name: "err internal server error",
 			args: args{
@@ -111,3 +165,39 @@ func Test_protocolHelpers_buildJsonResponse(t *testing.T) {
 		})
 	}
 }
+
+func Test_protocolHelpers_ttlFromRequest(t *testing.T) {
+	tests := []struct {
+		name string
+		req  jsonRequest
+		want time.Duration
+	}{
+		{
+			name: "seconds",
+			req:  jsonRequest{TTL: 30},
+			want: 30 * time.Second,
+		},
+		{
+			name: "milliseconds",
+			req:  jsonRequest{TTLMillis: 1500},
+			want: 1500 * time.Millisecond,
+		},
+		{
+			name: "milliseconds over seconds",
+			req:  jsonRequest{TTL: 30, TTLMillis: 5},
+			want: 5 * time.Millisecond,
+		},
+		{
+			name: "none",
+			req:  jsonRequest{},
+			want: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ttlFromRequest(tt.req); got != tt.want {
+				t.Errorf("ttlFromRequest() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -6,94 +6,202 @@ import (
 	"errors"
 	"log"
 	"net/http"
-	_ "net/http/pprof"
+	"task1/internal/auth"
 	"task1/internal/logger"
 	"task1/internal/metrics"
 	"task1/internal/store"
 	"time"
 )
 
-const (
-	httpaddr    = ":8080"
-	httptimeout = 5 * time.Second
-)
+const metricsPath = "/metrics"
 
 type HTTPServer struct {
-	http    *http.Server
-	logger  *logger.Logger
-	storage *store.Storage
-	metrics *metrics.Metrics
+	http       *http.Server
+	mux        *http.ServeMux
+	done       chan struct{}
+	requests   *inflight
+	state      *listenerState
+	logger     *logger.Logger
+	storage    store.Store
+	metrics    *metrics.Metrics
+	dispatcher *dispatcher
 }
 
 func NewHTTP(
+	addr string,
 	logger *logger.Logger,
-	storage *store.Storage,
+	storage store.Store,
 	metrics *metrics.Metrics,
 ) *HTTPServer {
 
 	return &HTTPServer{
 		http: &http.Server{
-			Addr: httpaddr,
+			Addr: addr,
 		},
-		logger:  logger,
-		storage: storage,
-		metrics: metrics,
+		mux:        http.NewServeMux(),
+		done:       make(chan struct{}),
+		requests:   &inflight{},
+		state:      newListenerState(protocolHTTP, addr),
+		logger:     logger,
+		storage:    storage,
+		metrics:    metrics,
+		dispatcher: newDispatcher(logger, storage, metrics),
 	}
 }
 
+// SetTLS serves HTTPS with the certificate in t. It must be called before
+// Start.
+func (hs *HTTPServer) SetTLS(t *TLS) {
+	hs.http.TLSConfig = t.Config()
+}
+
+// SetACL requires every request to carry a token allowed by acl. It must be
+// called before Start.
+func (hs *HTTPServer) SetACL(acl *auth.ACL) {
+	hs.dispatcher.acl = acl
+}
+
+// SetMode refuses the requests mode does not allow. It must be called before
+// Start.
+func (hs *HTTPServer) SetMode(mode *Mode) {
+	hs.dispatcher.mode = mode
+}
+
+// SetAdmin serves the health and readiness endpoints of admin alongside the
+// store. The admin API is only served too if admin has an ACL, without one
+// anyone reaching the public port could change the mode. It must be called
+// before Start.
+func (hs *HTTPServer) SetAdmin(admin *AdminServer) {
+	if admin.acl == nil {
+		hs.logger.Warn("admin api not served on the http listener without an acl")
+		admin.probes(hs.mux)
+
+		// rather than falling through to the root handler
+		hs.mux.HandleFunc(adminPath, http.NotFound)
+		hs.mux.HandleFunc(adminModePath, http.NotFound)
+
+		return
+	}
+
+	admin.routes(hs.mux)
+}
+
+func (hs HTTPServer) Status() ListenerStatus {
+	return hs.state.get()
+}
+
 func (hs HTTPServer) Start() {
-	http.HandleFunc("/", hs.rootHandler)
+	// every store route runs its requests through the dispatcher, which
+	// records them in metrics
+	hs.mux.HandleFunc("/", hs.rootHandler)
+	hs.mux.HandleFunc(keysPath, hs.keysHandler)
+	hs.mux.HandleFunc(listPath, hs.listHandler)
+	hs.mux.HandleFunc(watchPath, hs.watchHandler)
+	hs.mux.HandleFunc(metricsPath, hs.metricsHandler)
+	hs.http.Handler = hs.track(hs.mux)
+	hs.state.set(StateServing)
 
 	go func() {
-		log.Printf("http listning on %s", httpaddr)
-		if err := hs.http.ListenAndServe(); err != nil {
-			if !errors.Is(err, http.ErrServerClosed) {
-				panic(err)
-			}
+		serve := hs.http.ListenAndServe
+		if hs.http.TLSConfig != nil {
+			// the certificate comes from TLSConfig rather than files
+			serve = func() error { return hs.http.ListenAndServeTLS("", "") }
+		}
+
+		log.Printf("http listning on %s", hs.http.Addr)
+		if err := serve(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("http listener error: %v", err)
+			hs.state.fail(err)
 		}
 	}()
 }
 
 func (hs HTTPServer) Stop() {
-	ctx, cancel := context.WithTimeout(context.Background(), httptimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
 	defer cancel()
 
-	if err := hs.http.Shutdown(ctx); err != nil {
-		panic(err)
+	hs.Shutdown(ctx)
+}
+
+// Shutdown stops accepting and waits until ctx is done for in-flight
+// requests, then closes the conns still busy. It returns how many requests
+// were drained and how many aborted.
+func (hs HTTPServer) Shutdown(ctx context.Context) (drained, aborted int) {
+	hs.state.set(StateStopping)
+
+	// end watch streams, Shutdown waits for them otherwise
+	close(hs.done)
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- hs.http.Shutdown(ctx)
+	}()
+
+	drained, aborted = hs.requests.wait(ctx)
+
+	if err := <-shutdown; err != nil {
+		hs.http.Close()
 	}
-	log.Print("HTTP shutdown ok")
+	hs.state.set(StateStopped)
+	log.Printf("HTTP shutdown ok, %d requests drained, %d aborted", drained, aborted)
+
+	return drained, aborted
 }
 
+// track counts the requests next is serving so Shutdown can wait for them.
+func (hs HTTPServer) track(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hs.requests.begin()
+		defer hs.requests.end()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rootHandler serves JSON requests. The HTTP method is the command, apart
+// from commands such as CAS which are POSTed with the Method in the body.
 func (hs *HTTPServer) rootHandler(w http.ResponseWriter, r *http.Request) {
 	var (
-		req       jsonRequest
-		err       error
-		storeData interface{}
+		req   jsonRequest
+		start = time.Now()
+		rep   reply
 	)
 
-	if err == nil {
-		err = json.NewDecoder(r.Body).Decode(&req)
+	err := json.NewDecoder(r.Body).Decode(&req)
+
+	if r.Method != http.MethodPost || req.Method == "" {
+		req.Method = r.Method
 	}
 
-	if err == nil {
-		hs.metrics.LogMetrics(r.Method)
-		switch r.Method {
-		case http.MethodGet:
-			hs.logger.Log("HTTP GET request")
-			storeData, err = hs.storage.Get(req.Query)
-		case http.MethodPost:
-			hs.logger.Log("HTTP POST request")
-			err = hs.storage.Post(req.Payload)
-		case http.MethodDelete:
-			hs.logger.Log("HTTP DELETE request")
-			err = hs.storage.Delete(req.Query)
-		default:
-			err = ErrRouteForbidden
-		}
+	if token := tokenFromHeader(r); token != "" {
+		req.Token = token
 	}
 
-	status, out := BuildJsonResponse(err, storeData, hs.logger)
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	w.Write(out)
+	if err != nil {
+		rep = hs.dispatcher.respond(protocolHTTP, req, start, nil, 0, err)
+	} else {
+		rep = hs.dispatcher.dispatch(protocolHTTP, req, false, start)
+	}
+
+	hs.writeReply(w, rep)
+}
+
+// metricsHandler serves the Prometheus metrics. With an ACL they need a
+// token granted admin, as they reveal the store's size and traffic.
+func (hs *HTTPServer) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if err := hs.dispatcher.acl.Authorize(tokenFromHeader(r), auth.Admin, ""); err != nil {
+		status, body := BuildJsonResponse(err, nil, hs.logger)
+		hs.writeReply(w, reply{status: status, body: body, err: err})
+
+		return
+	}
+
+	hs.metrics.Handler().ServeHTTP(w, r)
+}
+
+// challenge asks the client for a token on a 401.
+func challenge(w http.ResponseWriter, status int) {
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="kvstore"`)
+	}
 }
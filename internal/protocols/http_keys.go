@@ -0,0 +1,232 @@
+package protocols
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"task1/internal/store"
+	"time"
+)
+
+const (
+	keysPath        = "/keys/"
+	listPath        = "/keys"
+	headerVersion   = "X-Kvstore-Version"
+	contentTypeJSON = "application/json"
+	contentTypeText = "text/plain; charset=utf-8"
+)
+
+// keysHandler serves a single key at /keys/{key}. String values are read and
+// written as the raw body, anything else as JSON. The key's version is sent
+// as an ETag so a PUT with If-Match becomes a compare and swap and a GET with
+// If-None-Match can be answered with 304. Each request runs as the command
+// it maps to, GET, POST, CAS or DELETE, through the dispatcher.
+func (hs *HTTPServer) keysHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	req := jsonRequest{Method: r.Method, Query: strings.TrimPrefix(r.URL.Path, keysPath), Token: tokenFromHeader(r)}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		req.Method = http.MethodGet
+		hs.getKey(w, r, hs.dispatcher.dispatch(protocolHTTP, req, false, start))
+	case http.MethodPut:
+		var rep reply
+
+		req, err := putRequest(r, req)
+		if err != nil {
+			rep = hs.dispatcher.respond(protocolHTTP, req, start, nil, 0, err)
+		} else {
+			rep = hs.dispatcher.dispatch(protocolHTTP, req, false, start)
+		}
+
+		if rep.err == nil && rep.version != 0 {
+			w.Header().Set("ETag", etagFromVersion(rep.version))
+		}
+
+		hs.writeReply(w, rep)
+	case http.MethodDelete:
+		hs.writeReply(w, hs.dispatcher.dispatch(protocolHTTP, req, false, start))
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+		hs.writeReply(w, hs.dispatcher.respond(protocolHTTP, req, start, nil, 0, ErrRouteForbidden))
+	}
+}
+
+// listHandler serves GET /keys?prefix=&cursor=&limit=, a page of keys in
+// lexicographic order, as a LIST. The response Data holds the Keys and the
+// Next cursor.
+func (hs *HTTPServer) listHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	query := r.URL.Query()
+	req := jsonRequest{
+		Method: methodList,
+		Query:  query.Get("prefix"),
+		Cursor: query.Get("cursor"),
+		Token:  tokenFromHeader(r),
+	}
+
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		hs.writeReply(w, hs.dispatcher.respond(protocolHTTP, req, start, nil, 0, ErrRouteForbidden))
+
+		return
+	}
+
+	if raw := query.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil {
+			err = fmt.Errorf("%w: %v", store.ErrLimitInvalid, err)
+			hs.writeReply(w, hs.dispatcher.respond(protocolHTTP, req, start, nil, 0, err))
+
+			return
+		}
+
+		req.Limit = limit
+	}
+
+	hs.writeReply(w, hs.dispatcher.dispatch(protocolHTTP, req, false, start))
+}
+
+// getKey writes the value of a dispatched GET as the raw body.
+func (hs *HTTPServer) getKey(w http.ResponseWriter, r *http.Request, rep reply) {
+	if rep.err != nil {
+		hs.writeReply(w, rep)
+
+		return
+	}
+
+	etag := etagFromVersion(rep.version)
+	w.Header().Set("ETag", etag)
+	w.Header().Set(headerVersion, strconv.FormatUint(rep.version, 10))
+
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+
+		return
+	}
+
+	var body []byte
+
+	if text, ok := rep.data.(string); ok {
+		w.Header().Set("Content-Type", contentTypeText)
+		body = []byte(text)
+	} else {
+		var err error
+		body, err = json.Marshal(rep.data)
+		if err != nil {
+			status, out := BuildJsonResponse(err, nil, hs.logger)
+			hs.writeReply(w, reply{status: status, body: out})
+
+			return
+		}
+		w.Header().Set("Content-Type", contentTypeJSON)
+	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// putRequest turns a PUT of the key in req into a CAS if it has an If-Match
+// header, or a POST otherwise. The request is returned even on error, so the
+// failure is recorded under its command.
+func putRequest(r *http.Request, req jsonRequest) (jsonRequest, error) {
+	req.Method = http.MethodPost
+
+	match := r.Header.Get("If-Match")
+	if match != "" {
+		req.Method = methodCAS
+	}
+
+	ttl, err := ttlFromQuery(r)
+	if err != nil {
+		return req, err
+	}
+	req.TTL = ttl
+
+	value, err := valueFromBody(r)
+	if err != nil {
+		return req, err
+	}
+
+	if match == "" {
+		req.Payload = store.StoreData{req.Query: value}
+
+		return req, nil
+	}
+
+	expected, err := versionFromEtag(match)
+	if err != nil {
+		return req, err
+	}
+
+	req.Value = value
+	req.ExpectedVersion = &expected
+
+	return req, nil
+}
+
+// writeReply writes a dispatched reply as the JSON response.
+func (hs *HTTPServer) writeReply(w http.ResponseWriter, rep reply) {
+	w.Header().Set("Content-Type", contentTypeJSON)
+	challenge(w, rep.status)
+	w.WriteHeader(rep.status)
+	w.Write(rep.body)
+}
+
+// valueFromBody decodes a JSON body, or takes any other body as a string.
+func valueFromBody(r *http.Request) (interface{}, error) {
+	body, err := io.ReadAll(http.MaxBytesReader(nil, r.Body, maxFrameSize))
+	if err != nil {
+		return nil, err
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != contentTypeJSON {
+		return string(body), nil
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
+
+// ttlFromQuery reads an optional ttl in seconds from the ttl query parameter.
+func ttlFromQuery(r *http.Request) (int64, error) {
+	raw := r.URL.Query().Get("ttl")
+	if raw == "" {
+		return 0, nil
+	}
+
+	seconds, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", store.ErrTTLInvalid, err)
+	}
+
+	return seconds, nil
+}
+
+func etagFromVersion(version uint64) string {
+	return strconv.Quote(strconv.FormatUint(version, 10))
+}
+
+func versionFromEtag(etag string) (uint64, error) {
+	unquoted, err := strconv.Unquote(etag)
+	if err != nil {
+		unquoted = etag
+	}
+
+	version, err := strconv.ParseUint(unquoted, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: If-Match must be a version", store.ErrCASNoCondition)
+	}
+
+	return version, nil
+}
@@ -0,0 +1,293 @@
+package protocols
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"task1/internal/logger"
+	"task1/internal/metrics"
+	"task1/internal/store"
+	"testing"
+)
+
+func TestHTTPHandlers_keysHandler(t *testing.T) {
+	type args struct {
+		method       string
+		path         string
+		body         string
+		headers      map[string]string
+		addStoreItem bool
+	}
+	tests := []struct {
+		name        string
+		args        args
+		want        string
+		wantStatus  int
+		wantHeaders map[string]string
+		wantValue   interface{}
+	}{
+		{
+			name: "GET ok - raw string",
+			args: args{
+				method:       http.MethodGet,
+				path:         "/keys/1",
+				addStoreItem: true,
+			},
+			want:       "hello world",
+			wantStatus: 200,
+			wantHeaders: map[string]string{
+				"Content-Type": contentTypeText,
+				"ETag":         `"1"`,
+			},
+		},
+		{
+			name: "GET ok - json value",
+			args: args{
+				method:       http.MethodGet,
+				path:         "/keys/2",
+				addStoreItem: true,
+			},
+			want:       `{"hello":"world"}`,
+			wantStatus: 200,
+			wantHeaders: map[string]string{
+				"Content-Type": contentTypeJSON,
+			},
+		},
+		{
+			name: "GET ok - not modified",
+			args: args{
+				method:       http.MethodGet,
+				path:         "/keys/1",
+				headers:      map[string]string{"If-None-Match": `"1"`},
+				addStoreItem: true,
+			},
+			want:       "",
+			wantStatus: 304,
+		},
+		{
+			name: "GET fail - key not found",
+			args: args{
+				method:       http.MethodGet,
+				path:         "/keys/3",
+				addStoreItem: true,
+			},
+			want:       `{"Err":"key not found in store","Status":404,"Data":null}`,
+			wantStatus: 404,
+		},
+		{
+			name: "HEAD ok",
+			args: args{
+				method:       http.MethodHead,
+				path:         "/keys/1",
+				addStoreItem: true,
+			},
+			wantStatus: 200,
+			wantHeaders: map[string]string{
+				"Content-Length":    "11",
+				"X-Kvstore-Version": "1",
+			},
+		},
+		{
+			name: "PUT ok - raw body",
+			args: args{
+				method: http.MethodPut,
+				path:   "/keys/new",
+				body:   "raw text",
+			},
+			want:       `{"Err":"","Status":200,"Data":null}`,
+			wantStatus: 200,
+			wantValue:  "raw text",
+		},
+		{
+			name: "PUT ok - json body",
+			args: args{
+				method:  http.MethodPut,
+				path:    "/keys/new",
+				body:    `{"a":1}`,
+				headers: map[string]string{"Content-Type": "application/json"},
+			},
+			want:       `{"Err":"","Status":200,"Data":null}`,
+			wantStatus: 200,
+			wantValue:  map[string]interface{}{"a": float64(1)},
+		},
+		{
+			name: "PUT ok - if match",
+			args: args{
+				method:       http.MethodPut,
+				path:         "/keys/1",
+				body:         "swapped",
+				headers:      map[string]string{"If-Match": `"1"`},
+				addStoreItem: true,
+			},
+			want:       `{"Err":"","Status":200,"Data":null,"Version":3}`,
+			wantStatus: 200,
+			wantHeaders: map[string]string{
+				"ETag": `"3"`,
+			},
+		},
+		{
+			name: "PUT fail - if match conflict",
+			args: args{
+				method:       http.MethodPut,
+				path:         "/keys/1",
+				body:         "swapped",
+				headers:      map[string]string{"If-Match": `"9"`},
+				addStoreItem: true,
+			},
+			want:       `{"Err":"compare and swap conflict","Status":409,"Data":null,"Version":1}`,
+			wantStatus: 409,
+		},
+		{
+			name: "PUT fail - key empty",
+			args: args{
+				method: http.MethodPut,
+				path:   "/keys/",
+				body:   "raw text",
+			},
+			want:       `{"Err":"key cannot be empty","Status":400,"Data":null}`,
+			wantStatus: 400,
+		},
+		{
+			name: "DELETE ok",
+			args: args{
+				method:       http.MethodDelete,
+				path:         "/keys/1",
+				addStoreItem: true,
+			},
+			want:       `{"Err":"","Status":200,"Data":null}`,
+			wantStatus: 200,
+		},
+		{
+			name: "METHOD fail - unsupported method",
+			args: args{
+				method:       http.MethodPatch,
+				path:         "/keys/1",
+				addStoreItem: true,
+			},
+			want:       `{"Err":"method forbidden","Status":405,"Data":null}`,
+			wantStatus: 405,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := logger.NewLogger()
+			logger.StartNoopLogger()
+			storage := store.NewStorage(logger)
+			metrics := metrics.NewMetrics(logger)
+			metrics.StartNoopMetrics()
+			hs := NewHTTP(":8080", logger, storage, metrics)
+
+			if tt.args.addStoreItem {
+				storage.Post(map[string]interface{}{"1": "hello world"})
+				storage.Post(map[string]interface{}{"2": map[string]interface{}{"hello": "world"}})
+			}
+
+			r := httptest.NewRequest(tt.args.method, tt.args.path, strings.NewReader(tt.args.body))
+			for k, v := range tt.args.headers {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+
+			hs.keysHandler(w, r)
+			res := w.Result()
+			defer res.Body.Close()
+
+			data, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Errorf("keysHandler error = %v", err)
+			}
+
+			if res.StatusCode != tt.wantStatus {
+				t.Errorf("keysHandler: status = %v, want = %v", res.StatusCode, tt.wantStatus)
+			}
+
+			if got := string(data); tt.args.method != http.MethodHead && got != tt.want {
+				t.Errorf("keysHandler: got = %v, want = %v", got, tt.want)
+			}
+
+			for k, want := range tt.wantHeaders {
+				if got := res.Header.Get(k); got != want {
+					t.Errorf("keysHandler: header %s = %v, want = %v", k, got, want)
+				}
+			}
+
+			if tt.wantValue != nil {
+				got, err := storage.Get(strings.TrimPrefix(tt.args.path, keysPath))
+				if err != nil || !reflect.DeepEqual(got, tt.wantValue) {
+					t.Errorf("storage.Get() = %v, %v, want %v", got, err, tt.wantValue)
+				}
+			}
+		})
+	}
+}
+
+func TestHTTPHandlers_listHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		want       string
+		wantStatus int
+	}{
+		{
+			name:       "LIST ok - prefix",
+			method:     http.MethodGet,
+			target:     "/keys?prefix=config/",
+			want:       `{"Err":"","Status":200,"Data":{"Keys":["config/1","config/2","config/3"],"Next":""}}`,
+			wantStatus: 200,
+		},
+		{
+			name:       "LIST ok - paged",
+			method:     http.MethodGet,
+			target:     "/keys?prefix=config/&cursor=config/1&limit=1",
+			want:       `{"Err":"","Status":200,"Data":{"Keys":["config/2"],"Next":"config/2"}}`,
+			wantStatus: 200,
+		},
+		{
+			name:       "LIST fail - bad limit",
+			method:     http.MethodGet,
+			target:     "/keys?limit=lots",
+			want:       `{"Err":"limit must be between 0 and 1000: strconv.Atoi: parsing \"lots\": invalid syntax","Status":400,"Data":null}`,
+			wantStatus: 400,
+		},
+		{
+			name:       "METHOD fail - unsupported method",
+			method:     http.MethodPost,
+			target:     "/keys",
+			want:       `{"Err":"method forbidden","Status":405,"Data":null}`,
+			wantStatus: 405,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := logger.NewLogger()
+			logger.StartNoopLogger()
+			storage := store.NewStorage(logger)
+			metrics := metrics.NewMetrics(logger)
+			metrics.StartNoopMetrics()
+			hs := NewHTTP(":8080", logger, storage, metrics)
+
+			storage.Post(map[string]interface{}{
+				"config/1":  "a",
+				"config/2":  "b",
+				"config/3":  "c",
+				"session/1": "token",
+			})
+
+			w := httptest.NewRecorder()
+			hs.listHandler(w, httptest.NewRequest(tt.method, tt.target, nil))
+			res := w.Result()
+			defer res.Body.Close()
+
+			data, _ := io.ReadAll(res.Body)
+			if res.StatusCode != tt.wantStatus {
+				t.Errorf("listHandler: status = %v, want = %v", res.StatusCode, tt.wantStatus)
+			}
+			if got := string(data); got != tt.want {
+				t.Errorf("listHandler: got = %v, want = %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
-	_ "net/http/pprof"
 	"strings"
 	"task1/internal/logger"
 	"task1/internal/metrics"
@@ -32,7 +31,7 @@ func TestHTTPHandlers_rootHandler(t *testing.T) {
 				w:            httptest.NewRecorder(),
 				addStoreItem: true,
 			},
-			want: `{"Err":"","Status":200,"Data":"hello world"}`,
+			want: `{"Err":"","Status":200,"Data":"hello world","Version":1}`,
 		},
 		{
 			name: "GET fail - store is empty",
@@ -110,6 +109,42 @@ func TestHTTPHandlers_rootHandler(t *testing.T) {
 			},
 			want: `{"Err":"key cannot be empty","Status":400,"Data":null}`,
 		},
+		{
+			name: "CAS ok - expected version",
+			args: args{
+				r:            httptest.NewRequest(http.MethodPost, url, strings.NewReader(`{"Method":"CAS","Query":"1","Value":"swapped","ExpectedVersion":1}`)),
+				w:            httptest.NewRecorder(),
+				addStoreItem: true,
+			},
+			want: `{"Err":"","Status":200,"Data":null,"Version":2}`,
+		},
+		{
+			name: "CAS fail - conflict",
+			args: args{
+				r:            httptest.NewRequest(http.MethodPost, url, strings.NewReader(`{"Method":"CAS","Query":"1","Value":"swapped","Expected":"other"}`)),
+				w:            httptest.NewRecorder(),
+				addStoreItem: true,
+			},
+			want: `{"Err":"compare and swap conflict","Status":409,"Data":null,"Version":1}`,
+		},
+		{
+			name: "TXN ok",
+			args: args{
+				r:            httptest.NewRequest(http.MethodPost, url, strings.NewReader(`{"Method":"TXN","Ops":[{"Op":"check-version","Key":"1","Version":1},{"Op":"set","Key":"2","Value":"two"},{"Op":"delete","Key":"1"}]}`)),
+				w:            httptest.NewRecorder(),
+				addStoreItem: true,
+			},
+			want: `{"Err":"","Status":200,"Data":[{"Op":"check-version","Key":"1","Version":1},{"Op":"set","Key":"2","Version":2},{"Op":"delete","Key":"1","Version":1}]}`,
+		},
+		{
+			name: "TXN fail - conflict",
+			args: args{
+				r:            httptest.NewRequest(http.MethodPost, url, strings.NewReader(`{"Method":"TXN","Ops":[{"Op":"delete","Key":"1"},{"Op":"check-version","Key":"1","Version":1}]}`)),
+				w:            httptest.NewRecorder(),
+				addStoreItem: true,
+			},
+			want: `{"Err":"compare and swap conflict: op 1 version 0, want 1","Status":409,"Data":null}`,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -118,7 +153,7 @@ func TestHTTPHandlers_rootHandler(t *testing.T) {
 			storage := store.NewStorage(logger)
 			metrics := metrics.NewMetrics(logger)
 			metrics.Start()
-			rh := NewHTTP(logger, storage, metrics)
+			rh := NewHTTP(":8080", logger, storage, metrics)
 
 			if tt.args.addStoreItem {
 				rh.storage.Post(map[string]interface{}{"1": "hello world"})
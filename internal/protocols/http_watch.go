@@ -0,0 +1,77 @@
+package protocols
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"task1/internal/logger"
+	"time"
+)
+
+const (
+	watchPath = "/watch"
+)
+
+// watchHandler streams changes to keys starting with the prefix query
+// parameter as Server-Sent Events until the client disconnects, the watch
+// falls behind, or the server stops. Each event is named after its Op and
+// carries the store.Event as JSON. The watch is started as a WATCH through
+// the dispatcher.
+func (hs *HTTPServer) watchHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	req := jsonRequest{Method: methodWatch, Query: r.URL.Query().Get("prefix"), Token: tokenFromHeader(r)}
+
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		hs.writeReply(w, hs.dispatcher.respond(protocolHTTP, req, start, nil, 0, ErrRouteForbidden))
+
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		hs.writeReply(w, hs.dispatcher.respond(protocolHTTP, req, start, nil, 0, ErrStreamingUnsupported))
+
+		return
+	}
+
+	rep := hs.dispatcher.dispatch(protocolHTTP, req, true, start)
+	if !rep.watch {
+		hs.writeReply(w, rep)
+
+		return
+	}
+
+	events, cancel := hs.storage.Watch(req.Query)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+
+			data, err := json.Marshal(event)
+			if err != nil {
+				hs.logger.Error("watch event encoding error", logger.F("err", err))
+
+				continue
+			}
+
+			if _, err := fmt.Fprintf(w, "event: %s\nid: %d\ndata: %s\n\n", event.Op, event.Version, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-hs.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package protocols
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"task1/internal/logger"
+	"task1/internal/metrics"
+	"task1/internal/store"
+	"testing"
+)
+
+func TestHTTPHandlers_watchHandler(t *testing.T) {
+	logger := logger.NewLogger()
+	logger.StartNoopLogger()
+	storage := store.NewStorage(logger)
+	metrics := metrics.NewMetrics(logger)
+	metrics.StartNoopMetrics()
+	hs := NewHTTP(":8080", logger, storage, metrics)
+
+	server := httptest.NewServer(http.HandlerFunc(hs.watchHandler))
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "?prefix=config/")
+	if err != nil {
+		t.Fatalf("watch request error: %v", err)
+	}
+
+	defer res.Body.Close()
+
+	if got := res.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("watchHandler: Content-Type = %v, want text/event-stream", got)
+	}
+
+	storage.Post(map[string]interface{}{"session/1": "token"})
+	storage.Post(map[string]interface{}{"config/a": "on"})
+	storage.Delete("config/a")
+
+	want := []string{
+		"event: POST",
+		"id: 2",
+		`data: {"Op":"POST","Key":"config/a","Old":null,"New":"on","Version":2}`,
+		"",
+		"event: DELETE",
+		"id: 2",
+		`data: {"Op":"DELETE","Key":"config/a","Old":"on","New":null,"Version":2}`,
+		"",
+	}
+
+	reader := bufio.NewReader(res.Body)
+	for _, w := range want {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("watchHandler: read error: %v", err)
+		}
+
+		if got := strings.TrimSuffix(line, "\n"); got != w {
+			t.Errorf("watchHandler: got = %v, want = %v", got, w)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package protocols
+
+import (
+	"sync"
+)
+
+// Listener states reported by the admin API.
+const (
+	StateStarting = "starting"
+	StateServing  = "serving"
+	StateStopping = "stopping"
+	StateStopped  = "stopped"
+	StateFailed   = "failed"
+)
+
+// ListenerStatus is what the admin API reports for a listener.
+type ListenerStatus struct {
+	Protocol string `json:"Protocol"`
+	Addr     string `json:"Addr"`
+	State    string `json:"State"`
+	Err      string `json:"Err,omitempty"`
+}
+
+// Listener is a protocol server whose status the admin API reports.
+type Listener interface {
+	Status() ListenerStatus
+}
+
+// listenerState is shared by the copies of a server so each sees the state
+// set by Start and Shutdown.
+type listenerState struct {
+	mutex  sync.Mutex
+	status ListenerStatus
+}
+
+func newListenerState(protocol, addr string) *listenerState {
+	return &listenerState{
+		status: ListenerStatus{Protocol: protocol, Addr: addr, State: StateStarting},
+	}
+}
+
+func (ls *listenerState) set(state string) {
+	ls.mutex.Lock()
+	ls.status.State = state
+	ls.mutex.Unlock()
+}
+
+// fail records err stopping the listener, unless it is being shut down.
+func (ls *listenerState) fail(err error) {
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+
+	if ls.status.State != StateServing {
+		return
+	}
+
+	ls.status.State = StateFailed
+	ls.status.Err = err.Error()
+}
+
+func (ls *listenerState) get() ListenerStatus {
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+
+	return ls.status
+}
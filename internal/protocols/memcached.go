@@ -0,0 +1,681 @@
+package protocols
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"task1/internal/auth"
+	"task1/internal/logger"
+	"task1/internal/metrics"
+	"task1/internal/store"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// memcachedVersion is the memcached version reported by version
+	memcachedVersion = "1.6.0"
+
+	maxMemcachedKey = 250
+
+	// exptimes up to 30 days are relative, anything larger is a unix time
+	maxRelativeExptime = 60 * 60 * 24 * 30
+)
+
+var (
+	errMemcachedFormat     = errors.New("bad command line format")
+	errMemcachedChunk      = errors.New("bad data chunk")
+	errMemcachedNonNumeric = errors.New("cannot increment or decrement non-numeric value")
+	errMemcachedDelta      = errors.New("invalid numeric delta argument")
+)
+
+// MemcachedServer serves the memcached text protocol. Values stored with no
+// flags which are valid UTF-8 are kept as plain strings, so other protocols
+// read them as is. Anything else is kept as an object holding the Flags and
+// the Data, or Base64 for binary data, so memcached clients get back exactly
+// what they stored. As with RedisServer every command runs through the
+// shared dispatcher.
+type MemcachedServer struct {
+	listener   net.Listener
+	conns      *connSet
+	done       chan struct{}
+	state      *listenerState
+	logger     *logger.Logger
+	dispatcher *dispatcher
+}
+
+// memcachedSession is the state of a single client connection. With an ACL
+// the first command must be a set whose data is "username token", the way
+// memcached authenticates text protocol clients.
+type memcachedSession struct {
+	w             *bufio.Writer
+	token         string
+	authenticated bool
+	quit          bool
+}
+
+func NewMemcached(
+	addr string,
+	logger *logger.Logger,
+	storage store.Store,
+	metrics *metrics.Metrics,
+) *MemcachedServer {
+
+	lis, err := net.Listen(tcpnetwork, addr)
+	if err != nil {
+		panic(err)
+	}
+
+	return &MemcachedServer{
+		listener:   lis,
+		conns:      newConnSet(protocolMemcached, logger, metrics),
+		done:       make(chan struct{}),
+		state:      newListenerState(protocolMemcached, lis.Addr().String()),
+		logger:     logger,
+		dispatcher: newDispatcher(logger, storage, metrics),
+	}
+}
+
+// SetTLS accepts only TLS connections, using the certificate in t. It must
+// be called before Start.
+func (ms *MemcachedServer) SetTLS(t *TLS) {
+	ms.listener = tls.NewListener(ms.listener, t.Config())
+}
+
+// SetACL requires every connection to authenticate with an allowed token
+// before running commands. It must be called before Start.
+func (ms *MemcachedServer) SetACL(acl *auth.ACL) {
+	ms.dispatcher.acl = acl
+}
+
+// SetMode refuses the commands mode does not allow. It must be called
+// before Start.
+func (ms *MemcachedServer) SetMode(mode *Mode) {
+	ms.dispatcher.mode = mode
+}
+
+func (ms MemcachedServer) Status() ListenerStatus {
+	return ms.state.get()
+}
+
+func (ms MemcachedServer) Start() {
+	log.Printf("memcached listening on %s", ms.listener.Addr().String())
+	ms.state.set(StateServing)
+
+	go func() {
+		for {
+			conn, err := ms.listener.Accept()
+			if err != nil {
+				select {
+				case <-ms.done:
+				default:
+					log.Printf("memcached listener error: %v", err)
+					ms.state.fail(err)
+				}
+
+				return
+			}
+
+			go ms.memcachedHandler(conn, ms.conns.add(conn))
+		}
+	}()
+}
+
+func (ms MemcachedServer) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	ms.Shutdown(ctx)
+}
+
+// Shutdown stops accepting, lets every conn finish the commands it has read
+// until ctx is done and closes the rest. It returns how many conns were
+// drained and how many aborted.
+func (ms MemcachedServer) Shutdown(ctx context.Context) (drained, aborted int) {
+	ms.state.set(StateStopping)
+	close(ms.done)
+	if err := ms.listener.Close(); err != nil {
+		log.Printf("memcached listener close err: %v", err)
+	}
+
+	drained, aborted = ms.conns.drain(ctx)
+	ms.state.set(StateStopped)
+	log.Printf("Memcached shutdown ok, %d conns drained, %d aborted", drained, aborted)
+
+	return drained, aborted
+}
+
+// memcachedHandler serves commands from conn until the client quits, closes
+// it or sits idle for tcpIdleTimeout. Replies to pipelined commands are only
+// flushed once no further commands are buffered.
+func (ms MemcachedServer) memcachedHandler(conn net.Conn, connID string) {
+	defer func() {
+		conn.Close()
+		ms.conns.remove(conn)
+	}()
+
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+	session := &memcachedSession{w: writer, authenticated: ms.dispatcher.acl == nil}
+
+	for {
+		if !ms.conns.keepAlive(conn, tcpIdleTimeout) && reader.Buffered() == 0 {
+			return
+		}
+
+		line, err := readRESPLine(reader)
+		if err != nil {
+			if errors.Is(err, ErrFrameTooLarge) {
+				writer.WriteString("SERVER_ERROR object too large for cache\r\n")
+				writer.Flush()
+			} else if !connEnded(err) {
+				ms.logger.Warn("conn read error", logger.F("protocol", protocolMemcached), logger.F("conn", connID), logger.F("err", err))
+			}
+
+			return
+		}
+
+		if err := ms.execute(session, strings.Fields(line), reader); err != nil {
+			ms.logger.Warn("conn read error", logger.F("protocol", protocolMemcached), logger.F("conn", connID), logger.F("err", err))
+			writer.Flush()
+
+			return
+		}
+
+		if session.quit {
+			writer.Flush()
+
+			return
+		}
+
+		if reader.Buffered() == 0 {
+			if err := writer.Flush(); err != nil {
+				ms.logger.Warn("conn flush error", logger.F("protocol", protocolMemcached), logger.F("conn", connID), logger.F("err", err))
+
+				return
+			}
+		}
+	}
+}
+
+// execute runs a single command, reading the data block of storage commands
+// from r. Only an error reading the data block is returned, and ends the
+// connection.
+func (ms MemcachedServer) execute(s *memcachedSession, fields []string, r *bufio.Reader) error {
+	if len(fields) == 0 {
+		s.w.WriteString("ERROR\r\n")
+
+		return nil
+	}
+
+	name, args := fields[0], fields[1:]
+
+	switch name {
+	case "set", "add", "replace", "cas":
+		return ms.storeCommand(s, name, args, r)
+	case "version":
+		s.w.WriteString("VERSION " + memcachedVersion + "\r\n")
+
+		return nil
+	case "quit":
+		s.quit = true
+
+		return nil
+	}
+
+	if !s.authenticated {
+		s.w.WriteString("CLIENT_ERROR unauthenticated\r\n")
+
+		return nil
+	}
+
+	switch name {
+	case "get":
+		ms.retrieve(s, args, false)
+	case "gets":
+		ms.retrieve(s, args, true)
+	case "delete":
+		ms.delete(s, args)
+	case "incr", "decr":
+		ms.incr(s, name == "incr", args)
+	case "touch":
+		ms.touch(s, args)
+	default:
+		s.w.WriteString("ERROR\r\n")
+	}
+
+	return nil
+}
+
+// dispatch runs req with the session's token.
+func (ms MemcachedServer) dispatch(s *memcachedSession, req jsonRequest) reply {
+	req.Token = s.token
+
+	return ms.dispatcher.dispatch(protocolMemcached, req, false, time.Now())
+}
+
+// reply writes line unless the command asked for noreply.
+func (s *memcachedSession) reply(noreply bool, line string) {
+	if !noreply {
+		s.w.WriteString(line + "\r\n")
+	}
+}
+
+// fail writes the error reply for err. Errors from the client are reported as
+// CLIENT_ERROR, everything else as SERVER_ERROR.
+func (s *memcachedSession) fail(noreply bool, err error) {
+	switch {
+	case errors.Is(err, errMemcachedFormat),
+		errors.Is(err, errMemcachedChunk),
+		errors.Is(err, errMemcachedNonNumeric),
+		errors.Is(err, errMemcachedDelta),
+		errors.Is(err, store.ErrKeyEmpty):
+		s.reply(noreply, "CLIENT_ERROR "+err.Error())
+	default:
+		s.reply(noreply, "SERVER_ERROR "+err.Error())
+	}
+}
+
+// retrieve runs get and gets, reading every key in one transaction. gets
+// adds the key's version as the cas unique.
+func (ms MemcachedServer) retrieve(s *memcachedSession, keys []string, cas bool) {
+	if len(keys) == 0 {
+		s.w.WriteString("ERROR\r\n")
+
+		return
+	}
+
+	ops := make([]jsonOp, len(keys))
+	for i, key := range keys {
+		if len(key) > maxMemcachedKey {
+			s.fail(false, errMemcachedFormat)
+
+			return
+		}
+
+		ops[i] = jsonOp{Op: store.TxGet, Key: key}
+	}
+
+	rep := ms.dispatch(s, jsonRequest{Method: methodTxn, Ops: ops})
+	if rep.err != nil {
+		s.fail(false, rep.err)
+
+		return
+	}
+
+	for _, result := range rep.data.([]store.TxResult) {
+		if result.Err != "" {
+			continue
+		}
+
+		data, flags := memcachedItem(result.Value)
+
+		fmt.Fprintf(s.w, "VALUE %s %d %d", result.Key, flags, len(data))
+		if cas {
+			fmt.Fprintf(s.w, " %d", result.Version)
+		}
+		s.w.WriteString("\r\n")
+		s.w.Write(data)
+		s.w.WriteString("\r\n")
+	}
+
+	s.w.WriteString("END\r\n")
+}
+
+// storeCommand runs set, add, replace and cas, as
+// <command> <key> <flags> <exptime> <bytes> [<cas unique>] [noreply]
+// followed by a data block of <bytes>.
+func (ms MemcachedServer) storeCommand(s *memcachedSession, name string, args []string, r *bufio.Reader) error {
+	want := 4
+	if name == "cas" {
+		want = 5
+	}
+
+	noreply := len(args) == want+1 && args[want] == "noreply"
+	if len(args) != want && !noreply {
+		s.fail(false, errMemcachedFormat)
+
+		return nil
+	}
+
+	size, err := strconv.Atoi(args[3])
+	if err != nil || size < 0 || size > maxFrameSize {
+		s.fail(noreply, errMemcachedFormat)
+
+		return nil
+	}
+
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return err
+	}
+
+	if data[size] != '\r' || data[size+1] != '\n' {
+		s.fail(noreply, errMemcachedChunk)
+
+		return nil
+	}
+
+	data = data[:size]
+
+	if !s.authenticated {
+		ms.authenticate(s, name, data)
+
+		return nil
+	}
+
+	key := args[0]
+	flags, ferr := strconv.ParseUint(args[1], 10, 32)
+	exptime, eerr := strconv.ParseInt(args[2], 10, 64)
+	if ferr != nil || eerr != nil || len(key) > maxMemcachedKey {
+		s.fail(noreply, errMemcachedFormat)
+
+		return nil
+	}
+
+	ttl, expired := ttlFromExptime(exptime)
+	value := memcachedValue(data, uint32(flags))
+
+	var rep reply
+
+	switch name {
+	case "set":
+		rep = ms.dispatch(s, jsonRequest{Method: http.MethodPost, Payload: store.StoreData{key: value}, TTL: ttl})
+	case "add":
+		var none uint64
+		rep = ms.dispatch(s, jsonRequest{Method: methodCAS, Query: key, Value: value, ExpectedVersion: &none, TTL: ttl})
+	case "replace":
+		_, rep, _ = ms.swap(s, key, ttl, func(interface{}) (interface{}, error) { return value, nil })
+	case "cas":
+		unique, err := strconv.ParseUint(args[4], 10, 64)
+		if err != nil {
+			s.fail(noreply, errMemcachedFormat)
+
+			return nil
+		}
+
+		if unique == 0 {
+			// a version of 0 would match a missing key, memcached never
+			// hands it out so it can only be stale
+			rep = ms.dispatch(s, jsonRequest{Method: http.MethodGet, Query: key})
+			if rep.err == nil {
+				rep.err = store.ErrCASConflict
+			}
+			break
+		}
+
+		rep = ms.dispatch(s, jsonRequest{Method: methodCAS, Query: key, Value: value, ExpectedVersion: &unique, TTL: ttl})
+		if errors.Is(rep.err, store.ErrCASConflict) && rep.version == 0 {
+			rep.err = store.ErrStoreKeyNotFound
+		}
+	}
+
+	switch {
+	case errors.Is(rep.err, store.ErrCASConflict) && name == "cas":
+		s.reply(noreply, "EXISTS")
+	case missing(rep.err) && name == "cas":
+		s.reply(noreply, "NOT_FOUND")
+	case errors.Is(rep.err, store.ErrCASConflict), missing(rep.err):
+		// add found the key, or replace did not
+		s.reply(noreply, "NOT_STORED")
+	case rep.err != nil:
+		s.fail(noreply, rep.err)
+	default:
+		if expired {
+			// an exptime in the past stores an item which has already expired
+			ms.dispatch(s, jsonRequest{Method: http.MethodDelete, Query: key})
+		}
+
+		s.reply(noreply, "STORED")
+	}
+
+	return nil
+}
+
+// authenticate checks the data of the first storage command holds a
+// username and an allowed token.
+func (ms MemcachedServer) authenticate(s *memcachedSession, name string, data []byte) {
+	fields := strings.Fields(string(data))
+
+	if name != "set" || len(fields) != 2 || ms.dispatcher.acl.Authenticate(fields[1]) != nil {
+		s.w.WriteString("CLIENT_ERROR authentication failure\r\n")
+
+		return
+	}
+
+	s.token = fields[1]
+	s.authenticated = true
+	s.w.WriteString("STORED\r\n")
+}
+
+// delete runs delete <key> [noreply].
+func (ms MemcachedServer) delete(s *memcachedSession, args []string) {
+	noreply := len(args) == 2 && args[1] == "noreply"
+	if len(args) != 1 && !noreply {
+		s.fail(false, errMemcachedFormat)
+
+		return
+	}
+
+	rep := ms.dispatch(s, jsonRequest{Method: http.MethodDelete, Query: args[0]})
+
+	switch {
+	case missing(rep.err):
+		s.reply(noreply, "NOT_FOUND")
+	case rep.err != nil:
+		s.fail(noreply, rep.err)
+	default:
+		s.reply(noreply, "DELETED")
+	}
+}
+
+// incr runs incr and decr <key> <delta> [noreply] on a decimal value. incr
+// wraps around at 64 bits and decr stops at 0, as in memcached, in a single
+// store update so concurrent clients never conflict. The key keeps its ttl,
+// and a missing key is NOT_FOUND.
+func (ms MemcachedServer) incr(s *memcachedSession, up bool, args []string) {
+	noreply := len(args) == 3 && args[2] == "noreply"
+	if len(args) != 2 && !noreply {
+		s.fail(false, errMemcachedFormat)
+
+		return
+	}
+
+	delta, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		s.fail(noreply, errMemcachedDelta)
+
+		return
+	}
+
+	var result uint64
+
+	update := func(value interface{}) (interface{}, error) {
+		data, flags := memcachedItem(value)
+
+		n, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+		if err != nil {
+			return nil, errMemcachedNonNumeric
+		}
+
+		switch {
+		case up:
+			result = n + delta
+		case delta > n:
+			result = 0
+		default:
+			result = n - delta
+		}
+
+		return memcachedValue([]byte(strconv.FormatUint(result, 10)), flags), nil
+	}
+
+	rep := ms.dispatch(s, jsonRequest{Method: methodUpdate, Query: args[0], update: update})
+
+	switch {
+	case missing(rep.err):
+		s.reply(noreply, "NOT_FOUND")
+	case rep.err != nil:
+		s.fail(noreply, rep.err)
+	default:
+		s.reply(noreply, strconv.FormatUint(result, 10))
+	}
+}
+
+// touch runs touch <key> <exptime> [noreply], replacing the key's ttl.
+func (ms MemcachedServer) touch(s *memcachedSession, args []string) {
+	noreply := len(args) == 3 && args[2] == "noreply"
+	if len(args) != 2 && !noreply {
+		s.fail(false, errMemcachedFormat)
+
+		return
+	}
+
+	exptime, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		s.fail(noreply, errMemcachedFormat)
+
+		return
+	}
+
+	ttl, expired := ttlFromExptime(exptime)
+
+	var rep reply
+	if expired {
+		rep = ms.dispatch(s, jsonRequest{Method: http.MethodDelete, Query: args[0]})
+	} else {
+		_, rep, _ = ms.swap(s, args[0], ttl, func(value interface{}) (interface{}, error) { return value, nil })
+	}
+
+	switch {
+	case missing(rep.err):
+		s.reply(noreply, "NOT_FOUND")
+	case rep.err != nil:
+		s.fail(noreply, rep.err)
+	default:
+		s.reply(noreply, "TOUCHED")
+	}
+}
+
+// swap replaces the value of an existing key with update(current) and sets
+// its ttl, by compare and swap so a write in between is retried rather than
+// lost. A ttl of -1 keeps the key's remaining ttl. An error from update is
+// returned on its own, anything else in the reply.
+func (ms MemcachedServer) swap(
+	s *memcachedSession,
+	key string,
+	ttl int64,
+	update func(value interface{}) (interface{}, error),
+) (interface{}, reply, error) {
+	for i := 0; ; i++ {
+		current := ms.dispatch(s, jsonRequest{Method: http.MethodGet, Query: key})
+		if current.err != nil {
+			return nil, current, nil
+		}
+
+		keep := ttl
+		if ttl < 0 {
+			left := ms.dispatch(s, jsonRequest{Method: methodTTL, Query: key})
+			if left.err != nil {
+				return nil, left, nil
+			}
+
+			// TTL reports -1 for no ttl, which stores without one
+			keep = left.data.(int64)
+			if keep < 0 {
+				keep = 0
+			}
+		}
+
+		value, err := update(current.data)
+		if err != nil {
+			return nil, current, err
+		}
+
+		rep := ms.dispatch(s, jsonRequest{
+			Method:          methodCAS,
+			Query:           key,
+			Value:           value,
+			ExpectedVersion: &current.version,
+			TTL:             keep,
+		})
+		if !errors.Is(rep.err, store.ErrCASConflict) || i == expireRetries {
+			return value, rep, nil
+		}
+	}
+}
+
+// ttlFromExptime converts a memcached exptime to a ttl in seconds, reporting
+// whether it is already in the past.
+func ttlFromExptime(exptime int64) (int64, bool) {
+	switch {
+	case exptime < 0:
+		return 0, true
+	case exptime <= maxRelativeExptime:
+		return exptime, false
+	}
+
+	ttl := exptime - time.Now().Unix()
+
+	return ttl, ttl <= 0
+}
+
+// memcachedValue converts data stored with flags to a store value.
+func memcachedValue(data []byte, flags uint32) interface{} {
+	text := utf8.Valid(data)
+	if flags == 0 && text {
+		return string(data)
+	}
+
+	// stored as it would be read back from JSON, so the value is the same
+	// before and after a restart
+	item := map[string]interface{}{"Flags": float64(flags)}
+	if text {
+		item["Data"] = string(data)
+	} else {
+		item["Base64"] = base64.StdEncoding.EncodeToString(data)
+	}
+
+	return item
+}
+
+// memcachedItem converts a store value back to its data and flags. Values
+// not written by memcachedValue are returned as strings, or as JSON if they
+// are not strings, with no flags.
+func memcachedItem(value interface{}) ([]byte, uint32) {
+	if text, ok := value.(string); ok {
+		return []byte(text), 0
+	}
+
+	if item, ok := value.(map[string]interface{}); ok && len(item) == 2 {
+		flags, ok := item["Flags"].(float64)
+		if ok {
+			if data, ok := item["Data"].(string); ok {
+				return []byte(data), uint32(flags)
+			}
+
+			if encoded, ok := item["Base64"].(string); ok {
+				if data, err := base64.StdEncoding.DecodeString(encoded); err == nil {
+					return data, uint32(flags)
+				}
+			}
+		}
+	}
+
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return []byte(fmt.Sprint(value)), 0
+	}
+
+	return raw, 0
+}
@@ -0,0 +1,275 @@
+package protocols
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"task1/internal/logger"
+	"task1/internal/metrics"
+	"task1/internal/store"
+	"testing"
+	"time"
+)
+
+func TestMemcachedServer_memcachedHandler(t *testing.T) {
+	type step struct {
+		send string
+		want string
+	}
+	tests := []struct {
+		name  string
+		acl   bool
+		mode  string
+		steps []step
+	}{
+		{
+			name: "read-only - get served, set refused",
+			mode: ModeReadOnly,
+			steps: []step{
+				{send: "get 1\r\n", want: "VALUE 1 0 11\r\nhello world\r\nEND\r\n"},
+				{send: "gets 1 json\r\n", want: "VALUE 1 0 11 1\r\nhello world\r\nVALUE json 0 7 2\r\n{\"a\":1}\r\nEND\r\n"},
+				{send: "set 2 0 0 3\r\ntwo\r\n", want: "SERVER_ERROR server is in read-only mode\r\n"},
+			},
+		},
+		{
+			name: "get and gets",
+			steps: []step{
+				{send: "get 1\r\n", want: "VALUE 1 0 11\r\nhello world\r\nEND\r\n"},
+				{send: "get 1 2 json\r\n", want: "VALUE 1 0 11\r\nhello world\r\nVALUE json 0 7\r\n{\"a\":1}\r\nEND\r\n"},
+				{send: "gets 1\r\n", want: "VALUE 1 0 11 1\r\nhello world\r\nEND\r\n"},
+				{send: "get 2\r\n", want: "END\r\n"},
+			},
+		},
+		{
+			name: "set, add and replace",
+			steps: []step{
+				{send: "set 2 5 0 3\r\ntwo\r\n", want: "STORED\r\n"},
+				{send: "get 2\r\n", want: "VALUE 2 5 3\r\ntwo\r\nEND\r\n"},
+				{send: "add 2 0 0 3\r\nnew\r\n", want: "NOT_STORED\r\n"},
+				{send: "add 3 0 0 5\r\nthree\r\n", want: "STORED\r\n"},
+				{send: "replace 4 0 0 4\r\nfour\r\n", want: "NOT_STORED\r\n"},
+				{send: "replace 3 0 0 1\r\n3\r\n", want: "STORED\r\n"},
+				{send: "get 3\r\n", want: "VALUE 3 0 1\r\n3\r\nEND\r\n"},
+				{send: "set 5 0 0 2 noreply\r\nhi\r\nget 5\r\n", want: "VALUE 5 0 2\r\nhi\r\nEND\r\n"},
+				{send: "set 6 0 0 2\r\nhiya\r\n", want: "CLIENT_ERROR bad data chunk\r\n"},
+			},
+		},
+		{
+			name: "set binary",
+			steps: []step{
+				{send: "set bin 0 0 2\r\n\xff\xfe\r\n", want: "STORED\r\n"},
+				{send: "get bin\r\n", want: "VALUE bin 0 2\r\n\xff\xfe\r\nEND\r\n"},
+			},
+		},
+		{
+			name: "cas",
+			steps: []step{
+				{send: "cas 1 0 0 3 99\r\nnew\r\n", want: "EXISTS\r\n"},
+				{send: "cas 1 0 0 3 1\r\nnew\r\n", want: "STORED\r\n"},
+				{send: "gets 1\r\n", want: "VALUE 1 0 3 3\r\nnew\r\nEND\r\n"},
+				{send: "cas 2 0 0 3 1\r\nnew\r\n", want: "NOT_FOUND\r\n"},
+			},
+		},
+		{
+			name: "delete",
+			steps: []step{
+				{send: "delete 1\r\n", want: "DELETED\r\n"},
+				{send: "delete 1\r\n", want: "NOT_FOUND\r\n"},
+			},
+		},
+		{
+			name: "incr and decr",
+			steps: []step{
+				{send: "set n 0 0 2\r\n10\r\n", want: "STORED\r\n"},
+				{send: "incr n 5\r\n", want: "15\r\n"},
+				{send: "decr n 20\r\n", want: "0\r\n"},
+				{send: "incr 2 3\r\n", want: "NOT_FOUND\r\n"},
+				{send: "get 2\r\n", want: "END\r\n"},
+				{send: "decr 3 1\r\n", want: "NOT_FOUND\r\n"},
+				{send: "set max 0 0 20\r\n18446744073709551615\r\n", want: "STORED\r\n"},
+				{send: "incr max 2\r\n", want: "1\r\n"},
+				{send: "set f 5 0 1\r\n7\r\n", want: "STORED\r\n"},
+				{send: "incr f 1\r\n", want: "8\r\n"},
+				{send: "get f\r\n", want: "VALUE f 5 1\r\n8\r\nEND\r\n"},
+				{send: "incr 1 1\r\n", want: "CLIENT_ERROR cannot increment or decrement non-numeric value\r\n"},
+				{send: "incr n x\r\n", want: "CLIENT_ERROR invalid numeric delta argument\r\n"},
+			},
+		},
+		{
+			name: "touch and expiry",
+			steps: []step{
+				{send: "touch 1 100\r\n", want: "TOUCHED\r\n"},
+				{send: "touch 2 100\r\n", want: "NOT_FOUND\r\n"},
+				{send: "set 2 0 -1 3\r\ntwo\r\n", want: "STORED\r\n"},
+				{send: "get 2\r\n", want: "END\r\n"},
+			},
+		},
+		{
+			name: "errors",
+			steps: []step{
+				{send: "nope\r\n", want: "ERROR\r\n"},
+				{send: "get " + strings.Repeat("k", 251) + "\r\n", want: "CLIENT_ERROR bad command line format\r\n"},
+				{send: "set 1 0 0\r\n", want: "CLIENT_ERROR bad command line format\r\n"},
+				{send: "version\r\n", want: "VERSION 1.6.0\r\n"},
+			},
+		},
+		{
+			name: "authentication",
+			acl:  true,
+			steps: []step{
+				{send: "get app/1\r\n", want: "CLIENT_ERROR unauthenticated\r\n"},
+				{send: "set auth 0 0 9\r\nuser nope\r\n", want: "CLIENT_ERROR authentication failure\r\n"},
+				{send: "set auth 0 0 14\r\nuser app-token\r\n", want: "STORED\r\n"},
+				{send: "set app/1 0 0 1\r\na\r\n", want: "STORED\r\n"},
+				{send: "delete app/1\r\n", want: "SERVER_ERROR token not permitted: app delete\r\n"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := logger.NewLogger()
+			logger.StartNoopLogger()
+			metrics := metrics.NewMetrics(logger)
+			metrics.StartNoopMetrics()
+			storage := store.NewStorage(logger)
+			storage.Post(store.StoreData{"1": "hello world", "json": map[string]interface{}{"a": 1}})
+
+			server := NewMemcached("127.0.0.1:0", logger, storage, metrics)
+			if tt.acl {
+				server.SetACL(testACL(t))
+			}
+			if tt.mode != "" {
+				mode := NewMode()
+				mode.Set(tt.mode)
+				server.SetMode(mode)
+			}
+			server.Start()
+			defer server.Stop()
+
+			conn, err := net.Dial(tcpnetwork, server.listener.Addr().String())
+			if err != nil {
+				t.Fatalf("failed to dial memcached server error: %v", err)
+			}
+			defer conn.Close()
+
+			for _, step := range tt.steps {
+				conn.Write([]byte(step.send))
+
+				conn.SetReadDeadline(time.Now().Add(time.Second))
+				got := make([]byte, len(step.want))
+				if _, err := io.ReadFull(conn, got); err != nil {
+					t.Fatalf("%q: read reply error: %v, got %q", step.send, err, got)
+				}
+
+				if string(got) != step.want {
+					t.Fatalf("%q: reply = %q, want %q", step.send, got, step.want)
+				}
+			}
+		})
+	}
+}
+
+// Concurrent incr on one key never conflicts, every increment lands.
+func TestMemcachedServer_incrConcurrent(t *testing.T) {
+	const clients, incrs = 8, 50
+
+	logger := logger.NewLogger()
+	logger.StartNoopLogger()
+	metrics := metrics.NewMetrics(logger)
+	metrics.StartNoopMetrics()
+	storage := store.NewStorage(logger)
+	storage.Post(store.StoreData{"n": "0"})
+
+	server := NewMemcached("127.0.0.1:0", logger, storage, metrics)
+	server.Start()
+	defer server.Stop()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, clients)
+
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			conn, err := net.Dial(tcpnetwork, server.listener.Addr().String())
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer conn.Close()
+
+			reader := bufio.NewReader(conn)
+			for j := 0; j < incrs; j++ {
+				conn.Write([]byte("incr n 1\r\n"))
+
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					errs <- err
+					return
+				}
+
+				if _, err := strconv.ParseUint(strings.TrimSpace(line), 10, 64); err != nil {
+					errs <- fmt.Errorf("incr reply = %q", line)
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if got, _ := storage.Get("n"); got != strconv.Itoa(clients*incrs) {
+		t.Errorf("stored n = %v, want %d", got, clients*incrs)
+	}
+}
+
+func Test_memcachedValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  []byte
+		flags uint32
+		want  interface{}
+	}{
+		{
+			name: "text without flags",
+			data: []byte("hello"),
+			want: "hello",
+		},
+		{
+			name:  "text with flags",
+			data:  []byte("hello"),
+			flags: 3,
+			want:  map[string]interface{}{"Flags": float64(3), "Data": "hello"},
+		},
+		{
+			name: "binary",
+			data: []byte{0xff, 0x00},
+			want: map[string]interface{}{"Flags": float64(0), "Base64": "/wA="},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := memcachedValue(tt.data, tt.flags)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("memcachedValue() = %v, want %v", got, tt.want)
+			}
+
+			data, flags := memcachedItem(got)
+			if !reflect.DeepEqual(data, tt.data) || flags != tt.flags {
+				t.Errorf("memcachedItem() = %q, %d, want %q, %d", data, flags, tt.data, tt.flags)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package protocols
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Modes the server can be switched to through the admin API.
+const (
+	// ModeNormal serves every request
+	ModeNormal = "normal"
+
+	// ModeReadOnly refuses commands which change the store, on every
+	// protocol
+	ModeReadOnly = "read-only"
+
+	// ModeMaintenance refuses every command and fails /readyz, so the
+	// server is taken out of rotation while it keeps running
+	ModeMaintenance = "maintenance"
+)
+
+var (
+	ErrModeInvalid     = errors.New("invalid mode")
+	ErrModeReadOnly    = errors.New("server is in read-only mode")
+	ErrModeMaintenance = errors.New("server is in maintenance mode")
+)
+
+// Mode is the mode shared by the dispatchers of every listener. A nil Mode
+// is always ModeNormal.
+type Mode struct {
+	mutex sync.RWMutex
+	mode  string
+}
+
+func NewMode() *Mode {
+	return &Mode{mode: ModeNormal}
+}
+
+func (m *Mode) Get() string {
+	if m == nil {
+		return ModeNormal
+	}
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return m.mode
+}
+
+// Set switches to mode, returning the mode it replaced.
+func (m *Mode) Set(mode string) (string, error) {
+	switch mode {
+	case ModeNormal, ModeReadOnly, ModeMaintenance:
+	default:
+		return "", fmt.Errorf("%w: %q", ErrModeInvalid, mode)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	old := m.mode
+	m.mode = mode
+
+	return old, nil
+}
+
+// allow returns the error refusing req, run as cmd, in the current mode.
+func (m *Mode) allow(cmd command, req jsonRequest) error {
+	switch m.Get() {
+	case ModeMaintenance:
+		return ErrModeMaintenance
+	case ModeReadOnly:
+		if cmd.write && (cmd.writes == nil || cmd.writes(req)) {
+			return ErrModeReadOnly
+		}
+	}
+
+	return nil
+}
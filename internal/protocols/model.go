@@ -1,13 +1,78 @@
 package protocols
 
+const (
+	protocolHTTP      = "http"
+	protocolTCP       = "tcp"
+	protocolUDP       = "udp"
+	protocolRedis     = "redis"
+	protocolMemcached = "memcached"
+	protocolGRPC      = "grpc"
+	protocolAdmin     = "admin"
+)
+
+const (
+	methodCAS   = "CAS"
+	methodWatch = "WATCH"
+	methodTxn   = "TXN"
+	methodList  = "LIST"
+
+	// EXPIRE sets a TTL on an existing key, TTL reads it back
+	methodExpire = "EXPIRE"
+	methodTTL    = "TTL"
+
+	// INCR and DECR add to or subtract from an integer value
+	methodIncr = "INCR"
+	methodDecr = "DECR"
+
+	// UPDATE replaces a key's value with a function of it, transports
+	// build it for changes the other commands cannot make atomically
+	methodUpdate = "UPDATE"
+)
+
 type jsonRequest struct {
 	Method  string                 `json:"Method"`
 	Query   string                 `json:"Query"`
 	Payload map[string]interface{} `json:"Payload"`
+	TTL     int64                  `json:"TTL"`
+
+	// TTL in milliseconds, used instead of TTL when set
+	TTLMillis int64 `json:"TTLMillis"`
+
+	// API token, checked against the ACL if there is one
+	Token string `json:"Token"`
+
+	// CAS fields, Query names the key to swap
+	Value           interface{} `json:"Value"`
+	Expected        interface{} `json:"Expected"`
+	ExpectedVersion *uint64     `json:"ExpectedVersion"`
+
+	// INCR and DECR amount, 1 if not given
+	Delta *int64 `json:"Delta"`
+
+	// LIST paging, Query is the key prefix
+	Cursor string `json:"Cursor"`
+	Limit  int    `json:"Limit"`
+
+	// TXN operations, applied in order all or nothing
+	Ops []jsonOp `json:"Ops"`
+
+	// UPDATE function, never decoded so UPDATE is refused over JSON
+	update func(value interface{}) (interface{}, error)
+}
+
+type jsonOp struct {
+	Op      string      `json:"Op"`
+	Key     string      `json:"Key"`
+	Value   interface{} `json:"Value"`
+	TTL     int64       `json:"TTL"`
+	Version uint64      `json:"Version"`
+
+	TTLMillis int64 `json:"TTLMillis"`
 }
 
 type jsonResponse struct {
-	Err    string      `json:"Err"`
-	Status int         `json:"Status"`
-	Data   interface{} `json:"Data"`
+	Err     string      `json:"Err"`
+	Status  int         `json:"Status"`
+	Data    interface{} `json:"Data"`
+	Version uint64      `json:"Version,omitempty"`
 }
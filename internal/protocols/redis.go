@@ -0,0 +1,951 @@
+package protocols
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"task1/internal/auth"
+	"task1/internal/logger"
+	"task1/internal/metrics"
+	"task1/internal/store"
+	"time"
+)
+
+const (
+	// redisVersion is the Redis version reported by HELLO, clients use it to
+	// pick which commands to send
+	redisVersion = "7.0.0"
+
+	scanCount      = 10
+	maxScanCursors = 4096
+)
+
+// RedisServer serves a subset of the Redis commands over RESP2 and RESP3 so
+// redis-cli and Redis client libraries can use the store. Keys hold Redis
+// strings, values stored through other protocols which are not strings are
+// read back as JSON. Every command runs through the shared dispatcher, so a
+// Redis GET is authorized and counted like a GET from any other protocol.
+type RedisServer struct {
+	listener   net.Listener
+	conns      *connSet
+	cursors    *scanCursors
+	done       chan struct{}
+	state      *listenerState
+	logger     *logger.Logger
+	dispatcher *dispatcher
+}
+
+// redisSession is the state of a single client connection.
+type redisSession struct {
+	w     respWriter
+	token string
+	quit  bool
+}
+
+// redisCommand is a Redis command. Like Redis, a negative arity is the
+// minimum number of arguments, including the command name.
+type redisCommand struct {
+	arity int
+	run   func(rs RedisServer, s *redisSession, args []string)
+}
+
+var redisCommands = map[string]redisCommand{
+	"PING":    {arity: -1, run: RedisServer.ping},
+	"ECHO":    {arity: 2, run: RedisServer.echo},
+	"QUIT":    {arity: 1, run: RedisServer.quit},
+	"SELECT":  {arity: 2, run: RedisServer.selectDB},
+	"AUTH":    {arity: -2, run: RedisServer.auth},
+	"HELLO":   {arity: -1, run: RedisServer.hello},
+	"CLIENT":  {arity: -2, run: RedisServer.client},
+	"COMMAND": {arity: -1, run: RedisServer.command},
+	"GET":     {arity: 2, run: RedisServer.get},
+	"SET":     {arity: -3, run: RedisServer.set},
+	"DEL":     {arity: -2, run: RedisServer.del},
+	"EXISTS":  {arity: -2, run: RedisServer.exists},
+	"MGET":    {arity: -2, run: RedisServer.mget},
+	"MSET":    {arity: -3, run: RedisServer.mset},
+	"KEYS":    {arity: 2, run: RedisServer.keys},
+	"SCAN":    {arity: -2, run: RedisServer.scan},
+	"EXPIRE":  {arity: 3, run: RedisServer.expire},
+	"TTL":     {arity: 2, run: RedisServer.ttl},
+	"INCR":    {arity: 2, run: RedisServer.incr},
+	"DECR":    {arity: 2, run: RedisServer.incr},
+	"INCRBY":  {arity: 3, run: RedisServer.incr},
+	"DECRBY":  {arity: 3, run: RedisServer.incr},
+}
+
+func NewRedis(
+	addr string,
+	logger *logger.Logger,
+	storage store.Store,
+	metrics *metrics.Metrics,
+) *RedisServer {
+
+	lis, err := net.Listen(tcpnetwork, addr)
+	if err != nil {
+		panic(err)
+	}
+
+	return &RedisServer{
+		listener:   lis,
+		conns:      newConnSet(protocolRedis, logger, metrics),
+		cursors:    newScanCursors(),
+		done:       make(chan struct{}),
+		state:      newListenerState(protocolRedis, lis.Addr().String()),
+		logger:     logger,
+		dispatcher: newDispatcher(logger, storage, metrics),
+	}
+}
+
+// SetTLS accepts only TLS connections, using the certificate in t. It must
+// be called before Start.
+func (rs *RedisServer) SetTLS(t *TLS) {
+	rs.listener = tls.NewListener(rs.listener, t.Config())
+}
+
+// SetACL requires every command touching keys to come from a connection
+// which sent an allowed token with AUTH or HELLO. It must be called before
+// Start.
+func (rs *RedisServer) SetACL(acl *auth.ACL) {
+	rs.dispatcher.acl = acl
+}
+
+// SetMode refuses the commands mode does not allow. It must be called
+// before Start.
+func (rs *RedisServer) SetMode(mode *Mode) {
+	rs.dispatcher.mode = mode
+}
+
+func (rs RedisServer) Status() ListenerStatus {
+	return rs.state.get()
+}
+
+func (rs RedisServer) Start() {
+	log.Printf("redis listening on %s", rs.listener.Addr().String())
+	rs.state.set(StateServing)
+
+	go func() {
+		for {
+			conn, err := rs.listener.Accept()
+			if err != nil {
+				select {
+				case <-rs.done:
+				default:
+					log.Printf("redis listener error: %v", err)
+					rs.state.fail(err)
+				}
+
+				return
+			}
+
+			go rs.redisHandler(conn, rs.conns.add(conn))
+		}
+	}()
+}
+
+func (rs RedisServer) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	rs.Shutdown(ctx)
+}
+
+// Shutdown stops accepting, lets every conn finish the commands it has read
+// until ctx is done and closes the rest. It returns how many conns were
+// drained and how many aborted.
+func (rs RedisServer) Shutdown(ctx context.Context) (drained, aborted int) {
+	rs.state.set(StateStopping)
+	close(rs.done)
+	if err := rs.listener.Close(); err != nil {
+		log.Printf("redis listener close err: %v", err)
+	}
+
+	drained, aborted = rs.conns.drain(ctx)
+	rs.state.set(StateStopped)
+	log.Printf("Redis shutdown ok, %d conns drained, %d aborted", drained, aborted)
+
+	return drained, aborted
+}
+
+// redisHandler serves commands from conn until the client quits, closes it
+// or sits idle for tcpIdleTimeout. As with tcpHandler, replies to pipelined
+// commands are only flushed once no further commands are buffered.
+func (rs RedisServer) redisHandler(conn net.Conn, connID string) {
+	defer func() {
+		conn.Close()
+		rs.conns.remove(conn)
+	}()
+
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+	session := &redisSession{w: respWriter{w: writer, proto: resp2}}
+
+	for {
+		if !rs.conns.keepAlive(conn, tcpIdleTimeout) && reader.Buffered() == 0 {
+			return
+		}
+
+		args, err := readCommand(reader)
+		if err != nil {
+			if errors.Is(err, ErrRESPProtocol) || errors.Is(err, ErrFrameTooLarge) {
+				session.w.error("ERR " + err.Error())
+				writer.Flush()
+			} else if !connEnded(err) {
+				rs.logger.Warn("conn read error", logger.F("protocol", protocolRedis), logger.F("conn", connID), logger.F("err", err))
+			}
+
+			return
+		}
+
+		if len(args) > 0 {
+			rs.execute(session, args)
+		}
+
+		if session.quit {
+			writer.Flush()
+
+			return
+		}
+
+		if reader.Buffered() == 0 {
+			if err := writer.Flush(); err != nil {
+				rs.logger.Warn("conn flush error", logger.F("protocol", protocolRedis), logger.F("conn", connID), logger.F("err", err))
+
+				return
+			}
+		}
+	}
+}
+
+// execute runs a single command, writing its reply to the session.
+func (rs RedisServer) execute(s *redisSession, args []string) {
+	name := strings.ToUpper(args[0])
+
+	cmd, ok := redisCommands[name]
+	if !ok {
+		s.w.error(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+
+		return
+	}
+
+	if (cmd.arity > 0 && len(args) != cmd.arity) || len(args) < -cmd.arity {
+		s.w.error(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(name)))
+
+		return
+	}
+
+	cmd.run(rs, s, args)
+}
+
+// dispatch runs req with the session's token.
+func (rs RedisServer) dispatch(s *redisSession, req jsonRequest) reply {
+	req.Token = s.token
+
+	return rs.dispatcher.dispatch(protocolRedis, req, false, time.Now())
+}
+
+func (rs RedisServer) ping(s *redisSession, args []string) {
+	switch len(args) {
+	case 1:
+		s.w.simple("PONG")
+	case 2:
+		s.w.bulk(args[1])
+	default:
+		s.w.error("ERR wrong number of arguments for 'ping' command")
+	}
+}
+
+func (rs RedisServer) echo(s *redisSession, args []string) {
+	s.w.bulk(args[1])
+}
+
+func (rs RedisServer) quit(s *redisSession, args []string) {
+	s.w.simple("OK")
+	s.quit = true
+}
+
+// selectDB accepts database 0, the only one there is.
+func (rs RedisServer) selectDB(s *redisSession, args []string) {
+	if args[1] != "0" {
+		s.w.error("ERR DB index is out of range")
+
+		return
+	}
+
+	s.w.simple("OK")
+}
+
+// auth takes the token as the password, with or without a username.
+func (rs RedisServer) auth(s *redisSession, args []string) {
+	if len(args) > 3 {
+		s.w.error("ERR syntax error")
+
+		return
+	}
+
+	if err := rs.authenticate(s, args[len(args)-1]); err != nil {
+		s.w.error(err.Error())
+
+		return
+	}
+
+	s.w.simple("OK")
+}
+
+func (rs RedisServer) authenticate(s *redisSession, token string) error {
+	if err := rs.dispatcher.acl.Authenticate(token); err != nil {
+		return errors.New("WRONGPASS invalid username-password pair or user is disabled.")
+	}
+
+	s.token = token
+
+	return nil
+}
+
+// hello switches the protocol version and optionally authenticates, as
+// HELLO [protover [AUTH username password] [SETNAME name]].
+func (rs RedisServer) hello(s *redisSession, args []string) {
+	proto := s.w.proto
+
+	if len(args) > 1 {
+		v, err := strconv.Atoi(args[1])
+		if err != nil {
+			s.w.error("ERR Protocol version is not an integer or out of range")
+
+			return
+		}
+
+		if v != resp2 && v != resp3 {
+			s.w.error("NOPROTO unsupported protocol version")
+
+			return
+		}
+
+		proto = v
+	}
+
+	for i := 2; i < len(args); i++ {
+		switch {
+		case strings.EqualFold(args[i], "AUTH") && i+2 < len(args):
+			if err := rs.authenticate(s, args[i+2]); err != nil {
+				s.w.error(err.Error())
+
+				return
+			}
+			i += 2
+		case strings.EqualFold(args[i], "SETNAME") && i+1 < len(args):
+			i++
+		default:
+			s.w.error(fmt.Sprintf("ERR Syntax error in HELLO option '%s'", args[i]))
+
+			return
+		}
+	}
+
+	s.w.proto = proto
+
+	s.w.mapOf(6)
+	s.w.bulk("server")
+	s.w.bulk("kvstore")
+	s.w.bulk("version")
+	s.w.bulk(redisVersion)
+	s.w.bulk("proto")
+	s.w.integer(int64(proto))
+	s.w.bulk("mode")
+	s.w.bulk("standalone")
+	s.w.bulk("role")
+	s.w.bulk("master")
+	s.w.bulk("modules")
+	s.w.array(0)
+}
+
+// client accepts the connection naming subcommands clients send on connect.
+func (rs RedisServer) client(s *redisSession, args []string) {
+	switch strings.ToUpper(args[1]) {
+	case "SETNAME", "SETINFO":
+		s.w.simple("OK")
+	default:
+		s.w.error(fmt.Sprintf("ERR unknown subcommand '%s'", args[1]))
+	}
+}
+
+// command answers COMMAND with no command docs, which redis-cli accepts.
+func (rs RedisServer) command(s *redisSession, args []string) {
+	s.w.array(0)
+}
+
+func (rs RedisServer) get(s *redisSession, args []string) {
+	rep := rs.dispatch(s, jsonRequest{Method: http.MethodGet, Query: args[1]})
+
+	switch {
+	case missing(rep.err):
+		s.w.null()
+	case rep.err != nil:
+		s.w.error(respError(rep.err))
+	default:
+		s.w.bulk(respValue(rep.data))
+	}
+}
+
+// set runs SET key value [EX seconds | PX milliseconds] [NX | XX].
+func (rs RedisServer) set(s *redisSession, args []string) {
+	var (
+		ttl     int64
+		millis  int64
+		nx, xx  bool
+		expires bool
+	)
+
+	for i := 3; i < len(args); i++ {
+		switch option := strings.ToUpper(args[i]); option {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "EX", "PX":
+			if expires || i+1 == len(args) {
+				s.w.error("ERR syntax error")
+
+				return
+			}
+
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil || n <= 0 {
+				s.w.error("ERR invalid expire time in 'set' command")
+
+				return
+			}
+
+			if option == "PX" {
+				millis = n
+			} else {
+				ttl = n
+			}
+
+			expires = true
+			i++
+		default:
+			s.w.error("ERR syntax error")
+
+			return
+		}
+	}
+
+	if nx && xx {
+		s.w.error("ERR syntax error")
+
+		return
+	}
+
+	req := jsonRequest{Method: http.MethodPost, Payload: store.StoreData{args[1]: args[2]}, TTL: ttl, TTLMillis: millis}
+	if nx || xx {
+		req = jsonRequest{Method: methodCAS, Query: args[1], Value: args[2], TTL: ttl, TTLMillis: millis}
+	}
+
+	var rep reply
+
+	for i := 0; ; i++ {
+		if xx {
+			// XX swaps whatever version is there, so the key must exist
+			current := rs.dispatch(s, jsonRequest{Method: http.MethodGet, Query: args[1]})
+			if current.err != nil {
+				rep = current
+				break
+			}
+
+			req.ExpectedVersion = &current.version
+		} else if nx {
+			var none uint64
+			req.ExpectedVersion = &none
+		}
+
+		rep = rs.dispatch(s, req)
+		if !xx || !errors.Is(rep.err, store.ErrCASConflict) || i == expireRetries {
+			break
+		}
+	}
+
+	switch {
+	case errors.Is(rep.err, store.ErrCASConflict), xx && missing(rep.err):
+		// the NX or XX condition did not hold
+		s.w.null()
+	case rep.err != nil:
+		s.w.error(respError(rep.err))
+	default:
+		s.w.simple("OK")
+	}
+}
+
+func (rs RedisServer) del(s *redisSession, args []string) {
+	var deleted int64
+
+	for _, key := range args[1:] {
+		rep := rs.dispatch(s, jsonRequest{Method: http.MethodDelete, Query: key})
+
+		switch {
+		case missing(rep.err):
+		case rep.err != nil:
+			s.w.error(respError(rep.err))
+
+			return
+		default:
+			deleted++
+		}
+	}
+
+	s.w.integer(deleted)
+}
+
+// exists counts the keys given which exist, a key given twice counts twice.
+func (rs RedisServer) exists(s *redisSession, args []string) {
+	results, err := rs.getAll(s, args[1:])
+	if err != nil {
+		s.w.error(respError(err))
+
+		return
+	}
+
+	var found int64
+	for _, result := range results {
+		if result.Err == "" {
+			found++
+		}
+	}
+
+	s.w.integer(found)
+}
+
+func (rs RedisServer) mget(s *redisSession, args []string) {
+	results, err := rs.getAll(s, args[1:])
+	if err != nil {
+		s.w.error(respError(err))
+
+		return
+	}
+
+	s.w.array(len(results))
+	for _, result := range results {
+		if result.Err != "" {
+			s.w.null()
+		} else {
+			s.w.bulk(respValue(result.Value))
+		}
+	}
+}
+
+// getAll reads keys in a single transaction so they come from one point in
+// time.
+func (rs RedisServer) getAll(s *redisSession, keys []string) ([]store.TxResult, error) {
+	ops := make([]jsonOp, len(keys))
+	for i, key := range keys {
+		ops[i] = jsonOp{Op: store.TxGet, Key: key}
+	}
+
+	rep := rs.dispatch(s, jsonRequest{Method: methodTxn, Ops: ops})
+	if rep.err != nil {
+		return nil, rep.err
+	}
+
+	return rep.data.([]store.TxResult), nil
+}
+
+func (rs RedisServer) mset(s *redisSession, args []string) {
+	if len(args)%2 != 1 {
+		s.w.error("ERR wrong number of arguments for 'mset' command")
+
+		return
+	}
+
+	payload := make(store.StoreData, len(args)/2)
+	for i := 1; i < len(args); i += 2 {
+		payload[args[i]] = args[i+1]
+	}
+
+	rep := rs.dispatch(s, jsonRequest{Method: http.MethodPost, Payload: payload})
+	if rep.err != nil {
+		s.w.error(respError(rep.err))
+
+		return
+	}
+
+	s.w.simple("OK")
+}
+
+// keys lists every key matching a glob pattern, paging through LIST from the
+// pattern's literal prefix.
+func (rs RedisServer) keys(s *redisSession, args []string) {
+	pattern := args[1]
+	matched := make([]string, 0)
+	cursor := ""
+
+	for {
+		rep := rs.dispatch(s, jsonRequest{
+			Method: methodList,
+			Query:  globPrefix(pattern),
+			Cursor: cursor,
+			Limit:  store.MaxListLimit,
+		})
+		if rep.err != nil {
+			s.w.error(respError(rep.err))
+
+			return
+		}
+
+		page := rep.data.(store.ListPage)
+		for _, key := range page.Keys {
+			if matchGlob(pattern, key) {
+				matched = append(matched, key)
+			}
+		}
+
+		if page.Next == "" {
+			break
+		}
+
+		cursor = page.Next
+	}
+
+	s.w.bulks(matched)
+}
+
+// scan runs SCAN cursor [MATCH pattern] [COUNT count]. Redis clients expect
+// numeric cursors while LIST pages from the last key seen, so the key is kept
+// server side under a cursor ID. Only the most recent maxScanCursors cursors
+// are kept.
+func (rs RedisServer) scan(s *redisSession, args []string) {
+	id, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		s.w.error("ERR invalid cursor")
+
+		return
+	}
+
+	pattern, count := "*", scanCount
+
+	for i := 2; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			s.w.error("ERR syntax error")
+
+			return
+		}
+
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			pattern = args[i+1]
+		case "COUNT":
+			count, err = strconv.Atoi(args[i+1])
+			if err != nil || count < 1 {
+				s.w.error("ERR value is out of range, must be positive")
+
+				return
+			}
+
+			if count > store.MaxListLimit {
+				count = store.MaxListLimit
+			}
+		default:
+			s.w.error("ERR syntax error")
+
+			return
+		}
+	}
+
+	cursor := ""
+	if id != 0 {
+		var ok bool
+		if cursor, ok = rs.cursors.get(id); !ok {
+			s.w.error("ERR invalid cursor")
+
+			return
+		}
+	}
+
+	rep := rs.dispatch(s, jsonRequest{
+		Method: methodList,
+		Query:  globPrefix(pattern),
+		Cursor: cursor,
+		Limit:  count,
+	})
+	if rep.err != nil {
+		s.w.error(respError(rep.err))
+
+		return
+	}
+
+	page := rep.data.(store.ListPage)
+
+	matched := make([]string, 0, len(page.Keys))
+	for _, key := range page.Keys {
+		if matchGlob(pattern, key) {
+			matched = append(matched, key)
+		}
+	}
+
+	var next uint64
+	if page.Next != "" {
+		next = rs.cursors.put(page.Next)
+	}
+
+	s.w.array(2)
+	s.w.bulk(strconv.FormatUint(next, 10))
+	s.w.bulks(matched)
+}
+
+// expire sets a ttl in seconds, replying 1 or 0 if the key does not exist.
+func (rs RedisServer) expire(s *redisSession, args []string) {
+	seconds, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		s.w.error("ERR value is not an integer or out of range")
+
+		return
+	}
+
+	rep := rs.dispatch(s, jsonRequest{Method: methodExpire, Query: args[1], TTL: seconds})
+
+	switch {
+	case missing(rep.err):
+		s.w.integer(0)
+	case rep.err != nil:
+		s.w.error(respError(rep.err))
+	default:
+		s.w.integer(1)
+	}
+}
+
+// ttl replies with the seconds left, -1 for no ttl or -2 for no key.
+func (rs RedisServer) ttl(s *redisSession, args []string) {
+	rep := rs.dispatch(s, jsonRequest{Method: methodTTL, Query: args[1]})
+
+	switch {
+	case missing(rep.err):
+		s.w.integer(-2)
+	case rep.err != nil:
+		s.w.error(respError(rep.err))
+	default:
+		s.w.integer(rep.data.(int64))
+	}
+}
+
+// incr runs INCR, DECR, INCRBY and DECRBY, replying with the new value.
+func (rs RedisServer) incr(s *redisSession, args []string) {
+	name := strings.ToUpper(args[0])
+
+	req := jsonRequest{Method: methodIncr, Query: args[1]}
+	if strings.HasPrefix(name, "DECR") {
+		req.Method = methodDecr
+	}
+
+	if len(args) == 3 {
+		delta, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			s.w.error(respError(store.ErrNotInteger))
+
+			return
+		}
+
+		req.Delta = &delta
+	}
+
+	rep := rs.dispatch(s, req)
+	if rep.err != nil {
+		s.w.error(respError(rep.err))
+
+		return
+	}
+
+	s.w.integer(rep.data.(int64))
+}
+
+// missing reports whether err means the key does not exist.
+func missing(err error) bool {
+	return errors.Is(err, store.ErrStoreKeyNotFound) || errors.Is(err, store.ErrStoreEmpty)
+}
+
+// respError converts err to a Redis error reply, prefixed with the error code
+// Redis would use.
+func respError(err error) string {
+	switch {
+	case errors.Is(err, auth.ErrUnauthorized):
+		return "NOAUTH Authentication required."
+	case errors.Is(err, auth.ErrForbidden):
+		return "NOPERM " + err.Error()
+	case errors.Is(err, store.ErrReadOnly), errors.Is(err, ErrModeReadOnly):
+		return "READONLY " + err.Error()
+	case errors.Is(err, store.ErrStoreFull):
+		return "OOM " + err.Error()
+	case errors.Is(err, store.ErrNotInteger):
+		return "ERR value is not an integer or out of range"
+	default:
+		return "ERR " + err.Error()
+	}
+}
+
+// respValue returns a stored value as a Redis string, JSON encoding anything
+// which is not a string.
+func respValue(value interface{}) string {
+	if text, ok := value.(string); ok {
+		return text
+	}
+
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+
+	return string(raw)
+}
+
+// scanCursors maps SCAN cursor IDs to the key the next page starts after.
+type scanCursors struct {
+	mutex sync.Mutex
+	next  uint64
+	keys  map[uint64]string
+	// IDs oldest first, so the oldest can be dropped
+	order []uint64
+}
+
+func newScanCursors() *scanCursors {
+	return &scanCursors{keys: make(map[uint64]string)}
+}
+
+func (c *scanCursors) put(key string) uint64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.next++
+	c.keys[c.next] = key
+	c.order = append(c.order, c.next)
+
+	if len(c.order) > maxScanCursors {
+		delete(c.keys, c.order[0])
+		c.order = c.order[1:]
+	}
+
+	return c.next
+}
+
+func (c *scanCursors) get(id uint64) (string, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	key, ok := c.keys[id]
+
+	return key, ok
+}
+
+// globPrefix returns the literal start of a glob pattern, which every match
+// starts with.
+func globPrefix(pattern string) string {
+	if i := strings.IndexAny(pattern, `*?[\`); i >= 0 {
+		return pattern[:i]
+	}
+
+	return pattern
+}
+
+// matchGlob reports whether s matches a Redis glob pattern, where * matches
+// any run of characters, ? any single character, [abc], [^abc] and [a-z]
+// character classes, and \ escapes the next character.
+func matchGlob(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+
+			if len(pattern) == 0 {
+				return true
+			}
+
+			for i := 0; i <= len(s); i++ {
+				if matchGlob(pattern, s[i:]) {
+					return true
+				}
+			}
+
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+
+			end := strings.IndexByte(pattern[1:], ']')
+			if end < 0 {
+				// an unterminated class matches itself
+				if s[0] != '[' {
+					return false
+				}
+
+				break
+			}
+
+			class := pattern[1 : end+1]
+			if !matchClass(class, s[0]) {
+				return false
+			}
+
+			pattern = pattern[end+1:]
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+
+			fallthrough
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+		}
+
+		pattern = pattern[1:]
+		s = s[1:]
+	}
+
+	return len(s) == 0
+}
+
+func matchClass(class string, c byte) bool {
+	negate := strings.HasPrefix(class, "^")
+	if negate {
+		class = class[1:]
+	}
+
+	matched := false
+
+	for i := 0; i < len(class); i++ {
+		if i+2 < len(class) && class[i+1] == '-' {
+			lo, hi := class[i], class[i+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+
+			if c >= lo && c <= hi {
+				matched = true
+			}
+
+			i += 2
+
+			continue
+		}
+
+		if class[i] == c {
+			matched = true
+		}
+	}
+
+	return matched != negate
+}
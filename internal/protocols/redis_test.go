@@ -0,0 +1,264 @@
+package protocols
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"task1/internal/logger"
+	"task1/internal/metrics"
+	"task1/internal/store"
+	"testing"
+	"time"
+)
+
+// respCommand encodes args as a RESP array of bulk strings.
+func respCommand(args ...string) string {
+	var b strings.Builder
+
+	b.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		b.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n")
+	}
+
+	return b.String()
+}
+
+func TestRedisServer_redisHandler(t *testing.T) {
+	type step struct {
+		args []string
+		want string
+	}
+	tests := []struct {
+		name  string
+		acl   bool
+		mode  string
+		steps []step
+	}{
+		{
+			name: "read-only - MGET and EXISTS served, SET refused",
+			mode: ModeReadOnly,
+			steps: []step{
+				{args: []string{"MGET", "1", "2"}, want: "*2\r\n$11\r\nhello world\r\n$-1\r\n"},
+				{args: []string{"EXISTS", "1", "2"}, want: ":1\r\n"},
+				{args: []string{"SET", "2", "two"}, want: "-READONLY server is in read-only mode\r\n"},
+			},
+		},
+		{
+			name: "PING and ECHO",
+			steps: []step{
+				{args: []string{"PING"}, want: "+PONG\r\n"},
+				{args: []string{"ping", "hi"}, want: "$2\r\nhi\r\n"},
+				{args: []string{"ECHO", "hello"}, want: "$5\r\nhello\r\n"},
+			},
+		},
+		{
+			name: "GET and SET",
+			steps: []step{
+				{args: []string{"GET", "1"}, want: "$11\r\nhello world\r\n"},
+				{args: []string{"GET", "2"}, want: "$-1\r\n"},
+				{args: []string{"SET", "2", "two"}, want: "+OK\r\n"},
+				{args: []string{"GET", "2"}, want: "$3\r\ntwo\r\n"},
+				{args: []string{"GET", "json"}, want: "$7\r\n{\"a\":1}\r\n"},
+			},
+		},
+		{
+			name: "SET NX and XX",
+			steps: []step{
+				{args: []string{"SET", "1", "again", "NX"}, want: "$-1\r\n"},
+				{args: []string{"SET", "2", "new", "NX"}, want: "+OK\r\n"},
+				{args: []string{"SET", "3", "missing", "XX"}, want: "$-1\r\n"},
+				{args: []string{"SET", "1", "swapped", "XX"}, want: "+OK\r\n"},
+				{args: []string{"GET", "1"}, want: "$7\r\nswapped\r\n"},
+				{args: []string{"SET", "1", "x", "NX", "XX"}, want: "-ERR syntax error\r\n"},
+			},
+		},
+		{
+			name: "DEL, EXISTS, MGET and MSET",
+			steps: []step{
+				{args: []string{"MSET", "2", "two", "3", "three"}, want: "+OK\r\n"},
+				{args: []string{"EXISTS", "1", "2", "4", "1"}, want: ":3\r\n"},
+				{args: []string{"MGET", "1", "4", "3"}, want: "*3\r\n$11\r\nhello world\r\n$-1\r\n$5\r\nthree\r\n"},
+				{args: []string{"DEL", "1", "2", "4"}, want: ":2\r\n"},
+				{args: []string{"MSET", "2"}, want: "-ERR wrong number of arguments for 'mset' command\r\n"},
+			},
+		},
+		{
+			name: "KEYS and SCAN",
+			steps: []step{
+				{args: []string{"MSET", "app/1", "a", "app/2", "b", "app/3", "c"}, want: "+OK\r\n"},
+				{args: []string{"KEYS", "app/*"}, want: "*3\r\n$5\r\napp/1\r\n$5\r\napp/2\r\n$5\r\napp/3\r\n"},
+				{args: []string{"KEYS", "*/2"}, want: "*1\r\n$5\r\napp/2\r\n"},
+				{args: []string{"SCAN", "0", "MATCH", "app/*", "COUNT", "2"}, want: "*2\r\n$1\r\n1\r\n*2\r\n$5\r\napp/1\r\n$5\r\napp/2\r\n"},
+				{args: []string{"SCAN", "1", "MATCH", "app/*", "COUNT", "2"}, want: "*2\r\n$1\r\n0\r\n*1\r\n$5\r\napp/3\r\n"},
+				{args: []string{"SCAN", "99"}, want: "-ERR invalid cursor\r\n"},
+			},
+		},
+		{
+			name: "EXPIRE and TTL",
+			steps: []step{
+				{args: []string{"TTL", "1"}, want: ":-1\r\n"},
+				{args: []string{"TTL", "2"}, want: ":-2\r\n"},
+				{args: []string{"EXPIRE", "1", "100"}, want: ":1\r\n"},
+				{args: []string{"TTL", "1"}, want: ":100\r\n"},
+				{args: []string{"GET", "1"}, want: "$11\r\nhello world\r\n"},
+				{args: []string{"EXPIRE", "2", "100"}, want: ":0\r\n"},
+				{args: []string{"SET", "2", "two", "EX", "50"}, want: "+OK\r\n"},
+				{args: []string{"TTL", "2"}, want: ":50\r\n"},
+				{args: []string{"EXPIRE", "1", "0"}, want: ":1\r\n"},
+				{args: []string{"GET", "1"}, want: "$-1\r\n"},
+			},
+		},
+		{
+			name: "INCR and DECR",
+			steps: []step{
+				{args: []string{"INCR", "n"}, want: ":1\r\n"},
+				{args: []string{"INCRBY", "n", "10"}, want: ":11\r\n"},
+				{args: []string{"DECR", "n"}, want: ":10\r\n"},
+				{args: []string{"DECRBY", "n", "15"}, want: ":-5\r\n"},
+				{args: []string{"GET", "n"}, want: "$2\r\n-5\r\n"},
+				{args: []string{"SET", "s", "41"}, want: "+OK\r\n"},
+				{args: []string{"INCR", "s"}, want: ":42\r\n"},
+				{args: []string{"GET", "s"}, want: "$2\r\n42\r\n"},
+				{args: []string{"INCR", "1"}, want: "-ERR value is not an integer or out of range\r\n"},
+				{args: []string{"INCRBY", "n", "x"}, want: "-ERR value is not an integer or out of range\r\n"},
+				{args: []string{"SET", "max", "9223372036854775807"}, want: "+OK\r\n"},
+				{args: []string{"INCR", "max"}, want: "-ERR increment or decrement would overflow\r\n"},
+			},
+		},
+		{
+			name: "HELLO 3",
+			steps: []step{
+				{args: []string{"HELLO", "3"}, want: "%6\r\n$6\r\nserver\r\n$7\r\nkvstore\r\n$7\r\nversion\r\n$5\r\n7.0.0\r\n" +
+					"$5\r\nproto\r\n:3\r\n$4\r\nmode\r\n$10\r\nstandalone\r\n$4\r\nrole\r\n$6\r\nmaster\r\n$7\r\nmodules\r\n*0\r\n"},
+				{args: []string{"GET", "2"}, want: "_\r\n"},
+				{args: []string{"HELLO", "4"}, want: "-NOPROTO unsupported protocol version\r\n"},
+			},
+		},
+		{
+			name: "errors",
+			steps: []step{
+				{args: []string{"NOPE"}, want: "-ERR unknown command 'NOPE'\r\n"},
+				{args: []string{"GET"}, want: "-ERR wrong number of arguments for 'get' command\r\n"},
+				{args: []string{"SELECT", "1"}, want: "-ERR DB index is out of range\r\n"},
+			},
+		},
+		{
+			name: "AUTH",
+			acl:  true,
+			steps: []step{
+				{args: []string{"GET", "app/1"}, want: "-NOAUTH Authentication required.\r\n"},
+				{args: []string{"AUTH", "nope"}, want: "-WRONGPASS invalid username-password pair or user is disabled.\r\n"},
+				{args: []string{"AUTH", "default", "app-token"}, want: "+OK\r\n"},
+				{args: []string{"SET", "app/1", "a"}, want: "+OK\r\n"},
+				{args: []string{"GET", "app/1"}, want: "$1\r\na\r\n"},
+				{args: []string{"DEL", "app/1"}, want: "-NOPERM token not permitted: app delete\r\n"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := logger.NewLogger()
+			logger.StartNoopLogger()
+			metrics := metrics.NewMetrics(logger)
+			metrics.StartNoopMetrics()
+			storage := store.NewStorage(logger)
+			storage.Post(store.StoreData{"1": "hello world", "json": map[string]interface{}{"a": 1}})
+
+			server := NewRedis("127.0.0.1:0", logger, storage, metrics)
+			if tt.acl {
+				server.SetACL(testACL(t))
+			}
+			if tt.mode != "" {
+				mode := NewMode()
+				mode.Set(tt.mode)
+				server.SetMode(mode)
+			}
+			server.Start()
+			defer server.Stop()
+
+			conn, err := net.Dial(tcpnetwork, server.listener.Addr().String())
+			if err != nil {
+				t.Fatalf("failed to dial redis server error: %v", err)
+			}
+			defer conn.Close()
+
+			for _, step := range tt.steps {
+				conn.Write([]byte(respCommand(step.args...)))
+
+				conn.SetReadDeadline(time.Now().Add(time.Second))
+				got := make([]byte, len(step.want))
+				if _, err := io.ReadFull(conn, got); err != nil {
+					t.Fatalf("%v: read reply error: %v, got %q", step.args, err, got)
+				}
+
+				if string(got) != step.want {
+					t.Fatalf("%v: reply = %q, want %q", step.args, got, step.want)
+				}
+			}
+		})
+	}
+}
+
+// PX keeps its milliseconds rather than rounding up to whole seconds.
+func TestRedisServer_setPX(t *testing.T) {
+	logger := logger.NewLogger()
+	logger.StartNoopLogger()
+	metrics := metrics.NewMetrics(logger)
+	metrics.StartNoopMetrics()
+	storage := store.NewStorage(logger)
+
+	server := NewRedis("127.0.0.1:0", logger, storage, metrics)
+	server.Start()
+	defer server.Stop()
+
+	conn, err := net.Dial(tcpnetwork, server.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial redis server error: %v", err)
+	}
+	defer conn.Close()
+
+	conn.Write([]byte(respCommand("SET", "1", "one", "PX", "1500")))
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	got := make([]byte, len("+OK\r\n"))
+	if _, err := io.ReadFull(conn, got); err != nil || string(got) != "+OK\r\n" {
+		t.Fatalf("SET reply = %q, %v, want +OK", got, err)
+	}
+
+	if ttl, _ := storage.TTL("1"); ttl <= time.Second || ttl > 1500*time.Millisecond {
+		t.Errorf("stored 1 ttl = %v, want over 1s up to 1.5s", ttl)
+	}
+}
+
+func TestRedisServer_pipelined(t *testing.T) {
+	logger := logger.NewLogger()
+	logger.StartNoopLogger()
+	metrics := metrics.NewMetrics(logger)
+	metrics.StartNoopMetrics()
+	storage := store.NewStorage(logger)
+
+	server := NewRedis("127.0.0.1:0", logger, storage, metrics)
+	server.Start()
+	defer server.Stop()
+
+	conn, err := net.Dial(tcpnetwork, server.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial redis server error: %v", err)
+	}
+	defer conn.Close()
+
+	// inline commands, as sent from telnet, mixed with RESP arrays
+	conn.Write([]byte("SET 1 one\r\n" + respCommand("GET", "1") + "QUIT\r\n"))
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	got, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("read replies error: %v", err)
+	}
+
+	want := "+OK\r\n$3\r\none\r\n+OK\r\n"
+	if string(got) != want {
+		t.Errorf("pipelined replies = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,140 @@
+package protocols
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// RESP is the Redis serialization protocol. Clients send commands as arrays
+// of bulk strings, or as a single line of space separated words for telnet
+// style use. Replies use RESP2 until a client switches to RESP3 with HELLO 3,
+// which only changes how nulls and maps are written.
+const (
+	resp2 = 2
+	resp3 = 3
+
+	// maxRESPArgs caps the arguments of a single command
+	maxRESPArgs = 1 << 16
+)
+
+var ErrRESPProtocol = errors.New("protocol error")
+
+// readCommand reads the next command from r. A blank inline line returns no
+// arguments and no error.
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readRESPLine(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) == 0 || line[0] != '*' {
+		return strings.Fields(line), nil
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 0 || n > maxRESPArgs {
+		return nil, fmt.Errorf("%w: invalid multibulk length", ErrRESPProtocol)
+	}
+
+	args := make([]string, 0, n)
+
+	for i := 0; i < n; i++ {
+		line, err := readRESPLine(r)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(line) == 0 || line[0] != '$' {
+			return nil, fmt.Errorf("%w: expected '$', got %q", ErrRESPProtocol, line)
+		}
+
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || size > maxFrameSize {
+			return nil, fmt.Errorf("%w: invalid bulk length", ErrRESPProtocol)
+		}
+
+		bulk := make([]byte, size+2)
+		if _, err := io.ReadFull(r, bulk); err != nil {
+			return nil, err
+		}
+
+		if bulk[size] != '\r' || bulk[size+1] != '\n' {
+			return nil, fmt.Errorf("%w: bulk string not terminated", ErrRESPProtocol)
+		}
+
+		args = append(args, string(bulk[:size]))
+	}
+
+	return args, nil
+}
+
+// readRESPLine reads a line without its trailing CRLF.
+func readRESPLine(r *bufio.Reader) (string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+// respWriter writes replies in the protocol version the client chose.
+type respWriter struct {
+	w     *bufio.Writer
+	proto int
+}
+
+func (rw *respWriter) simple(s string) {
+	rw.w.WriteString("+" + s + "\r\n")
+}
+
+// error writes msg, which starts with an upper case error code such as ERR.
+func (rw *respWriter) error(msg string) {
+	rw.w.WriteString("-" + strings.ReplaceAll(msg, "\r\n", " ") + "\r\n")
+}
+
+func (rw *respWriter) integer(n int64) {
+	rw.w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+func (rw *respWriter) bulk(s string) {
+	rw.w.WriteString("$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n")
+}
+
+func (rw *respWriter) null() {
+	if rw.proto == resp3 {
+		rw.w.WriteString("_\r\n")
+
+		return
+	}
+
+	rw.w.WriteString("$-1\r\n")
+}
+
+// array starts an array of n elements, which are written next.
+func (rw *respWriter) array(n int) {
+	rw.w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
+
+// mapOf starts a map of n key value pairs, which are written next as 2n
+// elements. RESP2 has no map type so a flat array is used instead.
+func (rw *respWriter) mapOf(n int) {
+	if rw.proto == resp3 {
+		rw.w.WriteString("%" + strconv.Itoa(n) + "\r\n")
+
+		return
+	}
+
+	rw.array(2 * n)
+}
+
+func (rw *respWriter) bulks(values []string) {
+	rw.array(len(values))
+	for _, v := range values {
+		rw.bulk(v)
+	}
+}
@@ -0,0 +1,91 @@
+package protocols
+
+import (
+	"bufio"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_readCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []string
+		wantErr error
+	}{
+		{
+			name:  "array ok",
+			input: "*3\r\n$3\r\nSET\r\n$1\r\n1\r\n$11\r\nhello world\r\n",
+			want:  []string{"SET", "1", "hello world"},
+		},
+		{
+			name:  "array ok - binary safe",
+			input: "*2\r\n$3\r\nGET\r\n$4\r\na\r\nb\r\n",
+			want:  []string{"GET", "a\r\nb"},
+		},
+		{
+			name:  "inline ok",
+			input: "GET  1\r\n",
+			want:  []string{"GET", "1"},
+		},
+		{
+			name:  "inline ok - blank line",
+			input: "\r\n",
+			want:  []string{},
+		},
+		{
+			name:    "fail - bulk length missing",
+			input:   "*1\r\nGET\r\n",
+			wantErr: ErrRESPProtocol,
+		},
+		{
+			name:    "fail - bulk not terminated",
+			input:   "*1\r\n$3\r\nGETX\r\n",
+			wantErr: ErrRESPProtocol,
+		},
+		{
+			name:    "fail - bad multibulk length",
+			input:   "*x\r\n",
+			wantErr: ErrRESPProtocol,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readCommand(bufio.NewReader(strings.NewReader(tt.input)))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("readCommand() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readCommand() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_matchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		want    bool
+	}{
+		{pattern: "*", s: "app/1", want: true},
+		{pattern: "app/*", s: "app/users/1", want: true},
+		{pattern: "app/*/1", s: "app/users/1", want: true},
+		{pattern: "app/?", s: "app/12", want: false},
+		{pattern: "h[ae]llo", s: "hallo", want: true},
+		{pattern: "h[^e]llo", s: "hello", want: false},
+		{pattern: "key[0-9]", s: "key7", want: true},
+		{pattern: `key\*`, s: "key*", want: true},
+		{pattern: `key\*`, s: "key1", want: false},
+		{pattern: "app/*", s: "other/1", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.s, func(t *testing.T) {
+			if got := matchGlob(tt.pattern, tt.s); got != tt.want {
+				t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,100 +1,129 @@
 package protocols
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"crypto/rand"
-	"encoding/json"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"log"
 	"net"
-	"net/http"
+	"task1/internal/auth"
 	"task1/internal/logger"
 	"task1/internal/metrics"
 	"task1/internal/store"
+	"time"
 )
 
 const (
-	tcpaddr    = ":8181"
-	tcpnetwork = "tcp"
+	tcpnetwork     = "tcp"
+	tcpIdleTimeout = 5 * time.Minute
 )
 
 type TCPServer struct {
-	listener net.Listener
-	conns    map[string]net.Conn
-	done     chan struct{}
-	logger   *logger.Logger
-	storage  *store.Storage
-	metrics  *metrics.Metrics
+	listener   net.Listener
+	conns      *connSet
+	done       chan struct{}
+	state      *listenerState
+	logger     *logger.Logger
+	storage    store.Store
+	metrics    *metrics.Metrics
+	dispatcher *dispatcher
 }
 
 func NewTCP(
+	addr string,
 	logger *logger.Logger,
-	storage *store.Storage,
+	storage store.Store,
 	metrics *metrics.Metrics,
 ) *TCPServer {
 
-	lis, err := net.Listen(tcpnetwork, tcpaddr)
+	lis, err := net.Listen(tcpnetwork, addr)
 	if err != nil {
 		panic(err)
 	}
 
 	return &TCPServer{
-		listener: lis,
-		conns:    make(map[string]net.Conn),
-		done:     make(chan struct{}),
-		logger:   logger,
-		storage:  storage,
-		metrics:  metrics,
+		listener:   lis,
+		conns:      newConnSet(protocolTCP, logger, metrics),
+		done:       make(chan struct{}),
+		state:      newListenerState(protocolTCP, lis.Addr().String()),
+		logger:     logger,
+		storage:    storage,
+		metrics:    metrics,
+		dispatcher: newDispatcher(logger, storage, metrics),
 	}
 }
 
+// SetTLS accepts only TLS connections, using the certificate in t. It must
+// be called before Start.
+func (ts *TCPServer) SetTLS(t *TLS) {
+	ts.listener = tls.NewListener(ts.listener, t.Config())
+}
+
+// SetACL requires every request to carry a token allowed by acl. It must be
+// called before Start.
+func (ts *TCPServer) SetACL(acl *auth.ACL) {
+	ts.dispatcher.acl = acl
+}
+
+// SetMode refuses the requests mode does not allow. It must be called before
+// Start.
+func (ts *TCPServer) SetMode(mode *Mode) {
+	ts.dispatcher.mode = mode
+}
+
+func (ts TCPServer) Status() ListenerStatus {
+	return ts.state.get()
+}
+
 func (ts TCPServer) Start() {
+	log.Printf("tcp listening on %s", ts.listener.Addr().String())
+	ts.state.set(StateServing)
+
 	go func() {
 		for {
-			log.Printf("tcp listning on %s", tcpaddr)
 			conn, err := ts.listener.Accept()
 			if err != nil {
 				select {
 				case <-ts.done:
-					if len(ts.conns) > 0 {
-						ts.logger.Log("closing active conns")
-
-						for n, c := range ts.conns {
-							ts.logger.Log(fmt.Sprintf("active conn %s closed", n))
-							c.Close()
-						}
-					}
-					return
 				default:
 					log.Printf("listener error: %v", err)
-
-					return
+					ts.state.fail(err)
 				}
-			}
 
-			connID := createConnID()
-			ts.addConn(conn, connID)
+				return
+			}
 
-			go ts.tcpHandler(conn, connID)
+			go ts.tcpHandler(conn, ts.conns.add(conn))
 		}
 	}()
 }
 
 func (ts TCPServer) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	ts.Shutdown(ctx)
+}
+
+// Shutdown stops accepting, lets every conn finish the requests it has read
+// until ctx is done and closes the rest. It returns how many conns were
+// drained and how many aborted.
+func (ts TCPServer) Shutdown(ctx context.Context) (drained, aborted int) {
+	ts.state.set(StateStopping)
 	close(ts.done)
 	if err := ts.listener.Close(); err != nil {
 		log.Printf("listener close err: %v", err)
 	}
-	log.Print("TCP shutdown ok")
-}
 
-func (ts TCPServer) addConn(conn net.Conn, connID string) {
-	ts.logger.Log(fmt.Sprintf("conn %s added", connID))
-	ts.conns[connID] = conn
-}
+	drained, aborted = ts.conns.drain(ctx)
+	ts.state.set(StateStopped)
+	log.Printf("TCP shutdown ok, %d conns drained, %d aborted", drained, aborted)
 
-func (ts TCPServer) removeConn(connID string) {
-	ts.logger.Log(fmt.Sprintf("conn %s removed", connID))
-	delete(ts.conns, connID)
+	return drained, aborted
 }
 
 func createConnID() string {
@@ -105,42 +134,112 @@ func createConnID() string {
 	return fmt.Sprintf("%X", id[0:4])
 }
 
+// tcpHandler serves requests from conn until the client closes it or it
+// sits idle for tcpIdleTimeout. Requests are answered in the order they are
+// read, and responses are only flushed once no further pipelined requests are
+// buffered, so a client can write many requests before reading any replies.
 func (ts TCPServer) tcpHandler(conn net.Conn, connID string) {
-	var (
-		err       error
-		n         int
-		req       jsonRequest
-		storeData interface{}
-	)
-
-	buf := make([]byte, 1024)
-	if err == nil {
-		n, err = conn.Read(buf)
+	defer func() {
+		conn.Close()
+		ts.conns.remove(conn)
+	}()
+
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+
+	for {
+		if !ts.conns.keepAlive(conn, tcpIdleTimeout) && reader.Buffered() == 0 {
+			return
+		}
+
+		frame, framing, err := readFrame(reader)
+		if len(bytes.TrimSpace(frame)) > 0 {
+			response, prefix, watching := ts.handleRequest(frame)
+
+			if werr := writeFrame(writer, framing, response); werr != nil {
+				ts.logger.Warn("conn write error", logger.F("protocol", protocolTCP), logger.F("conn", connID), logger.F("err", werr))
+
+				return
+			}
+
+			if watching && err == nil {
+				ts.watch(conn, reader, writer, framing, connID, prefix)
+
+				return
+			}
+		}
+
+		if err != nil {
+			if errors.Is(err, ErrFrameTooLarge) {
+				_, out := BuildJsonResponse(err, nil, ts.logger)
+				writeFrame(writer, framing, out)
+			} else if !connEnded(err) {
+				ts.logger.Warn("conn read error", logger.F("protocol", protocolTCP), logger.F("conn", connID), logger.F("err", err))
+			}
+
+			writer.Flush()
+
+			return
+		}
+
+		if reader.Buffered() == 0 {
+			if err := writer.Flush(); err != nil {
+				ts.logger.Warn("conn flush error", logger.F("protocol", protocolTCP), logger.F("conn", connID), logger.F("err", err))
+
+				return
+			}
+		}
 	}
+}
+
+// handleRequest answers a single request. A successful WATCH reports the
+// prefix to watch so the caller can switch the conn to subscription mode.
+func (ts TCPServer) handleRequest(frame []byte) ([]byte, string, bool) {
+	rep := ts.dispatcher.dispatchFrame(protocolTCP, frame, true)
+
+	return rep.body, rep.req.Query, rep.watch
+}
 
-	if err == nil {
-		err = json.Unmarshal(buf[:n], &req)
+// watch puts conn into subscription mode, streaming an event frame for every
+// change to a key starting with prefix. No further requests are read, any
+// data or a close from the client ends the subscription along with the conn.
+func (ts TCPServer) watch(conn net.Conn, reader *bufio.Reader, writer *bufio.Writer, framing int, connID string, prefix string) {
+	events, cancel := ts.storage.Watch(prefix)
+	defer cancel()
+
+	if err := writer.Flush(); err != nil {
+		return
 	}
 
-	if err == nil {
-		ts.metrics.LogMetrics(req.Method)
-		switch req.Method {
-		case http.MethodGet:
-			ts.logger.Log("TCP GET request")
-			storeData, err = ts.storage.Get(req.Query)
-		case http.MethodPost:
-			ts.logger.Log("TCP POST request")
-			err = ts.storage.Post(req.Payload)
-		case http.MethodDelete:
-			ts.logger.Log("TCP DELETE request")
-			err = ts.storage.Delete(req.Query)
-		default:
-			err = ErrRouteForbidden
-		}
+	if !ts.conns.keepAlive(conn, 0) {
+		return
 	}
 
-	_, response := BuildJsonResponse(err, storeData, ts.logger)
-	conn.Write(response)
-	conn.Close()
-	ts.removeConn(connID)
+	closed := make(chan struct{})
+	go func() {
+		reader.Discard(1)
+		close(closed)
+	}()
+
+	ts.logger.Debug("watching prefix", logger.F("protocol", protocolTCP), logger.F("conn", connID), logger.F("prefix", prefix))
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+
+			_, out := BuildVersionedJsonResponse(nil, event, event.Version, ts.logger)
+			if err := writeFrame(writer, framing, out); err != nil {
+				return
+			}
+
+			if err := writer.Flush(); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
 }
@@ -1,7 +1,11 @@
 package protocols
 
 import (
+	"bufio"
+	"encoding/binary"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net"
 	"reflect"
 	"task1/internal/logger"
@@ -22,7 +26,7 @@ func init() {
 	tcpstorage = store.NewStorage(tcplogService)
 	tcpmetrics := metrics.NewMetrics(tcplogService)
 	tcpmetrics.StartNoopMetrics()
-	tcpServer = NewTCP(tcplogService, tcpstorage, tcpmetrics)
+	tcpServer = NewTCP(":8181", tcplogService, tcpstorage, tcpmetrics)
 
 	tcpServer.Start()
 }
@@ -81,9 +85,10 @@ func TestTCPServer_tcpHandler(t *testing.T) {
 				},
 			},
 			want: jsonResponse{
-				Err:    "",
-				Status: 200,
-				Data:   "hello world",
+				Err:     "",
+				Status:  200,
+				Data:    "hello world",
+				Version: 1,
 			},
 		},
 		{
@@ -145,6 +150,24 @@ func TestTCPServer_tcpHandler(t *testing.T) {
 				Data:   nil,
 			},
 		},
+		{
+			name: "LIST ok",
+			args: args{
+				addStoreItem: true,
+				data: map[string]interface{}{
+					"Method": "LIST",
+					"Query":  "",
+				},
+			},
+			want: jsonResponse{
+				Err:    "",
+				Status: 200,
+				Data: map[string]interface{}{
+					"Keys": []interface{}{"1"},
+					"Next": "",
+				},
+			},
+		},
 		{
 			name: "POST ok - single item",
 			args: args{
@@ -209,7 +232,7 @@ func TestTCPServer_tcpHandler(t *testing.T) {
 				t.Errorf("marshal data error: %v", err)
 			}
 
-			if _, err := conn.Write(req); err != nil {
+			if _, err := conn.Write(append(req, '\n')); err != nil {
 				t.Errorf("write to tcp error: %v", err)
 			}
 
@@ -228,3 +251,160 @@ func TestTCPServer_tcpHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestTCPServer_tcpHandlerPipelined(t *testing.T) {
+	*tcpstorage = *store.NewStorage(tcplogService)
+
+	conn, err := net.Dial("tcp", ":8181")
+	if err != nil {
+		t.Fatalf("failed to dial tcp server error: %v", err)
+	}
+
+	defer conn.Close()
+
+	const ops = 500
+
+	go func() {
+		enc := json.NewEncoder(conn)
+		for i := 0; i < ops; i++ {
+			enc.Encode(map[string]interface{}{
+				"Method":  "POST",
+				"Payload": map[string]interface{}{fmt.Sprint(i): i},
+			})
+			enc.Encode(map[string]interface{}{
+				"Method": "GET",
+				"Query":  fmt.Sprint(i),
+			})
+		}
+	}()
+
+	reader := bufio.NewReader(conn)
+	for i := 0; i < ops; i++ {
+		for _, want := range []jsonResponse{
+			{Status: 200},
+			{Status: 200, Data: float64(i), Version: uint64(i + 1)},
+		} {
+			line, err := reader.ReadBytes('\n')
+			if err != nil {
+				t.Fatalf("read response %d error: %v", i, err)
+			}
+
+			var got jsonResponse
+			json.Unmarshal(line, &got)
+
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("TCPHandler response %d got = %v, want %v", i, got, want)
+			}
+		}
+	}
+}
+
+func TestTCPServer_tcpHandlerLengthPrefixed(t *testing.T) {
+	*tcpstorage = *store.NewStorage(tcplogService)
+
+	conn, err := net.Dial("tcp", ":8181")
+	if err != nil {
+		t.Fatalf("failed to dial tcp server error: %v", err)
+	}
+
+	defer conn.Close()
+
+	// larger than the old 1024 byte read buffer
+	value := make([]byte, 4096)
+	for i := range value {
+		value[i] = 'a'
+	}
+
+	requests := []map[string]interface{}{
+		{"Method": "POST", "Payload": map[string]interface{}{"1": string(value)}},
+		{"Method": "GET", "Query": "1"},
+	}
+	want := []jsonResponse{
+		{Status: 200},
+		{Status: 200, Data: string(value), Version: 1},
+	}
+
+	for _, r := range requests {
+		req, _ := json.Marshal(r)
+		prefix := make([]byte, 4)
+		binary.BigEndian.PutUint32(prefix, uint32(len(req)))
+
+		if _, err := conn.Write(append(prefix, req...)); err != nil {
+			t.Fatalf("write to tcp error: %v", err)
+		}
+	}
+
+	for i := range want {
+		prefix := make([]byte, 4)
+		if _, err := io.ReadFull(conn, prefix); err != nil {
+			t.Fatalf("read prefix error: %v", err)
+		}
+
+		res := make([]byte, binary.BigEndian.Uint32(prefix))
+		if _, err := io.ReadFull(conn, res); err != nil {
+			t.Fatalf("read frame error: %v", err)
+		}
+
+		var got jsonResponse
+		json.Unmarshal(res, &got)
+
+		if !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("TCPHandler response %d got = %v, want %v", i, got, want[i])
+		}
+	}
+}
+
+func TestTCPServer_tcpHandlerWatch(t *testing.T) {
+	*tcpstorage = *store.NewStorage(tcplogService)
+
+	conn, err := net.Dial("tcp", ":8181")
+	if err != nil {
+		t.Fatalf("failed to dial tcp server error: %v", err)
+	}
+
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(`{"Method":"WATCH","Query":"config/"}` + "\n")); err != nil {
+		t.Fatalf("write to tcp error: %v", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	ack, err := reader.ReadBytes('\n')
+	if err != nil {
+		t.Fatalf("read ack error: %v", err)
+	}
+
+	var got jsonResponse
+	json.Unmarshal(ack, &got)
+
+	if !reflect.DeepEqual(got, jsonResponse{Status: 200}) {
+		t.Fatalf("TCPHandler watch ack got = %v", got)
+	}
+
+	tcpstorage.Post(map[string]interface{}{"session/1": "token"})
+	tcpstorage.Post(map[string]interface{}{"config/a": "on"})
+
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		t.Fatalf("read event error: %v", err)
+	}
+
+	got = jsonResponse{}
+	json.Unmarshal(line, &got)
+
+	want := jsonResponse{
+		Status:  200,
+		Version: 2,
+		Data: map[string]interface{}{
+			"Op":      "POST",
+			"Key":     "config/a",
+			"Old":     nil,
+			"New":     "on",
+			"Version": float64(2),
+		},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("TCPHandler watch event got = %v, want %v", got, want)
+	}
+}
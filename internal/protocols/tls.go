@@ -0,0 +1,103 @@
+package protocols
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync/atomic"
+)
+
+var ErrTLSInvalid = errors.New("invalid tls certificate")
+
+// TLS holds the certificate served by the HTTP and TCP listeners, and for
+// mutual TLS the CAs client certificates must be signed by. Reload reads the
+// files again so certificates can be rotated without a restart, connections
+// already open keep the certificate they started with.
+type TLS struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+	current      atomic.Pointer[tls.Config]
+}
+
+// NewTLS loads the certificate and key in certFile and keyFile. If
+// clientCAFile is set clients must present a certificate signed by one of
+// the CAs in it.
+func NewTLS(certFile, keyFile, clientCAFile string) (*TLS, error) {
+	t := &TLS{
+		certFile:     certFile,
+		keyFile:      keyFile,
+		clientCAFile: clientCAFile,
+	}
+
+	if err := t.Reload(); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Reload reads the certificate, key and client CAs again. On error the
+// previous certificate is kept.
+func (t *TLS) Reload() error {
+	cert, err := tls.LoadX509KeyPair(t.certFile, t.keyFile)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrTLSInvalid, err)
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if t.clientCAFile != "" {
+		raw, err := os.ReadFile(t.clientCAFile)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrTLSInvalid, err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(raw) {
+			return fmt.Errorf("%w: no certificates in %s", ErrTLSInvalid, t.clientCAFile)
+		}
+
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	t.current.Store(config)
+	log.Printf("tls certificate loaded from %s", t.certFile)
+
+	return nil
+}
+
+// Config returns a tls.Config which picks up every Reload on the next
+// handshake.
+func (t *TLS) Config() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &t.current.Load().Certificates[0], nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return t.current.Load(), nil
+		},
+	}
+}
+
+// ClientConfig returns a tls.Config for dialling another instance, such as a
+// replica connecting to its leader. It presents the current certificate, for
+// instances requiring client certificates, and trusts the client CAs if set,
+// as the instances of a deployment share them, or else the system roots.
+func (t *TLS) ClientConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		RootCAs:    t.current.Load().ClientCAs,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return &t.current.Load().Certificates[0], nil
+		},
+	}
+}
@@ -0,0 +1,300 @@
+package protocols
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"task1/internal/logger"
+	"task1/internal/metrics"
+	"task1/internal/store"
+	"testing"
+	"time"
+)
+
+// testCA signs the server and client certificates used by the TLS tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate ca key error: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kvstore test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+
+	raw, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create ca error: %v", err)
+	}
+
+	cert, _ := x509.ParseCertificate(raw)
+
+	return &testCA{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: raw}),
+	}
+}
+
+// issue writes a certificate with serial signed by ca, and its key, to dir.
+func (ca *testCA) issue(t *testing.T, dir string, serial int64) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key error: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	raw, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("create certificate error: %v", err)
+	}
+
+	keyRaw, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key error: %v", err)
+	}
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: raw}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyRaw}), 0o600)
+
+	return certFile, keyFile
+}
+
+func TestTCPServer_TLS(t *testing.T) {
+	tests := []struct {
+		name       string
+		mutual     bool
+		clientCert bool
+		reload     bool
+		wantSerial int64
+		wantErr    bool
+	}{
+		{
+			name:       "tls - ok",
+			wantSerial: 2,
+		},
+		{
+			name:       "mutual tls - ok",
+			mutual:     true,
+			clientCert: true,
+			wantSerial: 2,
+		},
+		{
+			name:    "mutual tls - fail without client cert",
+			mutual:  true,
+			wantErr: true,
+		},
+		{
+			name:       "reload - new cert served",
+			reload:     true,
+			wantSerial: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ca := newTestCA(t)
+			serverDir, clientDir := t.TempDir(), t.TempDir()
+			certFile, keyFile := ca.issue(t, serverDir, 2)
+
+			caFile := ""
+			if tt.mutual {
+				caFile = filepath.Join(serverDir, "ca.pem")
+				os.WriteFile(caFile, ca.pem, 0o600)
+			}
+
+			certs, err := NewTLS(certFile, keyFile, caFile)
+			if err != nil {
+				t.Fatalf("NewTLS() error = %v", err)
+			}
+
+			logger := logger.NewLogger()
+			logger.StartNoopLogger()
+			metrics := metrics.NewMetrics(logger)
+			metrics.StartNoopMetrics()
+			storage := store.NewStorage(logger)
+			storage.Post(store.StoreData{"1": "hello world"})
+
+			server := NewTCP("127.0.0.1:0", logger, storage, metrics)
+			server.SetTLS(certs)
+			server.Start()
+			defer server.Stop()
+
+			// the listener picks up a reload without being restarted
+			if tt.reload {
+				ca.issue(t, serverDir, 3)
+				if err := certs.Reload(); err != nil {
+					t.Fatalf("TLS.Reload() error = %v", err)
+				}
+			}
+
+			roots := x509.NewCertPool()
+			roots.AppendCertsFromPEM(ca.pem)
+			config := &tls.Config{RootCAs: roots}
+
+			if tt.clientCert {
+				clientCert, clientKey := ca.issue(t, clientDir, 4)
+				pair, err := tls.LoadX509KeyPair(clientCert, clientKey)
+				if err != nil {
+					t.Fatalf("load client cert error: %v", err)
+				}
+				config.Certificates = []tls.Certificate{pair}
+			}
+
+			conn, err := tls.Dial("tcp", server.listener.Addr().String(), config)
+			if err != nil {
+				t.Fatalf("tls.Dial() error = %v", err)
+			}
+			defer conn.Close()
+
+			// with TLS 1.3 a rejected client cert only fails the first read
+			json.NewEncoder(conn).Encode(jsonRequest{Method: "GET", Query: "1"})
+			line, err := bufio.NewReader(conn).ReadBytes('\n')
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("read response error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			var got jsonResponse
+			json.Unmarshal(line, &got)
+			if got.Status != 200 || got.Data != "hello world" {
+				t.Errorf("TCPHandler response = %+v, want hello world", got)
+			}
+
+			if serial := conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64(); serial != tt.wantSerial {
+				t.Errorf("server certificate serial = %d, want %d", serial, tt.wantSerial)
+			}
+		})
+	}
+}
+
+func TestNewTLS(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	certFile, keyFile := ca.issue(t, dir, 2)
+	badFile := filepath.Join(dir, "bad.pem")
+	os.WriteFile(badFile, []byte("not a certificate"), 0o600)
+
+	tests := []struct {
+		name         string
+		certFile     string
+		keyFile      string
+		clientCAFile string
+		wantErr      bool
+	}{
+		{
+			name:     "ok",
+			certFile: certFile,
+			keyFile:  keyFile,
+		},
+		{
+			name:     "fail - missing cert",
+			certFile: filepath.Join(dir, "missing.pem"),
+			keyFile:  keyFile,
+			wantErr:  true,
+		},
+		{
+			name:         "fail - bad client ca",
+			certFile:     certFile,
+			keyFile:      keyFile,
+			clientCAFile: badFile,
+			wantErr:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewTLS(tt.certFile, tt.keyFile, tt.clientCAFile)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewTLS() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// A replica dials its leader with ClientConfig, which must pass the leader's
+// mutual TLS when both have certificates from the same CA.
+func TestTLS_ClientConfig(t *testing.T) {
+	ca := newTestCA(t)
+	leaderDir, replicaDir := t.TempDir(), t.TempDir()
+
+	var certs []*TLS
+	for i, dir := range []string{leaderDir, replicaDir} {
+		certFile, keyFile := ca.issue(t, dir, int64(i+2))
+		caFile := filepath.Join(dir, "ca.pem")
+		os.WriteFile(caFile, ca.pem, 0o600)
+
+		c, err := NewTLS(certFile, keyFile, caFile)
+		if err != nil {
+			t.Fatalf("NewTLS() error = %v", err)
+		}
+		certs = append(certs, c)
+	}
+
+	logger := logger.NewLogger()
+	logger.StartNoopLogger()
+	metrics := metrics.NewMetrics(logger)
+	metrics.StartNoopMetrics()
+	storage := store.NewStorage(logger)
+	storage.Post(store.StoreData{"1": "hello world"})
+
+	server := NewTCP("127.0.0.1:0", logger, storage, metrics)
+	server.SetTLS(certs[0])
+	server.Start()
+	defer server.Stop()
+
+	conn, err := tls.Dial("tcp", server.listener.Addr().String(), certs[1].ClientConfig())
+	if err != nil {
+		t.Fatalf("tls.Dial() error = %v", err)
+	}
+	defer conn.Close()
+
+	json.NewEncoder(conn).Encode(jsonRequest{Method: "GET", Query: "1"})
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		t.Fatalf("read response error = %v", err)
+	}
+
+	var got jsonResponse
+	json.Unmarshal(line, &got)
+	if got.Status != 200 || got.Data != "hello world" {
+		t.Errorf("TCPHandler response = %+v, want hello world", got)
+	}
+}
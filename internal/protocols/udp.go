@@ -1,111 +1,140 @@
 package protocols
 
 import (
-	"encoding/json"
+	"context"
 	"log"
 	"net"
-	"net/http"
+	"task1/internal/auth"
 	"task1/internal/logger"
 	"task1/internal/metrics"
 	"task1/internal/store"
+	"time"
 )
 
 const (
 	udpnetwork = "udp"
-	udpport    = 9001
-	zone       = ""
 	bufsize    = 1024
 )
 
 type UDPServer struct {
-	conn      *net.UDPConn
-	closeConn chan struct{}
-	logger    *logger.Logger
-	storage   *store.Storage
-	metrics   *metrics.Metrics
+	conn       *net.UDPConn
+	done       chan struct{}
+	stopped    chan struct{}
+	requests   *inflight
+	state      *listenerState
+	logger     *logger.Logger
+	storage    store.Store
+	metrics    *metrics.Metrics
+	dispatcher *dispatcher
 }
 
 func NewUDP(
+	addr string,
 	logger *logger.Logger,
-	storage *store.Storage,
+	storage store.Store,
 	metrics *metrics.Metrics,
 ) *UDPServer {
-	addr := &net.UDPAddr{
-		IP:   []byte{0, 0, 0, 0},
-		Port: udpport,
-		Zone: zone,
+	udpAddr, err := net.ResolveUDPAddr(udpnetwork, addr)
+	if err != nil {
+		panic(err)
 	}
 
-	conn, err := net.ListenUDP(udpnetwork, addr)
+	conn, err := net.ListenUDP(udpnetwork, udpAddr)
 	if err != nil {
 		panic(err)
 	}
 
 	return &UDPServer{
-		conn:      conn,
-		closeConn: make(chan struct{}),
-		logger:    logger,
-		storage:   storage,
-		metrics:   metrics,
+		conn:       conn,
+		done:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+		requests:   &inflight{},
+		state:      newListenerState(protocolUDP, conn.LocalAddr().String()),
+		logger:     logger,
+		storage:    storage,
+		metrics:    metrics,
+		dispatcher: newDispatcher(logger, storage, metrics),
 	}
 }
 
+// SetACL requires every request to carry a token allowed by acl. It must be
+// called before Start.
+func (us *UDPServer) SetACL(acl *auth.ACL) {
+	us.dispatcher.acl = acl
+}
+
+// SetMode refuses the requests mode does not allow. It must be called before
+// Start.
+func (us *UDPServer) SetMode(mode *Mode) {
+	us.dispatcher.mode = mode
+}
+
+func (us UDPServer) Status() ListenerStatus {
+	return us.state.get()
+}
+
 func (us UDPServer) Start() {
+	us.state.set(StateServing)
+
 	go func() {
+		defer close(us.stopped)
+
 		log.Printf("udp listening on %s", us.conn.LocalAddr().String())
 		buf := make([]byte, bufsize)
 
 		for {
-			select {
-			case <-us.closeConn:
-				us.conn.Close()
-
-				return
-			default:
-				n, retAddr, err := us.conn.ReadFromUDP(buf)
-				if err != nil {
+			n, retAddr, err := us.conn.ReadFromUDP(buf)
+			if err != nil {
+				select {
+				case <-us.done:
+					return
+				default:
 					log.Printf("startUDP error: %v", err)
-				}
 
-				go us.UDPHandler(buf, n, retAddr)
+					continue
+				}
 			}
+
+			// buf is reused by the next read while the handler runs
+			datagram := append([]byte(nil), buf[:n]...)
+
+			us.requests.begin()
+			go func() {
+				defer us.requests.end()
+
+				us.UDPHandler(datagram, n, retAddr)
+			}()
 		}
 	}()
 }
 
 func (us UDPServer) Stop() {
-	close(us.closeConn)
-	log.Print("UDP shutdown ok")
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	us.Shutdown(ctx)
 }
 
-func (us UDPServer) UDPHandler(buf []byte, n int, retAddr *net.UDPAddr) {
-	var (
-		req       jsonRequest
-		err       error
-		storeData interface{}
-	)
-
-	if err == nil {
-		err = json.Unmarshal(buf[0:n], &req)
-	}
+// Shutdown stops reading datagrams and waits until ctx is done for the
+// requests already read to be answered, before closing the conn. It returns
+// how many requests were drained and how many aborted.
+func (us UDPServer) Shutdown(ctx context.Context) (drained, aborted int) {
+	us.state.set(StateStopping)
+	close(us.done)
 
-	if err == nil {
-		us.metrics.LogMetrics(req.Method)
-		switch req.Method {
-		case http.MethodGet:
-			us.logger.Log("UDP GET request")
-			storeData, err = us.storage.Get(req.Query)
-		case http.MethodPost:
-			us.logger.Log("UDP POST request")
-			err = us.storage.Post(req.Payload)
-		case http.MethodDelete:
-			us.logger.Log("UDP DELETE request")
-			err = us.storage.Delete(req.Query)
-		default:
-			err = ErrRouteForbidden
-		}
-	}
+	// wake the read loop, which is blocked until the next datagram
+	us.conn.SetReadDeadline(time.Now())
+	<-us.stopped
+
+	drained, aborted = us.requests.wait(ctx)
+	us.conn.Close()
+	us.state.set(StateStopped)
+	log.Printf("UDP shutdown ok, %d requests drained, %d aborted", drained, aborted)
 
-	_, out := BuildJsonResponse(err, storeData, us.logger)
-	us.conn.WriteTo(out, retAddr)
+	return drained, aborted
+}
+
+func (us UDPServer) UDPHandler(buf []byte, n int, retAddr *net.UDPAddr) {
+	rep := us.dispatcher.dispatchFrame(protocolUDP, buf[0:n], false)
+	us.conn.WriteTo(rep.body, retAddr)
 }
@@ -22,7 +22,7 @@ func init() {
 	udpstorage = store.NewStorage(udplogService)
 	udpmetrics := metrics.NewMetrics(udplogService)
 	udpmetrics.StartNoopMetrics()
-	udpServer = NewUDP(udplogService, udpstorage, udpmetrics)
+	udpServer = NewUDP("0.0.0.0:9001", udplogService, udpstorage, udpmetrics)
 
 	udpServer.Start()
 }
@@ -87,9 +87,10 @@ func TestUDPHandlers_UDPHandler(t *testing.T) {
 				},
 			},
 			want: jsonResponse{
-				Err:    "",
-				Status: 200,
-				Data:   "hello world",
+				Err:     "",
+				Status:  200,
+				Data:    "hello world",
+				Version: 1,
 			},
 		},
 		{
@@ -0,0 +1,188 @@
+package replication
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"task1/internal/logger"
+	"task1/internal/store"
+	"time"
+)
+
+// Follower keeps the store in sync with a leader. The store must already be
+// a replica, see store.Storage.SetReplica. On any error the follower
+// reconnects and restores from a fresh snapshot.
+type Follower struct {
+	leaderAddr  string
+	token       string
+	tls         *tls.Config
+	done        chan struct{}
+	mutex       *sync.Mutex
+	conn        net.Conn
+	leaderSeq   uint64
+	lastContact time.Time
+	logger      *logger.Logger
+	storage     *store.Storage
+}
+
+func NewFollower(
+	leaderAddr string,
+	logger *logger.Logger,
+	storage *store.Storage,
+) *Follower {
+
+	return &Follower{
+		leaderAddr: leaderAddr,
+		done:       make(chan struct{}),
+		mutex:      &sync.Mutex{},
+		logger:     logger,
+		storage:    storage,
+	}
+}
+
+// SetTLS connects to the leader over TLS. It must be called before Start.
+func (f *Follower) SetTLS(config *tls.Config) {
+	f.tls = config
+}
+
+// SetToken sends token to the leader, for leaders with an ACL. It must be
+// called before Start.
+func (f *Follower) SetToken(token string) {
+	f.token = token
+}
+
+func (f *Follower) Start() {
+	log.Printf("replication following %s", f.leaderAddr)
+	go func() {
+		for {
+			if err := f.follow(); err != nil {
+				log.Printf("replication error: %v", err)
+			}
+
+			select {
+			case <-f.done:
+				return
+			case <-time.After(retryDelay):
+			}
+		}
+	}()
+}
+
+func (f *Follower) Stop() {
+	close(f.done)
+
+	f.mutex.Lock()
+	if f.conn != nil {
+		f.conn.Close()
+	}
+	f.mutex.Unlock()
+
+	log.Print("replication follower shutdown ok")
+}
+
+// LagEntries returns how many writes the leader has made which are not yet
+// applied here, as of the last message from the leader.
+func (f *Follower) LagEntries() uint64 {
+	f.mutex.Lock()
+	leaderSeq := f.leaderSeq
+	f.mutex.Unlock()
+
+	seq := f.storage.Seq()
+	if leaderSeq < seq {
+		return 0
+	}
+
+	return leaderSeq - seq
+}
+
+// LastContact returns the time since the last message from the leader.
+func (f *Follower) LastContact() time.Duration {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.lastContact.IsZero() {
+		return 0
+	}
+
+	return time.Since(f.lastContact)
+}
+
+func (f *Follower) follow() error {
+	conn, err := dial(f.leaderAddr, f.tls)
+	if err != nil {
+		return err
+	}
+
+	f.mutex.Lock()
+	select {
+	case <-f.done:
+		f.mutex.Unlock()
+		conn.Close()
+
+		return nil
+	default:
+		f.conn = conn
+	}
+	f.mutex.Unlock()
+
+	defer conn.Close()
+
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if err := json.NewEncoder(conn).Encode(message{Type: msgHello, Token: f.token, Sent: time.Now()}); err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(bufio.NewReader(conn))
+
+	for synced := false; ; {
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+
+		var msg message
+		if err := dec.Decode(&msg); err != nil {
+			select {
+			case <-f.done:
+				return nil
+			default:
+				return err
+			}
+		}
+
+		f.contact(msg)
+
+		switch {
+		case msg.Type == msgError:
+			return fmt.Errorf("leader refused replication: %s", msg.Err)
+		case msg.Type == msgSnapshot:
+			if err := f.storage.Restore(msg.Snapshot, msg.Seq); err != nil {
+				return err
+			}
+			synced = true
+			f.logger.Info("replication synced", logger.F("leader", f.leaderAddr), logger.F("seq", msg.Seq))
+		case !synced:
+			return fmt.Errorf("expected snapshot from leader, got %s", msg.Type)
+		case msg.Type == msgEntry:
+			if err := f.storage.ApplyReplicated(store.Replicated{Seq: msg.Seq, Entry: msg.Entry}); err != nil {
+				return err
+			}
+		case msg.Type == msgHeartbeat:
+		default:
+			return fmt.Errorf("unknown replication message %s", msg.Type)
+		}
+	}
+}
+
+func (f *Follower) contact(msg message) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.lastContact = time.Now()
+
+	// a snapshot resets the sequence, the leader may have restarted
+	if msg.Type == msgSnapshot || msg.Seq > f.leaderSeq {
+		f.leaderSeq = msg.Seq
+	}
+}
@@ -0,0 +1,264 @@
+package replication
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"task1/internal/auth"
+	"task1/internal/store"
+	"time"
+)
+
+// knownErrors are matched against leader responses so forwarded writes fail
+// with the same errors as local ones.
+var knownErrors = []error{
+	store.ErrStoreEmpty,
+	store.ErrStoreKeyNotFound,
+	store.ErrKeyEmpty,
+	store.ErrTTLInvalid,
+	store.ErrCASConflict,
+	store.ErrCASNoCondition,
+	store.ErrTxnEmpty,
+	store.ErrTxnOpInvalid,
+	store.ErrReadOnly,
+	store.ErrStoreFull,
+	store.ErrNotInteger,
+	store.ErrOverflow,
+	auth.ErrUnauthorized,
+	auth.ErrForbidden,
+}
+
+// idempotent methods leave the leader the same however many times they are
+// applied, so can be sent again after a failure.
+var idempotent = map[string]bool{
+	"DELETE": true,
+}
+
+type request struct {
+	Method          string                 `json:"Method"`
+	Query           string                 `json:"Query,omitempty"`
+	Payload         map[string]interface{} `json:"Payload,omitempty"`
+	TTLMillis       int64                  `json:"TTLMillis,omitempty"`
+	Value           interface{}            `json:"Value,omitempty"`
+	Expected        interface{}            `json:"Expected,omitempty"`
+	ExpectedVersion *uint64                `json:"ExpectedVersion,omitempty"`
+	Delta           *int64                 `json:"Delta,omitempty"`
+	Ops             []requestOp            `json:"Ops,omitempty"`
+	Token           string                 `json:"Token,omitempty"`
+}
+
+type requestOp struct {
+	Op        string      `json:"Op"`
+	Key       string      `json:"Key"`
+	Value     interface{} `json:"Value,omitempty"`
+	TTLMillis int64       `json:"TTLMillis,omitempty"`
+	Version   uint64      `json:"Version"`
+}
+
+type response struct {
+	Err     string          `json:"Err"`
+	Status  int             `json:"Status"`
+	Data    json.RawMessage `json:"Data"`
+	Version uint64          `json:"Version"`
+}
+
+// Forwarder sends writes made on a replica to the leader's TCP listener. It
+// implements store.Forwarder.
+type Forwarder struct {
+	addr   string
+	token  string
+	tls    *tls.Config
+	mutex  *sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func NewForwarder(addr string) *Forwarder {
+	return &Forwarder{
+		addr:  addr,
+		mutex: &sync.Mutex{},
+	}
+}
+
+// SetTLS connects to the leader over TLS. It must be called before the first
+// write.
+func (f *Forwarder) SetTLS(config *tls.Config) {
+	f.tls = config
+}
+
+// SetToken sends token with every forwarded write, for leaders with an ACL.
+// It must be called before the first write.
+func (f *Forwarder) SetToken(token string) {
+	f.token = token
+}
+
+func (f *Forwarder) Post(data store.StoreData, ttl time.Duration) error {
+	ms, err := millis(ttl)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.do(request{Method: "POST", Payload: data, TTLMillis: ms})
+
+	return err
+}
+
+func (f *Forwarder) Delete(key string) error {
+	_, err := f.do(request{Method: "DELETE", Query: key})
+
+	return err
+}
+
+func (f *Forwarder) CompareAndSwap(
+	key string,
+	value interface{},
+	expectedVersion *uint64,
+	expectedValue interface{},
+	ttl time.Duration,
+) (uint64, error) {
+	ms, err := millis(ttl)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := f.do(request{
+		Method:          "CAS",
+		Query:           key,
+		Value:           value,
+		Expected:        expectedValue,
+		ExpectedVersion: expectedVersion,
+		TTLMillis:       ms,
+	})
+
+	return res.Version, err
+}
+
+func (f *Forwarder) Increment(key string, delta int64) (int64, uint64, error) {
+	res, err := f.do(request{Method: "INCR", Query: key, Delta: &delta})
+	if err != nil {
+		return 0, res.Version, err
+	}
+
+	var value int64
+	if err := json.Unmarshal(res.Data, &value); err != nil {
+		return 0, res.Version, err
+	}
+
+	return value, res.Version, nil
+}
+
+func (f *Forwarder) Transact(ops []store.TxOp) ([]store.TxResult, error) {
+	req := request{Method: "TXN", Ops: make([]requestOp, len(ops))}
+	for i, op := range ops {
+		ms, err := millis(op.TTL)
+		if err != nil {
+			return nil, fmt.Errorf("%w: op %d", err, i)
+		}
+
+		req.Ops[i] = requestOp{
+			Op:        op.Op,
+			Key:       op.Key,
+			Value:     op.Value,
+			TTLMillis: ms,
+			Version:   op.Version,
+		}
+	}
+
+	res, err := f.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []store.TxResult
+	if err := json.Unmarshal(res.Data, &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// do sends req to the leader over a kept open connection, dialling again
+// once if the connection has failed. Once any of a write has been sent the
+// leader may have applied it, so it is only sent again if it is idempotent.
+func (f *Forwarder) do(req request) (response, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	req.Token = f.token
+	line, err := json.Marshal(req)
+	if err != nil {
+		return response{}, err
+	}
+	line = append(line, '\n')
+
+	var res response
+
+	for attempt := 0; attempt < 2; attempt++ {
+		if f.conn == nil {
+			if f.conn, err = dial(f.addr, f.tls); err != nil {
+				return res, fmt.Errorf("forward to leader: %w", err)
+			}
+			f.reader = bufio.NewReader(f.conn)
+		}
+
+		f.conn.SetDeadline(time.Now().Add(writeTimeout))
+
+		var raw []byte
+		var n int
+		if n, err = f.conn.Write(line); err == nil {
+			raw, err = f.reader.ReadBytes('\n')
+		}
+
+		if err != nil {
+			f.conn.Close()
+			f.conn = nil
+
+			if n == 0 || idempotent[req.Method] {
+				continue
+			}
+
+			return res, fmt.Errorf("forward to leader: %w", err)
+		}
+
+		if err := json.Unmarshal(raw, &res); err != nil {
+			return res, err
+		}
+
+		return res, errorFromResponse(res)
+	}
+
+	return res, fmt.Errorf("forward to leader: %w", err)
+}
+
+func errorFromResponse(res response) error {
+	if res.Err == "" {
+		return nil
+	}
+
+	for _, known := range knownErrors {
+		if res.Err == known.Error() {
+			return known
+		}
+
+		if detail, ok := strings.CutPrefix(res.Err, known.Error()); ok {
+			return fmt.Errorf("%w%s", known, detail)
+		}
+	}
+
+	return errors.New(res.Err)
+}
+
+// millis converts ttl to the milliseconds sent to the leader. A ttl which
+// would round down to 0 is rejected, as the leader reads 0 as no expiry.
+func millis(ttl time.Duration) (int64, error) {
+	if ttl > 0 && ttl < time.Millisecond {
+		return 0, fmt.Errorf("%w: %v", store.ErrTTLTooShort, ttl)
+	}
+
+	return int64(ttl / time.Millisecond), nil
+}
@@ -0,0 +1,228 @@
+package replication
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"task1/internal/auth"
+	"task1/internal/logger"
+	"task1/internal/store"
+	"time"
+)
+
+// Leader streams the store to every follower which connects to it.
+type Leader struct {
+	listener  net.Listener
+	done      chan struct{}
+	mutex     *sync.Mutex
+	followers map[net.Conn]struct{}
+	acl       *auth.ACL
+	logger    *logger.Logger
+	storage   *store.Storage
+}
+
+func NewLeader(
+	addr string,
+	logger *logger.Logger,
+	storage *store.Storage,
+) *Leader {
+
+	lis, err := net.Listen(network, addr)
+	if err != nil {
+		panic(err)
+	}
+
+	return &Leader{
+		listener:  lis,
+		done:      make(chan struct{}),
+		mutex:     &sync.Mutex{},
+		followers: make(map[net.Conn]struct{}),
+		logger:    logger,
+		storage:   storage,
+	}
+}
+
+// SetTLS accepts only TLS connections from followers. It must be called
+// before Start.
+func (l *Leader) SetTLS(config *tls.Config) {
+	l.listener = tls.NewListener(l.listener, config)
+}
+
+// SetACL requires followers to send a token granted read on every key, as
+// they receive the whole store. It must be called before Start.
+func (l *Leader) SetACL(acl *auth.ACL) {
+	l.acl = acl
+}
+
+func (l *Leader) Start() {
+	log.Printf("replication leader listening on %s", l.listener.Addr().String())
+	go func() {
+		for {
+			conn, err := l.listener.Accept()
+			if err != nil {
+				select {
+				case <-l.done:
+				default:
+					log.Printf("replication listener error: %v", err)
+				}
+
+				return
+			}
+
+			l.mutex.Lock()
+			l.followers[conn] = struct{}{}
+			l.mutex.Unlock()
+
+			go l.serve(conn)
+		}
+	}()
+}
+
+func (l *Leader) Stop() {
+	close(l.done)
+	if err := l.listener.Close(); err != nil {
+		log.Printf("replication listener close err: %v", err)
+	}
+
+	l.mutex.Lock()
+	for conn := range l.followers {
+		conn.Close()
+	}
+	l.mutex.Unlock()
+
+	log.Print("replication leader shutdown ok")
+}
+
+// Followers returns the number of connected followers.
+func (l *Leader) Followers() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return len(l.followers)
+}
+
+func (l *Leader) serve(conn net.Conn) {
+	defer func() {
+		conn.Close()
+
+		l.mutex.Lock()
+		delete(l.followers, conn)
+		l.mutex.Unlock()
+	}()
+
+	addr := conn.RemoteAddr().String()
+
+	if err := l.hello(conn); err != nil {
+		l.logger.Warn("follower refused", logger.F("follower", addr), logger.F("err", err))
+
+		return
+	}
+
+	raw, seq, entries, cancel, err := l.storage.Replicate()
+	if err != nil {
+		l.logger.Error("follower snapshot error", logger.F("follower", addr), logger.F("err", err))
+
+		return
+	}
+	defer cancel()
+
+	writer := bufio.NewWriter(conn)
+	enc := json.NewEncoder(writer)
+
+	send := func(msg message, flush bool) error {
+		msg.Sent = time.Now()
+		conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+
+		if err := enc.Encode(msg); err != nil {
+			return err
+		}
+
+		if flush {
+			return writer.Flush()
+		}
+
+		return nil
+	}
+
+	if err := send(message{Type: msgSnapshot, Seq: seq, Snapshot: raw}, true); err != nil {
+		l.logger.Warn("follower snapshot send error", logger.F("follower", addr), logger.F("err", err))
+
+		return
+	}
+
+	l.logger.Info("follower synced", logger.F("follower", addr), logger.F("seq", seq))
+
+	// followers never send anything, a read returning means they have gone
+	closed := make(chan struct{})
+	go func() {
+		conn.Read(make([]byte, 1))
+		close(closed)
+	}()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		var err error
+
+		select {
+		case r, ok := <-entries:
+			if !ok {
+				l.logger.Warn("follower fell behind, closing", logger.F("follower", addr))
+
+				return
+			}
+
+			err = send(message{Type: msgEntry, Seq: r.Seq, Entry: r.Entry}, len(entries) == 0)
+		case <-heartbeat.C:
+			err = send(message{Type: msgHeartbeat, Seq: l.storage.Seq()}, true)
+		case <-closed:
+			l.logger.Info("follower disconnected", logger.F("follower", addr))
+
+			return
+		case <-l.done:
+			return
+		}
+
+		if err != nil {
+			l.logger.Warn("follower send error", logger.F("follower", addr), logger.F("err", err))
+
+			return
+		}
+	}
+}
+
+// hello reads the follower's hello and checks its token, sending the follower
+// an error if it is refused.
+func (l *Leader) hello(conn net.Conn) error {
+	conn.SetReadDeadline(time.Now().Add(readTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	// the whole line, a newline left unread would look like the follower
+	// going away to serve
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		return err
+	}
+
+	var msg message
+	if err := json.Unmarshal(line, &msg); err != nil {
+		return err
+	}
+
+	err = l.acl.AuthorizePrefix(msg.Token, auth.Read, "")
+	if msg.Type != msgHello {
+		err = fmt.Errorf("expected hello from follower, got %s", msg.Type)
+	}
+
+	if err != nil {
+		conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		json.NewEncoder(conn).Encode(message{Type: msgError, Err: err.Error(), Sent: time.Now()})
+	}
+
+	return err
+}
@@ -0,0 +1,47 @@
+package replication
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net"
+	"time"
+)
+
+// A follower connects to the leader's replication address and sends a hello
+// carrying its token. It then receives a stream of newline-delimited JSON
+// messages: a single snapshot, then every write in order, with heartbeats in
+// between carrying the leader's latest sequence number so the follower can
+// report how far behind it is. A leader refusing the token sends an error
+// instead and closes the connection.
+const (
+	msgHello          = "HELLO"
+	msgError          = "ERROR"
+	msgSnapshot       = "SNAPSHOT"
+	msgEntry          = "ENTRY"
+	msgHeartbeat      = "HEARTBEAT"
+	network           = "tcp"
+	heartbeatInterval = 1 * time.Second
+	writeTimeout      = 10 * time.Second
+	readTimeout       = 3 * heartbeatInterval
+	retryDelay        = 2 * time.Second
+)
+
+type message struct {
+	Type     string          `json:"Type"`
+	Seq      uint64          `json:"Seq"`
+	Snapshot json.RawMessage `json:"Snapshot,omitempty"`
+	Entry    json.RawMessage `json:"Entry,omitempty"`
+	Token    string          `json:"Token,omitempty"`
+	Err      string          `json:"Err,omitempty"`
+	Sent     time.Time       `json:"Sent"`
+}
+
+// dial connects to addr, over TLS if config is set.
+func dial(addr string, config *tls.Config) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: writeTimeout}
+	if config != nil {
+		return tls.DialWithDialer(dialer, network, addr, config)
+	}
+
+	return dialer.Dial(network, addr)
+}
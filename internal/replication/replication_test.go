@@ -0,0 +1,467 @@
+package replication
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"reflect"
+	"strings"
+	"task1/internal/auth"
+	"task1/internal/logger"
+	"task1/internal/store"
+	"testing"
+	"time"
+)
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestReplication_LeaderFollower(t *testing.T) {
+	logger := logger.NewLogger()
+	logger.StartNoopLogger()
+
+	primary := store.NewStorage(logger)
+	primary.Post(map[string]interface{}{"1": "hello world", "2": "hello"})
+
+	leader := NewLeader("127.0.0.1:0", logger, primary)
+	leader.Start()
+	defer leader.Stop()
+
+	replica := store.NewStorage(logger)
+	replica.SetReplica(nil)
+
+	follower := NewFollower(leader.listener.Addr().String(), logger, replica)
+	follower.Start()
+	defer follower.Stop()
+
+	waitFor(t, "snapshot", func() bool {
+		value, _ := replica.Get("1")
+		return value == "hello world"
+	})
+
+	if got := leader.Followers(); got != 1 {
+		t.Errorf("Leader.Followers() = %v, want 1", got)
+	}
+
+	primary.Post(map[string]interface{}{"3": "world"})
+	primary.Delete("2")
+	primary.Transact([]store.TxOp{{Op: store.TxSet, Key: "4", Value: "txn"}})
+
+	waitFor(t, "entries", func() bool {
+		return replica.Seq() == primary.Seq()
+	})
+
+	for key, want := range map[string]interface{}{"1": "hello world", "3": "world", "4": "txn"} {
+		got, version, err := replica.GetVersion(key)
+		_, wantVersion, _ := primary.GetVersion(key)
+
+		if err != nil || !reflect.DeepEqual(got, want) || version != wantVersion {
+			t.Errorf("replica.GetVersion(%s) = %v, %v, %v, want %v, %v", key, got, version, err, want, wantVersion)
+		}
+	}
+
+	if _, err := replica.Get("2"); !errors.Is(err, store.ErrStoreKeyNotFound) {
+		t.Errorf("replica.Get(2) error = %v, want %v", err, store.ErrStoreKeyNotFound)
+	}
+
+	if err := replica.Post(map[string]interface{}{"5": "local"}); !errors.Is(err, store.ErrReadOnly) {
+		t.Errorf("replica.Post() error = %v, want %v", err, store.ErrReadOnly)
+	}
+
+	if lag := follower.LagEntries(); lag != 0 {
+		t.Errorf("Follower.LagEntries() = %v, want 0", lag)
+	}
+}
+
+func TestForwarder(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		call     func(f *Forwarder) error
+		wantReq  string
+		wantErr  error
+	}{
+		{
+			name:     "POST ok",
+			response: `{"Err":"","Status":200,"Data":null}`,
+			call: func(f *Forwarder) error {
+				return f.Post(store.StoreData{"1": "hello"}, 30*time.Second)
+			},
+			wantReq: `{"Method":"POST","Payload":{"1":"hello"},"TTLMillis":30000}`,
+		},
+		{
+			name:     "POST ok - sub-second ttl",
+			response: `{"Err":"","Status":200,"Data":null}`,
+			call: func(f *Forwarder) error {
+				return f.Post(store.StoreData{"1": "hello"}, 1500*time.Microsecond)
+			},
+			wantReq: `{"Method":"POST","Payload":{"1":"hello"},"TTLMillis":1}`,
+		},
+		{
+			name: "POST fail - ttl rounds to 0",
+			call: func(f *Forwarder) error {
+				return f.Post(store.StoreData{"1": "hello"}, 500*time.Microsecond)
+			},
+			wantErr: store.ErrTTLTooShort,
+		},
+		{
+			name:     "DELETE fail - not found",
+			response: `{"Err":"key not found in store","Status":404,"Data":null}`,
+			call: func(f *Forwarder) error {
+				return f.Delete("1")
+			},
+			wantReq: `{"Method":"DELETE","Query":"1"}`,
+			wantErr: store.ErrStoreKeyNotFound,
+		},
+		{
+			name:     "INCR ok",
+			response: `{"Err":"","Status":200,"Data":5,"Version":3}`,
+			call: func(f *Forwarder) error {
+				value, version, err := f.Increment("1", -2)
+				if value != 5 || version != 3 {
+					return fmt.Errorf("Increment() = %v, %v, want 5, 3", value, version)
+				}
+				return err
+			},
+			wantReq: `{"Method":"INCR","Query":"1","Delta":-2}`,
+		},
+		{
+			name:     "INCR fail - not integer",
+			response: `{"Err":"value is not an integer","Status":400,"Data":null}`,
+			call: func(f *Forwarder) error {
+				_, _, err := f.Increment("1", 1)
+				return err
+			},
+			wantReq: `{"Method":"INCR","Query":"1","Delta":1}`,
+			wantErr: store.ErrNotInteger,
+		},
+		{
+			name:     "TXN fail - conflict",
+			response: `{"Err":"compare and swap conflict: op 0 version 2, want 1","Status":409,"Data":null}`,
+			call: func(f *Forwarder) error {
+				_, err := f.Transact([]store.TxOp{{Op: store.TxCheckVersion, Key: "1", Version: 1}})
+				return err
+			},
+			wantReq: `{"Method":"TXN","Ops":[{"Op":"check-version","Key":"1","Version":1}]}`,
+			wantErr: store.ErrCASConflict,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lis, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("listen error: %v", err)
+			}
+			defer lis.Close()
+
+			got := make(chan string, 1)
+			go func() {
+				conn, err := lis.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+
+				line, _ := bufio.NewReader(conn).ReadString('\n')
+				got <- line[:len(line)-1]
+				conn.Write([]byte(tt.response + "\n"))
+			}()
+
+			f := NewForwarder(lis.Addr().String())
+			if err := tt.call(f); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Forwarder error = %v, want %v", err, tt.wantErr)
+			}
+
+			if tt.wantReq == "" {
+				return
+			}
+
+			if req := <-got; req != tt.wantReq {
+				t.Errorf("Forwarder request = %v, want %v", req, tt.wantReq)
+			}
+		})
+	}
+}
+
+// A leader which drops the connection after reading a write may have applied
+// it, so only idempotent writes are sent again.
+func TestForwarder_retry(t *testing.T) {
+	tests := []struct {
+		name      string
+		call      func(f *Forwarder) error
+		wantSends int
+		wantErr   bool
+	}{
+		{
+			name: "POST - not resent",
+			call: func(f *Forwarder) error {
+				return f.Post(store.StoreData{"1": "hello"}, 0)
+			},
+			wantSends: 1,
+			wantErr:   true,
+		},
+		{
+			name: "INCR - not resent",
+			call: func(f *Forwarder) error {
+				_, _, err := f.Increment("1", 1)
+				return err
+			},
+			wantSends: 1,
+			wantErr:   true,
+		},
+		{
+			name: "DELETE - resent",
+			call: func(f *Forwarder) error {
+				return f.Delete("1")
+			},
+			wantSends: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lis, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("listen error: %v", err)
+			}
+			defer lis.Close()
+
+			sends := make(chan string, 2)
+			go func() {
+				for i := 0; ; i++ {
+					conn, err := lis.Accept()
+					if err != nil {
+						return
+					}
+
+					line, _ := bufio.NewReader(conn).ReadString('\n')
+					sends <- line
+					if i > 0 {
+						conn.Write([]byte(`{"Err":"","Status":200,"Data":null}` + "\n"))
+					}
+					conn.Close()
+				}
+			}()
+
+			f := NewForwarder(lis.Addr().String())
+			if err := tt.call(f); (err != nil) != tt.wantErr {
+				t.Errorf("Forwarder error = %v, wantErr %v", err, tt.wantErr)
+			}
+			lis.Close()
+
+			if got := len(sends); got != tt.wantSends {
+				t.Errorf("Forwarder sent %d times, want %d", got, tt.wantSends)
+			}
+		})
+	}
+}
+
+func TestReplication_token(t *testing.T) {
+	acl, err := auth.NewACL([]auth.Token{
+		{Name: "replica", Token: "replica-token", Grants: []auth.Grant{{Prefix: "", Permissions: []string{auth.Read}}}},
+		{Name: "app", Token: "app-token", Grants: []auth.Grant{{Prefix: "app/", Permissions: []string{auth.Read}}}},
+	})
+	if err != nil {
+		t.Fatalf("NewACL() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		acl        *auth.ACL
+		token      string
+		wantSynced bool
+	}{
+		{name: "no acl", wantSynced: true},
+		{name: "acl - token reads every key", acl: acl, token: "replica-token", wantSynced: true},
+		{name: "acl - token reads a prefix", acl: acl, token: "app-token"},
+		{name: "acl - unknown token", acl: acl, token: "wrong"},
+		{name: "acl - no token", acl: acl},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := logger.NewLogger()
+			logger.StartNoopLogger()
+
+			primary := store.NewStorage(logger)
+			primary.Post(map[string]interface{}{"1": "hello world"})
+
+			leader := NewLeader("127.0.0.1:0", logger, primary)
+			leader.SetACL(tt.acl)
+			leader.Start()
+			defer leader.Stop()
+
+			replica := store.NewStorage(logger)
+			replica.SetReplica(nil)
+
+			follower := NewFollower(leader.listener.Addr().String(), logger, replica)
+			follower.SetToken(tt.token)
+
+			// a single attempt, Start would keep retrying
+			errs := make(chan error, 1)
+			go func() {
+				errs <- follower.follow()
+			}()
+
+			if tt.wantSynced {
+				waitFor(t, "snapshot", func() bool {
+					value, _ := replica.Get("1")
+					return value == "hello world"
+				})
+
+				return
+			}
+
+			select {
+			case err := <-errs:
+				if err == nil || !strings.Contains(err.Error(), "leader refused replication") {
+					t.Errorf("Follower.follow() error = %v, want refused", err)
+				}
+			case <-time.After(3 * time.Second):
+				t.Fatal("timed out waiting for the leader to refuse")
+			}
+
+			if _, err := replica.Get("1"); err == nil {
+				t.Error("replica.Get(1) found a key from a refused leader")
+			}
+		})
+	}
+}
+
+// testTLS returns a server and a client config sharing one self-signed
+// certificate for 127.0.0.1, each trusting it, as replicas and their leader
+// use the same certificate flags.
+func testTLS(t *testing.T) (server, client *tls.Config) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key error: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kvstore"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	raw, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate error: %v", err)
+	}
+
+	cert, _ := x509.ParseCertificate(raw)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	pair := tls.Certificate{Certificate: [][]byte{raw}, PrivateKey: key}
+
+	server = &tls.Config{
+		Certificates: []tls.Certificate{pair},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	client = &tls.Config{
+		Certificates: []tls.Certificate{pair},
+		RootCAs:      pool,
+	}
+
+	return server, client
+}
+
+func TestReplication_TLS(t *testing.T) {
+	logger := logger.NewLogger()
+	logger.StartNoopLogger()
+
+	server, client := testTLS(t)
+
+	primary := store.NewStorage(logger)
+	primary.Post(map[string]interface{}{"1": "hello world"})
+
+	leader := NewLeader("127.0.0.1:0", logger, primary)
+	leader.SetTLS(server)
+	leader.Start()
+	defer leader.Stop()
+
+	replica := store.NewStorage(logger)
+	replica.SetReplica(nil)
+
+	follower := NewFollower(leader.listener.Addr().String(), logger, replica)
+	follower.SetTLS(client)
+	follower.Start()
+	defer follower.Stop()
+
+	waitFor(t, "snapshot", func() bool {
+		value, _ := replica.Get("1")
+		return value == "hello world"
+	})
+
+	primary.Post(map[string]interface{}{"2": "hello"})
+
+	waitFor(t, "entries", func() bool {
+		return replica.Seq() == primary.Seq()
+	})
+
+	// a follower without TLS never gets past the handshake
+	plain := store.NewStorage(logger)
+	plain.SetReplica(nil)
+
+	if err := NewFollower(leader.listener.Addr().String(), logger, plain).follow(); err == nil {
+		t.Error("Follower.follow() without TLS error = nil, want error")
+	}
+}
+
+func TestForwarder_TLS(t *testing.T) {
+	server, client := testTLS(t)
+
+	lis, err := tls.Listen("tcp", "127.0.0.1:0", server)
+	if err != nil {
+		t.Fatalf("listen error: %v", err)
+	}
+	defer lis.Close()
+
+	got := make(chan string, 1)
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		got <- line[:len(line)-1]
+		conn.Write([]byte(`{"Err":"","Status":200,"Data":null}` + "\n"))
+	}()
+
+	f := NewForwarder(lis.Addr().String())
+	f.SetTLS(client)
+
+	if err := f.Delete("1"); err != nil {
+		t.Errorf("Forwarder.Delete() error = %v, want nil", err)
+	}
+
+	if req, want := <-got, `{"Method":"DELETE","Query":"1"}`; req != want {
+		t.Errorf("Forwarder request = %v, want %v", req, want)
+	}
+}
@@ -0,0 +1,229 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"task1/internal/logger"
+	"time"
+)
+
+var (
+	ErrNotInteger = errors.New("value is not an integer")
+	ErrOverflow   = errors.New("increment or decrement would overflow")
+)
+
+// Increment adds delta to the integer value of key, creating the key as 0
+// first if it does not exist, and returns the new value and version. The key
+// keeps its ttl. A value stored as a decimal string stays a string, as Redis
+// and memcached store counters, any other value is stored as a number.
+func (s *Storage) Increment(key string, delta int64) (int64, uint64, error) {
+	if replica, fwd := s.replicaState(); replica {
+		if fwd == nil {
+			return 0, 0, ErrReadOnly
+		}
+
+		return fwd.Increment(key, delta)
+	}
+
+	if key == "" {
+		s.logger.Debug(ErrKeyEmpty.Error())
+
+		return 0, 0, ErrKeyEmpty
+	}
+
+	s.logger.Debug("increment store access", logger.F("key", key), logger.F("delta", delta))
+
+	s.rwMutex.Lock()
+	defer s.rwMutex.Unlock()
+
+	current, version, ok := s.lookup(key)
+
+	var n int64
+	if ok {
+		var err error
+		if n, err = integer(current); err != nil {
+			s.logger.Debug(err.Error(), logger.F("key", key))
+
+			return 0, version, err
+		}
+	}
+
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		s.logger.Debug(ErrOverflow.Error(), logger.F("key", key), logger.F("value", n), logger.F("delta", delta))
+
+		return 0, version, ErrOverflow
+	}
+
+	n += delta
+
+	var value interface{} = n
+	if _, text := current.(string); text {
+		value = strconv.FormatInt(n, 10)
+	}
+
+	entry := logEntry{Op: opPost, Data: StoreData{key: value}}
+	if expires, ttl := s.expiry[key]; ttl {
+		entry.Expiry = map[string]time.Time{key: expires}
+	}
+
+	if err := s.write(entry); err != nil {
+		return 0, version, err
+	}
+
+	s.logger.Debug("key incremented in store", logger.F("key", key), logger.F("value", n))
+
+	return n, s.versions[key], nil
+}
+
+// Update replaces the value of key with fn of its current value in one step,
+// so no other write can land in between, and returns the new value and
+// version. The key must exist and keeps its ttl. An error from fn is returned
+// as is, leaving the key unchanged.
+//
+// fn cannot be forwarded, so a replica forwarding writes compares and swaps
+// against its own copy of the key, which fails with ErrCASConflict if the
+// leader has moved on and the copy has not caught up after a few tries.
+func (s *Storage) Update(key string, fn func(value interface{}) (interface{}, error)) (interface{}, uint64, error) {
+	if replica, fwd := s.replicaState(); replica {
+		if fwd == nil {
+			return nil, 0, ErrReadOnly
+		}
+
+		return s.forwardUpdate(fwd, key, fn)
+	}
+
+	if key == "" {
+		s.logger.Debug(ErrKeyEmpty.Error())
+
+		return nil, 0, ErrKeyEmpty
+	}
+
+	s.logger.Debug("update store access", logger.F("key", key))
+
+	s.rwMutex.Lock()
+	defer s.rwMutex.Unlock()
+
+	current, version, ok := s.lookup(key)
+	if !ok {
+		s.logger.Debug(ErrStoreKeyNotFound.Error(), logger.F("key", key))
+
+		return nil, version, ErrStoreKeyNotFound
+	}
+
+	value, err := fn(current)
+	if err != nil {
+		return nil, version, err
+	}
+
+	entry := logEntry{Op: opPost, Data: StoreData{key: value}}
+	if expires, ttl := s.expiry[key]; ttl {
+		entry.Expiry = map[string]time.Time{key: expires}
+	}
+
+	if err := s.write(entry); err != nil {
+		return nil, version, err
+	}
+
+	s.logger.Debug("key updated in store", logger.F("key", key), logger.F("value", value))
+
+	return value, s.versions[key], nil
+}
+
+func (s *Storage) forwardUpdate(fwd Forwarder, key string, fn func(value interface{}) (interface{}, error)) (interface{}, uint64, error) {
+	for i := 0; ; i++ {
+		current, version, err := s.GetVersion(key)
+		if err != nil {
+			return nil, version, err
+		}
+
+		ttl, err := s.TTL(key)
+		if err != nil {
+			return nil, version, err
+		}
+
+		value, err := fn(current)
+		if err != nil {
+			return nil, version, err
+		}
+
+		version, err = fwd.CompareAndSwap(key, value, &version, nil, ttl)
+		if !errors.Is(err, ErrCASConflict) || i == forwardRetries {
+			return value, version, err
+		}
+
+		time.Sleep(forwardRetryDelay)
+	}
+}
+
+// integer returns value as an int64. Numbers read back from JSON are
+// float64, so a float64 is accepted as long as it holds a whole number in
+// range.
+func integer(value interface{}) (int64, error) {
+	switch v := value.(type) {
+	case int64:
+		return v, nil
+	case int:
+		return int64(v), nil
+	case float64:
+		if v != math.Trunc(v) || v < math.MinInt64 || v >= math.MaxInt64 {
+			return 0, ErrNotInteger
+		}
+
+		return int64(v), nil
+	case string:
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, ErrNotInteger
+		}
+
+		return n, nil
+	default:
+		return 0, ErrNotInteger
+	}
+}
+
+// equal reports whether a and b hold the same value. Numbers are compared by
+// value, as a counter is stored as an int64 but JSON clients send the value
+// they expect as a float64.
+func equal(a, b interface{}) bool {
+	x, xok := number(a)
+	y, yok := number(b)
+	if xok && yok {
+		return x.Cmp(y) == 0
+	}
+
+	return reflect.DeepEqual(a, b)
+}
+
+// number returns value exactly if it is a number.
+func number(value interface{}) (*big.Float, bool) {
+	switch v := value.(type) {
+	case int:
+		return new(big.Float).SetInt64(int64(v)), true
+	case int64:
+		return new(big.Float).SetInt64(v), true
+	case float64:
+		if math.IsNaN(v) {
+			return nil, false
+		}
+
+		return new(big.Float).SetFloat64(v), true
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return new(big.Float).SetInt64(n), true
+		}
+
+		f, err := v.Float64()
+		if err != nil || math.IsNaN(f) {
+			return nil, false
+		}
+
+		return new(big.Float).SetFloat64(f), true
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,271 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"task1/internal/logger"
+	"testing"
+	"time"
+)
+
+func TestService_Increment(t *testing.T) {
+	type args struct {
+		key   string
+		delta int64
+	}
+	tests := []struct {
+		name      string
+		args      args
+		want      int64
+		wantErr   error
+		wantValue interface{}
+	}{
+		{
+			name:      "INCR - ok create missing key",
+			args:      args{key: "new", delta: 5},
+			want:      5,
+			wantValue: int64(5),
+		},
+		{
+			name:      "INCR - ok number",
+			args:      args{key: "number", delta: 3},
+			want:      13,
+			wantValue: int64(13),
+		},
+		{
+			name:      "INCR - ok decrement below 0",
+			args:      args{key: "number", delta: -15},
+			want:      -5,
+			wantValue: int64(-5),
+		},
+		{
+			name:      "INCR - ok string stays string",
+			args:      args{key: "text", delta: 1},
+			want:      8,
+			wantValue: "8",
+		},
+		{
+			name:      "INCR - ok float from JSON",
+			args:      args{key: "json", delta: -1},
+			want:      1,
+			wantValue: int64(1),
+		},
+		{
+			name:      "INCR fail - not integer",
+			args:      args{key: "word", delta: 1},
+			wantErr:   ErrNotInteger,
+			wantValue: "hello",
+		},
+		{
+			name:      "INCR fail - fraction",
+			args:      args{key: "fraction", delta: 1},
+			wantErr:   ErrNotInteger,
+			wantValue: 1.5,
+		},
+		{
+			name:      "INCR fail - overflow",
+			args:      args{key: "max", delta: 1},
+			wantErr:   ErrOverflow,
+			wantValue: int64(math.MaxInt64),
+		},
+		{
+			name:      "INCR fail - underflow",
+			args:      args{key: "min", delta: -1},
+			wantErr:   ErrOverflow,
+			wantValue: int64(math.MinInt64),
+		},
+		{
+			name:    "INCR fail - key empty",
+			args:    args{key: "", delta: 1},
+			wantErr: ErrKeyEmpty,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := logger.NewLogger()
+			logger.StartNoopLogger()
+			kv := NewStorage(logger)
+			kv.Post(StoreData{
+				"number":   10,
+				"text":     "7",
+				"json":     float64(2),
+				"word":     "hello",
+				"fraction": 1.5,
+				"max":      int64(math.MaxInt64),
+				"min":      int64(math.MinInt64),
+			})
+
+			got, _, err := kv.Increment(tt.args.key, tt.args.delta)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Service.Increment() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Service.Increment() = %v, want %v", got, tt.want)
+			}
+
+			if tt.wantValue == nil {
+				return
+			}
+
+			value, _ := kv.Get(tt.args.key)
+			if !reflect.DeepEqual(value, tt.wantValue) {
+				t.Errorf("Service.Get() = %#v, want %#v", value, tt.wantValue)
+			}
+		})
+	}
+}
+
+func TestService_IncrementKeepsTTL(t *testing.T) {
+	logger := logger.NewLogger()
+	logger.StartNoopLogger()
+	kv := NewStorage(logger)
+	kv.PostWithTTL(StoreData{"1": 1}, time.Minute)
+
+	_, version, err := kv.Increment("1", 1)
+	if err != nil || version != 2 {
+		t.Fatalf("Service.Increment() version = %v, error = %v, want 2", version, err)
+	}
+
+	if ttl, _ := kv.TTL("1"); ttl <= 0 || ttl > time.Minute {
+		t.Errorf("Service.TTL() = %v, want the ttl kept", ttl)
+	}
+}
+
+// A counter above 2^53 must come back exactly, not rounded through float64.
+func TestService_IncrementPersisted(t *testing.T) {
+	const start = int64(1)<<53 + 1
+
+	tests := []struct {
+		name string
+		open func(logger *logger.Logger, dir string) *Storage
+	}{
+		{name: "memory engine", open: NewDurableStorage},
+		{name: "disk engine", open: NewDiskStorage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			logger := logger.NewLogger()
+			logger.StartNoopLogger()
+
+			kv := tt.open(logger, dir)
+			kv.Post(StoreData{"snapshot": start, "log": start})
+			kv.Snapshot()
+			kv.Increment("log", 1)
+
+			if got, err := kv.Get("log"); err != nil || got != start+1 {
+				t.Errorf("Service.Get(log) = %#v, %v, want %d", got, err, start+1)
+			}
+
+			// closed without a final snapshot, so log is replayed
+			if kv.wal != nil {
+				kv.wal.close()
+			}
+			kv.table.close()
+
+			restored := tt.open(logger, dir)
+			defer restored.Stop()
+
+			for key, want := range map[string]int64{"snapshot": start, "log": start + 1} {
+				if got, err := restored.Get(key); err != nil || got != want {
+					t.Errorf("restored.Get(%s) = %#v, %v, want %d", key, got, err, want)
+				}
+			}
+
+			if got, _, err := restored.Increment("log", 1); err != nil || got != start+2 {
+				t.Errorf("restored.Increment() = %v, %v, want %d", got, err, start+2)
+			}
+		})
+	}
+}
+
+// JSON clients send the value a CAS expects as a float64, which must match a
+// counter stored as an int64.
+func TestService_CompareAndSwapAfterIncrement(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected interface{}
+		wantErr  error
+	}{
+		{name: "CAS ok - float64", expected: float64(5)},
+		{name: "CAS ok - int64", expected: int64(5)},
+		{name: "CAS ok - json.Number", expected: json.Number("5")},
+		{name: "CAS fail - other number", expected: float64(4), wantErr: ErrCASConflict},
+		{name: "CAS fail - fraction", expected: 5.5, wantErr: ErrCASConflict},
+		{name: "CAS fail - string", expected: "5", wantErr: ErrCASConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := logger.NewLogger()
+			logger.StartNoopLogger()
+			kv := NewStorage(logger)
+			kv.Increment("c", 5)
+
+			if _, err := kv.CompareAndSwap("c", 6, nil, tt.expected, 0); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Service.CompareAndSwap() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestService_Update(t *testing.T) {
+	errUpdate := errors.New("update refused")
+
+	tests := []struct {
+		name        string
+		key         string
+		fn          func(value interface{}) (interface{}, error)
+		want        interface{}
+		wantVersion uint64
+		wantErr     error
+	}{
+		{
+			name:        "UPDATE - ok",
+			key:         "1",
+			fn:          func(value interface{}) (interface{}, error) { return value.(string) + "!", nil },
+			want:        "hello!",
+			wantVersion: 2,
+		},
+		{
+			name:    "UPDATE - fail missing key",
+			key:     "missing",
+			fn:      func(value interface{}) (interface{}, error) { return value, nil },
+			wantErr: ErrStoreKeyNotFound,
+		},
+		{
+			name:        "UPDATE - fail fn error leaves key",
+			key:         "1",
+			fn:          func(value interface{}) (interface{}, error) { return nil, errUpdate },
+			want:        "hello",
+			wantVersion: 1,
+			wantErr:     errUpdate,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := logger.NewLogger()
+			logger.StartNoopLogger()
+			kv := NewStorage(logger)
+			kv.PostWithTTL(StoreData{"1": "hello"}, time.Minute)
+
+			if _, _, err := kv.Update(tt.key, tt.fn); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Service.Update() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.want == nil {
+				return
+			}
+
+			got, version, _ := kv.GetVersion(tt.key)
+			if got != tt.want || version != tt.wantVersion {
+				t.Errorf("Service.GetVersion() = %v, %v, want %v, %v", got, version, tt.want, tt.wantVersion)
+			}
+
+			if ttl, _ := kv.TTL(tt.key); ttl <= 0 || ttl > time.Minute {
+				t.Errorf("Service.TTL() = %v, want the ttl kept", ttl)
+			}
+		})
+	}
+}
@@ -0,0 +1,465 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"task1/internal/logger"
+	"time"
+)
+
+const (
+	diskFile       = "kvstore.db"
+	compactMinSize = 1 << 20
+)
+
+// diskRecord is a single line of the disk engine's data file. A record with
+// no key only carries the store version, so it survives compaction even when
+// the newest key has been deleted.
+//
+// The records of a write to several keys are marked Txn and only count once
+// a Commit record follows them, so a crash part way through leaves none of
+// the write.
+type diskRecord struct {
+	Key     string      `json:"Key,omitempty"`
+	Value   interface{} `json:"Value,omitempty"`
+	Version uint64      `json:"Version"`
+	Expires int64       `json:"Expires,omitempty"`
+	Deleted bool        `json:"Deleted,omitempty"`
+	Txn     bool        `json:"Txn,omitempty"`
+	Commit  bool        `json:"Commit,omitempty"`
+}
+
+// diskLine is a record read back from the data file, with where it was.
+type diskLine struct {
+	record diskRecord
+	offset int64
+	length int64
+}
+
+// diskEntry locates the latest record of a key in the data file.
+type diskEntry struct {
+	offset int64
+	length int64
+	size   int64
+}
+
+// diskTable keeps values in an append-only data file with only an index of
+// keys in memory, so the store can hold more than fits in memory. Every set
+// and delete appends a record and the file is rewritten without the stale
+// records once they outweigh the live ones. Writes are not fsynced, as with
+// the write log.
+type diskTable struct {
+	dir     string
+	file    *os.File
+	index   map[string]diskEntry
+	version uint64
+	end     int64
+	live    int64
+	bytes   int64
+
+	// set by group while its records are written, counting them
+	txn     bool
+	grouped int
+}
+
+// NewDiskStorage returns a Storage which keeps its values in a data file in
+// dir rather than in memory. Only keys, versions and ttls are held in
+// memory, and they are rebuilt from the data file on start.
+func NewDiskStorage(logger *logger.Logger, dir string) *Storage {
+	s := NewStorage(logger)
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		panic(err)
+	}
+
+	table, meta, err := openDiskTable(dir)
+	if err != nil {
+		panic(err)
+	}
+
+	s.table = table
+	s.expiry = meta.Expiry
+	s.versions = meta.Versions
+	s.version = meta.Version
+
+	for key := range s.versions {
+		s.use(key)
+	}
+
+	log.Printf("opened data file, %d keys restored", table.len())
+
+	return s
+}
+
+// openDiskTable opens the data file in dir, rebuilding the index along with
+// the versions and ttls of every key. A truncated final record or a final
+// transaction with no commit record, left by a crash mid-write, is cut off.
+func openDiskTable(dir string) (*diskTable, snapshot, error) {
+	meta := snapshot{
+		Expiry:   make(map[string]time.Time),
+		Versions: make(map[string]uint64),
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, diskFile), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, meta, fmt.Errorf("%w: %v", ErrPersist, err)
+	}
+
+	t := &diskTable{
+		dir:   dir,
+		file:  file,
+		index: make(map[string]diskEntry),
+	}
+
+	restore := func(l diskLine) {
+		t.track(l.record, l.offset, l.length)
+
+		switch record := l.record; {
+		case record.Key == "":
+		case record.Deleted:
+			delete(meta.Expiry, record.Key)
+			delete(meta.Versions, record.Key)
+		default:
+			meta.Versions[record.Key] = record.Version
+			if record.Expires != 0 {
+				meta.Expiry[record.Key] = time.Unix(0, record.Expires)
+			} else {
+				delete(meta.Expiry, record.Key)
+			}
+		}
+	}
+
+	reader := bufio.NewReader(file)
+
+	// the records of a transaction, restored once its commit is read
+	var pending []diskLine
+	cut := int64(-1)
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) && len(line) == 0 {
+			break
+		}
+
+		var record diskRecord
+		if err != nil || unmarshal(line, &record) != nil {
+			log.Printf("truncating unreadable data file record at offset %d", t.end)
+			cut = t.end
+
+			break
+		}
+
+		l := diskLine{record: record, offset: t.end, length: int64(len(line))}
+		t.end += l.length
+
+		switch {
+		case record.Txn:
+			pending = append(pending, l)
+
+			continue
+		case record.Commit:
+		case len(pending) > 0:
+			// only a failed write leaves a transaction without a commit
+			// before later records
+			log.Printf("skipping uncommitted data file transaction at offset %d", pending[0].offset)
+			pending = nil
+		}
+
+		for _, p := range pending {
+			restore(p)
+		}
+		pending = nil
+
+		restore(l)
+	}
+
+	if len(pending) > 0 {
+		log.Printf("truncating uncommitted data file transaction at offset %d", pending[0].offset)
+		cut = pending[0].offset
+	}
+
+	if cut >= 0 {
+		if err := file.Truncate(cut); err != nil {
+			file.Close()
+
+			return nil, meta, fmt.Errorf("%w: %v", ErrPersist, err)
+		}
+
+		t.end = cut
+	}
+
+	meta.Version = t.version
+
+	return t, meta, nil
+}
+
+// track points the index at a record written at offset.
+func (t *diskTable) track(record diskRecord, offset, length int64) {
+	if record.Version > t.version {
+		t.version = record.Version
+	}
+
+	if record.Key == "" {
+		return
+	}
+
+	if old, ok := t.index[record.Key]; ok {
+		t.live -= old.length
+		t.bytes -= old.size
+		delete(t.index, record.Key)
+	}
+
+	if record.Deleted {
+		return
+	}
+
+	size := entrySize(record.Key, record.Value)
+	t.index[record.Key] = diskEntry{offset: offset, length: length, size: size}
+	t.live += length
+	t.bytes += size
+}
+
+func (t *diskTable) get(key string) (interface{}, bool) {
+	entry, ok := t.index[key]
+	if !ok {
+		return nil, false
+	}
+
+	record, err := t.read(entry)
+	if err != nil {
+		log.Printf("key: %s - data file read error: %v", key, err)
+
+		return nil, false
+	}
+
+	return record.Value, true
+}
+
+func (t *diskTable) read(entry diskEntry) (diskRecord, error) {
+	var record diskRecord
+
+	line := make([]byte, entry.length)
+	if _, err := t.file.ReadAt(line, entry.offset); err != nil {
+		return record, fmt.Errorf("%w: %v", ErrPersist, err)
+	}
+
+	if err := unmarshal(line, &record); err != nil {
+		return record, fmt.Errorf("%w: %v", ErrPersist, err)
+	}
+
+	return record, nil
+}
+
+func (t *diskTable) set(key string, value interface{}, version uint64, expires time.Time) error {
+	record := diskRecord{Key: key, Value: value, Version: version}
+	if !expires.IsZero() {
+		record.Expires = expires.UnixNano()
+	}
+
+	return t.append(record)
+}
+
+func (t *diskTable) delete(key string) error {
+	if _, ok := t.index[key]; !ok {
+		return nil
+	}
+
+	return t.append(diskRecord{Key: key, Version: t.version, Deleted: true})
+}
+
+// group marks the records fn writes as a transaction and commits them once
+// fn returns without an error. Compaction waits for the commit, so the file
+// never holds part of a transaction outside one.
+func (t *diskTable) group(fn func() error) error {
+	if t.txn {
+		return fn()
+	}
+
+	t.txn, t.grouped = true, 0
+	err := fn()
+	t.txn = false
+
+	if err != nil || t.grouped == 0 {
+		return err
+	}
+
+	return t.append(diskRecord{Version: t.version, Commit: true})
+}
+
+func (t *diskTable) append(record diskRecord) error {
+	if t.txn {
+		record.Txn = true
+		t.grouped++
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPersist, err)
+	}
+	line = append(line, '\n')
+
+	if _, err := t.file.Write(line); err != nil {
+		return fmt.Errorf("%w: %v", ErrPersist, err)
+	}
+
+	t.track(record, t.end, int64(len(line)))
+	t.end += int64(len(line))
+
+	if garbage := t.end - t.live; !t.txn && garbage > compactMinSize && garbage > t.live {
+		return t.compact()
+	}
+
+	return nil
+}
+
+// compact rewrites the data file with only the latest record of each key.
+func (t *diskTable) compact() error {
+	records := make([]diskRecord, 0, len(t.index))
+
+	for _, entry := range t.index {
+		record, err := t.read(entry)
+		if err != nil {
+			return err
+		}
+
+		records = append(records, record)
+	}
+
+	return t.rewrite(records)
+}
+
+// rewrite replaces the data file with records. The new file is written to a
+// temporary file and renamed so a crash mid-write leaves the old one intact.
+func (t *diskTable) rewrite(records []diskRecord) error {
+	path := filepath.Join(t.dir, diskFile)
+	tmp := path + ".tmp"
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+
+	if err := enc.Encode(diskRecord{Version: t.version}); err != nil {
+		return fmt.Errorf("%w: %v", ErrPersist, err)
+	}
+
+	index := make(map[string]diskEntry, len(records))
+	var live, size int64
+
+	for _, record := range records {
+		record.Txn = false
+
+		offset := int64(buf.Len())
+		if err := enc.Encode(record); err != nil {
+			return fmt.Errorf("%w: %v", ErrPersist, err)
+		}
+
+		length := int64(buf.Len()) - offset
+		entry := diskEntry{offset: offset, length: length, size: entrySize(record.Key, record.Value)}
+		index[record.Key] = entry
+		live += length
+		size += entry.size
+	}
+
+	if err := writeFileSync(tmp, buf.Bytes()); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("%w: %v", ErrPersist, err)
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPersist, err)
+	}
+
+	t.file.Close()
+	t.file = file
+	t.index = index
+	t.end = int64(buf.Len())
+	t.live = live
+	t.bytes = size
+
+	return nil
+}
+
+func writeFileSync(path string, data []byte) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPersist, err)
+	}
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+
+		return fmt.Errorf("%w: %v", ErrPersist, err)
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+
+		return fmt.Errorf("%w: %v", ErrPersist, err)
+	}
+
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("%w: %v", ErrPersist, err)
+	}
+
+	return nil
+}
+
+func (t *diskTable) len() int {
+	return len(t.index)
+}
+
+func (t *diskTable) keys() []string {
+	keys := make([]string, 0, len(t.index))
+	for key := range t.index {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+func (t *diskTable) size() int64 {
+	return t.bytes
+}
+
+func (t *diskTable) data() StoreData {
+	data := make(StoreData, len(t.index))
+
+	for key := range t.index {
+		if value, ok := t.get(key); ok {
+			data[key] = value
+		}
+	}
+
+	return data
+}
+
+func (t *diskTable) reset(snap snapshot) error {
+	records := make([]diskRecord, 0, len(snap.Store))
+
+	for key, value := range snap.Store {
+		record := diskRecord{Key: key, Value: value, Version: snap.Versions[key]}
+		if expires, ok := snap.Expiry[key]; ok {
+			record.Expires = expires.UnixNano()
+		}
+
+		records = append(records, record)
+	}
+
+	t.version = snap.Version
+
+	return t.rewrite(records)
+}
+
+func (t *diskTable) close() error {
+	return t.file.Close()
+}
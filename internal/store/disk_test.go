@@ -0,0 +1,194 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"task1/internal/logger"
+	"testing"
+	"time"
+)
+
+func TestDiskStorage_Reopen(t *testing.T) {
+	tests := []struct {
+		name        string
+		ops         func(kv *Storage)
+		corrupt     string
+		want        StoreData
+		wantVersion uint64
+	}{
+		{
+			name: "reopen - posts",
+			ops: func(kv *Storage) {
+				kv.Post(map[string]interface{}{"1": "hello world", "2": "hello"})
+			},
+			want:        StoreData{"1": "hello world", "2": "hello"},
+			wantVersion: 2,
+		},
+		{
+			name: "reopen - deletes",
+			ops: func(kv *Storage) {
+				kv.Post(map[string]interface{}{"1": "hello world", "2": "hello"})
+				kv.Delete("2")
+			},
+			want:        StoreData{"1": "hello world"},
+			wantVersion: 2,
+		},
+		{
+			name: "reopen - expired keys dropped",
+			ops: func(kv *Storage) {
+				kv.Post(map[string]interface{}{"1": "hello world"})
+				kv.PostWithTTL(map[string]interface{}{"2": "hello"}, time.Millisecond)
+				time.Sleep(5 * time.Millisecond)
+			},
+			want:        StoreData{"1": "hello world"},
+			wantVersion: 2,
+		},
+		{
+			name: "reopen - transaction",
+			ops: func(kv *Storage) {
+				kv.Post(map[string]interface{}{"1": "hello world", "2": "hello"})
+				kv.Transact([]TxOp{
+					{Op: TxDelete, Key: "1"},
+					{Op: TxSet, Key: "3", Value: "world"},
+				})
+			},
+			want:        StoreData{"2": "hello", "3": "world"},
+			wantVersion: 3,
+		},
+		{
+			name: "reopen - truncated final record cut off",
+			ops: func(kv *Storage) {
+				kv.Post(map[string]interface{}{"1": "hello world"})
+			},
+			corrupt:     `{"Key":"9","Value":`,
+			want:        StoreData{"1": "hello world"},
+			wantVersion: 1,
+		},
+		{
+			name: "reopen - uncommitted transaction cut off",
+			ops: func(kv *Storage) {
+				kv.Post(map[string]interface{}{"1": "hello world"})
+			},
+			corrupt: `{"Key":"1","Version":1,"Deleted":true,"Txn":true}` + "\n" +
+				`{"Key":"3","Value":"world","Version":2,"Txn":true}` + "\n",
+			want:        StoreData{"1": "hello world"},
+			wantVersion: 1,
+		},
+		{
+			name: "reopen - transaction truncated mid-record cut off",
+			ops: func(kv *Storage) {
+				kv.Post(map[string]interface{}{"1": "hello world"})
+			},
+			corrupt:     `{"Key":"1","Version":1,"Deleted":true,"Txn":true}` + "\n" + `{"Key":"3","Val`,
+			want:        StoreData{"1": "hello world"},
+			wantVersion: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			logger := logger.NewLogger()
+			logger.StartNoopLogger()
+
+			kv := NewDiskStorage(logger, dir)
+			tt.ops(kv)
+			kv.table.close()
+
+			if tt.corrupt != "" {
+				f, err := os.OpenFile(filepath.Join(dir, diskFile), os.O_WRONLY|os.O_APPEND, 0o644)
+				if err != nil {
+					t.Fatalf("open data file error: %v", err)
+				}
+				f.WriteString(tt.corrupt)
+				f.Close()
+			}
+
+			restored := NewDiskStorage(logger, dir)
+			defer restored.table.close()
+
+			for key, want := range tt.want {
+				got, err := restored.Get(key)
+				if err != nil {
+					t.Errorf("restored.Get(%s) error = %v", key, err)
+					continue
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("restored.Get(%s) = %v, want %v", key, got, want)
+				}
+			}
+
+			for _, key := range restored.table.keys() {
+				if _, ok := tt.want[key]; !ok && !restored.expired(key, time.Now()) {
+					t.Errorf("restored store has unexpected key %s", key)
+				}
+			}
+
+			if restored.version != tt.wantVersion {
+				t.Errorf("restored version = %d, want %d", restored.version, tt.wantVersion)
+			}
+
+			if tt.corrupt != "" {
+				// the cut off records must not break later appends
+				if err := restored.Post(StoreData{"4": "again"}); err != nil {
+					t.Errorf("restored.Post() error = %v", err)
+				}
+
+				restored.table.close()
+				again := NewDiskStorage(logger, dir)
+				defer again.table.close()
+
+				if got, err := again.Get("4"); err != nil || got != "again" {
+					t.Errorf("reopened Get(4) = %v, %v, want again", got, err)
+				}
+			}
+		})
+	}
+}
+
+func TestDiskStorage_Compact(t *testing.T) {
+	dir := t.TempDir()
+	logger := logger.NewLogger()
+	logger.StartNoopLogger()
+
+	kv := NewDiskStorage(logger, dir)
+	value := strings.Repeat("x", 64*1024)
+
+	kv.Post(StoreData{"keep": "hello"})
+	for i := 0; i < 64; i++ {
+		if err := kv.Post(StoreData{"big": value}); err != nil {
+			t.Fatalf("Post() error = %v", err)
+		}
+	}
+	kv.Delete("big")
+
+	info, err := os.Stat(filepath.Join(dir, diskFile))
+	if err != nil {
+		t.Fatalf("stat data file error: %v", err)
+	}
+	if info.Size() > compactMinSize*2 {
+		t.Errorf("data file size = %d, want compacted below %d", info.Size(), compactMinSize*2)
+	}
+
+	keys, size := kv.Stats()
+	if keys != 1 || size != entrySize("keep", "hello") {
+		t.Errorf("Stats() = %d, %d, want 1, %d", keys, size, entrySize("keep", "hello"))
+	}
+
+	version := kv.version
+	kv.table.close()
+
+	restored := NewDiskStorage(logger, dir)
+	defer restored.table.close()
+
+	if got, err := restored.Get("keep"); err != nil || got != "hello" {
+		t.Errorf("restored.Get(keep) = %v, %v, want hello", got, err)
+	}
+	if _, err := restored.Get("big"); err != ErrStoreKeyNotFound {
+		t.Errorf("restored.Get(big) error = %v, want %v", err, ErrStoreKeyNotFound)
+	}
+	if restored.version != version {
+		t.Errorf("restored version = %d, want %d", restored.version, version)
+	}
+}
@@ -0,0 +1,44 @@
+package store
+
+import (
+	"time"
+)
+
+// Store is implemented by every storage engine. The transports only depend
+// on Store so any engine can serve them:
+//
+//   - NewStorage keeps values in memory behind a single lock
+//   - NewDurableStorage adds a write log and snapshots to NewStorage
+//   - NewShardedStorage splits keys across in-memory shards, each with its
+//     own lock
+//   - NewDiskStorage keeps values in a data file with only keys in memory
+type Store interface {
+	Get(key string) (interface{}, error)
+	GetVersion(key string) (interface{}, uint64, error)
+	TTL(key string) (time.Duration, error)
+	Post(data StoreData) error
+	PostWithTTL(data StoreData, ttl time.Duration) error
+	CompareAndSwap(
+		key string,
+		value interface{},
+		expectedVersion *uint64,
+		expectedValue interface{},
+		ttl time.Duration,
+	) (uint64, error)
+	Increment(key string, delta int64) (int64, uint64, error)
+	Update(key string, fn func(value interface{}) (interface{}, error)) (interface{}, uint64, error)
+	Delete(key string) error
+	Transact(ops []TxOp) ([]TxResult, error)
+	List(prefix, cursor string, limit int) (ListPage, error)
+	Watch(prefix string) (<-chan Event, func())
+	Stats() (int, int64)
+	SetLimits(limits Limits) error
+	Evictions() uint64
+	Start()
+	Stop()
+}
+
+var (
+	_ Store = (*Storage)(nil)
+	_ Store = (*ShardedStorage)(nil)
+)
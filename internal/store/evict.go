@@ -0,0 +1,256 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"task1/internal/logger"
+)
+
+// Eviction policies, see Limits.
+const (
+	EvictLRU    = "lru"
+	EvictLFU    = "lfu"
+	EvictRandom = "random"
+	EvictTTL    = "ttl"
+	EvictReject = "reject"
+
+	// evictSamples is how many keys are compared to pick each key to evict,
+	// as in Redis, so evicting never scans the whole store. Stores with
+	// fewer keys evict exactly by policy.
+	evictSamples = 16
+	opEvict      = "EVICT"
+)
+
+var (
+	ErrStoreFull      = errors.New("store is full")
+	ErrPolicyInvalid  = errors.New("unknown eviction policy")
+	ErrLimitsNegative = errors.New("limits cannot be negative")
+	ErrLimitsTooSmall = errors.New("limits are smaller than the number of shards")
+)
+
+// Limits caps the size of a Storage. A zero MaxKeys or MaxBytes is not
+// checked. A write which takes the store past a limit evicts other keys by
+// Policy until the store fits again:
+//
+//   - EvictLRU evicts the least recently read or written key
+//   - EvictLFU evicts the least often read or written key
+//   - EvictRandom evicts any key
+//   - EvictTTL evicts the key closest to expiring, and rejects writes once no
+//     key has a ttl
+//   - EvictReject evicts nothing and rejects the write
+//
+// Rejected writes, and writes too large to ever fit, fail with ErrStoreFull.
+type Limits struct {
+	MaxKeys  int    `json:"MaxKeys"`
+	MaxBytes int64  `json:"MaxBytes"`
+	Policy   string `json:"Policy"`
+}
+
+func (l Limits) enabled() bool {
+	return l.MaxKeys > 0 || l.MaxBytes > 0
+}
+
+func (l Limits) over(keys int, bytes int64) bool {
+	return (l.MaxKeys > 0 && keys > l.MaxKeys) || (l.MaxBytes > 0 && bytes > l.MaxBytes)
+}
+
+// usage tracks reads and writes of a key for EvictLRU and EvictLFU. It is
+// updated atomically so reads only need the read lock.
+type usage struct {
+	last atomic.Uint64
+	hits atomic.Uint64
+}
+
+// pending is the change a log entry makes to a single key.
+type pending struct {
+	value   interface{}
+	deleted bool
+}
+
+// SetLimits sets the limits of the store, evicting keys straight away if it
+// is already past them.
+func (s *Storage) SetLimits(limits Limits) error {
+	switch limits.Policy {
+	case EvictLRU, EvictLFU, EvictRandom, EvictTTL, EvictReject:
+	default:
+		return fmt.Errorf("%w: %q", ErrPolicyInvalid, limits.Policy)
+	}
+
+	if limits.MaxKeys < 0 || limits.MaxBytes < 0 {
+		return ErrLimitsNegative
+	}
+
+	s.rwMutex.Lock()
+	defer s.rwMutex.Unlock()
+
+	s.limits = limits
+
+	if s.replica {
+		return nil
+	}
+
+	return s.evict(nil)
+}
+
+// Evictions returns the number of keys evicted since the store started.
+func (s *Storage) Evictions() uint64 {
+	s.rwMutex.RLock()
+	defer s.rwMutex.RUnlock()
+
+	return s.evictions
+}
+
+// use records a read or write of key. Callers must hold at least the read
+// lock, and the write lock for a key not yet in usage.
+func (s *Storage) use(key string) {
+	u, ok := s.usage[key]
+	if !ok {
+		u = &usage{}
+		s.usage[key] = u
+	}
+
+	u.last.Store(s.clock.Add(1))
+	u.hits.Add(1)
+}
+
+// changes collects the change entry makes to each key into into.
+func changes(entry logEntry, into map[string]pending) {
+	switch entry.Op {
+	case opPost:
+		for key, value := range entry.Data {
+			into[key] = pending{value: value}
+		}
+	case opDelete, opEvict:
+		into[entry.Key] = pending{deleted: true}
+	case opTxn:
+		for _, op := range entry.Ops {
+			changes(op, into)
+		}
+	}
+}
+
+// limited reports whether writes to the store must be checked against its
+// limits. Replicas apply the evictions of their leader rather than making
+// their own. Callers must hold the lock.
+func (s *Storage) limited(entry logEntry) bool {
+	return s.limits.enabled() && !s.replica && entry.Op != opEvict
+}
+
+// admit returns ErrStoreFull if entry would take the store past its limits
+// and no keys can be evicted to make room. Callers must hold the lock.
+func (s *Storage) admit(entry logEntry) error {
+	if !s.limited(entry) {
+		return nil
+	}
+
+	written := make(map[string]pending)
+	changes(entry, written)
+
+	keys, bytes := s.table.len(), s.table.size()
+	var ownKeys int
+	var ownBytes int64
+
+	for key, p := range written {
+		if old, ok := s.table.get(key); ok {
+			keys--
+			bytes -= entrySize(key, old)
+		}
+
+		if !p.deleted {
+			size := entrySize(key, p.value)
+			keys++
+			bytes += size
+			ownKeys++
+			ownBytes += size
+		}
+	}
+
+	switch {
+	case !s.limits.over(keys, bytes):
+		return nil
+	case s.limits.Policy == EvictReject, s.limits.over(ownKeys, ownBytes):
+		return ErrStoreFull
+	case s.limits.Policy == EvictTTL && len(s.expiry) == 0:
+		return ErrStoreFull
+	}
+
+	return nil
+}
+
+// evict removes keys by policy until the store is within its limits, never
+// evicting the keys in written. Each eviction is logged and replicated like a
+// delete. Callers must hold the lock.
+func (s *Storage) evict(written map[string]pending) error {
+	for s.limits.over(s.table.len(), s.table.size()) {
+		key, ok := s.victim(written)
+		if !ok {
+			return nil
+		}
+
+		if err := s.write(logEntry{Op: opEvict, Key: key}); err != nil {
+			return err
+		}
+
+		s.evictions++
+		s.logger.Debug("key evicted", logger.F("key", key), logger.F("policy", s.limits.Policy))
+	}
+
+	return nil
+}
+
+// victim picks the key to evict next from a sample of the store. Callers
+// must hold the lock.
+func (s *Storage) victim(written map[string]pending) (string, bool) {
+	var (
+		best    string
+		score   uint64
+		found   bool
+		samples int
+	)
+
+	// consider scores key and reports whether enough keys have been sampled
+	consider := func(key string, candidate uint64) bool {
+		if _, ok := written[key]; ok {
+			return false
+		}
+
+		if !found || candidate < score {
+			best, score, found = key, candidate, true
+		}
+
+		samples++
+
+		return s.limits.Policy == EvictRandom || samples == evictSamples
+	}
+
+	if s.limits.Policy == EvictTTL {
+		for key, expires := range s.expiry {
+			if consider(key, uint64(expires.UnixNano())) {
+				break
+			}
+		}
+
+		return best, found
+	}
+
+	// go starts each map iteration at a random key, which is enough for
+	// EvictRandom
+	for key := range s.versions {
+		var candidate uint64
+		if u, ok := s.usage[key]; ok {
+			switch s.limits.Policy {
+			case EvictLRU:
+				candidate = u.last.Load()
+			case EvictLFU:
+				candidate = u.hits.Load()
+			}
+		}
+
+		if consider(key, candidate) {
+			break
+		}
+	}
+
+	return best, found
+}
@@ -0,0 +1,211 @@
+package store
+
+import (
+	"errors"
+	"reflect"
+	"task1/internal/logger"
+	"testing"
+	"time"
+)
+
+func TestService_Evict(t *testing.T) {
+	tests := []struct {
+		name          string
+		limits        Limits
+		ops           func(kv *Storage) error
+		want          StoreData
+		wantEvictions uint64
+		wantErr       error
+	}{
+		{
+			name:   "lru - least recently used evicted",
+			limits: Limits{MaxKeys: 2, Policy: EvictLRU},
+			ops: func(kv *Storage) error {
+				kv.Post(StoreData{"1": "hello"})
+				kv.Post(StoreData{"2": "world"})
+				kv.Get("1")
+				return kv.Post(StoreData{"3": "again"})
+			},
+			want:          StoreData{"1": "hello", "3": "again"},
+			wantEvictions: 1,
+		},
+		{
+			name:   "lfu - least frequently used evicted",
+			limits: Limits{MaxKeys: 2, Policy: EvictLFU},
+			ops: func(kv *Storage) error {
+				kv.Post(StoreData{"1": "hello"})
+				kv.Post(StoreData{"2": "world"})
+				kv.Get("1")
+				kv.Get("1")
+				kv.Get("2")
+				kv.Get("1")
+				return kv.Post(StoreData{"3": "again"})
+			},
+			want:          StoreData{"1": "hello", "3": "again"},
+			wantEvictions: 1,
+		},
+		{
+			name:   "ttl - soonest expiry evicted",
+			limits: Limits{MaxKeys: 2, Policy: EvictTTL},
+			ops: func(kv *Storage) error {
+				kv.Post(StoreData{"1": "hello"})
+				kv.PostWithTTL(StoreData{"2": "world"}, time.Hour)
+				return kv.Post(StoreData{"3": "again"})
+			},
+			want:          StoreData{"1": "hello", "3": "again"},
+			wantEvictions: 1,
+		},
+		{
+			name:   "ttl - no keys with ttl",
+			limits: Limits{MaxKeys: 2, Policy: EvictTTL},
+			ops: func(kv *Storage) error {
+				kv.Post(StoreData{"1": "hello"})
+				kv.Post(StoreData{"2": "world"})
+				return kv.Post(StoreData{"3": "again"})
+			},
+			want:    StoreData{"1": "hello", "2": "world"},
+			wantErr: ErrStoreFull,
+		},
+		{
+			name:   "reject - write rejected",
+			limits: Limits{MaxKeys: 2, Policy: EvictReject},
+			ops: func(kv *Storage) error {
+				kv.Post(StoreData{"1": "hello"})
+				kv.Post(StoreData{"2": "world"})
+				return kv.Post(StoreData{"3": "again"})
+			},
+			want:    StoreData{"1": "hello", "2": "world"},
+			wantErr: ErrStoreFull,
+		},
+		{
+			name:   "reject - overwrite allowed",
+			limits: Limits{MaxKeys: 2, Policy: EvictReject},
+			ops: func(kv *Storage) error {
+				kv.Post(StoreData{"1": "hello"})
+				kv.Post(StoreData{"2": "world"})
+				return kv.Post(StoreData{"2": "again"})
+			},
+			want: StoreData{"1": "hello", "2": "again"},
+		},
+		{
+			name:   "max bytes - evicted until it fits",
+			limits: Limits{MaxBytes: 12, Policy: EvictLRU},
+			ops: func(kv *Storage) error {
+				kv.Post(StoreData{"1": "hello"})
+				kv.Post(StoreData{"2": "world"})
+				return kv.Post(StoreData{"3": "hello world"})
+			},
+			want:          StoreData{"3": "hello world"},
+			wantEvictions: 2,
+		},
+		{
+			name:   "max bytes - too large to fit",
+			limits: Limits{MaxBytes: 4, Policy: EvictLRU},
+			ops: func(kv *Storage) error {
+				return kv.Post(StoreData{"1": "hello world"})
+			},
+			want:    StoreData{},
+			wantErr: ErrStoreFull,
+		},
+		{
+			name:   "random - store kept at limit",
+			limits: Limits{MaxKeys: 1, Policy: EvictRandom},
+			ops: func(kv *Storage) error {
+				kv.Post(StoreData{"1": "hello"})
+				return kv.Post(StoreData{"2": "world"})
+			},
+			want:          StoreData{"2": "world"},
+			wantEvictions: 1,
+		},
+		{
+			name:   "transaction - rejected as a whole",
+			limits: Limits{MaxKeys: 2, Policy: EvictReject},
+			ops: func(kv *Storage) error {
+				kv.Post(StoreData{"1": "hello"})
+				_, err := kv.Transact([]TxOp{
+					{Op: TxSet, Key: "2", Value: "world"},
+					{Op: TxSet, Key: "3", Value: "again"},
+				})
+				return err
+			},
+			want:    StoreData{"1": "hello"},
+			wantErr: ErrStoreFull,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := logger.NewLogger()
+			logger.StartNoopLogger()
+			kv := NewStorage(logger)
+
+			if err := kv.SetLimits(tt.limits); err != nil {
+				t.Fatalf("Service.SetLimits() error = %v", err)
+			}
+
+			err := tt.ops(kv)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Service.Post() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := kv.table.data(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Service.Post() store = %v, want %v", got, tt.want)
+			}
+			if got := kv.Evictions(); got != tt.wantEvictions {
+				t.Errorf("Service.Evictions() = %d, want %d", got, tt.wantEvictions)
+			}
+		})
+	}
+}
+
+func TestService_SetLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		limits  Limits
+		want    StoreData
+		wantErr error
+	}{
+		{
+			name:   "ok - evicts straight away",
+			limits: Limits{MaxKeys: 1, Policy: EvictLRU},
+			want:   StoreData{"2": "world"},
+		},
+		{
+			name:    "fail - unknown policy",
+			limits:  Limits{MaxKeys: 1, Policy: "oldest"},
+			want:    StoreData{"1": "hello", "2": "world"},
+			wantErr: ErrPolicyInvalid,
+		},
+		{
+			name:    "fail - negative",
+			limits:  Limits{MaxKeys: -1, Policy: EvictLRU},
+			want:    StoreData{"1": "hello", "2": "world"},
+			wantErr: ErrLimitsNegative,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := logger.NewLogger()
+			logger.StartNoopLogger()
+			kv := NewStorage(logger)
+			kv.Post(StoreData{"1": "hello"})
+			kv.Post(StoreData{"2": "world"})
+
+			events, cancel := kv.Watch("")
+			defer cancel()
+
+			err := kv.SetLimits(tt.limits)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Service.SetLimits() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := kv.table.data(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Service.SetLimits() store = %v, want %v", got, tt.want)
+			}
+
+			if tt.wantErr == nil {
+				event := <-events
+				if event.Op != OpEvict || event.Key != "1" {
+					t.Errorf("Service.Watch() event = %+v, want %s of 1", event, OpEvict)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package store
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"task1/internal/logger"
+	"time"
+)
+
+const (
+	DefaultListLimit = 100
+	MaxListLimit     = 1000
+)
+
+var ErrLimitInvalid = errors.New("limit must be between 0 and 1000")
+
+// ListPage is a page of keys in lexicographic order. Next is the cursor for
+// the following page and is empty on the last page.
+type ListPage struct {
+	Keys []string `json:"Keys"`
+	Next string   `json:"Next"`
+}
+
+// List returns up to limit keys starting with prefix which sort after cursor.
+// A limit of 0 returns DefaultListLimit keys. Pass the previous page's Next
+// as cursor to continue a scan; keys written behind the cursor during a scan
+// are not returned.
+func (s *Storage) List(prefix, cursor string, limit int) (ListPage, error) {
+	if limit < 0 || limit > MaxListLimit {
+		s.logger.Debug(ErrLimitInvalid.Error(), logger.F("limit", limit))
+
+		return ListPage{}, ErrLimitInvalid
+	}
+
+	if limit == 0 {
+		limit = DefaultListLimit
+	}
+
+	s.logger.Debug("list store access", logger.F("prefix", prefix))
+
+	s.rwMutex.RLock()
+	defer s.rwMutex.RUnlock()
+
+	now := time.Now()
+	keys := make([]string, 0)
+
+	for _, key := range s.table.keys() {
+		if strings.HasPrefix(key, prefix) && key > cursor && !s.expired(key, now) {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	page := ListPage{Keys: keys}
+	if len(keys) > limit {
+		page.Keys = keys[:limit]
+		page.Next = keys[limit-1]
+	}
+
+	return page, nil
+}
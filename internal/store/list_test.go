@@ -0,0 +1,84 @@
+package store
+
+import (
+	"errors"
+	"reflect"
+	"task1/internal/logger"
+	"testing"
+	"time"
+)
+
+func TestService_List(t *testing.T) {
+	type args struct {
+		prefix string
+		cursor string
+		limit  int
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    ListPage
+		wantErr error
+	}{
+		{
+			name: "LIST - ok all keys sorted",
+			args: args{},
+			want: ListPage{Keys: []string{"a", "config/1", "config/2", "config/3", "session/1"}},
+		},
+		{
+			name: "LIST - ok prefix",
+			args: args{prefix: "config/"},
+			want: ListPage{Keys: []string{"config/1", "config/2", "config/3"}},
+		},
+		{
+			name: "LIST - ok first page",
+			args: args{prefix: "config/", limit: 2},
+			want: ListPage{Keys: []string{"config/1", "config/2"}, Next: "config/2"},
+		},
+		{
+			name: "LIST - ok last page",
+			args: args{prefix: "config/", cursor: "config/2", limit: 2},
+			want: ListPage{Keys: []string{"config/3"}},
+		},
+		{
+			name: "LIST - ok exact final page",
+			args: args{prefix: "config/", cursor: "config/1", limit: 2},
+			want: ListPage{Keys: []string{"config/2", "config/3"}},
+		},
+		{
+			name: "LIST - ok no matches",
+			args: args{prefix: "none/"},
+			want: ListPage{Keys: []string{}},
+		},
+		{
+			name:    "LIST fail - limit too large",
+			args:    args{limit: MaxListLimit + 1},
+			wantErr: ErrLimitInvalid,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := logger.NewLogger()
+			logger.StartNoopLogger()
+			kv := NewStorage(logger)
+			kv.Post(map[string]interface{}{
+				"config/2":  "b",
+				"config/1":  "a",
+				"session/1": "token",
+				"config/3":  "c",
+				"a":         "a",
+			})
+			kv.PostWithTTL(map[string]interface{}{"config/0": "expired"}, time.Millisecond)
+			time.Sleep(5 * time.Millisecond)
+
+			got, err := kv.List(tt.args.prefix, tt.args.cursor, tt.args.limit)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Service.List() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Service.List() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
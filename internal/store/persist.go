@@ -0,0 +1,576 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"task1/internal/logger"
+	"time"
+)
+
+const (
+	logFile          = "kvstore.log"
+	snapshotFile     = "kvstore.snapshot"
+	snapshotInterval = 1 * time.Minute
+	opPost           = "POST"
+	opDelete         = "DELETE"
+
+	// integers no larger than maxExact are held exactly by a float64
+	maxExact = 1 << 53
+)
+
+var ErrPersist = errors.New("store persistence failed")
+
+// logEntry is a single line of the append-only write log.
+type logEntry struct {
+	Op      string               `json:"Op"`
+	Data    StoreData            `json:"Data,omitempty"`
+	Expiry  map[string]time.Time `json:"Expiry,omitempty"`
+	Key     string               `json:"Key,omitempty"`
+	Ops     []logEntry           `json:"Ops,omitempty"`
+	Written time.Time            `json:"Written"`
+}
+
+// snapshot is a compacted copy of the store written to disk.
+type snapshot struct {
+	Store    StoreData            `json:"Store"`
+	Expiry   map[string]time.Time `json:"Expiry"`
+	Versions map[string]uint64    `json:"Versions"`
+	Version  uint64               `json:"Version"`
+
+	// the last rotated log the snapshot includes
+	Generation uint64 `json:"Generation,omitempty"`
+}
+
+// unmarshal decodes persisted JSON into v, a *snapshot, *logEntry or
+// *diskRecord. Numbers decode as float64, as they do from clients, except
+// integers too large for a float64 to hold exactly, such as counters, which
+// decode as int64 so they read back unchanged.
+func unmarshal(raw []byte, v interface{}) error {
+	d := json.NewDecoder(bytes.NewReader(raw))
+	d.UseNumber()
+	if err := d.Decode(v); err != nil {
+		return err
+	}
+	if d.More() {
+		return errors.New("unexpected data after the value")
+	}
+
+	switch v := v.(type) {
+	case *snapshot:
+		exactData(v.Store)
+	case *logEntry:
+		v.exact()
+	case *diskRecord:
+		v.Value = exact(v.Value)
+	}
+
+	return nil
+}
+
+func (e *logEntry) exact() {
+	exactData(e.Data)
+	for i := range e.Ops {
+		e.Ops[i].exact()
+	}
+}
+
+func exactData(data StoreData) {
+	for key, value := range data {
+		data[key] = exact(value)
+	}
+}
+
+// exact replaces the json.Numbers in a value decoded by unmarshal.
+func exact(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil && (n > maxExact || n < -maxExact) {
+			return n
+		}
+
+		f, _ := v.Float64()
+
+		return f
+	case map[string]interface{}:
+		for key, nested := range v {
+			v[key] = exact(nested)
+		}
+	case []interface{}:
+		for i, nested := range v {
+			v[i] = exact(nested)
+		}
+	}
+
+	return value
+}
+
+// postEntry builds the log entry for writing data with ttl.
+func postEntry(data StoreData, ttl time.Duration) logEntry {
+	entry := logEntry{Op: opPost, Data: data}
+
+	if ttl > 0 {
+		expires := time.Now().Add(ttl)
+		entry.Expiry = make(map[string]time.Time, len(data))
+
+		for key := range data {
+			entry.Expiry[key] = expires
+		}
+	}
+
+	return entry
+}
+
+// writeLog appends every Post and Delete to a file in dir and periodically
+// compacts the file into a snapshot. Writes are not fsynced, so a process
+// crash loses nothing but a host crash can lose the latest entries.
+//
+// To compact, the log is first rotated to kvstore.log.<generation> so writes
+// carry on into a new log while the snapshot is written. The snapshot records
+// its generation, and rotated logs up to it are removed once it is in place.
+type writeLog struct {
+	dir        string
+	file       *os.File
+	enc        *json.Encoder
+	generation uint64
+
+	// mutex orders compactions finishing, so an older snapshot never
+	// replaces a newer one
+	mutex       sync.Mutex
+	snapshotted uint64
+}
+
+// NewDurableStorage returns a Storage which replays the snapshot and write
+// log found in dir and records every later change there.
+func NewDurableStorage(logger *logger.Logger, dir string) *Storage {
+	s := NewStorage(logger)
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		panic(err)
+	}
+
+	generation, err := s.replay(dir)
+	if err != nil {
+		panic(err)
+	}
+
+	wal, err := openWriteLog(dir, generation)
+	if err != nil {
+		panic(err)
+	}
+
+	s.wal = wal
+
+	return s
+}
+
+func openWriteLog(dir string, generation uint64) (*writeLog, error) {
+	file, err := os.OpenFile(filepath.Join(dir, logFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &writeLog{
+		dir:         dir,
+		file:        file,
+		enc:         json.NewEncoder(file),
+		generation:  generation,
+		snapshotted: generation,
+	}, nil
+}
+
+// rotate moves the log aside as the next generation and starts a new one,
+// returning the generation. Callers must hold the store lock.
+func (w *writeLog) rotate() (uint64, error) {
+	path := filepath.Join(w.dir, logFile)
+	generation := w.generation + 1
+
+	if err := w.file.Close(); err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrPersist, err)
+	}
+
+	if err := os.Rename(path, rotatedLog(path, generation)); err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrPersist, err)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrPersist, err)
+	}
+
+	w.file = file
+	w.enc = json.NewEncoder(file)
+	w.generation = generation
+
+	return generation, nil
+}
+
+func rotatedLog(path string, generation uint64) string {
+	return path + "." + strconv.FormatUint(generation, 10)
+}
+
+// rotatedLogs returns the generations of the rotated logs in dir, oldest
+// first.
+func rotatedLogs(dir string) ([]uint64, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, logFile+".*"))
+	if err != nil {
+		return nil, err
+	}
+
+	generations := make([]uint64, 0, len(paths))
+	for _, path := range paths {
+		generation, err := strconv.ParseUint(filepath.Ext(path)[1:], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		generations = append(generations, generation)
+	}
+
+	sort.Slice(generations, func(i, j int) bool { return generations[i] < generations[j] })
+
+	return generations, nil
+}
+
+func (w *writeLog) append(entry logEntry) error {
+	if err := w.enc.Encode(entry); err != nil {
+		return fmt.Errorf("%w: %v", ErrPersist, err)
+	}
+
+	return nil
+}
+
+// compact writes data, taken when the log was rotated to data.Generation, to
+// a new snapshot and removes the rotated logs it includes. The snapshot is
+// written to a temporary file and renamed so a crash mid-write leaves the
+// previous snapshot intact. It does not need the store lock.
+func (w *writeLog) compact(data snapshot) error {
+	path := filepath.Join(w.dir, snapshotFile)
+
+	file, err := os.CreateTemp(w.dir, snapshotFile+".tmp*")
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPersist, err)
+	}
+	tmp := file.Name()
+	defer os.Remove(tmp)
+
+	if err := json.NewEncoder(file).Encode(data); err != nil {
+		file.Close()
+
+		return fmt.Errorf("%w: %v", ErrPersist, err)
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+
+		return fmt.Errorf("%w: %v", ErrPersist, err)
+	}
+
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("%w: %v", ErrPersist, err)
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	// a later compaction finished first
+	if data.Generation <= w.snapshotted {
+		return nil
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("%w: %v", ErrPersist, err)
+	}
+	w.snapshotted = data.Generation
+
+	generations, err := rotatedLogs(w.dir)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPersist, err)
+	}
+
+	for _, generation := range generations {
+		if generation > data.Generation {
+			break
+		}
+
+		if err := os.Remove(rotatedLog(filepath.Join(w.dir, logFile), generation)); err != nil {
+			return fmt.Errorf("%w: %v", ErrPersist, err)
+		}
+	}
+
+	return nil
+}
+
+func (w *writeLog) close() error {
+	return w.file.Close()
+}
+
+// replay loads the snapshot in dir, if any, then applies the rotated logs
+// it does not include and the write log on top of it. It returns the latest
+// generation found.
+func (s *Storage) replay(dir string) (uint64, error) {
+	var generation uint64
+
+	raw, err := os.ReadFile(filepath.Join(dir, snapshotFile))
+	switch {
+	case err == nil:
+		var snap snapshot
+		if err := unmarshal(raw, &snap); err != nil {
+			return 0, fmt.Errorf("%w: snapshot: %v", ErrPersist, err)
+		}
+
+		if err := s.load(snap); err != nil {
+			return 0, err
+		}
+		generation = snap.Generation
+	case !errors.Is(err, os.ErrNotExist):
+		return 0, fmt.Errorf("%w: snapshot: %v", ErrPersist, err)
+	}
+
+	path := filepath.Join(dir, logFile)
+
+	generations, err := rotatedLogs(dir)
+	if err != nil {
+		return 0, fmt.Errorf("%w: log: %v", ErrPersist, err)
+	}
+
+	entries := 0
+
+	for _, rotated := range generations {
+		// left behind by a crash after the snapshot including it was written
+		if rotated <= generation {
+			os.Remove(rotatedLog(path, rotated))
+
+			continue
+		}
+
+		n, err := s.replayLog(rotatedLog(path, rotated))
+		if err != nil {
+			return 0, err
+		}
+		entries += n
+		generation = rotated
+	}
+
+	n, err := s.replayLog(path)
+	if err != nil {
+		return 0, err
+	}
+	entries += n
+
+	log.Printf("replayed %d log entries, %d keys restored", entries, s.table.len())
+
+	return generation, nil
+}
+
+// replayLog applies the log at path, returning how many entries it held. A
+// truncated final line, left by a crash mid-write, is skipped and cut from
+// the log so later entries start on a line of their own. An unreadable line
+// anywhere else means the log is corrupt and fails the replay.
+func (s *Storage) replayLog(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+
+		return 0, fmt.Errorf("%w: log: %v", ErrPersist, err)
+	}
+	defer file.Close()
+
+	var (
+		entries int
+		// end of the last readable line
+		good       int64
+		unreadable error
+	)
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+
+	for scanner.Scan() {
+		if unreadable != nil {
+			return 0, fmt.Errorf("%w: %s entry %d: %v", ErrPersist, filepath.Base(path), entries+1, unreadable)
+		}
+
+		var entry logEntry
+		if err := unmarshal(scanner.Bytes(), &entry); err != nil {
+			unreadable = err
+
+			continue
+		}
+
+		if err := s.apply(entry); err != nil {
+			return 0, err
+		}
+		entries++
+		good += int64(len(scanner.Bytes())) + 1
+	}
+
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("%w: log: %v", ErrPersist, err)
+	}
+
+	if unreadable != nil {
+		log.Printf("skipping truncated %s entry %d: %v", filepath.Base(path), entries+1, unreadable)
+
+		if err := os.Truncate(path, good); err != nil {
+			return 0, fmt.Errorf("%w: log: %v", ErrPersist, err)
+		}
+	}
+
+	return entries, nil
+}
+
+// load replaces the contents of the store with snap. Callers must hold the
+// lock.
+func (s *Storage) load(snap snapshot) error {
+	if err := s.table.reset(snap); err != nil {
+		return err
+	}
+
+	s.expiry = make(map[string]time.Time, len(snap.Expiry))
+	s.versions = make(map[string]uint64, len(snap.Versions))
+	s.usage = make(map[string]*usage, len(snap.Store))
+
+	for key := range snap.Store {
+		s.use(key)
+	}
+
+	for key, expires := range snap.Expiry {
+		s.expiry[key] = expires
+	}
+
+	for key, version := range snap.Versions {
+		s.versions[key] = version
+	}
+
+	s.version = snap.Version
+
+	return nil
+}
+
+// apply applies a single log entry to the store, both for live writes and
+// on replay, so versions come out the same either way. Callers must hold the
+// lock.
+func (s *Storage) apply(entry logEntry) error {
+	// a write to several keys is grouped, for the disk engine to keep it
+	// all or nothing
+	if entry.Op == opTxn || len(entry.Data) > 1 {
+		return s.table.group(func() error {
+			return s.applyEntry(entry)
+		})
+	}
+
+	return s.applyEntry(entry)
+}
+
+func (s *Storage) applyEntry(entry logEntry) error {
+	switch entry.Op {
+	case opPost:
+		// sorted so each key gets the same version on every replay
+		keys := make([]string, 0, len(entry.Data))
+		for key := range entry.Data {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			old, _ := s.table.get(key)
+			value := entry.Data[key]
+			expires, ttl := entry.Expiry[key]
+
+			if err := s.table.set(key, value, s.version+1, expires); err != nil {
+				return err
+			}
+
+			s.version++
+			s.versions[key] = s.version
+			s.use(key)
+			s.notify(Event{Op: OpPost, Key: key, Old: old, New: value, Version: s.version})
+
+			if ttl {
+				s.expiry[key] = expires
+			} else {
+				delete(s.expiry, key)
+			}
+		}
+	case opDelete, opEvict:
+		old, _ := s.table.get(entry.Key)
+		version := s.versions[entry.Key]
+
+		if err := s.remove(entry.Key); err != nil {
+			return err
+		}
+
+		op := OpDelete
+		if entry.Op == opEvict {
+			op = OpEvict
+		}
+
+		s.notify(Event{Op: op, Key: entry.Key, Old: old, Version: version})
+	case opTxn:
+		// a transaction is a single log line so it replays all or nothing
+		for _, op := range entry.Ops {
+			if err := s.applyEntry(op); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Snapshot compacts the write log into a snapshot of the current store. It
+// is a no-op for storage without persistence. The store is only locked to
+// copy it and rotate the log, so writes carry on while the snapshot is
+// written.
+func (s *Storage) Snapshot() error {
+	if s.wal == nil {
+		return nil
+	}
+
+	s.rwMutex.Lock()
+
+	generation, err := s.wal.rotate()
+	if err != nil {
+		s.rwMutex.Unlock()
+
+		return err
+	}
+
+	snap := s.snapshot()
+	snap.Generation = generation
+
+	s.rwMutex.Unlock()
+
+	return s.wal.compact(snap)
+}
+
+// snapshot copies the store. Callers must hold the lock.
+func (s *Storage) snapshot() snapshot {
+	snap := snapshot{
+		Store:    s.table.data(),
+		Expiry:   make(map[string]time.Time, len(s.expiry)),
+		Versions: make(map[string]uint64, len(s.versions)),
+		Version:  s.version,
+	}
+
+	for key, expires := range s.expiry {
+		snap.Expiry[key] = expires
+	}
+
+	for key, version := range s.versions {
+		snap.Versions[key] = version
+	}
+
+	return snap
+}
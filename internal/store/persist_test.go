@@ -0,0 +1,166 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"task1/internal/logger"
+	"testing"
+	"time"
+)
+
+func TestService_Replay(t *testing.T) {
+	tests := []struct {
+		name     string
+		ops      func(kv *Storage)
+		snapshot bool
+		corrupt  bool
+		want     StoreData
+	}{
+		{
+			name: "replay - posts from log",
+			ops: func(kv *Storage) {
+				kv.Post(map[string]interface{}{"1": "hello world", "2": "hello"})
+			},
+			want: StoreData{"1": "hello world", "2": "hello"},
+		},
+		{
+			name: "replay - deletes from log",
+			ops: func(kv *Storage) {
+				kv.Post(map[string]interface{}{"1": "hello world", "2": "hello"})
+				kv.Delete("2")
+			},
+			want: StoreData{"1": "hello world"},
+		},
+		{
+			name: "replay - expired keys dropped",
+			ops: func(kv *Storage) {
+				kv.Post(map[string]interface{}{"1": "hello world"})
+				kv.PostWithTTL(map[string]interface{}{"2": "hello"}, time.Millisecond)
+				time.Sleep(5 * time.Millisecond)
+			},
+			want: StoreData{"1": "hello world"},
+		},
+		{
+			name: "replay - snapshot then log",
+			ops: func(kv *Storage) {
+				kv.Post(map[string]interface{}{"1": "hello world", "2": "hello"})
+				kv.Snapshot()
+				kv.Delete("1")
+				kv.Post(map[string]interface{}{"3": "world"})
+			},
+			want: StoreData{"2": "hello", "3": "world"},
+		},
+		{
+			name: "replay - rotated log not yet in a snapshot",
+			ops: func(kv *Storage) {
+				kv.Post(map[string]interface{}{"1": "hello world"})
+				kv.Snapshot()
+				kv.Post(map[string]interface{}{"2": "hello"})
+				// a crash between rotating and writing the snapshot
+				kv.wal.rotate()
+				kv.Post(map[string]interface{}{"3": "world"})
+			},
+			want: StoreData{"1": "hello world", "2": "hello", "3": "world"},
+		},
+		{
+			name: "replay - rotated log already in the snapshot",
+			ops: func(kv *Storage) {
+				kv.Post(map[string]interface{}{"1": "hello world"})
+				kv.Snapshot()
+				// a crash before the rotated log was removed
+				stale := rotatedLog(filepath.Join(kv.wal.dir, logFile), kv.wal.generation)
+				os.WriteFile(stale, []byte(`{"Op":"POST","Data":{"stale":"x"}}`+"\n"), 0o644)
+			},
+			want: StoreData{"1": "hello world"},
+		},
+		{
+			name: "replay - transaction",
+			ops: func(kv *Storage) {
+				kv.Post(map[string]interface{}{"1": "hello world", "2": "hello"})
+				kv.Transact([]TxOp{
+					{Op: TxDelete, Key: "1"},
+					{Op: TxSet, Key: "3", Value: "world"},
+				})
+			},
+			want: StoreData{"2": "hello", "3": "world"},
+		},
+		{
+			name: "replay - truncated final entry skipped",
+			ops: func(kv *Storage) {
+				kv.Post(map[string]interface{}{"1": "hello world"})
+			},
+			corrupt: true,
+			want:    StoreData{"1": "hello world"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			logger := logger.NewLogger()
+			logger.StartNoopLogger()
+
+			kv := NewDurableStorage(logger, dir)
+			tt.ops(kv)
+			kv.wal.close()
+
+			if tt.corrupt {
+				f, err := os.OpenFile(filepath.Join(dir, logFile), os.O_WRONLY|os.O_APPEND, 0o644)
+				if err != nil {
+					t.Fatalf("open log error: %v", err)
+				}
+				f.WriteString(`{"Op":"POST","Data":{"9":`)
+				f.Close()
+			}
+
+			restored := NewDurableStorage(logger, dir)
+			defer restored.wal.close()
+
+			// a later write must not be lost behind a truncated entry
+			restored.Post(StoreData{"later": "write"})
+			restored.wal.close()
+
+			restored = NewDurableStorage(logger, dir)
+			defer restored.wal.close()
+
+			if got, err := restored.Get("later"); err != nil || got != "write" {
+				t.Errorf("restored.Get(later) = %v, %v, want write", got, err)
+			}
+			restored.Delete("later")
+
+			for key, want := range tt.want {
+				got, err := restored.Get(key)
+				if err != nil {
+					t.Errorf("restored.Get(%s) error = %v", key, err)
+					continue
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("restored.Get(%s) = %v, want %v", key, got, want)
+				}
+			}
+
+			for _, key := range restored.table.keys() {
+				if _, ok := tt.want[key]; !ok && !restored.expired(key, time.Now()) {
+					t.Errorf("restored store has unexpected key %s", key)
+				}
+			}
+		})
+	}
+}
+
+func TestService_ReplayCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	logger := logger.NewLogger()
+	logger.StartNoopLogger()
+
+	log := `{"Op":"POST","Data":{"1":"hello"}}` + "\n" +
+		`{"Op":"POST","Data":{"2":` + "\n" +
+		`{"Op":"POST","Data":{"3":"world"}}` + "\n"
+	os.WriteFile(filepath.Join(dir, logFile), []byte(log), 0o644)
+
+	kv := NewStorage(logger)
+	if _, err := kv.replay(dir); !errors.Is(err, ErrPersist) {
+		t.Errorf("Service.replay() error = %v, want %v", err, ErrPersist)
+	}
+}
@@ -0,0 +1,192 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"task1/internal/logger"
+	"time"
+)
+
+const (
+	replicaBuffer = 1024
+
+	// an Update on a replica retries its compare and swap, giving
+	// replication time to bring the key up to date
+	forwardRetries    = 3
+	forwardRetryDelay = 10 * time.Millisecond
+)
+
+var (
+	ErrReadOnly       = errors.New("store is a read only replica")
+	ErrReplicationGap = errors.New("replicated entry out of sequence")
+	ErrTTLTooShort    = errors.New("ttl under a millisecond cannot be forwarded")
+)
+
+// Replicated is a write log entry numbered by its position in the stream of
+// writes since the store started. The entry is opaque outside this package.
+type Replicated struct {
+	Seq   uint64          `json:"Seq"`
+	Entry json.RawMessage `json:"Entry"`
+}
+
+// Forwarder receives the writes made to a replica so they can be sent on to
+// the leader. Forwarded writes reach the replica again through replication,
+// so a read straight after a forwarded write may not see it yet.
+type Forwarder interface {
+	Post(data StoreData, ttl time.Duration) error
+	Delete(key string) error
+	CompareAndSwap(key string, value interface{}, expectedVersion *uint64, expectedValue interface{}, ttl time.Duration) (uint64, error)
+	Increment(key string, delta int64) (int64, uint64, error)
+	Transact(ops []TxOp) ([]TxResult, error)
+}
+
+// SetReplica makes the store a read only replica which only changes through
+// Restore and ApplyReplicated. Writes are sent to fwd, or rejected with
+// ErrReadOnly if fwd is nil.
+func (s *Storage) SetReplica(fwd Forwarder) {
+	s.rwMutex.Lock()
+	defer s.rwMutex.Unlock()
+
+	s.replica = true
+	s.forwarder = fwd
+}
+
+func (s *Storage) replicaState() (bool, Forwarder) {
+	s.rwMutex.RLock()
+	defer s.rwMutex.RUnlock()
+
+	return s.replica, s.forwarder
+}
+
+// Replicate returns a snapshot of the store and the sequence number it was
+// taken at, along with a channel receiving every later write in order. A
+// subscriber which falls replicaBuffer entries behind has its channel closed
+// and must start again from a new snapshot.
+func (s *Storage) Replicate() ([]byte, uint64, <-chan Replicated, func(), error) {
+	s.rwMutex.Lock()
+	defer s.rwMutex.Unlock()
+
+	raw, err := json.Marshal(snapshot{
+		Store:    s.table.data(),
+		Expiry:   s.expiry,
+		Versions: s.versions,
+		Version:  s.version,
+	})
+	if err != nil {
+		return nil, 0, nil, nil, fmt.Errorf("%w: %v", ErrPersist, err)
+	}
+
+	s.replicaID++
+	id := s.replicaID
+	entries := make(chan Replicated, replicaBuffer)
+	s.replicas[id] = entries
+
+	s.logger.Info("replica subscribed", logger.F("replica", id), logger.F("seq", s.seq))
+
+	cancel := func() {
+		s.rwMutex.Lock()
+		defer s.rwMutex.Unlock()
+
+		s.unreplicate(id)
+	}
+
+	return raw, s.seq, entries, cancel, nil
+}
+
+// Restore replaces the contents of the store with a snapshot from Replicate.
+func (s *Storage) Restore(raw []byte, seq uint64) error {
+	var snap snapshot
+	if err := unmarshal(raw, &snap); err != nil {
+		return fmt.Errorf("%w: %v", ErrPersist, err)
+	}
+
+	s.rwMutex.Lock()
+	defer s.rwMutex.Unlock()
+
+	if err := s.load(snap); err != nil {
+		return err
+	}
+	s.seq = seq
+
+	s.logger.Info("store restored", logger.F("keys", s.table.len()), logger.F("seq", seq))
+
+	if s.wal != nil {
+		// the log holds writes from before the restore, so it is rotated
+		// into the snapshot rather than replayed on top of it
+		generation, err := s.wal.rotate()
+		if err != nil {
+			return err
+		}
+
+		snap.Generation = generation
+
+		return s.wal.compact(snap)
+	}
+
+	return nil
+}
+
+// ApplyReplicated applies an entry received from Replicate. Entries must
+// arrive in sequence, a gap returns ErrReplicationGap and the replica must
+// restore from a new snapshot.
+func (s *Storage) ApplyReplicated(r Replicated) error {
+	var entry logEntry
+	if err := unmarshal(r.Entry, &entry); err != nil {
+		return fmt.Errorf("%w: %v", ErrPersist, err)
+	}
+
+	s.rwMutex.Lock()
+	defer s.rwMutex.Unlock()
+
+	if r.Seq != s.seq+1 {
+		return fmt.Errorf("%w: got %d, want %d", ErrReplicationGap, r.Seq, s.seq+1)
+	}
+
+	return s.write(entry)
+}
+
+// Seq returns the sequence number of the latest write.
+func (s *Storage) Seq() uint64 {
+	s.rwMutex.RLock()
+	defer s.rwMutex.RUnlock()
+
+	return s.seq
+}
+
+// replicate numbers entry and sends it to every subscriber. Callers must hold
+// the lock.
+func (s *Storage) replicate(entry logEntry) {
+	s.seq++
+
+	if len(s.replicas) == 0 {
+		return
+	}
+
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		s.logger.Error("replication encoding error", logger.F("err", err))
+
+		return
+	}
+
+	for id, entries := range s.replicas {
+		select {
+		case entries <- Replicated{Seq: s.seq, Entry: raw}:
+		default:
+			s.logger.Warn("replica fell behind and was closed", logger.F("replica", id))
+			s.unreplicate(id)
+		}
+	}
+}
+
+// unreplicate closes and removes a subscriber. Callers must hold the lock.
+func (s *Storage) unreplicate(id uint64) {
+	entries, ok := s.replicas[id]
+	if !ok {
+		return
+	}
+
+	close(entries)
+	delete(s.replicas, id)
+}
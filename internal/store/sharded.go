@@ -0,0 +1,380 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"sort"
+	"sync"
+	"task1/internal/logger"
+	"time"
+)
+
+const (
+	DefaultShards = 16
+)
+
+// ShardedStorage spreads keys over independently locked Storage partitions
+// chosen by key hash, so operations on different keys rarely contend. Writes
+// touching several shards lock them in index order and are atomic in memory.
+// Versions are counted per shard, so they increase per key but are not
+// comparable across keys.
+type ShardedStorage struct {
+	shards []*Storage
+	logger logger.Logger
+}
+
+func NewShardedStorage(logger *logger.Logger, shards int) *ShardedStorage {
+	if shards < 1 {
+		shards = DefaultShards
+	}
+
+	s := &ShardedStorage{
+		shards: make([]*Storage, shards),
+		logger: *logger,
+	}
+
+	for i := range s.shards {
+		s.shards[i] = NewStorage(logger)
+	}
+
+	return s
+}
+
+// Start runs each shard's reaper, logging once for the whole store.
+func (s *ShardedStorage) Start() {
+	log.Printf("storage reaper started, %d shards", len(s.shards))
+	for _, sh := range s.shards {
+		go sh.run()
+	}
+}
+
+func (s *ShardedStorage) Stop() {
+	for _, sh := range s.shards {
+		sh.stop()
+	}
+	log.Print("storage shutdown ok")
+}
+
+func (s *ShardedStorage) index(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+
+	return int(h.Sum32() % uint32(len(s.shards)))
+}
+
+func (s *ShardedStorage) shard(key string) *Storage {
+	return s.shards[s.index(key)]
+}
+
+// lock write locks the shards holding keys in index order and returns a func
+// unlocking them.
+func (s *ShardedStorage) lock(keys []string) func() {
+	held := make(map[int]bool)
+	for _, key := range keys {
+		held[s.index(key)] = true
+	}
+
+	indexes := make([]int, 0, len(held))
+	for i := range held {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+
+	for _, i := range indexes {
+		s.shards[i].rwMutex.Lock()
+	}
+
+	return func() {
+		for _, i := range indexes {
+			s.shards[i].rwMutex.Unlock()
+		}
+	}
+}
+
+// notFound maps a shard reporting ErrStoreEmpty to ErrStoreKeyNotFound when
+// other shards hold keys, so callers see the same errors as from Storage.
+func (s *ShardedStorage) notFound(err error) error {
+	if !errors.Is(err, ErrStoreEmpty) {
+		return err
+	}
+
+	if keys, _ := s.Stats(); keys > 0 {
+		return ErrStoreKeyNotFound
+	}
+
+	return err
+}
+
+func (s *ShardedStorage) Get(key string) (interface{}, error) {
+	value, _, err := s.GetVersion(key)
+
+	return value, err
+}
+
+func (s *ShardedStorage) GetVersion(key string) (interface{}, uint64, error) {
+	value, version, err := s.shard(key).GetVersion(key)
+
+	return value, version, s.notFound(err)
+}
+
+func (s *ShardedStorage) TTL(key string) (time.Duration, error) {
+	ttl, err := s.shard(key).TTL(key)
+
+	return ttl, s.notFound(err)
+}
+
+func (s *ShardedStorage) Post(data StoreData) error {
+	return s.PostWithTTL(data, 0)
+}
+
+func (s *ShardedStorage) PostWithTTL(data StoreData, ttl time.Duration) error {
+	if ttl < 0 {
+		s.logger.Debug(ErrTTLInvalid.Error(), logger.F("ttl", ttl))
+
+		return ErrTTLInvalid
+	}
+
+	groups := make(map[*Storage]StoreData)
+	keys := make([]string, 0, len(data))
+
+	for key, value := range data {
+		if key == "" {
+			s.logger.Debug(ErrKeyEmpty.Error())
+
+			return ErrKeyEmpty
+		}
+
+		sh := s.shard(key)
+		if groups[sh] == nil {
+			groups[sh] = make(StoreData)
+		}
+		groups[sh][key] = value
+		keys = append(keys, key)
+	}
+
+	unlock := s.lock(keys)
+	defer unlock()
+
+	entries := make(map[*Storage]logEntry, len(groups))
+	for sh, group := range groups {
+		entries[sh] = postEntry(group, ttl)
+
+		// every shard must have room before any of them are written
+		if err := sh.admit(entries[sh]); err != nil {
+			return err
+		}
+	}
+
+	for sh, entry := range entries {
+		if err := sh.write(entry); err != nil {
+			return err
+		}
+	}
+
+	for key, value := range data {
+		s.logger.Debug("key added to store", logger.F("key", key), logger.F("value", value))
+	}
+
+	return nil
+}
+
+func (s *ShardedStorage) CompareAndSwap(
+	key string,
+	value interface{},
+	expectedVersion *uint64,
+	expectedValue interface{},
+	ttl time.Duration,
+) (uint64, error) {
+	version, err := s.shard(key).CompareAndSwap(key, value, expectedVersion, expectedValue, ttl)
+
+	return version, s.notFound(err)
+}
+
+func (s *ShardedStorage) Increment(key string, delta int64) (int64, uint64, error) {
+	value, version, err := s.shard(key).Increment(key, delta)
+
+	return value, version, s.notFound(err)
+}
+
+func (s *ShardedStorage) Update(key string, fn func(value interface{}) (interface{}, error)) (interface{}, uint64, error) {
+	value, version, err := s.shard(key).Update(key, fn)
+
+	return value, version, s.notFound(err)
+}
+
+func (s *ShardedStorage) Delete(key string) error {
+	return s.notFound(s.shard(key).Delete(key))
+}
+
+func (s *ShardedStorage) Transact(ops []TxOp) ([]TxResult, error) {
+	if len(ops) == 0 {
+		s.logger.Debug(ErrTxnEmpty.Error())
+
+		return nil, ErrTxnEmpty
+	}
+
+	s.logger.Debug("transaction store access", logger.F("ops", len(ops)))
+
+	keys := make([]string, len(ops))
+	for i, op := range ops {
+		keys[i] = op.Key
+	}
+
+	unlock := s.lock(keys)
+	defer unlock()
+
+	results, err := transact(ops, s.shard)
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Debug("transaction applied", logger.F("ops", len(ops)))
+
+	return results, nil
+}
+
+// List merges the pages of every shard. Each shard returns its first limit
+// keys after cursor so the merged first limit keys are the right page.
+func (s *ShardedStorage) List(prefix, cursor string, limit int) (ListPage, error) {
+	if limit == 0 {
+		limit = DefaultListLimit
+	}
+
+	keys := make([]string, 0)
+	more := false
+
+	for _, sh := range s.shards {
+		page, err := sh.List(prefix, cursor, limit)
+		if err != nil {
+			return ListPage{}, err
+		}
+
+		keys = append(keys, page.Keys...)
+		more = more || page.Next != ""
+	}
+
+	sort.Strings(keys)
+
+	page := ListPage{Keys: keys}
+	if len(keys) > limit || (more && len(keys) == limit) {
+		page.Keys = keys[:limit]
+		page.Next = keys[limit-1]
+	}
+
+	return page, nil
+}
+
+func (s *ShardedStorage) Stats() (int, int64) {
+	var (
+		keys  int
+		bytes int64
+	)
+
+	for _, sh := range s.shards {
+		k, b := sh.Stats()
+		keys += k
+		bytes += b
+	}
+
+	return keys, bytes
+}
+
+// SetLimits splits limits across the shards, their shares adding up to the
+// limits exactly so the store never holds more. A shard can fill up and
+// evict while others have room, keys hash evenly enough for the store to
+// stay close to the limits. Every shard needs a share of at least one key
+// and one byte, as a limit of 0 is no limit, so smaller limits are
+// ErrLimitsTooSmall.
+func (s *ShardedStorage) SetLimits(limits Limits) error {
+	n := len(s.shards)
+	if (limits.MaxKeys > 0 && limits.MaxKeys < n) || (limits.MaxBytes > 0 && limits.MaxBytes < int64(n)) {
+		return fmt.Errorf("%w: %d shards", ErrLimitsTooSmall, n)
+	}
+
+	for i, sh := range s.shards {
+		perShard := Limits{
+			MaxKeys:  int(share(int64(limits.MaxKeys), n, i)),
+			MaxBytes: share(limits.MaxBytes, n, i),
+			Policy:   limits.Policy,
+		}
+
+		if err := sh.SetLimits(perShard); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// share is shard i's part of limit split n ways, the remainder going to the
+// first shards.
+func share(limit int64, n, i int) int64 {
+	part := limit / int64(n)
+	if int64(i) < limit%int64(n) {
+		part++
+	}
+
+	return part
+}
+
+func (s *ShardedStorage) Evictions() uint64 {
+	var evictions uint64
+	for _, sh := range s.shards {
+		evictions += sh.Evictions()
+	}
+
+	return evictions
+}
+
+// Watch merges the watches of every shard. Events for a key arrive in order
+// but events for keys on different shards may interleave. If any shard's
+// watch falls behind the merged channel is closed.
+func (s *ShardedStorage) Watch(prefix string) (<-chan Event, func()) {
+	var (
+		merged  = make(chan Event, watchBuffer)
+		done    = make(chan struct{})
+		cancels = make([]func(), len(s.shards))
+		once    sync.Once
+		wg      sync.WaitGroup
+	)
+
+	stop := func() {
+		once.Do(func() {
+			close(done)
+			for _, cancel := range cancels {
+				cancel()
+			}
+		})
+	}
+
+	watches := make([]<-chan Event, len(s.shards))
+	for i, sh := range s.shards {
+		watches[i], cancels[i] = sh.Watch(prefix)
+	}
+
+	for _, events := range watches {
+		wg.Add(1)
+		go func(events <-chan Event) {
+			defer wg.Done()
+
+			for event := range events {
+				select {
+				case merged <- event:
+				case <-done:
+				}
+			}
+
+			// a shard closing its watch ends the merged watch too
+			go stop()
+		}(events)
+	}
+
+	go func() {
+		wg.Wait()
+		close(merged)
+	}()
+
+	return merged, stop
+}
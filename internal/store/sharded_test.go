@@ -0,0 +1,184 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"task1/internal/logger"
+	"testing"
+)
+
+func TestShardedStorage(t *testing.T) {
+	logger := logger.NewLogger()
+	logger.StartNoopLogger()
+	kv := NewShardedStorage(logger, 4)
+
+	if _, err := kv.Get("1"); !errors.Is(err, ErrStoreEmpty) {
+		t.Errorf("ShardedStorage.Get() empty error = %v, want %v", err, ErrStoreEmpty)
+	}
+
+	data := StoreData{}
+	for i := 0; i < 20; i++ {
+		data[fmt.Sprintf("key/%02d", i)] = i
+	}
+
+	if err := kv.Post(data); err != nil {
+		t.Fatalf("ShardedStorage.Post() error = %v", err)
+	}
+
+	if err := kv.Post(StoreData{"1": "a", "": "b"}); !errors.Is(err, ErrKeyEmpty) {
+		t.Errorf("ShardedStorage.Post() error = %v, want %v", err, ErrKeyEmpty)
+	}
+
+	for key, want := range data {
+		got, err := kv.Get(key)
+		if err != nil || got != want {
+			t.Errorf("ShardedStorage.Get(%s) = %v, %v, want %v", key, got, err, want)
+		}
+	}
+
+	if _, err := kv.Get("missing"); !errors.Is(err, ErrStoreKeyNotFound) {
+		t.Errorf("ShardedStorage.Get() missing error = %v, want %v", err, ErrStoreKeyNotFound)
+	}
+
+	if err := kv.Delete("missing"); !errors.Is(err, ErrStoreKeyNotFound) {
+		t.Errorf("ShardedStorage.Delete() missing error = %v, want %v", err, ErrStoreKeyNotFound)
+	}
+
+	page, err := kv.List("key/", "key/04", 3)
+	want := ListPage{Keys: []string{"key/05", "key/06", "key/07"}, Next: "key/07"}
+	if err != nil || !reflect.DeepEqual(page, want) {
+		t.Errorf("ShardedStorage.List() = %+v, %v, want %+v", page, err, want)
+	}
+
+	page, err = kv.List("key/", "key/16", 10)
+	want = ListPage{Keys: []string{"key/17", "key/18", "key/19"}}
+	if err != nil || !reflect.DeepEqual(page, want) {
+		t.Errorf("ShardedStorage.List() last page = %+v, %v, want %+v", page, err, want)
+	}
+
+	events, cancel := kv.Watch("key/0")
+
+	// spans shards, and fails as a whole on the missing key
+	_, err = kv.Transact([]TxOp{
+		{Op: TxDelete, Key: "key/00"},
+		{Op: TxDelete, Key: "key/01"},
+		{Op: TxDelete, Key: "missing"},
+	})
+	if !errors.Is(err, ErrStoreKeyNotFound) {
+		t.Errorf("ShardedStorage.Transact() error = %v, want %v", err, ErrStoreKeyNotFound)
+	}
+
+	results, err := kv.Transact([]TxOp{
+		{Op: TxDelete, Key: "key/00"},
+		{Op: TxDelete, Key: "key/01"},
+		{Op: TxGet, Key: "key/02"},
+	})
+	if err != nil || results[2].Value != 2 {
+		t.Errorf("ShardedStorage.Transact() = %+v, %v", results, err)
+	}
+
+	if keys, _ := kv.Stats(); keys != 18 {
+		t.Errorf("ShardedStorage.Stats() keys = %v, want 18", keys)
+	}
+
+	got := map[string]string{}
+	for i := 0; i < 2; i++ {
+		event := <-events
+		got[event.Key] = event.Op
+	}
+	cancel()
+
+	if want := map[string]string{"key/00": OpDelete, "key/01": OpDelete}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ShardedStorage.Watch() = %v, want %v", got, want)
+	}
+
+	for range events {
+	}
+}
+
+func TestShardedStorage_StartStop(t *testing.T) {
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	defer log.SetOutput(os.Stderr)
+
+	logger := logger.NewLogger()
+	logger.StartNoopLogger()
+	kv := NewShardedStorage(logger, 4)
+	kv.Start()
+	kv.Stop()
+
+	for _, line := range []string{"storage reaper started", "storage shutdown ok"} {
+		if got := strings.Count(out.String(), line); got != 1 {
+			t.Errorf("ShardedStorage logged %q %d times, want once:\n%s", line, got, out.String())
+		}
+	}
+}
+
+// The shards' limits add up to the store's, so it never holds more keys
+// than MaxKeys however they hash.
+func TestShardedStorage_SetLimits(t *testing.T) {
+	tests := []struct {
+		name     string
+		limits   Limits
+		wantKeys int
+		wantErr  error
+	}{
+		{
+			name:     "limits - max keys split exactly",
+			limits:   Limits{MaxKeys: 6, Policy: EvictRandom},
+			wantKeys: 6,
+		},
+		{
+			name:     "limits - one key per shard",
+			limits:   Limits{MaxKeys: 4, Policy: EvictLRU},
+			wantKeys: 4,
+		},
+		{
+			name:    "limits - fail max keys under shards",
+			limits:  Limits{MaxKeys: 1, Policy: EvictLRU},
+			wantErr: ErrLimitsTooSmall,
+		},
+		{
+			name:    "limits - fail max bytes under shards",
+			limits:  Limits{MaxBytes: 3, Policy: EvictLRU},
+			wantErr: ErrLimitsTooSmall,
+		},
+		{
+			name:    "limits - fail negative",
+			limits:  Limits{MaxKeys: -4, Policy: EvictLRU},
+			wantErr: ErrLimitsNegative,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := logger.NewLogger()
+			logger.StartNoopLogger()
+			kv := NewShardedStorage(logger, 4)
+
+			if err := kv.SetLimits(tt.limits); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ShardedStorage.SetLimits() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr != nil {
+				return
+			}
+
+			for i := 0; i < 200; i++ {
+				kv.Post(StoreData{fmt.Sprint(i): i})
+
+				if keys, _ := kv.Stats(); keys > tt.limits.MaxKeys {
+					t.Fatalf("ShardedStorage.Stats() keys = %d after %d posts, want at most %d", keys, i+1, tt.limits.MaxKeys)
+				}
+			}
+
+			if keys, _ := kv.Stats(); keys != tt.wantKeys {
+				t.Errorf("ShardedStorage.Stats() keys = %d, want %d", keys, tt.wantKeys)
+			}
+		})
+	}
+}
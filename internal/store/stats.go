@@ -0,0 +1,47 @@
+package store
+
+import (
+	"encoding/json"
+)
+
+// Stats returns the number of keys in the store and their approximate size
+// in bytes. Keys whose ttl has passed are counted until they are reaped.
+func (s *Storage) Stats() (int, int64) {
+	s.rwMutex.RLock()
+	defer s.rwMutex.RUnlock()
+
+	return s.table.len(), s.table.size()
+}
+
+// entrySize approximates the memory held by a key and its value.
+func entrySize(key string, value interface{}) int64 {
+	return int64(len(key)) + valueSize(value)
+}
+
+func valueSize(value interface{}) int64 {
+	switch v := value.(type) {
+	case nil:
+		return 0
+	case bool:
+		return 1
+	case string:
+		return int64(len(v))
+	case float64, int, int64, uint64:
+		return 8
+	case []interface{}:
+		var size int64
+		for _, item := range v {
+			size += valueSize(item)
+		}
+		return size
+	case map[string]interface{}:
+		var size int64
+		for key, item := range v {
+			size += entrySize(key, item)
+		}
+		return size
+	default:
+		out, _ := json.Marshal(v)
+		return int64(len(out))
+	}
+}
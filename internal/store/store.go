@@ -2,108 +2,452 @@ package store
 
 import (
 	"errors"
-	"fmt"
+	"log"
 	"sync"
+	"sync/atomic"
 	"task1/internal/logger"
+	"time"
+)
+
+const (
+	reapInterval = 1 * time.Second
 )
 
 var (
 	ErrStoreEmpty       = errors.New("store is empty")
 	ErrStoreKeyNotFound = errors.New("key not found in store")
 	ErrKeyEmpty         = errors.New("key cannot be empty")
+	ErrTTLInvalid       = errors.New("ttl cannot be negative")
+	ErrCASConflict      = errors.New("compare and swap conflict")
+	ErrCASNoCondition   = errors.New("compare and swap needs an expected version or value")
 )
 
 type StoreData map[string]interface{}
 
 type Storage struct {
-	store   StoreData
+	table    table
+	expiry   map[string]time.Time
+	versions map[string]uint64
+	version  uint64
+	watchers map[uint64]*watcher
+	watchID  uint64
+
+	// memory limits, see evict.go
+	limits    Limits
+	usage     map[string]*usage
+	clock     atomic.Uint64
+	evictions uint64
+
+	// replication, see replicate.go
+	seq       uint64
+	replicas  map[uint64]chan Replicated
+	replicaID uint64
+	replica   bool
+	forwarder Forwarder
+
+	wal     *writeLog
+	done    chan struct{}
 	logger  logger.Logger
 	rwMutex *sync.RWMutex
 }
 
 func NewStorage(logger *logger.Logger) *Storage {
-	store := make(StoreData)
+	expiry := make(map[string]time.Time)
 	rwMutex := &sync.RWMutex{}
 
 	return &Storage{
-		store:   store,
-		logger:  *logger,
-		rwMutex: rwMutex,
+		table:    newMemTable(),
+		expiry:   expiry,
+		versions: make(map[string]uint64),
+		usage:    make(map[string]*usage),
+		watchers: make(map[uint64]*watcher),
+		replicas: make(map[uint64]chan Replicated),
+		done:     make(chan struct{}),
+		logger:   *logger,
+		rwMutex:  rwMutex,
+	}
+}
+
+// Start runs the reaper which removes expired keys in the background and,
+// for durable storage, periodically compacts the write log into a snapshot.
+// Get rejects expired keys on its own so the reaper only frees memory.
+func (s *Storage) Start() {
+	log.Print("storage reaper started")
+	go s.run()
+}
+
+func (s *Storage) run() {
+	reaper := time.NewTicker(reapInterval)
+	defer reaper.Stop()
+
+	snapshots := time.NewTicker(snapshotInterval)
+	defer snapshots.Stop()
+
+	for {
+		select {
+		case <-reaper.C:
+			s.reap()
+		case <-snapshots.C:
+			if err := s.Snapshot(); err != nil {
+				s.logger.Error("snapshot error", logger.F("err", err))
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *Storage) Stop() {
+	s.stop()
+	log.Print("storage shutdown ok")
+}
+
+// stop is Stop without the shutdown log, for stores stopped as part of a
+// larger one.
+func (s *Storage) stop() {
+	close(s.done)
+
+	s.rwMutex.Lock()
+	for id := range s.watchers {
+		s.unwatch(id)
+	}
+	s.rwMutex.Unlock()
+
+	if s.wal != nil {
+		if err := s.Snapshot(); err != nil {
+			log.Printf("snapshot error: %v", err)
+		}
+
+		if err := s.wal.close(); err != nil {
+			log.Printf("write log close error: %v", err)
+		}
+	}
+
+	if err := s.table.close(); err != nil {
+		log.Printf("storage close error: %v", err)
 	}
 }
 
 func (s *Storage) Get(key string) (interface{}, error) {
+	value, _, err := s.GetVersion(key)
+
+	return value, err
+}
+
+// GetVersion returns the value of key along with its version. Every write to
+// any key takes the next version from a store wide counter, so a key's
+// version changes on every write and is never reused after a delete.
+func (s *Storage) GetVersion(key string) (interface{}, uint64, error) {
 	if key == "" {
-		s.logger.Log(ErrKeyEmpty.Error())
+		s.logger.Debug(ErrKeyEmpty.Error())
 
-		return nil, ErrKeyEmpty
+		return nil, 0, ErrKeyEmpty
 	}
 
-	s.logger.Log("get store access")
+	s.logger.Debug("get store access", logger.F("key", key))
 
-	s.rwMutex.Lock()
-	defer s.rwMutex.Unlock()
+	s.rwMutex.RLock()
 
-	if len(s.store) == 0 {
-		return nil, ErrStoreEmpty
+	if s.table.len() == 0 {
+		s.rwMutex.RUnlock()
+
+		return nil, 0, ErrStoreEmpty
+	}
+
+	value, ok := s.table.get(key)
+	version := s.versions[key]
+	expired := s.expired(key, time.Now())
+
+	if ok && !expired {
+		if _, tracked := s.usage[key]; tracked {
+			s.use(key)
+		}
+	}
+
+	s.rwMutex.RUnlock()
+
+	if expired {
+		// only an expired key needs the write lock, to remove it
+		s.rwMutex.Lock()
+		value, version, ok = s.lookup(key)
+		s.rwMutex.Unlock()
 	}
 
-	value, ok := s.store[key]
 	if !ok {
-		return nil, ErrStoreKeyNotFound
+		return nil, 0, ErrStoreKeyNotFound
 	}
 
-	return value, nil
+	return value, version, nil
+}
+
+// TTL returns how long key has left before it expires, or 0 if it has no
+// ttl.
+func (s *Storage) TTL(key string) (time.Duration, error) {
+	if key == "" {
+		s.logger.Debug(ErrKeyEmpty.Error())
+
+		return 0, ErrKeyEmpty
+	}
+
+	s.rwMutex.RLock()
+	defer s.rwMutex.RUnlock()
+
+	if s.table.len() == 0 {
+		return 0, ErrStoreEmpty
+	}
+
+	now := time.Now()
+
+	// an expired key is left for Get or the reaper to remove
+	if _, ok := s.table.get(key); !ok || s.expired(key, now) {
+		return 0, ErrStoreKeyNotFound
+	}
+
+	expires, ok := s.expiry[key]
+	if !ok {
+		return 0, nil
+	}
+
+	return expires.Sub(now), nil
 }
 
 func (s *Storage) Post(data StoreData) error {
-	keys := make([]string, len(data))
-	index := 0
+	return s.PostWithTTL(data, 0)
+}
+
+// PostWithTTL adds data to the store, expiring every key in data once ttl
+// has passed. A ttl of 0 stores the keys without expiry.
+func (s *Storage) PostWithTTL(data StoreData, ttl time.Duration) error {
+	if replica, fwd := s.replicaState(); replica {
+		if fwd == nil {
+			return ErrReadOnly
+		}
+
+		return fwd.Post(data, ttl)
+	}
+
+	if ttl < 0 {
+		s.logger.Debug(ErrTTLInvalid.Error(), logger.F("ttl", ttl))
+
+		return ErrTTLInvalid
+	}
 
 	for key := range data {
 		if key == "" {
-			s.logger.Log(ErrKeyEmpty.Error())
+			s.logger.Debug(ErrKeyEmpty.Error())
 
 			return ErrKeyEmpty
 		}
-		keys[index] = key
-		index++
 	}
 
 	s.rwMutex.Lock()
 	defer s.rwMutex.Unlock()
 
-	for key, value := range data {
-		s.store[key] = value
+	if err := s.write(postEntry(data, ttl)); err != nil {
+		return err
+	}
 
-		s.logger.Log(fmt.Sprintf("key: %s, value: %v - added to store", key, value))
+	for key, value := range data {
+		s.logger.Debug("key added to store", logger.F("key", key), logger.F("value", value))
 	}
 
 	return nil
 }
 
+// CompareAndSwap sets key to value only if the key's current version matches
+// expectedVersion and its current value matches expectedValue. A nil
+// expectation is not checked but at least one must be given. An expected
+// version of 0 only matches a key which does not exist. The new version is
+// returned on success.
+func (s *Storage) CompareAndSwap(
+	key string,
+	value interface{},
+	expectedVersion *uint64,
+	expectedValue interface{},
+	ttl time.Duration,
+) (uint64, error) {
+	if replica, fwd := s.replicaState(); replica {
+		if fwd == nil {
+			return 0, ErrReadOnly
+		}
+
+		return fwd.CompareAndSwap(key, value, expectedVersion, expectedValue, ttl)
+	}
+
+	switch {
+	case key == "":
+		s.logger.Debug(ErrKeyEmpty.Error())
+
+		return 0, ErrKeyEmpty
+	case ttl < 0:
+		s.logger.Debug(ErrTTLInvalid.Error(), logger.F("ttl", ttl))
+
+		return 0, ErrTTLInvalid
+	case expectedVersion == nil && expectedValue == nil:
+		s.logger.Debug(ErrCASNoCondition.Error(), logger.F("key", key))
+
+		return 0, ErrCASNoCondition
+	}
+
+	s.logger.Debug("cas store access", logger.F("key", key))
+
+	s.rwMutex.Lock()
+	defer s.rwMutex.Unlock()
+
+	current, version, ok := s.lookup(key)
+
+	if expectedVersion != nil && *expectedVersion != version {
+		s.logger.Debug("cas version mismatch", logger.F("key", key), logger.F("version", version), logger.F("want", *expectedVersion))
+
+		return version, ErrCASConflict
+	}
+
+	if expectedValue != nil && (!ok || !equal(current, expectedValue)) {
+		s.logger.Debug("cas value mismatch", logger.F("key", key))
+
+		return version, ErrCASConflict
+	}
+
+	if err := s.write(postEntry(StoreData{key: value}, ttl)); err != nil {
+		return version, err
+	}
+
+	s.logger.Debug("key swapped in store", logger.F("key", key), logger.F("value", value))
+
+	return s.versions[key], nil
+}
+
 func (s *Storage) Delete(key string) error {
+	if replica, fwd := s.replicaState(); replica {
+		if fwd == nil {
+			return ErrReadOnly
+		}
+
+		return fwd.Delete(key)
+	}
+
 	if key == "" {
-		s.logger.Log(ErrKeyEmpty.Error())
+		s.logger.Debug(ErrKeyEmpty.Error())
 
 		return ErrKeyEmpty
 	}
 
-	s.logger.Log("delete store access")
+	s.logger.Debug("delete store access", logger.F("key", key))
 
 	s.rwMutex.Lock()
 	defer s.rwMutex.Unlock()
 
-	if len(s.store) == 0 {
+	if s.table.len() == 0 {
 		return ErrStoreEmpty
 	}
 
-	_, ok := s.store[key]
-	if !ok {
+	if _, _, ok := s.lookup(key); !ok {
 		return ErrStoreKeyNotFound
 	}
 
-	delete(s.store, key)
+	return s.write(logEntry{Op: opDelete, Key: key})
+}
+
+// lookup returns the live value and version of key, removing it first if its
+// ttl has passed. Callers must hold the lock.
+func (s *Storage) lookup(key string) (interface{}, uint64, bool) {
+	if s.expired(key, time.Now()) {
+		s.expire(key)
+		s.logger.Debug("key expired", logger.F("key", key))
+
+		return nil, 0, false
+	}
+
+	value, ok := s.table.get(key)
+
+	return value, s.versions[key], ok
+}
+
+// write records entry in the write log, if there is one, then applies it to
+// the store. Callers must hold the lock.
+func (s *Storage) write(entry logEntry) error {
+	if entry.Written.IsZero() {
+		entry.Written = time.Now()
+	}
+
+	if err := s.admit(entry); err != nil {
+		s.logger.Warn("write rejected", logger.F("op", entry.Op), logger.F("err", err))
+
+		return err
+	}
+
+	if s.wal != nil {
+		if err := s.wal.append(entry); err != nil {
+			s.logger.Error("write log append error", logger.F("op", entry.Op), logger.F("err", err))
+
+			return err
+		}
+	}
+
+	if err := s.apply(entry); err != nil {
+		s.logger.Error("write apply error", logger.F("op", entry.Op), logger.F("err", err))
+
+		return err
+	}
+
+	s.replicate(entry)
+
+	if s.limited(entry) {
+		written := make(map[string]pending)
+		changes(entry, written)
+
+		return s.evict(written)
+	}
 
 	return nil
 }
+
+// expired reports whether key has a ttl which has passed. Callers must hold
+// the lock.
+func (s *Storage) expired(key string, now time.Time) bool {
+	expires, ok := s.expiry[key]
+
+	return ok && !now.Before(expires)
+}
+
+// remove deletes key along with its ttl and version. Callers must hold the
+// lock.
+func (s *Storage) remove(key string) error {
+	if err := s.table.delete(key); err != nil {
+		return err
+	}
+
+	delete(s.expiry, key)
+	delete(s.versions, key)
+	delete(s.usage, key)
+
+	return nil
+}
+
+// expire removes key once its ttl has passed. Callers must hold the lock.
+func (s *Storage) expire(key string) {
+	old, _ := s.table.get(key)
+	version := s.versions[key]
+
+	if err := s.remove(key); err != nil {
+		s.logger.Error("expire error", logger.F("key", key), logger.F("err", err))
+
+		return
+	}
+
+	s.notify(Event{Op: OpExpire, Key: key, Old: old, Version: version})
+}
+
+func (s *Storage) reap() {
+	s.rwMutex.Lock()
+	defer s.rwMutex.Unlock()
+
+	now := time.Now()
+
+	for key := range s.expiry {
+		if s.expired(key, now) {
+			s.expire(key)
+			s.logger.Debug("key expired and removed by reaper", logger.F("key", key))
+		}
+	}
+}
@@ -1,9 +1,12 @@
 package store
 
 import (
+	"errors"
+	"fmt"
 	"reflect"
 	"task1/internal/logger"
 	"testing"
+	"time"
 )
 
 func TestService_Get(t *testing.T) {
@@ -108,3 +111,358 @@ func TestService_Post(t *testing.T) {
 		})
 	}
 }
+
+func TestService_PostWithTTL(t *testing.T) {
+	type args struct {
+		data StoreData
+		ttl  time.Duration
+	}
+	tests := []struct {
+		name       string
+		args       args
+		wait       time.Duration
+		reap       bool
+		want       interface{}
+		wantErr    bool
+		wantGetErr bool
+	}{
+		{
+			name: "POST TTL - ok before expiry",
+			args: args{
+				data: map[string]interface{}{"1": "hello world"},
+				ttl:  time.Minute,
+			},
+			want: "hello world",
+		},
+		{
+			name: "POST TTL - expired on get",
+			args: args{
+				data: map[string]interface{}{"1": "hello world"},
+				ttl:  time.Millisecond,
+			},
+			wait:       5 * time.Millisecond,
+			want:       nil,
+			wantGetErr: true,
+		},
+		{
+			name: "POST TTL - expired by reaper",
+			args: args{
+				data: map[string]interface{}{"1": "hello world"},
+				ttl:  time.Millisecond,
+			},
+			wait:       5 * time.Millisecond,
+			reap:       true,
+			want:       nil,
+			wantGetErr: true,
+		},
+		{
+			name: "POST TTL fail - negative ttl",
+			args: args{
+				data: map[string]interface{}{"1": "hello world"},
+				ttl:  -time.Second,
+			},
+			want:       nil,
+			wantErr:    true,
+			wantGetErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := logger.NewLogger()
+			logger.StartNoopLogger()
+			kv := NewStorage(logger)
+
+			if err := kv.PostWithTTL(tt.args.data, tt.args.ttl); (err != nil) != tt.wantErr {
+				t.Errorf("Service.PostWithTTL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			time.Sleep(tt.wait)
+
+			if tt.reap {
+				kv.reap()
+				if kv.table.len() != 0 || len(kv.expiry) != 0 {
+					t.Errorf("Service.reap() store = %v, expiry = %v, want empty", kv.table.data(), kv.expiry)
+				}
+			}
+
+			got, err := kv.Get("1")
+			if (err != nil) != tt.wantGetErr {
+				t.Errorf("Service.Get() error = %v, wantErr %v", err, tt.wantGetErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Service.Get() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestService_TTL(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		ttl     time.Duration
+		wait    time.Duration
+		wantMin time.Duration
+		wantMax time.Duration
+		wantErr error
+	}{
+		{
+			name:    "TTL - ok",
+			key:     "1",
+			ttl:     time.Minute,
+			wantMin: 59 * time.Second,
+			wantMax: time.Minute,
+		},
+		{
+			name: "TTL - no ttl",
+			key:  "1",
+		},
+		{
+			name:    "TTL fail - expired",
+			key:     "1",
+			ttl:     time.Millisecond,
+			wait:    5 * time.Millisecond,
+			wantErr: ErrStoreKeyNotFound,
+		},
+		{
+			name:    "TTL fail - key not found",
+			key:     "2",
+			wantErr: ErrStoreKeyNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := logger.NewLogger()
+			logger.StartNoopLogger()
+			kv := NewStorage(logger)
+			kv.PostWithTTL(StoreData{"1": "hello world"}, tt.ttl)
+
+			time.Sleep(tt.wait)
+
+			got, err := kv.TTL(tt.key)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Service.TTL() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got < tt.wantMin || got > tt.wantMax {
+				t.Errorf("Service.TTL() = %v, want between %v and %v", got, tt.wantMin, tt.wantMax)
+			}
+		})
+	}
+}
+
+func TestService_CompareAndSwap(t *testing.T) {
+	version := func(v uint64) *uint64 { return &v }
+
+	type args struct {
+		key             string
+		value           interface{}
+		expectedVersion *uint64
+		expectedValue   interface{}
+	}
+	tests := []struct {
+		name        string
+		args        args
+		want        uint64
+		wantErr     error
+		wantValue   interface{}
+		wantVersion uint64
+	}{
+		{
+			name: "CAS - ok expected version",
+			args: args{
+				key:             "1",
+				value:           "swapped",
+				expectedVersion: version(1),
+			},
+			want:        2,
+			wantValue:   "swapped",
+			wantVersion: 2,
+		},
+		{
+			name: "CAS - ok expected value",
+			args: args{
+				key:           "1",
+				value:         "swapped",
+				expectedValue: "hello world",
+			},
+			want:        2,
+			wantValue:   "swapped",
+			wantVersion: 2,
+		},
+		{
+			name: "CAS - ok create when version 0",
+			args: args{
+				key:             "2",
+				value:           "created",
+				expectedVersion: version(0),
+			},
+			want: 2,
+		},
+		{
+			name: "CAS fail - version conflict",
+			args: args{
+				key:             "1",
+				value:           "swapped",
+				expectedVersion: version(7),
+			},
+			want:        1,
+			wantErr:     ErrCASConflict,
+			wantValue:   "hello world",
+			wantVersion: 1,
+		},
+		{
+			name: "CAS fail - value conflict",
+			args: args{
+				key:           "1",
+				value:         "swapped",
+				expectedValue: "goodbye world",
+			},
+			want:        1,
+			wantErr:     ErrCASConflict,
+			wantValue:   "hello world",
+			wantVersion: 1,
+		},
+		{
+			name: "CAS fail - key exists when version 0",
+			args: args{
+				key:             "1",
+				value:           "swapped",
+				expectedVersion: version(0),
+			},
+			want:        1,
+			wantErr:     ErrCASConflict,
+			wantValue:   "hello world",
+			wantVersion: 1,
+		},
+		{
+			name: "CAS fail - no condition",
+			args: args{
+				key:   "1",
+				value: "swapped",
+			},
+			wantErr:     ErrCASNoCondition,
+			wantValue:   "hello world",
+			wantVersion: 1,
+		},
+		{
+			name: "CAS fail - key empty",
+			args: args{
+				key:             "",
+				value:           "swapped",
+				expectedVersion: version(1),
+			},
+			wantErr:     ErrKeyEmpty,
+			wantValue:   "hello world",
+			wantVersion: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := logger.NewLogger()
+			logger.StartNoopLogger()
+			kv := NewStorage(logger)
+			kv.Post(map[string]interface{}{"1": "hello world"})
+
+			got, err := kv.CompareAndSwap(tt.args.key, tt.args.value, tt.args.expectedVersion, tt.args.expectedValue, 0)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Service.CompareAndSwap() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Service.CompareAndSwap() = %v, want %v", got, tt.want)
+			}
+
+			if tt.wantValue == nil {
+				return
+			}
+
+			value, version, _ := kv.GetVersion("1")
+			if !reflect.DeepEqual(value, tt.wantValue) || version != tt.wantVersion {
+				t.Errorf("Service.GetVersion() = %v, %v, want %v, %v", value, version, tt.wantValue, tt.wantVersion)
+			}
+		})
+	}
+}
+
+func TestService_Stats(t *testing.T) {
+	logger := logger.NewLogger()
+	logger.StartNoopLogger()
+	kv := NewStorage(logger)
+
+	kv.Post(map[string]interface{}{"1": "hello world", "2": true})
+	kv.Post(map[string]interface{}{"1": "hello"})
+	kv.Post(map[string]interface{}{"3": map[string]interface{}{"a": float64(1)}})
+	kv.Delete("2")
+
+	keys, bytes := kv.Stats()
+	if keys != 2 {
+		t.Errorf("Service.Stats() keys = %v, want 2", keys)
+	}
+
+	// "1"+"hello" and "3"+"a"+float64
+	if bytes != 6+10 {
+		t.Errorf("Service.Stats() bytes = %v, want 16", bytes)
+	}
+}
+
+// benchmarkConcurrent runs Get and Post from parallel goroutines with
+// writePercent of operations being writes. Store calls log at debug, below the
+// logger's default level, so no entries are queued while it runs.
+func benchmarkConcurrent(b *testing.B, kv Store, writePercent int) {
+	const keys = 1024
+
+	for i := 0; i < keys; i++ {
+		kv.Post(StoreData{fmt.Sprint(i): i})
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			key := fmt.Sprint(i % keys)
+			if i%100 < writePercent {
+				kv.Post(StoreData{key: i})
+			} else {
+				kv.Get(key)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkStorage_Concurrent(b *testing.B) {
+	for _, writes := range []int{0, 10, 50} {
+		b.Run(fmt.Sprintf("writes=%d%%", writes), func(b *testing.B) {
+			logger := logger.NewLogger()
+			logger.StartNoopLogger()
+
+			benchmarkConcurrent(b, NewStorage(logger), writes)
+		})
+	}
+}
+
+func BenchmarkShardedStorage_Concurrent(b *testing.B) {
+	for _, writes := range []int{0, 10, 50} {
+		b.Run(fmt.Sprintf("writes=%d%%", writes), func(b *testing.B) {
+			logger := logger.NewLogger()
+			logger.StartNoopLogger()
+
+			benchmarkConcurrent(b, NewShardedStorage(logger, DefaultShards), writes)
+		})
+	}
+}
+
+func BenchmarkDiskStorage_Concurrent(b *testing.B) {
+	for _, writes := range []int{0, 10, 50} {
+		b.Run(fmt.Sprintf("writes=%d%%", writes), func(b *testing.B) {
+			logger := logger.NewLogger()
+			logger.StartNoopLogger()
+
+			kv := NewDiskStorage(logger, b.TempDir())
+			defer kv.table.close()
+
+			benchmarkConcurrent(b, kv, writes)
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package store
+
+import (
+	"time"
+)
+
+// table holds the values of a Storage. Versions, ttls and everything built on
+// them live in Storage itself, but are passed to set so a table which keeps
+// its values on disk can restore them on start. Callers must hold the
+// Storage lock.
+type table interface {
+	get(key string) (interface{}, bool)
+	set(key string, value interface{}, version uint64, expires time.Time) error
+	delete(key string) error
+	len() int
+	keys() []string
+	// size approximates the bytes held by keys and values
+	size() int64
+	// data copies every key and value, for snapshots
+	data() StoreData
+	// reset replaces the contents of the table with snap
+	reset(snap snapshot) error
+	// group runs fn, whose writes are kept all or nothing across a crash
+	group(fn func() error) error
+	close() error
+}
+
+// memTable keeps values in a map, the original engine.
+type memTable struct {
+	store StoreData
+	bytes int64
+}
+
+func newMemTable() *memTable {
+	return &memTable{
+		store: make(StoreData),
+	}
+}
+
+func (t *memTable) get(key string) (interface{}, bool) {
+	value, ok := t.store[key]
+
+	return value, ok
+}
+
+func (t *memTable) set(key string, value interface{}, _ uint64, _ time.Time) error {
+	if old, ok := t.store[key]; ok {
+		t.bytes -= entrySize(key, old)
+	}
+
+	t.store[key] = value
+	t.bytes += entrySize(key, value)
+
+	return nil
+}
+
+func (t *memTable) delete(key string) error {
+	if old, ok := t.store[key]; ok {
+		t.bytes -= entrySize(key, old)
+	}
+
+	delete(t.store, key)
+
+	return nil
+}
+
+func (t *memTable) group(fn func() error) error {
+	return fn()
+}
+
+func (t *memTable) len() int {
+	return len(t.store)
+}
+
+func (t *memTable) keys() []string {
+	keys := make([]string, 0, len(t.store))
+	for key := range t.store {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+func (t *memTable) size() int64 {
+	return t.bytes
+}
+
+func (t *memTable) data() StoreData {
+	data := make(StoreData, len(t.store))
+	for key, value := range t.store {
+		data[key] = value
+	}
+
+	return data
+}
+
+func (t *memTable) reset(snap snapshot) error {
+	t.store = make(StoreData, len(snap.Store))
+	t.bytes = 0
+
+	for key, value := range snap.Store {
+		t.store[key] = value
+		t.bytes += entrySize(key, value)
+	}
+
+	return nil
+}
+
+func (t *memTable) close() error {
+	return nil
+}
@@ -0,0 +1,182 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"task1/internal/logger"
+	"time"
+)
+
+const (
+	TxGet          = "get"
+	TxSet          = "set"
+	TxDelete       = "delete"
+	TxCheckVersion = "check-version"
+	opTxn          = "TXN"
+)
+
+var (
+	ErrTxnEmpty     = errors.New("transaction has no operations")
+	ErrTxnOpInvalid = errors.New("transaction operation invalid")
+)
+
+// TxOp is a single operation in a transaction. Value and TTL are only used by
+// set, and Version only by check-version where, as with CompareAndSwap, a
+// version of 0 requires the key to not exist.
+type TxOp struct {
+	Op      string
+	Key     string
+	Value   interface{}
+	TTL     time.Duration
+	Version uint64
+}
+
+// TxResult is the outcome of a TxOp. Value is only set for a get, and Err
+// only for a get of a key which does not exist, which does not fail the
+// transaction.
+type TxResult struct {
+	Op      string      `json:"Op"`
+	Key     string      `json:"Key"`
+	Value   interface{} `json:"Value,omitempty"`
+	Version uint64      `json:"Version"`
+	Err     string      `json:"Err,omitempty"`
+}
+
+// staged is the view of a key inside a transaction after earlier operations.
+type staged struct {
+	value   interface{}
+	version uint64
+	exists  bool
+}
+
+// Transact applies ops in order under the store lock. Either every operation
+// succeeds or none are applied, in which case the error names the index of the
+// failing operation. Later operations see the writes of earlier ones.
+func (s *Storage) Transact(ops []TxOp) ([]TxResult, error) {
+	if len(ops) == 0 {
+		s.logger.Debug(ErrTxnEmpty.Error())
+
+		return nil, ErrTxnEmpty
+	}
+
+	if replica, fwd := s.replicaState(); replica && hasWrites(ops) {
+		if fwd == nil {
+			return nil, ErrReadOnly
+		}
+
+		return fwd.Transact(ops)
+	}
+
+	s.logger.Debug("transaction store access", logger.F("ops", len(ops)))
+
+	s.rwMutex.Lock()
+	defer s.rwMutex.Unlock()
+
+	results, err := transact(ops, func(string) *Storage { return s })
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Debug("transaction applied", logger.F("ops", len(ops)))
+
+	return results, nil
+}
+
+// transact runs ops against the storage shardFor returns for each key.
+// Callers must hold the lock of every storage shardFor can return. Each
+// storage gets a single log entry holding its share of the writes.
+func transact(ops []TxOp, shardFor func(key string) *Storage) ([]TxResult, error) {
+	var (
+		results = make([]TxResult, len(ops))
+		view    = make(map[string]staged)
+		writes  = make(map[*Storage][]logEntry)
+		order   = make([]*Storage, 0)
+		version = make(map[*Storage]uint64)
+	)
+
+	current := func(key string) staged {
+		if st, ok := view[key]; ok {
+			return st
+		}
+
+		value, v, ok := shardFor(key).lookup(key)
+
+		return staged{value: value, version: v, exists: ok}
+	}
+
+	stage := func(sh *Storage, entry logEntry) {
+		if _, ok := writes[sh]; !ok {
+			order = append(order, sh)
+		}
+
+		writes[sh] = append(writes[sh], entry)
+	}
+
+	for i, op := range ops {
+		if op.Key == "" {
+			return nil, fmt.Errorf("%w: op %d", ErrKeyEmpty, i)
+		}
+
+		sh := shardFor(op.Key)
+		st := current(op.Key)
+		results[i] = TxResult{Op: op.Op, Key: op.Key, Version: st.version}
+
+		switch op.Op {
+		case TxGet:
+			if !st.exists {
+				results[i].Err = ErrStoreKeyNotFound.Error()
+				continue
+			}
+			results[i].Value = st.value
+		case TxSet:
+			if op.TTL < 0 {
+				return nil, fmt.Errorf("%w: op %d", ErrTTLInvalid, i)
+			}
+			if _, ok := version[sh]; !ok {
+				version[sh] = sh.version
+			}
+			version[sh]++
+			view[op.Key] = staged{value: op.Value, version: version[sh], exists: true}
+			results[i].Version = version[sh]
+			stage(sh, postEntry(StoreData{op.Key: op.Value}, op.TTL))
+		case TxDelete:
+			if !st.exists {
+				return nil, fmt.Errorf("%w: op %d", ErrStoreKeyNotFound, i)
+			}
+			view[op.Key] = staged{}
+			stage(sh, logEntry{Op: opDelete, Key: op.Key})
+		case TxCheckVersion:
+			if st.version != op.Version {
+				return nil, fmt.Errorf("%w: op %d version %d, want %d", ErrCASConflict, i, st.version, op.Version)
+			}
+		default:
+			return nil, fmt.Errorf("%w: op %d %q", ErrTxnOpInvalid, i, op.Op)
+		}
+	}
+
+	// every shard must have room before any of them are written
+	for _, sh := range order {
+		if err := sh.admit(logEntry{Op: opTxn, Ops: writes[sh]}); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, sh := range order {
+		if err := sh.write(logEntry{Op: opTxn, Ops: writes[sh]}); err != nil {
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
+
+// hasWrites reports whether any of ops change the store.
+func hasWrites(ops []TxOp) bool {
+	for _, op := range ops {
+		if op.Op == TxSet || op.Op == TxDelete {
+			return true
+		}
+	}
+
+	return false
+}
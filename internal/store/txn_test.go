@@ -0,0 +1,113 @@
+package store
+
+import (
+	"errors"
+	"reflect"
+	"task1/internal/logger"
+	"testing"
+)
+
+func TestService_Transact(t *testing.T) {
+	tests := []struct {
+		name      string
+		ops       []TxOp
+		want      []TxResult
+		wantErr   error
+		wantStore StoreData
+	}{
+		{
+			name: "TXN - ok mixed reads and writes",
+			ops: []TxOp{
+				{Op: TxCheckVersion, Key: "1", Version: 1},
+				{Op: TxGet, Key: "1"},
+				{Op: TxSet, Key: "3", Value: "new"},
+				{Op: TxGet, Key: "3"},
+				{Op: TxDelete, Key: "2"},
+				{Op: TxGet, Key: "2"},
+			},
+			want: []TxResult{
+				{Op: TxCheckVersion, Key: "1", Version: 1},
+				{Op: TxGet, Key: "1", Value: "hello world", Version: 1},
+				{Op: TxSet, Key: "3", Version: 3},
+				{Op: TxGet, Key: "3", Value: "new", Version: 3},
+				{Op: TxDelete, Key: "2", Version: 2},
+				{Op: TxGet, Key: "2", Err: ErrStoreKeyNotFound.Error()},
+			},
+			wantStore: StoreData{"1": "hello world", "3": "new"},
+		},
+		{
+			name: "TXN - ok delete several keys",
+			ops: []TxOp{
+				{Op: TxDelete, Key: "1"},
+				{Op: TxDelete, Key: "2"},
+			},
+			want: []TxResult{
+				{Op: TxDelete, Key: "1", Version: 1},
+				{Op: TxDelete, Key: "2", Version: 2},
+			},
+			wantStore: StoreData{},
+		},
+		{
+			name: "TXN fail - version conflict applies nothing",
+			ops: []TxOp{
+				{Op: TxSet, Key: "1", Value: "changed"},
+				{Op: TxDelete, Key: "2"},
+				{Op: TxCheckVersion, Key: "2", Version: 2},
+			},
+			wantErr:   ErrCASConflict,
+			wantStore: StoreData{"1": "hello world", "2": "hello"},
+		},
+		{
+			name: "TXN fail - delete missing key applies nothing",
+			ops: []TxOp{
+				{Op: TxDelete, Key: "1"},
+				{Op: TxDelete, Key: "9"},
+			},
+			wantErr:   ErrStoreKeyNotFound,
+			wantStore: StoreData{"1": "hello world", "2": "hello"},
+		},
+		{
+			name: "TXN fail - unknown op",
+			ops: []TxOp{
+				{Op: "rename", Key: "1"},
+			},
+			wantErr:   ErrTxnOpInvalid,
+			wantStore: StoreData{"1": "hello world", "2": "hello"},
+		},
+		{
+			name:      "TXN fail - empty",
+			ops:       []TxOp{},
+			wantErr:   ErrTxnEmpty,
+			wantStore: StoreData{"1": "hello world", "2": "hello"},
+		},
+		{
+			name: "TXN fail - key empty",
+			ops: []TxOp{
+				{Op: TxGet, Key: ""},
+			},
+			wantErr:   ErrKeyEmpty,
+			wantStore: StoreData{"1": "hello world", "2": "hello"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := logger.NewLogger()
+			logger.StartNoopLogger()
+			kv := NewStorage(logger)
+			kv.Post(map[string]interface{}{"1": "hello world"})
+			kv.Post(map[string]interface{}{"2": "hello"})
+
+			got, err := kv.Transact(tt.ops)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Service.Transact() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Service.Transact() = %+v, want %+v", got, tt.want)
+			}
+			if got := kv.table.data(); !reflect.DeepEqual(got, tt.wantStore) {
+				t.Errorf("Service.Transact() store = %v, want %v", got, tt.wantStore)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package store
+
+import (
+	"strings"
+	"task1/internal/logger"
+)
+
+const (
+	watchBuffer = 64
+	OpPost      = opPost
+	OpDelete    = opDelete
+	OpExpire    = "EXPIRE"
+	OpEvict     = "EVICT"
+)
+
+// Event describes a single change to a key. Old is nil when the key did not
+// exist and New is nil when it was deleted, expired or evicted.
+type Event struct {
+	Op      string      `json:"Op"`
+	Key     string      `json:"Key"`
+	Old     interface{} `json:"Old"`
+	New     interface{} `json:"New"`
+	Version uint64      `json:"Version"`
+}
+
+type watcher struct {
+	prefix string
+	events chan Event
+}
+
+// Watch returns a channel receiving an Event for every change to a key
+// starting with prefix, and a func which ends the watch. An empty prefix
+// watches every key. Events are sent without blocking writers, so a watcher
+// which falls watchBuffer events behind has its channel closed and must
+// watch again.
+func (s *Storage) Watch(prefix string) (<-chan Event, func()) {
+	w := &watcher{
+		prefix: prefix,
+		events: make(chan Event, watchBuffer),
+	}
+
+	s.rwMutex.Lock()
+	s.watchID++
+	id := s.watchID
+	s.watchers[id] = w
+	s.rwMutex.Unlock()
+
+	s.logger.Debug("watch added", logger.F("watch", id), logger.F("prefix", prefix))
+
+	cancel := func() {
+		s.rwMutex.Lock()
+		defer s.rwMutex.Unlock()
+
+		s.unwatch(id)
+	}
+
+	return w.events, cancel
+}
+
+// notify sends event to every matching watcher. Callers must hold the lock.
+func (s *Storage) notify(event Event) {
+	for id, w := range s.watchers {
+		if !strings.HasPrefix(event.Key, w.prefix) {
+			continue
+		}
+
+		select {
+		case w.events <- event:
+		default:
+			s.logger.Warn("watch fell behind and was closed", logger.F("watch", id))
+			s.unwatch(id)
+		}
+	}
+}
+
+// unwatch closes and removes a watcher. Callers must hold the lock.
+func (s *Storage) unwatch(id uint64) {
+	w, ok := s.watchers[id]
+	if !ok {
+		return
+	}
+
+	close(w.events)
+	delete(s.watchers, id)
+}
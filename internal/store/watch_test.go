@@ -0,0 +1,109 @@
+package store
+
+import (
+	"reflect"
+	"task1/internal/logger"
+	"testing"
+	"time"
+)
+
+func TestService_Watch(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		ops    func(kv *Storage)
+		want   []Event
+	}{
+		{
+			name:   "watch - post and delete",
+			prefix: "",
+			ops: func(kv *Storage) {
+				kv.Post(map[string]interface{}{"1": "hello"})
+				kv.Post(map[string]interface{}{"1": "world"})
+				kv.Delete("1")
+			},
+			want: []Event{
+				{Op: OpPost, Key: "1", Old: nil, New: "hello", Version: 1},
+				{Op: OpPost, Key: "1", Old: "hello", New: "world", Version: 2},
+				{Op: OpDelete, Key: "1", Old: "world", New: nil, Version: 2},
+			},
+		},
+		{
+			name:   "watch - prefix filter",
+			prefix: "config/",
+			ops: func(kv *Storage) {
+				kv.Post(map[string]interface{}{"session/1": "token"})
+				kv.Post(map[string]interface{}{"config/a": "on"})
+			},
+			want: []Event{
+				{Op: OpPost, Key: "config/a", Old: nil, New: "on", Version: 2},
+			},
+		},
+		{
+			name:   "watch - expiry",
+			prefix: "",
+			ops: func(kv *Storage) {
+				kv.PostWithTTL(map[string]interface{}{"1": "hello"}, time.Millisecond)
+				time.Sleep(5 * time.Millisecond)
+				kv.reap()
+			},
+			want: []Event{
+				{Op: OpPost, Key: "1", Old: nil, New: "hello", Version: 1},
+				{Op: OpExpire, Key: "1", Old: "hello", New: nil, Version: 1},
+			},
+		},
+		{
+			name:   "watch - cas",
+			prefix: "",
+			ops: func(kv *Storage) {
+				var absent uint64
+				kv.CompareAndSwap("1", "hello", &absent, nil, 0)
+			},
+			want: []Event{
+				{Op: OpPost, Key: "1", Old: nil, New: "hello", Version: 1},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := logger.NewLogger()
+			logger.StartNoopLogger()
+			kv := NewStorage(logger)
+
+			events, cancel := kv.Watch(tt.prefix)
+			tt.ops(kv)
+			cancel()
+
+			got := []Event{}
+			for event := range events {
+				got = append(got, event)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Service.Watch() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestService_WatchFallsBehind(t *testing.T) {
+	logger := logger.NewLogger()
+	logger.StartNoopLogger()
+	kv := NewStorage(logger)
+
+	events, cancel := kv.Watch("")
+	defer cancel()
+
+	for i := 0; i <= watchBuffer; i++ {
+		kv.Post(map[string]interface{}{"1": i})
+	}
+
+	received := 0
+	for range events {
+		received++
+	}
+
+	if received != watchBuffer {
+		t.Errorf("Service.Watch() received = %v, want %v before close", received, watchBuffer)
+	}
+}